@@ -8,6 +8,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/das"
 	"github.com/offchainlabs/nitro/staker"
 	"github.com/offchainlabs/nitro/validator"
 )
@@ -20,6 +21,16 @@ func (a *BlockValidatorAPI) LatestValidated(ctx context.Context) (*staker.Global
 	return a.val.ReadLastValidatedInfo()
 }
 
+type DataAvailabilitySamplingAPI struct {
+	sampler *das.AvailabilitySampler
+}
+
+// DataAvailabilityScores returns the availability of each DAS committee member's REST endpoint,
+// as seen by sampling the batches the node reads.
+func (a *DataAvailabilitySamplingAPI) DataAvailabilityScores(ctx context.Context) ([]das.MemberAvailability, error) {
+	return a.sampler.Scores(), nil
+}
+
 type BlockValidatorDebugAPI struct {
 	val *staker.StatelessBlockValidator
 }
@@ -1054,6 +1054,40 @@ const ethPosBlockTime = 12 * time.Second
 
 var errAttemptLockFailed = errors.New("failed to acquire lock; either another batch poster posted a batch or this node fell behind")
 
+// shouldUse4844 decides whether the batch starting at message msgCount is posted in blobs rather than in calldata.
+func (b *BatchPoster) shouldUse4844(config *BatchPosterConfig, latestHeader *types.Header, msgCount arbutil.MessageIndex) (bool, error) {
+	var use4844 bool
+	if config.Post4844Blobs && latestHeader.ExcessBlobGas != nil && latestHeader.BlobGasUsed != nil {
+		arbOSVersion, err := b.arbOSVersionGetter.ArbOSVersionForMessageNumber(arbutil.MessageIndex(arbmath.SaturatingUSub(uint64(msgCount), 1)))
+		if err != nil {
+			return false, err
+		}
+		if arbOSVersion >= 20 {
+			if config.IgnoreBlobPrice {
+				use4844 = true
+			} else {
+				backlog := b.backlog.Load()
+				// Logic to prevent switching from non-4844 batches to 4844 batches too often,
+				// so that blocks can be filled efficiently. The geth txpool rejects txs for
+				// accounts that already have the other type of txs in the pool with
+				// "address already reserved". This logic makes sure that, if there is a backlog,
+				// that enough non-4844 batches have been posted to fill a block before switching.
+				if backlog == 0 ||
+					b.non4844BatchCount == 0 ||
+					b.non4844BatchCount > 16 {
+					blobFeePerByte := eip4844.CalcBlobFee(eip4844.CalcExcessBlobGas(*latestHeader.ExcessBlobGas, *latestHeader.BlobGasUsed))
+					blobFeePerByte.Mul(blobFeePerByte, blobTxBlobGasPerBlob)
+					blobFeePerByte.Div(blobFeePerByte, usableBytesInBlob)
+
+					calldataFeePerByte := arbmath.BigMulByUint(latestHeader.BaseFee, 16)
+					use4844 = arbmath.BigLessThan(blobFeePerByte, calldataFeePerByte)
+				}
+			}
+		}
+	}
+	return use4844, nil
+}
+
 func (b *BatchPoster) maybePostSequencerBatch(ctx context.Context) (bool, error) {
 	if b.batchReverted.Load() {
 		return false, fmt.Errorf("batch was reverted, not posting any more batches")
@@ -1081,33 +1115,11 @@ func (b *BatchPoster) maybePostSequencerBatch(ctx context.Context) (bool, error)
 		}
 		var use4844 bool
 		config := b.config()
-		if config.Post4844Blobs && b.dapWriter == nil && latestHeader.ExcessBlobGas != nil && latestHeader.BlobGasUsed != nil {
-			arbOSVersion, err := b.arbOSVersionGetter.ArbOSVersionForMessageNumber(arbutil.MessageIndex(arbmath.SaturatingUSub(uint64(batchPosition.MessageCount), 1)))
+		if b.dapWriter == nil {
+			use4844, err = b.shouldUse4844(config, latestHeader, batchPosition.MessageCount)
 			if err != nil {
 				return false, err
 			}
-			if arbOSVersion >= 20 {
-				if config.IgnoreBlobPrice {
-					use4844 = true
-				} else {
-					backlog := b.backlog.Load()
-					// Logic to prevent switching from non-4844 batches to 4844 batches too often,
-					// so that blocks can be filled efficiently. The geth txpool rejects txs for
-					// accounts that already have the other type of txs in the pool with
-					// "address already reserved". This logic makes sure that, if there is a backlog,
-					// that enough non-4844 batches have been posted to fill a block before switching.
-					if backlog == 0 ||
-						b.non4844BatchCount == 0 ||
-						b.non4844BatchCount > 16 {
-						blobFeePerByte := eip4844.CalcBlobFee(eip4844.CalcExcessBlobGas(*latestHeader.ExcessBlobGas, *latestHeader.BlobGasUsed))
-						blobFeePerByte.Mul(blobFeePerByte, blobTxBlobGasPerBlob)
-						blobFeePerByte.Div(blobFeePerByte, usableBytesInBlob)
-
-						calldataFeePerByte := arbmath.BigMulByUint(latestHeader.BaseFee, 16)
-						use4844 = arbmath.BigLessThan(blobFeePerByte, calldataFeePerByte)
-					}
-				}
-			}
 		}
 
 		b.building = &buildingBatch{
@@ -1302,11 +1314,39 @@ func (b *BatchPoster) maybePostSequencerBatch(ctx context.Context) (bool, error)
 			batchPosterDAFailureCounter.Inc(1)
 			return false, fmt.Errorf("%w: nonce changed from %d to %d while creating batch", storage.ErrStorageRace, nonce, gotNonce)
 		}
-		sequencerMsg, err = b.dapWriter.Store(ctx, sequencerMsg, uint64(time.Now().Add(config.DASRetentionPeriod).Unix()), config.DisableDapFallbackStoreDataOnChain)
+		storedMsg, err := b.dapWriter.Store(ctx, sequencerMsg, uint64(time.Now().Add(config.DASRetentionPeriod).Unix()), config.DisableDapFallbackStoreDataOnChain)
 		if err != nil {
 			batchPosterDAFailureCounter.Inc(1)
 			return false, err
 		}
+		b.building.use4844 = false
+		// The DA provider falls back to returning the batch itself when it should be posted on chain,
+		// in which case it's posted in blobs if they're cheaper.
+		if bytes.Equal(storedMsg, sequencerMsg) {
+			latestHeader, err := b.l1Reader.LastHeader(ctx)
+			if err != nil {
+				return false, err
+			}
+			b.building.use4844, err = b.shouldUse4844(config, latestHeader, b.building.startMsgCount)
+			if err != nil {
+				return false, err
+			}
+		}
+		sequencerMsg = storedMsg
+		if len(sequencerMsg) > 32 && daprovider.IsICDAMessageHeaderByte(sequencerMsg[0]) {
+			// The sequencer inbox doesn't check the keysets of ICDA batches, and nodes can't read a
+			// batch under a keyset it doesn't know.
+			var keysetHash [32]byte
+			copy(keysetHash[:], sequencerMsg[1:33])
+			valid, err := b.seqInbox.IsValidKeysetHash(&bind.CallOpts{Context: ctx}, keysetHash)
+			if err != nil {
+				return false, err
+			}
+			if !valid {
+				batchPosterDAFailureCounter.Inc(1)
+				return false, fmt.Errorf("ICDA keyset %x isn't valid in the sequencer inbox", keysetHash)
+			}
+		}
 
 		batchPosterDASuccessCounter.Inc(1)
 		batchPosterDALastSuccessfulActionGauge.Update(time.Now().Unix())
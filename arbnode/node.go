@@ -129,6 +129,9 @@ func (c *Config) Validate() error {
 	if err := c.Staker.Validate(); err != nil {
 		return err
 	}
+	if err := c.DataAvailability.Validate(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -271,6 +274,7 @@ type Node struct {
 	SeqCoordinator          *SeqCoordinator
 	MaintenanceRunner       *MaintenanceRunner
 	DASLifecycleManager     *das.LifecycleManager
+	DASSampler              *das.AvailabilitySampler
 	SyncMonitor             *SyncMonitor
 	configFetcher           ConfigFetcher
 	ctx                     context.Context
@@ -502,6 +506,7 @@ func createNodeImpl(
 			SeqCoordinator:          coordinator,
 			MaintenanceRunner:       maintenanceRunner,
 			DASLifecycleManager:     nil,
+			DASSampler:              nil,
 			SyncMonitor:             syncMonitor,
 			configFetcher:           configFetcher,
 			ctx:                     ctx,
@@ -521,11 +526,18 @@ func createNodeImpl(
 	}
 
 	var daWriter das.DataAvailabilityServiceWriter
+	var icdaWriter daprovider.ICDAWriter
 	var daReader das.DataAvailabilityServiceReader
 	var dasLifecycleManager *das.LifecycleManager
 	var dasKeysetFetcher *das.KeysetFetcher
+	var dasSampler *das.AvailabilitySampler
 	if config.DataAvailability.Enable {
-		if config.BatchPoster.Enable {
+		if config.BatchPoster.Enable && config.DataAvailability.ICStorage.Enable {
+			icdaWriter, daReader, dasKeysetFetcher, dasLifecycleManager, err = das.CreateBatchPosterICDA(ctx, &config.DataAvailability, dataSigner, l1client, deployInfo.SequencerInbox)
+			if err != nil {
+				return nil, err
+			}
+		} else if config.BatchPoster.Enable {
 			daWriter, daReader, dasKeysetFetcher, dasLifecycleManager, err = das.CreateBatchPosterDAS(ctx, &config.DataAvailability, dataSigner, l1client, deployInfo.SequencerInbox)
 			if err != nil {
 				return nil, err
@@ -536,6 +548,9 @@ func createNodeImpl(
 				return nil, err
 			}
 		}
+		if restAgg, ok := daReader.(*das.SimpleDASReaderAggregator); ok {
+			dasSampler = restAgg.Sampler()
+		}
 
 		daReader = das.NewReaderTimeoutWrapper(daReader, config.DataAvailability.RequestTimeout)
 
@@ -556,6 +571,7 @@ func createNodeImpl(
 	var dapReaders []daprovider.Reader
 	if daReader != nil {
 		dapReaders = append(dapReaders, daprovider.NewReaderForDAS(daReader, dasKeysetFetcher))
+		dapReaders = append(dapReaders, daprovider.NewReaderForICDA(daReader, dasKeysetFetcher))
 	}
 	if blobReader != nil {
 		dapReaders = append(dapReaders, daprovider.NewReaderForBlobReader(blobReader))
@@ -684,7 +700,9 @@ func createNodeImpl(
 			return nil, errors.New("batchposter, but no TxOpts")
 		}
 		var dapWriter daprovider.Writer
-		if daWriter != nil {
+		if icdaWriter != nil {
+			dapWriter = daprovider.NewWriterForICDA(icdaWriter)
+		} else if daWriter != nil {
 			dapWriter = daprovider.NewWriterForDAS(daWriter)
 		}
 		batchPoster, err = NewBatchPoster(ctx, &BatchPosterOpts{
@@ -737,6 +755,7 @@ func createNodeImpl(
 		SeqCoordinator:          coordinator,
 		MaintenanceRunner:       maintenanceRunner,
 		DASLifecycleManager:     dasLifecycleManager,
+		DASSampler:              dasSampler,
 		SyncMonitor:             syncMonitor,
 		configFetcher:           configFetcher,
 		ctx:                     ctx,
@@ -788,6 +807,15 @@ func CreateNode(
 		})
 	}
 
+	if currentNode.DASSampler != nil {
+		apis = append(apis, rpc.API{
+			Namespace: "arb",
+			Version:   "1.0",
+			Service:   &DataAvailabilitySamplingAPI{sampler: currentNode.DASSampler},
+			Public:    false,
+		})
+	}
+
 	stack.RegisterAPIs(apis)
 
 	return currentNode, nil
@@ -0,0 +1,441 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package daprovider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/arbos/util"
+	"github.com/offchainlabs/nitro/blsSignatures"
+	"github.com/offchainlabs/nitro/das/dastree"
+)
+
+var (
+	ErrBatchToICDAFailed = errors.New("unable to batch to ICDA")
+	ErrInvalidICDAProof  = errors.New("invalid ICDA certified data proof")
+)
+
+type ICDAWriter interface {
+	// Store requests that the message be stored until timeout (UTC time in unix epoch seconds)
+	// and returns a certificate proving that the IC has certified the stored data.
+	Store(ctx context.Context, message []byte, timeout uint64) (*ICDACertificate, error)
+	fmt.Stringer
+}
+
+const (
+	// ICDACertificateVersion1 certificates carry a single proof.
+	ICDACertificateVersion1 uint8 = 1
+	// ICDACertificateVersion2 certificates carry a proof from each of several canisters, so that
+	// they can meet the threshold of a versioned keyset. Each proof is prefixed with its two byte
+	// length and the number of proofs is a single byte.
+	ICDACertificateVersion2 uint8 = 2
+	// ICDACertificateVersionHybrid certificates carry the aggregated BLS signature of a DAS
+	// committee before their proofs, and are checked against a HybridKeyset. The signature is
+	// omitted if its signers mask is zero, and there may be no proofs, as the keyset's policy
+	// may accept a batch that only the committee or only the IC vouched for.
+	ICDACertificateVersionHybrid uint8 = 3
+)
+
+const maxICDACertificateProofs = maxKeysetCanisters
+
+// ICDACertificate is the sequencer message body of an ICDA batch. The batch data is addressed
+// by its dastree root, exactly like a DAS batch, but instead of an aggregated BLS signature it
+// carries the IC certificates and witnesses showing that storage canisters have certified it.
+type ICDACertificate struct {
+	KeysetHash [32]byte
+	DataHash   [32]byte
+	Timeout    uint64
+	Version    uint8
+	Proofs     []ICCertifiedData
+
+	// SignersMask and Sig are only set for hybrid certificates.
+	SignersMask uint64
+	Sig         blsSignatures.Signature
+}
+
+func DeserializeICDACertFrom(rd io.Reader) (*ICDACertificate, error) {
+	r := bufio.NewReader(rd)
+	c := &ICDACertificate{}
+
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if !IsICDAMessageHeaderByte(header) {
+		return nil, errors.New("tried to deserialize a message that doesn't have the ICDA header")
+	}
+
+	if _, err := io.ReadFull(r, c.KeysetHash[:]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, c.DataHash[:]); err != nil {
+		return nil, err
+	}
+
+	var timeoutBuf [8]byte
+	if _, err := io.ReadFull(r, timeoutBuf[:]); err != nil {
+		return nil, err
+	}
+	c.Timeout = binary.BigEndian.Uint64(timeoutBuf[:])
+
+	if c.Version, err = r.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	switch c.Version {
+	case ICDACertificateVersion2:
+		c.Proofs, err = readICDACertProofs(r, 1)
+	case ICDACertificateVersionHybrid:
+		var signersMaskBuf [8]byte
+		if _, err := io.ReadFull(r, signersMaskBuf[:]); err != nil {
+			return nil, err
+		}
+		c.SignersMask = binary.BigEndian.Uint64(signersMaskBuf[:])
+		if c.SignersMask != 0 {
+			var sigBuf [96]byte
+			if _, err := io.ReadFull(r, sigBuf[:]); err != nil {
+				return nil, err
+			}
+			if c.Sig, err = blsSignatures.SignatureFromBytes(sigBuf[:]); err != nil {
+				return nil, err
+			}
+		}
+		c.Proofs, err = readICDACertProofs(r, 0)
+	default:
+		var proofBuf []byte
+		if proofBuf, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+		var proof *ICCertifiedData
+		if proof, err = DeserializeICCertifiedData(proofBuf); err != nil {
+			return nil, err
+		}
+		c.Proofs = []ICCertifiedData{*proof}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readICDACertProofs reads the count-prefixed proofs of version 2 and hybrid certificates, which
+// must end the certificate.
+func readICDACertProofs(r *bufio.Reader, minProofs int) ([]ICCertifiedData, error) {
+	numProofs, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if int(numProofs) < minProofs || int(numProofs) > maxICDACertificateProofs {
+		return nil, fmt.Errorf("ICDA certificate has %d proofs", numProofs)
+	}
+	proofs := make([]ICCertifiedData, 0, numProofs)
+	for i := 0; i < int(numProofs); i++ {
+		proofBuf, err := readICProofField(r, maxICProofSize)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read ICDA certificate proof: %w", err)
+		}
+		proof, err := DeserializeICCertifiedData(proofBuf)
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, *proof)
+	}
+	if _, err := r.ReadByte(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("trailing bytes after ICDA certificate proofs")
+	}
+	return proofs, nil
+}
+
+func (c *ICDACertificate) Serialize() ([]byte, error) {
+	buf := make([]byte, 0, 1+32+32+8+1)
+	buf = append(buf, ICDAMessageHeaderFlag)
+	buf = append(buf, c.KeysetHash[:]...)
+	buf = append(buf, c.DataHash[:]...)
+
+	var intData [8]byte
+	binary.BigEndian.PutUint64(intData[:], c.Timeout)
+	buf = append(buf, intData[:]...)
+
+	buf = append(buf, c.Version)
+
+	switch c.Version {
+	case ICDACertificateVersion2:
+		return appendICDACertProofs(buf, c.Proofs, 1)
+	case ICDACertificateVersionHybrid:
+		buf = binary.BigEndian.AppendUint64(buf, c.SignersMask)
+		if c.SignersMask != 0 {
+			buf = append(buf, blsSignatures.SignatureToBytes(c.Sig)...)
+		}
+		return appendICDACertProofs(buf, c.Proofs, 0)
+	default:
+		if len(c.Proofs) != 1 {
+			return nil, fmt.Errorf("version %d ICDA certificate must carry exactly one proof, got %d", c.Version, len(c.Proofs))
+		}
+		proof, err := c.Proofs[0].Serialize()
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, proof...), nil
+	}
+}
+
+func appendICDACertProofs(buf []byte, proofs []ICCertifiedData, minProofs int) ([]byte, error) {
+	if len(proofs) < minProofs || len(proofs) > maxICDACertificateProofs {
+		return nil, fmt.Errorf("ICDA certificate has %d proofs", len(proofs))
+	}
+	buf = append(buf, byte(len(proofs)))
+	for i := range proofs {
+		proof, err := proofs[i].Serialize()
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(proof)))
+		buf = append(buf, proof...)
+	}
+	return buf, nil
+}
+
+// Verify checks that the certificate's proofs were signed by the IC subnet with the keyset's
+// root key, that each comes from a different canister the keyset allows, that the storage
+// canisters certified the DataHash with an expiry no earlier than the certificate's Timeout,
+// and that there are enough of them to meet the threshold.
+func (c *ICDACertificate) Verify(keyset *ICDAKeyset) error {
+	if uint64(len(c.Proofs)) < keyset.threshold() {
+		return fmt.Errorf("%w: %d proofs don't meet the keyset threshold of %d canisters", ErrInvalidICDAProof, len(c.Proofs), keyset.threshold())
+	}
+	seen := make(map[string]bool)
+	for i := range c.Proofs {
+		proof := &c.Proofs[i]
+		if !keyset.AllowsCanister(proof.Canister) {
+			return fmt.Errorf("%w: canister %x isn't allowed by the keyset", ErrInvalidICDAProof, proof.Canister)
+		}
+		if seen[string(proof.Canister)] {
+			return fmt.Errorf("%w: canister %x proved the batch twice", ErrInvalidICDAProof, proof.Canister)
+		}
+		seen[string(proof.Canister)] = true
+		size, err := VerifyICWitness(proof, keyset.RootKey, ICBatchPath(c.DataHash, ICLabelSize))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidICDAProof, err)
+		}
+		if len(size) != 8 {
+			return fmt.Errorf("%w: certified batch size is %d bytes long", ErrInvalidICDAProof, len(size))
+		}
+		expiry, err := VerifyICWitness(proof, keyset.RootKey, ICBatchPath(c.DataHash, ICLabelExpiry))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidICDAProof, err)
+		}
+		if len(expiry) != 8 {
+			return fmt.Errorf("%w: certified batch expiry is %d bytes long", ErrInvalidICDAProof, len(expiry))
+		}
+		if certified := binary.BigEndian.Uint64(expiry); certified != 0 && certified < c.Timeout {
+			return fmt.Errorf("%w: canister %x keeps the batch until %d, before the certificate's timeout %d", ErrInvalidICDAProof, proof.Canister, certified, c.Timeout)
+		}
+	}
+	return nil
+}
+
+// ICDAKeyset is the keyset registered with the sequencer inbox for ICDA batches. It is
+// serialized as a VersionedKeyset with an IC subnet trust root, which lists the canisters
+// allowed to certify batches and how many of them must have done so.
+//
+// A keyset without canisters is serialized in the legacy format, which shares the
+// DataAvailabilityKeyset wire format with the base64-encoded DER root key in its single key
+// slot and Threshold as its AssumedHonest count. Such a keyset trusts any canister on the
+// subnets the root key delegates to, and a single proof is enough. It is only kept so that
+// batches posted under it can still be read.
+type ICDAKeyset struct {
+	Threshold uint64
+	RootKey   []byte
+	Canisters [][]byte
+}
+
+func (keyset *ICDAKeyset) legacy() bool {
+	return len(keyset.Canisters) == 0
+}
+
+func (keyset *ICDAKeyset) threshold() uint64 {
+	if keyset.legacy() {
+		return 1
+	}
+	return keyset.Threshold
+}
+
+// AllowsCanister returns whether the keyset trusts proofs from the canister with the given raw principal.
+func (keyset *ICDAKeyset) AllowsCanister(canister []byte) bool {
+	if keyset.legacy() {
+		return true
+	}
+	for _, allowed := range keyset.Canisters {
+		if bytes.Equal(allowed, canister) {
+			return true
+		}
+	}
+	return false
+}
+
+func (keyset *ICDAKeyset) Serialize(wr io.Writer) error {
+	if !keyset.legacy() {
+		versioned := &VersionedKeyset{
+			TrustRoot: KeysetTrustRootICSubnet,
+			Threshold: keyset.Threshold,
+			Keys:      [][]byte{keyset.RootKey},
+			Canisters: keyset.Canisters,
+		}
+		return versioned.Serialize(wr)
+	}
+
+	if err := util.Uint64ToWriter(keyset.Threshold, wr); err != nil {
+		return err
+	}
+	if err := util.Uint64ToWriter(1, wr); err != nil {
+		return err
+	}
+
+	keyBuf := []byte(base64.StdEncoding.EncodeToString(keyset.RootKey))
+	if len(keyBuf) > 0xffff {
+		return errors.New("IC root key too large")
+	}
+	buf := []byte{byte(len(keyBuf) / 256), byte(len(keyBuf) % 256)}
+	_, err := wr.Write(append(buf, keyBuf...))
+	return err
+}
+
+func (keyset *ICDAKeyset) Hash() (common.Hash, error) {
+	wr := bytes.NewBuffer([]byte{})
+	if err := keyset.Serialize(wr); err != nil {
+		return common.Hash{}, err
+	}
+	if wr.Len() > dastree.BinSize {
+		return common.Hash{}, errors.New("keyset too large")
+	}
+	return dastree.Hash(wr.Bytes()), nil
+}
+
+func DeserializeICDAKeyset(rd io.Reader) (*ICDAKeyset, error) {
+	versioned, rd, err := peekKeysetHeader(rd)
+	if err != nil {
+		return nil, err
+	}
+	if versioned {
+		keyset, err := DeserializeVersionedKeyset(rd)
+		if err != nil {
+			return nil, err
+		}
+		if keyset.TrustRoot != KeysetTrustRootICSubnet {
+			return nil, fmt.Errorf("unexpected %v keyset, expected an IC subnet keyset", keyset.TrustRoot)
+		}
+		return &ICDAKeyset{
+			Threshold: keyset.Threshold,
+			RootKey:   keyset.Keys[0],
+			Canisters: keyset.Canisters,
+		}, nil
+	}
+
+	assumedHonest, err := util.Uint64FromReader(rd)
+	if err != nil {
+		return nil, err
+	}
+	numKeys, err := util.Uint64FromReader(rd)
+	if err != nil {
+		return nil, err
+	}
+	if numKeys != 1 {
+		return nil, fmt.Errorf("ICDA keyset must contain exactly one IC root key, got %d keys", numKeys)
+	}
+	buf2 := []byte{0, 0}
+	if _, err := io.ReadFull(rd, buf2); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, int(buf2[0])*256+int(buf2[1]))
+	if _, err := io.ReadFull(rd, buf); err != nil {
+		return nil, err
+	}
+	rootKey, err := base64.StdEncoding.DecodeString(string(buf))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode IC root key: %w", err)
+	}
+	return &ICDAKeyset{
+		Threshold: assumedHonest,
+		RootKey:   rootKey,
+	}, nil
+}
+
+func RecoverPayloadFromICDABatch(
+	ctx context.Context,
+	batchNum uint64,
+	sequencerMsg []byte,
+	dasReader DASReader,
+	keysetFetcher DASKeysetFetcher,
+	preimageRecorder PreimageRecorder,
+	validateSeqMsg bool,
+) ([]byte, error) {
+	cert, err := DeserializeICDACertFrom(bytes.NewReader(sequencerMsg[40:]))
+	if err != nil {
+		log.Error("Failed to deserialize ICDA message", "err", err)
+		return nil, nil
+	}
+	if cert.Version != ICDACertificateVersion1 && cert.Version != ICDACertificateVersion2 && cert.Version != ICDACertificateVersionHybrid {
+		log.Error("Your node software is probably out of date", "icdaCertificateVersion", cert.Version)
+		return nil, nil
+	}
+
+	keysetPreimage, err := keysetFetcher.GetKeysetByHash(ctx, cert.KeysetHash)
+	if err != nil {
+		log.Error("Couldn't get keyset", "err", err, "keysetHash", common.Bytes2Hex(cert.KeysetHash[:]))
+		return nil, err
+	}
+	if preimageRecorder != nil {
+		dastree.RecordHash(preimageRecorder, keysetPreimage)
+	}
+
+	var verifyErr error
+	if cert.Version == ICDACertificateVersionHybrid {
+		keyset, err := DeserializeHybridKeyset(bytes.NewReader(keysetPreimage), !validateSeqMsg)
+		if err != nil {
+			return nil, fmt.Errorf("%w. Couldn't deserialize hybrid keyset, err: %w, keyset hash: %x batch num: %d", ErrSeqMsgValidation, err, cert.KeysetHash, batchNum)
+		}
+		verifyErr = cert.VerifyHybrid(keyset)
+	} else {
+		keyset, err := DeserializeICDAKeyset(bytes.NewReader(keysetPreimage))
+		if err != nil {
+			return nil, fmt.Errorf("%w. Couldn't deserialize ICDA keyset, err: %w, keyset hash: %x batch num: %d", ErrSeqMsgValidation, err, cert.KeysetHash, batchNum)
+		}
+		verifyErr = cert.Verify(keyset)
+	}
+	if verifyErr != nil {
+		log.Error("Bad certificate on ICDA batch", "err", verifyErr)
+		return nil, nil
+	}
+
+	maxTimestamp := binary.BigEndian.Uint64(sequencerMsg[8:16])
+	if cert.Timeout < maxTimestamp+MinLifetimeSecondsForDataAvailabilityCert {
+		log.Error("ICDA cert expires too soon", "err", "")
+		return nil, nil
+	}
+
+	payload, err := dasReader.GetByHash(ctx, cert.DataHash)
+	if err != nil {
+		log.Error("Couldn't fetch ICDA batch contents", "err", err)
+		return nil, err
+	}
+	if dastree.Hash(payload) != cert.DataHash {
+		log.Error("preimage mismatch for hash", "hash", cert.DataHash, "err", ErrHashMismatch)
+		return nil, ErrHashMismatch
+	}
+	if preimageRecorder != nil {
+		dastree.RecordHash(preimageRecorder, payload)
+	}
+
+	return payload, nil
+}
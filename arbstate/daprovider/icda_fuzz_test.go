@@ -0,0 +1,165 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package daprovider
+
+import (
+	"bytes"
+	"testing"
+)
+
+// header, keyset hash, data hash, timeout and version precede the proof
+const icdaCertFixedSize = 1 + 32 + 32 + 8 + 1
+
+func testICCertifiedData() ICCertifiedData {
+	return ICCertifiedData{
+		Certificate: bytes.Repeat([]byte{0xd9, 0xd9, 0xf7}, 100),
+		Witness:     bytes.Repeat([]byte{0x83, 0x01}, 50),
+		Canister:    []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x01},
+	}
+}
+
+func TestICCertifiedDataRoundTrip(t *testing.T) {
+	proof := testICCertifiedData()
+	serialized, err := proof.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeICCertifiedData(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Certificate, proof.Certificate) || !bytes.Equal(decoded.Witness, proof.Witness) || !bytes.Equal(decoded.Canister, proof.Canister) {
+		t.Fatal("IC certified data did not round trip", decoded, proof)
+	}
+
+	if _, err := DeserializeICCertifiedData(append(serialized, 0)); err == nil {
+		t.Fatal("expected trailing bytes to be rejected")
+	}
+	if _, err := DeserializeICCertifiedData(serialized[:len(serialized)-1]); err == nil {
+		t.Fatal("expected truncated proof to be rejected")
+	}
+
+	proof.Certificate = make([]byte, maxICCertificateSize+1)
+	if _, err := proof.Serialize(); err == nil {
+		t.Fatal("expected oversized certificate to be rejected")
+	}
+}
+
+func TestICDACertificateRoundTrip(t *testing.T) {
+	otherProof := testICCertifiedData()
+	otherProof.Canister = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x01, 0x01}
+	for _, cert := range []*ICDACertificate{
+		{
+			KeysetHash: [32]byte{1, 2, 3},
+			DataHash:   [32]byte{4, 5, 6},
+			Timeout:    1234567890,
+			Version:    ICDACertificateVersion1,
+			Proofs:     []ICCertifiedData{testICCertifiedData()},
+		},
+		{
+			KeysetHash: [32]byte{1, 2, 3},
+			DataHash:   [32]byte{4, 5, 6},
+			Timeout:    1234567890,
+			Version:    ICDACertificateVersion2,
+			Proofs:     []ICCertifiedData{testICCertifiedData(), otherProof},
+		},
+	} {
+		serialized, err := cert.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsICDAMessageHeaderByte(serialized[0]) {
+			t.Fatal("serialized certificate is missing the ICDA header byte")
+		}
+		decoded, err := DeserializeICDACertFrom(bytes.NewReader(serialized))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded.Proofs) != len(cert.Proofs) {
+			t.Fatal("version", cert.Version, "certificate decoded with", len(decoded.Proofs), "proofs, expected", len(cert.Proofs))
+		}
+		reserialized, err := decoded.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(serialized, reserialized) {
+			t.Fatal("version", cert.Version, "ICDA certificate did not round trip")
+		}
+		if _, err := DeserializeICDACertFrom(bytes.NewReader(serialized[:len(serialized)-1])); err == nil {
+			t.Fatal("expected truncated version", cert.Version, "certificate to be rejected")
+		}
+	}
+
+	multipleProofs := &ICDACertificate{Version: ICDACertificateVersion1, Proofs: []ICCertifiedData{testICCertifiedData(), otherProof}}
+	if _, err := multipleProofs.Serialize(); err == nil {
+		t.Fatal("expected version 1 certificate with several proofs to be rejected")
+	}
+}
+
+func FuzzDeserializeICCertifiedData(f *testing.F) {
+	proof := testICCertifiedData()
+	serialized, err := proof.Serialize()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(serialized)
+	f.Add([]byte{ICProofBinaryV1, 0, 0, 0, 0, 0})
+	f.Add([]byte(`{"Certificate":"","Witness":"","Data":"","Canister":""}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoded, err := DeserializeICCertifiedData(data)
+		if err != nil || data[0] != ICProofBinaryV1 {
+			return
+		}
+		// Binary encoding is canonical, so anything that decodes must re-encode to the same bytes.
+		reserialized, err := decoded.Serialize()
+		if err != nil {
+			t.Fatal("failed to reserialize decoded IC certified data", err)
+		}
+		if !bytes.Equal(data, reserialized) {
+			t.Fatal("IC certified data did not round trip", data, reserialized)
+		}
+	})
+}
+
+func FuzzDeserializeICDACert(f *testing.F) {
+	for _, cert := range []*ICDACertificate{
+		{Version: ICDACertificateVersion1, Proofs: []ICCertifiedData{testICCertifiedData()}},
+		{Version: ICDACertificateVersion2, Proofs: []ICCertifiedData{testICCertifiedData(), testICCertifiedData()}},
+		{Version: ICDACertificateVersionHybrid, Proofs: []ICCertifiedData{testICCertifiedData()}},
+	} {
+		serialized, err := cert.Serialize()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(serialized)
+	}
+	f.Add([]byte{ICDAMessageHeaderFlag})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoded, err := DeserializeICDACertFrom(bytes.NewReader(data))
+		// Only the exact header byte and binary proofs are expected to re-encode identically,
+		// version 2 and hybrid certificates only accept binary proofs. A hybrid certificate's
+		// signature needn't be canonically encoded, so only unsigned ones must round trip.
+		if err != nil || data[0] != ICDAMessageHeaderFlag {
+			return
+		}
+		switch decoded.Version {
+		case ICDACertificateVersion2:
+		case ICDACertificateVersionHybrid:
+			if decoded.SignersMask != 0 {
+				return
+			}
+		default:
+			if data[icdaCertFixedSize] != ICProofBinaryV1 {
+				return
+			}
+		}
+		reserialized, err := decoded.Serialize()
+		if err != nil {
+			t.Fatal("failed to reserialize decoded ICDA certificate", err)
+		}
+		if !bytes.Equal(data, reserialized) {
+			t.Fatal("ICDA certificate did not round trip", data, reserialized)
+		}
+	})
+}
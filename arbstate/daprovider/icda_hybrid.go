@@ -0,0 +1,157 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package daprovider
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/blsSignatures"
+)
+
+var ErrNoCommitteeSignature = errors.New("hybrid ICDA certificate isn't signed by the committee")
+
+// HybridKeyset is the keyset of hybrid ICDA batches, which both a DAS committee and IC storage
+// canisters vouch for. It is serialized as a VersionedKeyset with a hybrid trust root.
+type HybridKeyset struct {
+	Committee *DataAvailabilityKeyset
+	IC        *ICDAKeyset
+	Policy    HybridPolicy
+}
+
+func (keyset *HybridKeyset) versioned() *VersionedKeyset {
+	versioned := &VersionedKeyset{
+		TrustRoot:         KeysetTrustRootHybrid,
+		Threshold:         keyset.Committee.AssumedHonest,
+		Canisters:         keyset.IC.Canisters,
+		RootKey:           keyset.IC.RootKey,
+		CanisterThreshold: keyset.IC.Threshold,
+		Policy:            keyset.Policy,
+	}
+	for _, pk := range keyset.Committee.PubKeys {
+		versioned.Keys = append(versioned.Keys, blsSignatures.PublicKeyToBytes(pk))
+	}
+	return versioned
+}
+
+func (keyset *HybridKeyset) Serialize(wr io.Writer) error {
+	return keyset.versioned().Serialize(wr)
+}
+
+func (keyset *HybridKeyset) Hash() (common.Hash, error) {
+	return keyset.versioned().Hash()
+}
+
+func DeserializeHybridKeyset(rd io.Reader, assumeKeysetValid bool) (*HybridKeyset, error) {
+	versioned, err := DeserializeVersionedKeyset(rd)
+	if err != nil {
+		return nil, err
+	}
+	if versioned.TrustRoot != KeysetTrustRootHybrid {
+		return nil, fmt.Errorf("unexpected %v keyset, expected a hybrid keyset", versioned.TrustRoot)
+	}
+	committee, err := committeeKeysetFromVersioned(versioned, assumeKeysetValid)
+	if err != nil {
+		return nil, err
+	}
+	return &HybridKeyset{
+		Committee: committee,
+		IC: &ICDAKeyset{
+			Threshold: versioned.CanisterThreshold,
+			RootKey:   versioned.RootKey,
+			Canisters: versioned.Canisters,
+		},
+		Policy: versioned.Policy,
+	}, nil
+}
+
+// VersionedCommitteeKeyset returns the keyset of a DAS committee as a versioned keyset with a BLS
+// committee trust root. Batches with the DAS header are only ever signed under legacy keysets,
+// which DeserializeKeyset reads; the versioned format only names the committee of hybrid ICDA
+// batches.
+func VersionedCommitteeKeyset(keyset *DataAvailabilityKeyset) *VersionedKeyset {
+	versioned := &VersionedKeyset{
+		TrustRoot: KeysetTrustRootBLSCommittee,
+		Threshold: keyset.AssumedHonest,
+	}
+	for _, pk := range keyset.PubKeys {
+		versioned.Keys = append(versioned.Keys, blsSignatures.PublicKeyToBytes(pk))
+	}
+	return versioned
+}
+
+// DeserializeCommitteeKeyset reads the keyset of the DAS committee behind a hybrid ICDA writer,
+// either legacy or versioned with a BLS committee trust root.
+func DeserializeCommitteeKeyset(rd io.Reader, assumeKeysetValid bool) (*DataAvailabilityKeyset, error) {
+	isVersioned, rd, err := peekKeysetHeader(rd)
+	if err != nil {
+		return nil, err
+	}
+	if !isVersioned {
+		return DeserializeKeyset(rd, assumeKeysetValid)
+	}
+	versioned, err := DeserializeVersionedKeyset(rd)
+	if err != nil {
+		return nil, err
+	}
+	if versioned.TrustRoot != KeysetTrustRootBLSCommittee {
+		return nil, fmt.Errorf("unexpected %v keyset, expected a BLS committee keyset", versioned.TrustRoot)
+	}
+	return committeeKeysetFromVersioned(versioned, assumeKeysetValid)
+}
+
+func committeeKeysetFromVersioned(versioned *VersionedKeyset, assumeKeysetValid bool) (*DataAvailabilityKeyset, error) {
+	pubkeys := make([]blsSignatures.PublicKey, len(versioned.Keys))
+	for i, key := range versioned.Keys {
+		var err error
+		pubkeys[i], err = blsSignatures.PublicKeyFromBytes(key, assumeKeysetValid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DataAvailabilityKeyset{
+		AssumedHonest: versioned.Threshold,
+		PubKeys:       pubkeys,
+	}, nil
+}
+
+// CommitteeSignableFields returns what the committee signed for a hybrid certificate. The members
+// sign the same fields as for a version 1 DataAvailabilityCertificate, so that the aggregated
+// signature an AnyTrust committee produces can be carried as is.
+func (c *ICDACertificate) CommitteeSignableFields() []byte {
+	dasCert := &DataAvailabilityCertificate{
+		DataHash: c.DataHash,
+		Timeout:  c.Timeout,
+		Version:  1,
+	}
+	return dasCert.SerializeSignableFields()
+}
+
+// VerifyHybrid checks the committee signature and the IC proofs of a hybrid certificate, and
+// accepts it if both, or either, are valid according to the keyset's policy.
+func (c *ICDACertificate) VerifyHybrid(keyset *HybridKeyset) error {
+	if c.Version != ICDACertificateVersionHybrid {
+		return fmt.Errorf("version %d ICDA certificate isn't hybrid", c.Version)
+	}
+	committeeErr := ErrNoCommitteeSignature
+	if c.SignersMask != 0 {
+		committeeErr = keyset.Committee.VerifySignature(c.SignersMask, c.CommitteeSignableFields(), c.Sig)
+	}
+	icErr := c.Verify(keyset.IC)
+
+	switch keyset.Policy {
+	case HybridPolicyRequireBoth:
+		return errors.Join(committeeErr, icErr)
+	case HybridPolicyRequireEither:
+		if committeeErr == nil || icErr == nil {
+			return nil
+		}
+		return errors.Join(committeeErr, icErr)
+	default:
+		return fmt.Errorf("keyset has %v", keyset.Policy)
+	}
+}
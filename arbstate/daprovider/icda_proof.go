@@ -0,0 +1,125 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package daprovider
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ICProofBinaryV1 is the first byte of a binary encoded ICCertifiedData.
+// Proofs produced before the binary encoding existed are JSON objects, and so always start with '{'.
+const ICProofBinaryV1 byte = 0x01
+
+const (
+	maxICCertificateSize = 16 * 1024
+	maxICWitnessSize     = 16 * 1024
+	// Principals are at most 29 bytes long.
+	maxICPrincipalSize = 29
+	// maxICProofSize is the size of the largest binary encoded ICCertifiedData.
+	maxICProofSize = 1 + 1 + maxICPrincipalSize + 2 + maxICCertificateSize + 2 + maxICWitnessSize
+)
+
+var ErrUnknownICProofEncoding = errors.New("unknown IC certified data proof encoding")
+
+// ICCertifiedData is the proof that an IC storage canister has certified a batch:
+// the certificate signed by the subnet, the witness revealing the certified batch
+// in the canister's hash tree, and the principal of the canister that certified it.
+type ICCertifiedData struct {
+	// Certificate is the CBOR-encoded IC certificate.
+	Certificate []byte
+	// Witness is the CBOR-encoded pruned hash tree of the canister.
+	Witness []byte
+	// Canister is the raw (not textual) principal of the storage canister.
+	Canister []byte
+}
+
+// Serialize encodes the proof as:
+//
+//	version (1 byte) || len(canister) (1 byte) || canister ||
+//	len(certificate) (2 bytes) || certificate || len(witness) (2 bytes) || witness
+//
+// with all lengths big-endian.
+func (d *ICCertifiedData) Serialize() ([]byte, error) {
+	if len(d.Canister) > maxICPrincipalSize {
+		return nil, fmt.Errorf("canister principal too long: %d bytes", len(d.Canister))
+	}
+	if len(d.Certificate) > maxICCertificateSize {
+		return nil, fmt.Errorf("IC certificate too large: %d bytes", len(d.Certificate))
+	}
+	if len(d.Witness) > maxICWitnessSize {
+		return nil, fmt.Errorf("IC witness too large: %d bytes", len(d.Witness))
+	}
+
+	buf := make([]byte, 0, 1+1+len(d.Canister)+2+len(d.Certificate)+2+len(d.Witness))
+	buf = append(buf, ICProofBinaryV1)
+	buf = append(buf, byte(len(d.Canister)))
+	buf = append(buf, d.Canister...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(d.Certificate)))
+	buf = append(buf, d.Certificate...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(d.Witness)))
+	buf = append(buf, d.Witness...)
+	return buf, nil
+}
+
+// DeserializeICCertifiedData decodes a proof produced by ICCertifiedData.Serialize.
+// JSON encoded proofs were never posted with the ICDA header, and are decoded by
+// das.DeserializeICCertifiedDataJSON outside of the state transition.
+func DeserializeICCertifiedData(data []byte) (*ICCertifiedData, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty IC certified data proof")
+	}
+	switch data[0] {
+	case ICProofBinaryV1:
+		return deserializeICCertifiedDataV1(bytes.NewReader(data[1:]))
+	default:
+		return nil, fmt.Errorf("%w: 0x%02x", ErrUnknownICProofEncoding, data[0])
+	}
+}
+
+func deserializeICCertifiedDataV1(r *bytes.Reader) (*ICCertifiedData, error) {
+	d := &ICCertifiedData{}
+
+	canisterLen, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if canisterLen > maxICPrincipalSize {
+		return nil, fmt.Errorf("canister principal too long: %d bytes", canisterLen)
+	}
+	d.Canister = make([]byte, canisterLen)
+	if _, err := io.ReadFull(r, d.Canister); err != nil {
+		return nil, err
+	}
+
+	if d.Certificate, err = readICProofField(r, maxICCertificateSize); err != nil {
+		return nil, fmt.Errorf("couldn't read IC certificate: %w", err)
+	}
+	if d.Witness, err = readICProofField(r, maxICWitnessSize); err != nil {
+		return nil, fmt.Errorf("couldn't read IC witness: %w", err)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after IC certified data proof", r.Len())
+	}
+	return d, nil
+}
+
+func readICProofField(r io.Reader, maxSize int) ([]byte, error) {
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(lenBuf[:]))
+	if size > maxSize {
+		return nil, fmt.Errorf("field too large: %d bytes", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package daprovider
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aviate-labs/agent-go/certification"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// The storage canister certifies the batches it stores in a hash tree laid out as:
+//
+//	batches/<dastree root>/size           -> batch size in bytes, 8 bytes big-endian
+//	batches/<dastree root>/chunk_count    -> number of chunks, 4 bytes big-endian
+//	batches/<dastree root>/expiry         -> unix time after which the batch may be pruned,
+//	                                         0 if it's kept forever, 8 bytes big-endian
+//	batches/<dastree root>/chunks/<index> -> sha256 of the chunk, index is 4 bytes big-endian
+//
+// The root hash of this tree is the canister's certified data, which the subnet signs in its
+// certificate. A witness is the tree pruned down to the paths needed to answer a request.
+var (
+	ICLabelBatches    = hashtree.Label("batches")
+	ICLabelSize       = hashtree.Label("size")
+	ICLabelChunkCount = hashtree.Label("chunk_count")
+	ICLabelExpiry     = hashtree.Label("expiry")
+	ICLabelChunks     = hashtree.Label("chunks")
+	// ICLabelTime is the label of the time the subnet signed a certificate at, in nanoseconds
+	// since the epoch, LEB128 encoded. It's at the root of the certificate tree.
+	ICLabelTime = hashtree.Label("time")
+)
+
+var ErrICWitnessMismatch = errors.New("IC witness doesn't match the certified data")
+
+// ICBatchPath returns the path of a label under the batch with the given dastree root.
+func ICBatchPath(root [32]byte, label hashtree.Label) []hashtree.Label {
+	return []hashtree.Label{ICLabelBatches, root[:], label}
+}
+
+// ICChunkPath returns the path of the hash of the chunk at index in the batch with the given dastree root.
+func ICChunkPath(root [32]byte, index uint32) []hashtree.Label {
+	return []hashtree.Label{ICLabelBatches, root[:], ICLabelChunks, binary.BigEndian.AppendUint32(nil, index)}
+}
+
+// VerifyICWitness checks that the proof's certificate is signed under rootKey, and that it
+// certifies the root of the proof's witness as the canister's data. It returns the value
+// at path in the witness.
+func VerifyICWitness(proof *ICCertifiedData, rootKey []byte, path []hashtree.Label) ([]byte, error) {
+	certificate, witness, err := decodeICProof(proof)
+	if err != nil {
+		return nil, err
+	}
+	digest := witness.Reconstruct()
+	canister := principal.Principal{Raw: proof.Canister}
+	if err := certification.VerifyCertifiedData(*certificate, canister, rootKey, digest[:]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrICWitnessMismatch, err)
+	}
+	return hashtree.Lookup(witness, path...)
+}
+
+// ICCertificateTime returns the time the subnet signed the proof's certificate at. The signature
+// isn't checked, so the time can only be trusted for proofs that VerifyICWitness accepts.
+func ICCertificateTime(proof *ICCertifiedData) (certified time.Time, err error) {
+	certificate, _, err := decodeICProof(proof)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			certified, err = time.Time{}, fmt.Errorf("malformed IC certificate tree: %v", r)
+		}
+	}()
+	rawTime, err := certificate.Tree.Lookup(ICLabelTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("IC certificate has no time: %w", err)
+	}
+	nanos, n := binary.Uvarint(rawTime)
+	if n <= 0 || n != len(rawTime) || nanos > math.MaxInt64 {
+		return time.Time{}, errors.New("malformed IC certificate time")
+	}
+	return time.Unix(0, int64(nanos)), nil
+}
+
+// decodeICProof decodes the CBOR encoded certificate and witness of a proof. The hashtree package
+// assumes well formed input and panics otherwise, which must not happen on data read from the inbox.
+func decodeICProof(proof *ICCertifiedData) (certificate *certification.Certificate, witness hashtree.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			certificate, witness, err = nil, nil, fmt.Errorf("malformed IC hash tree: %v", r)
+		}
+	}()
+	certificate = &certification.Certificate{}
+	if err := cbor.Unmarshal(proof.Certificate, certificate); err != nil {
+		return nil, nil, fmt.Errorf("couldn't decode IC certificate: %w", err)
+	}
+	if certificate.Tree.Root == nil {
+		return nil, nil, errors.New("IC certificate has no tree")
+	}
+	witness, err = hashtree.Deserialize(proof.Witness)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't decode IC witness: %w", err)
+	}
+	return certificate, witness, nil
+}
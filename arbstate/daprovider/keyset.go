@@ -0,0 +1,308 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package daprovider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbos/util"
+	"github.com/offchainlabs/nitro/das/dastree"
+)
+
+// VersionedKeysetHeaderByte begins a versioned keyset. Legacy keysets begin with their big-endian
+// AssumedHonest count, whose first byte is zero for any committee that fits in a keyset.
+const VersionedKeysetHeaderByte byte = 0xff
+
+// KeysetVersion1 is the only versioned keyset format so far.
+const KeysetVersion1 byte = 1
+
+const (
+	maxKeysetKeys      = 64
+	maxKeysetCanisters = 64
+)
+
+// KeysetTrustRoot is what a versioned keyset trusts to vouch for the availability of a batch.
+type KeysetTrustRoot uint8
+
+const (
+	// KeysetTrustRootBLSCommittee trusts a committee of DAS members. Its keys are BLS public keys,
+	// and its threshold is the number of members assumed to be honest.
+	KeysetTrustRootBLSCommittee KeysetTrustRoot = 0
+	// KeysetTrustRootICSubnet trusts an IC subnet. Its single key is the DER-encoded IC root key,
+	// and its threshold is the number of allowed canisters that must have certified a batch.
+	KeysetTrustRootICSubnet KeysetTrustRoot = 1
+	// KeysetTrustRootHybrid trusts both a BLS committee and an IC subnet. Its keys and threshold
+	// are the committee's, its canisters are certified under RootKey, CanisterThreshold of them
+	// must have certified a batch, and Policy says whether one or both must vouch for a batch.
+	KeysetTrustRootHybrid KeysetTrustRoot = 2
+)
+
+// HybridPolicy is which of the trust roots of a hybrid keyset must vouch for a batch.
+type HybridPolicy uint8
+
+const (
+	// HybridPolicyRequireBoth accepts a batch only if both the committee and the IC vouch for it.
+	HybridPolicyRequireBoth HybridPolicy = 0
+	// HybridPolicyRequireEither accepts a batch if either the committee or the IC vouches for it.
+	HybridPolicyRequireEither HybridPolicy = 1
+)
+
+func (p HybridPolicy) String() string {
+	switch p {
+	case HybridPolicyRequireBoth:
+		return "both"
+	case HybridPolicyRequireEither:
+		return "either"
+	default:
+		return fmt.Sprintf("unknown policy %d", uint8(p))
+	}
+}
+
+func (r KeysetTrustRoot) String() string {
+	switch r {
+	case KeysetTrustRootBLSCommittee:
+		return "BLS committee"
+	case KeysetTrustRootICSubnet:
+		return "IC subnet"
+	case KeysetTrustRootHybrid:
+		return "BLS committee and IC subnet"
+	default:
+		return fmt.Sprintf("unknown trust root %d", uint8(r))
+	}
+}
+
+// VersionedKeyset is the wire format of a keyset that declares its trust root. Unlike a legacy
+// keyset, which can only hold BLS public keys, it also lists the canisters an ICDA certificate
+// may come from, so that the keyset registered with setValidKeyset fully describes who is trusted.
+type VersionedKeyset struct {
+	TrustRoot KeysetTrustRoot
+	Threshold uint64
+	Keys      [][]byte
+	// Canisters are the raw principals of the canisters allowed to certify batches. They are
+	// only set for an IC subnet or hybrid trust root.
+	Canisters [][]byte
+
+	// The remaining fields are only set, and only serialized, for a hybrid trust root.
+	RootKey           []byte
+	CanisterThreshold uint64
+	Policy            HybridPolicy
+}
+
+// IsVersionedKeyset returns whether the serialized keyset is in the versioned format.
+func IsVersionedKeyset(keysetBytes []byte) bool {
+	return len(keysetBytes) > 0 && keysetBytes[0] == VersionedKeysetHeaderByte
+}
+
+func (k *VersionedKeyset) Validate() error {
+	switch k.TrustRoot {
+	case KeysetTrustRootBLSCommittee:
+		if err := validateCommittee(k.Keys, k.Threshold); err != nil {
+			return err
+		}
+		if len(k.Canisters) != 0 {
+			return errors.New("BLS committee keyset can't list canisters")
+		}
+	case KeysetTrustRootICSubnet:
+		if len(k.Keys) != 1 {
+			return fmt.Errorf("IC subnet keyset must have exactly one root key, got %d keys", len(k.Keys))
+		}
+		if err := validateCanisters(k.Canisters, k.Threshold); err != nil {
+			return err
+		}
+	case KeysetTrustRootHybrid:
+		if err := validateCommittee(k.Keys, k.Threshold); err != nil {
+			return err
+		}
+		if len(k.RootKey) == 0 || len(k.RootKey) > 0xffff {
+			return fmt.Errorf("hybrid keyset IC root key is %d bytes long", len(k.RootKey))
+		}
+		if err := validateCanisters(k.Canisters, k.CanisterThreshold); err != nil {
+			return err
+		}
+		if k.Policy != HybridPolicyRequireBoth && k.Policy != HybridPolicyRequireEither {
+			return fmt.Errorf("hybrid keyset has %v", k.Policy)
+		}
+	default:
+		return fmt.Errorf("keyset has %v", k.TrustRoot)
+	}
+	if k.TrustRoot != KeysetTrustRootHybrid && (len(k.RootKey) != 0 || k.CanisterThreshold != 0 || k.Policy != 0) {
+		return fmt.Errorf("%v keyset can't have hybrid fields", k.TrustRoot)
+	}
+	for _, key := range k.Keys {
+		if len(key) > 0xffff {
+			return errors.New("keyset key too large")
+		}
+	}
+	return nil
+}
+
+func validateCommittee(keys [][]byte, assumedHonest uint64) error {
+	if len(keys) == 0 || len(keys) > maxKeysetKeys {
+		return fmt.Errorf("BLS committee keyset must have between 1 and %d keys, got %d", maxKeysetKeys, len(keys))
+	}
+	if assumedHonest == 0 || assumedHonest > uint64(len(keys)) {
+		return fmt.Errorf("BLS committee keyset must assume between 1 and %d members honest, got %d", len(keys), assumedHonest)
+	}
+	return nil
+}
+
+func validateCanisters(canisters [][]byte, threshold uint64) error {
+	if len(canisters) == 0 || len(canisters) > maxKeysetCanisters {
+		return fmt.Errorf("IC subnet keyset must allow between 1 and %d canisters, got %d", maxKeysetCanisters, len(canisters))
+	}
+	seen := make(map[string]bool)
+	for _, canister := range canisters {
+		if len(canister) > maxICPrincipalSize {
+			return fmt.Errorf("canister principal is %d bytes long", len(canister))
+		}
+		if seen[string(canister)] {
+			return fmt.Errorf("canister %x is listed twice", canister)
+		}
+		seen[string(canister)] = true
+	}
+	if threshold == 0 || threshold > uint64(len(canisters)) {
+		return fmt.Errorf("IC subnet keyset threshold must be between 1 and %d canisters, got %d", len(canisters), threshold)
+	}
+	return nil
+}
+
+func (k *VersionedKeyset) Serialize(wr io.Writer) error {
+	if err := k.Validate(); err != nil {
+		return err
+	}
+	if _, err := wr.Write([]byte{VersionedKeysetHeaderByte, KeysetVersion1, byte(k.TrustRoot)}); err != nil {
+		return err
+	}
+	if err := util.Uint64ToWriter(k.Threshold, wr); err != nil {
+		return err
+	}
+	if err := writeKeysetEntries(wr, k.Keys); err != nil {
+		return err
+	}
+	if err := writeKeysetEntries(wr, k.Canisters); err != nil {
+		return err
+	}
+	if k.TrustRoot != KeysetTrustRootHybrid {
+		return nil
+	}
+	buf := []byte{byte(len(k.RootKey) / 256), byte(len(k.RootKey) % 256)}
+	if _, err := wr.Write(append(buf, k.RootKey...)); err != nil {
+		return err
+	}
+	if err := util.Uint64ToWriter(k.CanisterThreshold, wr); err != nil {
+		return err
+	}
+	_, err := wr.Write([]byte{byte(k.Policy)})
+	return err
+}
+
+func (k *VersionedKeyset) Hash() (common.Hash, error) {
+	wr := bytes.NewBuffer([]byte{})
+	if err := k.Serialize(wr); err != nil {
+		return common.Hash{}, err
+	}
+	if wr.Len() > dastree.BinSize {
+		return common.Hash{}, errors.New("keyset too large")
+	}
+	return dastree.Hash(wr.Bytes()), nil
+}
+
+// writeKeysetEntries writes a count followed by each entry prefixed with its two byte length,
+// the same way a legacy keyset writes its public keys.
+func writeKeysetEntries(wr io.Writer, entries [][]byte) error {
+	if err := util.Uint64ToWriter(uint64(len(entries)), wr); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		buf := []byte{byte(len(entry) / 256), byte(len(entry) % 256)}
+		if _, err := wr.Write(append(buf, entry...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readKeysetEntries(rd io.Reader, maxEntries uint64) ([][]byte, error) {
+	numEntries, err := util.Uint64FromReader(rd)
+	if err != nil {
+		return nil, err
+	}
+	if numEntries > maxEntries {
+		return nil, fmt.Errorf("too many entries in serialized keyset: %d", numEntries)
+	}
+	entries := make([][]byte, numEntries)
+	buf2 := []byte{0, 0}
+	for i := range entries {
+		if _, err := io.ReadFull(rd, buf2); err != nil {
+			return nil, err
+		}
+		entries[i] = make([]byte, int(buf2[0])*256+int(buf2[1]))
+		if _, err := io.ReadFull(rd, entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// DeserializeVersionedKeyset reads a versioned keyset, including its header byte, and checks
+// that it is well formed.
+func DeserializeVersionedKeyset(rd io.Reader) (*VersionedKeyset, error) {
+	header := []byte{0, 0, 0}
+	if _, err := io.ReadFull(rd, header); err != nil {
+		return nil, err
+	}
+	if header[0] != VersionedKeysetHeaderByte {
+		return nil, errors.New("keyset isn't versioned")
+	}
+	if header[1] != KeysetVersion1 {
+		return nil, fmt.Errorf("unsupported keyset version %d, your node software is probably out of date", header[1])
+	}
+	k := &VersionedKeyset{TrustRoot: KeysetTrustRoot(header[2])}
+	var err error
+	if k.Threshold, err = util.Uint64FromReader(rd); err != nil {
+		return nil, err
+	}
+	if k.Keys, err = readKeysetEntries(rd, maxKeysetKeys); err != nil {
+		return nil, err
+	}
+	if k.Canisters, err = readKeysetEntries(rd, maxKeysetCanisters); err != nil {
+		return nil, err
+	}
+	if k.TrustRoot == KeysetTrustRootHybrid {
+		buf := []byte{0, 0}
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		k.RootKey = make([]byte, int(buf[0])*256+int(buf[1]))
+		if _, err := io.ReadFull(rd, k.RootKey); err != nil {
+			return nil, err
+		}
+		if k.CanisterThreshold, err = util.Uint64FromReader(rd); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(rd, buf[:1]); err != nil {
+			return nil, err
+		}
+		k.Policy = HybridPolicy(buf[0])
+	}
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// peekKeysetHeader reads the first byte of a serialized keyset and returns whether the keyset is
+// versioned, along with a reader that still yields the whole keyset.
+func peekKeysetHeader(rd io.Reader) (bool, io.Reader, error) {
+	first := []byte{0}
+	if _, err := io.ReadFull(rd, first); err != nil {
+		return false, nil, err
+	}
+	return first[0] == VersionedKeysetHeaderByte, io.MultiReader(bytes.NewReader(first), rd), nil
+}
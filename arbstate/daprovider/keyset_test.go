@@ -0,0 +1,246 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package daprovider
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/offchainlabs/nitro/blsSignatures"
+)
+
+var testCanisters = [][]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x01},
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x01, 0x01},
+}
+
+func TestICDAKeysetVersions(t *testing.T) {
+	rootKey := []byte("a DER encoded IC root key")
+	versioned := &ICDAKeyset{Threshold: 2, RootKey: rootKey, Canisters: testCanisters}
+	legacy := &ICDAKeyset{Threshold: 1, RootKey: rootKey}
+
+	for _, keyset := range []*ICDAKeyset{versioned, legacy} {
+		wr := bytes.NewBuffer([]byte{})
+		if err := keyset.Serialize(wr); err != nil {
+			t.Fatal(err)
+		}
+		if IsVersionedKeyset(wr.Bytes()) != (len(keyset.Canisters) != 0) {
+			t.Fatal("keyset with", len(keyset.Canisters), "canisters has the wrong format")
+		}
+		decoded, err := DeserializeICDAKeyset(bytes.NewReader(wr.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := keyset.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodedHash, err := decoded.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != decodedHash || !bytes.Equal(decoded.RootKey, rootKey) {
+			t.Fatal("ICDA keyset did not round trip", keyset, decoded)
+		}
+	}
+
+	if !versioned.AllowsCanister(testCanisters[1]) || versioned.AllowsCanister([]byte{0x01}) {
+		t.Fatal("versioned keyset doesn't allow exactly its canisters")
+	}
+	if !legacy.AllowsCanister([]byte{0x01}) {
+		t.Fatal("legacy keyset should allow any canister")
+	}
+
+	for _, invalid := range []*ICDAKeyset{
+		{Threshold: 0, RootKey: rootKey, Canisters: testCanisters},
+		{Threshold: 3, RootKey: rootKey, Canisters: testCanisters},
+		{Threshold: 1, RootKey: rootKey, Canisters: [][]byte{testCanisters[0], testCanisters[0]}},
+		{Threshold: 1, RootKey: rootKey, Canisters: [][]byte{make([]byte, maxICPrincipalSize+1)}},
+	} {
+		if err := invalid.Serialize(bytes.NewBuffer([]byte{})); err == nil {
+			t.Fatal("expected invalid ICDA keyset to be rejected", invalid)
+		}
+	}
+}
+
+func TestVersionedDataAvailabilityKeyset(t *testing.T) {
+	var pubKeys []blsSignatures.PublicKey
+	for i := 0; i < 3; i++ {
+		pubKey, _, err := blsSignatures.GenerateKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	keyset := &DataAvailabilityKeyset{AssumedHonest: 2, PubKeys: pubKeys}
+	legacy := bytes.NewBuffer([]byte{})
+	if err := keyset.Serialize(legacy); err != nil {
+		t.Fatal(err)
+	}
+	versioned := bytes.NewBuffer([]byte{})
+	if err := VersionedCommitteeKeyset(keyset).Serialize(versioned); err != nil {
+		t.Fatal(err)
+	}
+	if IsVersionedKeyset(legacy.Bytes()) || !IsVersionedKeyset(versioned.Bytes()) {
+		t.Fatal("DAS keyset has the wrong format")
+	}
+	hash, err := keyset.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, serialized := range [][]byte{legacy.Bytes(), versioned.Bytes()} {
+		decoded, err := DeserializeCommitteeKeyset(bytes.NewReader(serialized), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodedHash, err := decoded.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != decodedHash || decoded.AssumedHonest != 2 || len(decoded.PubKeys) != 3 {
+			t.Fatal("committee keyset did not round trip, versioned:", IsVersionedKeyset(serialized))
+		}
+	}
+	// Batches with the DAS header are only read under legacy keysets.
+	if _, err := DeserializeKeyset(bytes.NewReader(versioned.Bytes()), false); err == nil {
+		t.Fatal("versioned BLS committee keyset was read as a DAS keyset")
+	}
+	if _, err := DeserializeICDAKeyset(bytes.NewReader(versioned.Bytes())); err == nil {
+		t.Fatal("versioned BLS committee keyset was read as an ICDA keyset")
+	}
+
+	icdaKeyset := &ICDAKeyset{Threshold: 1, RootKey: []byte("root key"), Canisters: testCanisters}
+	wr := bytes.NewBuffer([]byte{})
+	if err := icdaKeyset.Serialize(wr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeserializeKeyset(bytes.NewReader(wr.Bytes()), false); err == nil {
+		t.Fatal("IC subnet keyset was read as a DAS keyset")
+	}
+}
+
+func TestHybridICDACertificate(t *testing.T) {
+	var pubKeys []blsSignatures.PublicKey
+	var privKeys []blsSignatures.PrivateKey
+	for i := 0; i < 2; i++ {
+		pubKey, privKey, err := blsSignatures.GenerateKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKeys = append(pubKeys, pubKey)
+		privKeys = append(privKeys, privKey)
+	}
+	keyset := &HybridKeyset{
+		Committee: &DataAvailabilityKeyset{AssumedHonest: 1, PubKeys: pubKeys},
+		IC:        &ICDAKeyset{Threshold: 1, RootKey: []byte("root key"), Canisters: testCanisters},
+		Policy:    HybridPolicyRequireEither,
+	}
+	wr := bytes.NewBuffer([]byte{})
+	if err := keyset.Serialize(wr); err != nil {
+		t.Fatal(err)
+	}
+	decodedKeyset, err := DeserializeHybridKeyset(bytes.NewReader(wr.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := keyset.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedHash, err := decodedKeyset.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != decodedHash || decodedKeyset.Policy != HybridPolicyRequireEither || decodedKeyset.IC.Threshold != 1 {
+		t.Fatal("hybrid keyset did not round trip")
+	}
+	if _, err := DeserializeICDAKeyset(bytes.NewReader(wr.Bytes())); err == nil {
+		t.Fatal("hybrid keyset was read as an IC subnet keyset")
+	}
+	if _, err := DeserializeKeyset(bytes.NewReader(wr.Bytes()), false); err == nil {
+		t.Fatal("hybrid keyset was read as a BLS committee keyset")
+	}
+
+	// Both members sign, but there are no IC proofs, so only the either policy accepts it.
+	cert := &ICDACertificate{
+		KeysetHash:  hash,
+		DataHash:    [32]byte{4, 5, 6},
+		Timeout:     1234567890,
+		Version:     ICDACertificateVersionHybrid,
+		SignersMask: 3,
+	}
+	var sigs []blsSignatures.Signature
+	for _, privKey := range privKeys {
+		sig, err := blsSignatures.SignMessage(privKey, cert.CommitteeSignableFields())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigs = append(sigs, sig)
+	}
+	cert.Sig = blsSignatures.AggregateSignatures(sigs)
+
+	serialized, err := cert.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeICDACertFrom(bytes.NewReader(serialized))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.SignersMask != 3 || len(decoded.Proofs) != 0 {
+		t.Fatal("hybrid certificate did not round trip")
+	}
+	if err := decoded.VerifyHybrid(decodedKeyset); err != nil {
+		t.Fatal(err)
+	}
+	decodedKeyset.Policy = HybridPolicyRequireBoth
+	if err := decoded.VerifyHybrid(decodedKeyset); err == nil {
+		t.Fatal("certificate without IC proofs verified under the both policy")
+	}
+	decodedKeyset.Policy = HybridPolicyRequireEither
+	decoded.Timeout++
+	if err := decoded.VerifyHybrid(decodedKeyset); err == nil {
+		t.Fatal("committee signature verified for different signable fields")
+	}
+}
+
+func FuzzDeserializeVersionedKeyset(f *testing.F) {
+	wr := bytes.NewBuffer([]byte{})
+	keyset := &VersionedKeyset{TrustRoot: KeysetTrustRootICSubnet, Threshold: 1, Keys: [][]byte{[]byte("root key")}, Canisters: testCanisters}
+	if err := keyset.Serialize(wr); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(wr.Bytes())
+	hybrid := &VersionedKeyset{
+		TrustRoot:         KeysetTrustRootHybrid,
+		Threshold:         1,
+		Keys:              [][]byte{[]byte("committee member key")},
+		Canisters:         testCanisters,
+		RootKey:           []byte("root key"),
+		CanisterThreshold: 2,
+		Policy:            HybridPolicyRequireEither,
+	}
+	wr.Reset()
+	if err := hybrid.Serialize(wr); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(wr.Bytes())
+	f.Add([]byte{VersionedKeysetHeaderByte, KeysetVersion1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rd := bytes.NewReader(data)
+		decoded, err := DeserializeVersionedKeyset(rd)
+		if err != nil {
+			return
+		}
+		// Anything that decodes is valid and re-encodes to the bytes it was read from.
+		reserialized := bytes.NewBuffer([]byte{})
+		if err := decoded.Serialize(reserialized); err != nil {
+			t.Fatal("failed to reserialize decoded keyset", err)
+		}
+		if !bytes.Equal(data[:len(data)-rd.Len()], reserialized.Bytes()) {
+			t.Fatal("versioned keyset did not round trip", data, reserialized.Bytes())
+		}
+	})
+}
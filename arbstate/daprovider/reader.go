@@ -57,6 +57,36 @@ func (d *readerForDAS) RecoverPayloadFromBatch(
 	return RecoverPayloadFromDasBatch(ctx, batchNum, sequencerMsg, d.dasReader, d.keysetFetcher, preimageRecorder, validateSeqMsg)
 }
 
+// NewReaderForICDA is generally meant to be only used by nitro.
+// The dasReader is any store of batch data addressed by dastree root, since ICDA batches are
+// verified against the IC certificate carried in the sequencer message rather than by the reader.
+func NewReaderForICDA(dasReader DASReader, keysetFetcher DASKeysetFetcher) *readerForICDA {
+	return &readerForICDA{
+		dasReader:     dasReader,
+		keysetFetcher: keysetFetcher,
+	}
+}
+
+type readerForICDA struct {
+	dasReader     DASReader
+	keysetFetcher DASKeysetFetcher
+}
+
+func (d *readerForICDA) IsValidHeaderByte(headerByte byte) bool {
+	return IsICDAMessageHeaderByte(headerByte)
+}
+
+func (d *readerForICDA) RecoverPayloadFromBatch(
+	ctx context.Context,
+	batchNum uint64,
+	batchBlockHash common.Hash,
+	sequencerMsg []byte,
+	preimageRecorder PreimageRecorder,
+	validateSeqMsg bool,
+) ([]byte, error) {
+	return RecoverPayloadFromICDABatch(ctx, batchNum, sequencerMsg, d.dasReader, d.keysetFetcher, preimageRecorder, validateSeqMsg)
+}
+
 // NewReaderForBlobReader is generally meant to be only used by nitro.
 // DA Providers should implement methods in the Reader interface independently
 func NewReaderForBlobReader(blobReader BlobReader) *readerForBlobReader {
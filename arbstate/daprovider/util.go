@@ -7,16 +7,12 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"time"
 
-	"github.com/CommoDor64/icdaserver/icutils"
-
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/crypto/kzg4844"
@@ -89,11 +85,16 @@ const ZeroheavyMessageHeaderFlag byte = 0x20
 // BlobHashesHeaderFlag indicates that this message contains EIP 4844 versioned hashes of the committments calculated over the blob data for the batch data.
 const BlobHashesHeaderFlag byte = L1AuthenticatedMessageHeaderFlag | 0x10 // 0x50
 
+// ICDAMessageHeaderFlag indicates that this data is a certificate for the Internet Computer data availability
+// provider (ICDA), which will retrieve the full batch data. Unlike DASMessageHeaderFlag, availability is attested
+// by data certified by an IC storage canister rather than by a BLS-signing committee.
+const ICDAMessageHeaderFlag byte = 0x01
+
 // BrotliMessageHeaderByte indicates that the message is brotli-compressed.
 const BrotliMessageHeaderByte byte = 0
 
 // KnownHeaderBits is all header bits with known meaning to this nitro version
-const KnownHeaderBits byte = DASMessageHeaderFlag | TreeDASMessageHeaderFlag | L1AuthenticatedMessageHeaderFlag | ZeroheavyMessageHeaderFlag | BlobHashesHeaderFlag | BrotliMessageHeaderByte
+const KnownHeaderBits byte = DASMessageHeaderFlag | TreeDASMessageHeaderFlag | L1AuthenticatedMessageHeaderFlag | ZeroheavyMessageHeaderFlag | BlobHashesHeaderFlag | ICDAMessageHeaderFlag | BrotliMessageHeaderByte
 
 // hasBits returns true if `checking` has all `bits`
 func hasBits(checking byte, bits byte) bool {
@@ -120,6 +121,10 @@ func IsBlobHashesHeaderByte(header byte) bool {
 	return hasBits(header, BlobHashesHeaderFlag)
 }
 
+func IsICDAMessageHeaderByte(header byte) bool {
+	return hasBits(header, ICDAMessageHeaderFlag)
+}
+
 func IsBrotliMessageHeaderByte(b uint8) bool {
 	return b == BrotliMessageHeaderByte
 }
@@ -160,7 +165,7 @@ func RecoverPayloadFromDasBatch(
 	}
 	version := cert.Version
 
-	if version >= 2 {
+	if version > ErasureCodedDASCertificateVersion {
 		log.Error("Your node software is probably out of date", "certificateVersion", version)
 		return nil, nil
 	}
@@ -183,7 +188,7 @@ func RecoverPayloadFromDasBatch(
 		switch {
 		case version == 0 && crypto.Keccak256Hash(preimage) != hash:
 			fallthrough
-		case version == 1 && dastree.Hash(preimage) != hash:
+		case version >= 1 && dastree.Hash(preimage) != hash:
 			log.Error(
 				"preimage mismatch for hash",
 				"hash", hash, "err", ErrHashMismatch, "version", version,
@@ -206,14 +211,10 @@ func RecoverPayloadFromDasBatch(
 	if err != nil {
 		return nil, fmt.Errorf("%w. Couldn't deserialize keyset, err: %w, keyset hash: %x batch num: %d", ErrSeqMsgValidation, err, cert.KeysetHash, batchNum)
 	}
-
-	rootKey := keyset.PubKeys[0]
-	decodedLen := base64.StdEncoding.DecodedLen(len(rootKey))
-	k := make([]byte, decodedLen)
-
-	_, err = base64.StdEncoding.Decode(k, rootKey)
+	err = keyset.VerifySignature(cert.SignersMask, cert.SerializeSignableFields(), cert.Sig)
 	if err != nil {
-		return nil, err
+		log.Error("Bad signature on DAS batch", "err", err)
+		return nil, nil
 	}
 
 	maxTimestamp := binary.BigEndian.Uint64(sequencerMsg[8:16])
@@ -238,25 +239,31 @@ func RecoverPayloadFromDasBatch(
 		}
 	}
 
-	var cb icutils.CertifiedBlock
-	if err := json.Unmarshal(cert.Sig, &cb); err != nil {
-		return nil, err
-	}
-
-	if _, err := icutils.VerifyDataFromIC(cb.Certificate, k, icutils.ToPrincipal(cb.Canister), cb.Witness, dataHash[:]); err != nil {
-		return nil, err
-	}
-
 	return payload, nil
 }
 
+// ErasureCodedDASCertificateVersion is the version of certificates of batches erasure coded
+// across the committee. Besides the batch, the members sign the commitment to its shards and how
+// it was coded, so that all the signers hold shards under the same commitment.
+//
+// Earlier nodes treat version 2 certificates as out of date and their batches as empty, and the
+// sequencer message parser has no ArbOS version to gate reading them on. A chain must move its
+// nodes and its WASM module root to a release that reads them before its batch poster sets
+// rpc-aggregator.data-shards, and no version 2 certificate may be posted before then, or the
+// nodes that read it as empty and those that sync it later disagree on the batch.
+const ErasureCodedDASCertificateVersion uint8 = 2
+
 type DataAvailabilityCertificate struct {
 	KeysetHash  [32]byte
 	DataHash    [32]byte
 	Timeout     uint64
 	SignersMask uint64
-	Sig         []byte
+	Sig         blsSignatures.Signature
 	Version     uint8
+	// Commitment, DataShards and TotalShards are only set for version 2 certificates.
+	Commitment  [32]byte
+	DataShards  uint8
+	TotalShards uint8
 }
 
 func DeserializeDASCertFrom(rd io.Reader) (c *DataAvailabilityCertificate, err error) {
@@ -297,6 +304,22 @@ func DeserializeDASCertFrom(rd io.Reader) (c *DataAvailabilityCertificate, err e
 		c.Version = versionBuf[0]
 	}
 
+	if c.Version == ErasureCodedDASCertificateVersion {
+		_, err = io.ReadFull(r, c.Commitment[:])
+		if err != nil {
+			return nil, err
+		}
+		var shardsBuf [2]byte
+		_, err = io.ReadFull(r, shardsBuf[:])
+		if err != nil {
+			return nil, err
+		}
+		c.DataShards, c.TotalShards = shardsBuf[0], shardsBuf[1]
+		if c.DataShards == 0 || c.DataShards > c.TotalShards {
+			return nil, fmt.Errorf("certificate of a code of %d data shards out of %d", c.DataShards, c.TotalShards)
+		}
+	}
+
 	var signersMaskBuf [8]byte
 	_, err = io.ReadFull(r, signersMaskBuf[:])
 	if err != nil {
@@ -304,12 +327,15 @@ func DeserializeDASCertFrom(rd io.Reader) (c *DataAvailabilityCertificate, err e
 	}
 	c.SignersMask = binary.BigEndian.Uint64(signersMaskBuf[:])
 
-	blsSignaturesBuf, err := io.ReadAll(r)
+	var blsSignaturesBuf [96]byte
+	_, err = io.ReadFull(r, blsSignaturesBuf[:])
+	if err != nil {
+		return nil, err
+	}
+	c.Sig, err = blsSignatures.SignatureFromBytes(blsSignaturesBuf[:])
 	if err != nil {
 		return nil, err
 	}
-
-	c.Sig = blsSignaturesBuf
 
 	return c, nil
 }
@@ -325,6 +351,10 @@ func (c *DataAvailabilityCertificate) SerializeSignableFields() []byte {
 	if c.Version != 0 {
 		buf = append(buf, c.Version)
 	}
+	if c.Version == ErasureCodedDASCertificateVersion {
+		buf = append(buf, c.Commitment[:]...)
+		buf = append(buf, c.DataShards, c.TotalShards)
+	}
 
 	return buf
 }
@@ -346,7 +376,7 @@ func (c *DataAvailabilityCertificate) RecoverKeyset(
 
 type DataAvailabilityKeyset struct {
 	AssumedHonest uint64
-	PubKeys       [][]byte
+	PubKeys       []blsSignatures.PublicKey
 }
 
 func (keyset *DataAvailabilityKeyset) Serialize(wr io.Writer) error {
@@ -359,7 +389,7 @@ func (keyset *DataAvailabilityKeyset) Serialize(wr io.Writer) error {
 	}
 
 	for _, pk := range keyset.PubKeys {
-		pkBuf := pk
+		pkBuf := blsSignatures.PublicKeyToBytes(pk)
 		buf := []byte{byte(len(pkBuf) / 256), byte(len(pkBuf) % 256)}
 		_, err := wr.Write(append(buf, pkBuf...))
 		if err != nil {
@@ -393,7 +423,7 @@ func DeserializeKeyset(rd io.Reader, assumeKeysetValid bool) (*DataAvailabilityK
 	if numKeys > 64 {
 		return nil, errors.New("too many keys in serialized DataAvailabilityKeyset")
 	}
-	pubkeys := make([][]byte, numKeys)
+	pubkeys := make([]blsSignatures.PublicKey, numKeys)
 	buf2 := []byte{0, 0}
 	for i := uint64(0); i < numKeys; i++ {
 		if _, err := io.ReadFull(rd, buf2); err != nil {
@@ -403,13 +433,10 @@ func DeserializeKeyset(rd io.Reader, assumeKeysetValid bool) (*DataAvailabilityK
 		if _, err := io.ReadFull(rd, buf); err != nil {
 			return nil, err
 		}
-
-		pubkeys[i] = buf
-		// maybe check for valid keys
-		// pubkeys[i], err = blsSignatures.PublicKeyFromBytes(buf, assumeKeysetValid)
-		// if err != nil {
-		// 	return nil, err
-		// }
+		pubkeys[i], err = blsSignatures.PublicKeyFromBytes(buf, assumeKeysetValid)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return &DataAvailabilityKeyset{
 		AssumedHonest: assumedHonest,
@@ -418,7 +445,7 @@ func DeserializeKeyset(rd io.Reader, assumeKeysetValid bool) (*DataAvailabilityK
 }
 
 func (keyset *DataAvailabilityKeyset) VerifySignature(signersMask uint64, data []byte, sig blsSignatures.Signature) error {
-	pubkeys := [][]byte{}
+	pubkeys := []blsSignatures.PublicKey{}
 	numNonSigners := uint64(0)
 	for i := 0; i < len(keyset.PubKeys); i++ {
 		if (1<<i)&signersMask != 0 {
@@ -430,7 +457,15 @@ func (keyset *DataAvailabilityKeyset) VerifySignature(signersMask uint64, data [
 	if numNonSigners >= keyset.AssumedHonest {
 		return errors.New("not enough signers")
 	}
+	aggregatedPubKey := blsSignatures.AggregatePublicKeys(pubkeys)
+	success, err := blsSignatures.VerifySignature(sig, data, aggregatedPubKey)
 
+	if err != nil {
+		return err
+	}
+	if !success {
+		return errors.New("bad signature")
+	}
 	return nil
 }
 
@@ -480,7 +515,6 @@ func StringToExpirationPolicy(s string) (ExpirationPolicy, error) {
 }
 
 func Serialize(c *DataAvailabilityCertificate) []byte {
-
 	flags := DASMessageHeaderFlag
 	if c.Version != 0 {
 		flags |= TreeDASMessageHeaderFlag
@@ -495,6 +529,5 @@ func Serialize(c *DataAvailabilityCertificate) []byte {
 	binary.BigEndian.PutUint64(intData[:], c.SignersMask)
 	buf = append(buf, intData[:]...)
 
-	s := append(buf, c.Sig...)
-	return s
+	return append(buf, blsSignatures.SignatureToBytes(c.Sig)...)
 }
@@ -0,0 +1,104 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package daprovider
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/blsSignatures"
+	"github.com/offchainlabs/nitro/das/dastree"
+)
+
+type testDASPreimages map[common.Hash][]byte
+
+func (p testDASPreimages) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	return p[hash], nil
+}
+
+func (p testDASPreimages) GetKeysetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	return p[hash], nil
+}
+
+func (p testDASPreimages) ExpirationPolicy(ctx context.Context) (ExpirationPolicy, error) {
+	return KeepForever, nil
+}
+
+// The upgrade to erasure-coded certificates: version 2 certificates are read along with earlier
+// ones, their members' signature covers the shard commitment and code, and later versions are
+// still treated as out of date.
+func TestRecoverErasureCodedDASCertificate(t *testing.T) {
+	ctx := context.Background()
+	pubKey, privKey, err := blsSignatures.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyset := &DataAvailabilityKeyset{AssumedHonest: 1, PubKeys: []blsSignatures.PublicKey{pubKey}}
+	keysetBuf := bytes.NewBuffer([]byte{})
+	if err := keyset.Serialize(keysetBuf); err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("a batch erasure coded across the committee")
+	preimages := testDASPreimages{
+		dastree.Hash(keysetBuf.Bytes()): keysetBuf.Bytes(),
+		dastree.Hash(payload):           payload,
+	}
+
+	recoverBatch := func(cert *DataAvailabilityCertificate, sign bool) []byte {
+		t.Helper()
+		if sign {
+			cert.Sig, err = blsSignatures.SignMessage(privKey, cert.SerializeSignableFields())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		sequencerMsg := append(make([]byte, 40), Serialize(cert)...)
+		recovered, err := RecoverPayloadFromDasBatch(ctx, 0, sequencerMsg, preimages, preimages, nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return recovered
+	}
+	newCert := func(version uint8) *DataAvailabilityCertificate {
+		cert := &DataAvailabilityCertificate{
+			KeysetHash:  dastree.Hash(keysetBuf.Bytes()),
+			DataHash:    dastree.Hash(payload),
+			Timeout:     MinLifetimeSecondsForDataAvailabilityCert,
+			SignersMask: 1,
+			Version:     version,
+		}
+		if version == ErasureCodedDASCertificateVersion {
+			cert.Commitment = common.HexToHash("0xc0")
+			cert.DataShards, cert.TotalShards = 2, 3
+		}
+		return cert
+	}
+
+	if !bytes.Equal(recoverBatch(newCert(1), true), payload) {
+		t.Fatal("version 1 certificate wasn't recovered")
+	}
+	erasureCoded := newCert(ErasureCodedDASCertificateVersion)
+	if !bytes.Equal(recoverBatch(erasureCoded, true), payload) {
+		t.Fatal("version 2 certificate wasn't recovered")
+	}
+	// The members signed the commitment and code, so neither can be swapped afterwards.
+	erasureCoded.Commitment = common.HexToHash("0xc1")
+	if recoverBatch(erasureCoded, false) != nil {
+		t.Fatal("version 2 certificate with another commitment than the members signed was recovered")
+	}
+	erasureCoded = newCert(ErasureCodedDASCertificateVersion)
+	if recoverBatch(erasureCoded, true) == nil {
+		t.Fatal("version 2 certificate wasn't recovered")
+	}
+	erasureCoded.DataShards = 1
+	if recoverBatch(erasureCoded, false) != nil {
+		t.Fatal("version 2 certificate with another code than the members signed was recovered")
+	}
+	if recoverBatch(newCert(ErasureCodedDASCertificateVersion+1), true) != nil {
+		t.Fatal("certificate of an unknown version was recovered")
+	}
+}
@@ -45,3 +45,27 @@ func (d *writerForDAS) Store(ctx context.Context, message []byte, timeout uint64
 		return Serialize(cert), nil
 	}
 }
+
+// NewWriterForICDA is generally meant to be only used by nitro.
+// DA Providers should implement methods in the DAProviderWriter interface independently
+func NewWriterForICDA(icdaWriter ICDAWriter) *writerForICDA {
+	return &writerForICDA{icdaWriter: icdaWriter}
+}
+
+type writerForICDA struct {
+	icdaWriter ICDAWriter
+}
+
+func (d *writerForICDA) Store(ctx context.Context, message []byte, timeout uint64, disableFallbackStoreDataOnChain bool) ([]byte, error) {
+	cert, err := d.icdaWriter.Store(ctx, message, timeout)
+	if errors.Is(err, ErrBatchToICDAFailed) {
+		if disableFallbackStoreDataOnChain {
+			return nil, errors.New("unable to batch to ICDA and fallback storing data on chain is disabled")
+		}
+		log.Warn("Falling back to storing data on chain", "err", err)
+		return message, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return cert.Serialize()
+}
@@ -78,13 +78,14 @@ func parseSequencerMessage(ctx context.Context, batchNum uint64, batchBlockHash
 	if len(payload) > 0 {
 		foundDA := false
 		var err error
+		header := payload[0]
 		for _, dapReader := range dapReaders {
-			if dapReader != nil && dapReader.IsValidHeaderByte(payload[0]) {
+			if dapReader != nil && dapReader.IsValidHeaderByte(header) {
 				payload, err = dapReader.RecoverPayloadFromBatch(ctx, batchNum, batchBlockHash, data, nil, keysetValidationMode != daprovider.KeysetDontValidate)
 				if err != nil {
 					// Matches the way keyset validation was done inside DAS readers i.e logging the error
 					//  But other daproviders might just want to return the error
-					if errors.Is(err, daprovider.ErrSeqMsgValidation) && daprovider.IsDASMessageHeaderByte(payload[0]) {
+					if errors.Is(err, daprovider.ErrSeqMsgValidation) && (daprovider.IsDASMessageHeaderByte(header) || daprovider.IsICDAMessageHeaderByte(header)) {
 						logLevel := log.Error
 						if keysetValidationMode == daprovider.KeysetPanicIfInvalid {
 							logLevel = log.Crit
@@ -105,6 +106,8 @@ func parseSequencerMessage(ctx context.Context, batchNum uint64, batchBlockHash
 		if !foundDA {
 			if daprovider.IsDASMessageHeaderByte(payload[0]) {
 				log.Error("No DAS Reader configured, but sequencer message found with DAS header")
+			} else if daprovider.IsICDAMessageHeaderByte(payload[0]) {
+				log.Error("No ICDA Reader configured, but sequencer message found with ICDA header")
 			} else if daprovider.IsBlobHashesHeaderByte(payload[0]) {
 				return nil, daprovider.ErrNoBlobReader
 			}
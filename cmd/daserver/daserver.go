@@ -114,11 +114,20 @@ func parseDAServer(args []string) (*DAServerConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = das.FixKeysetCLIParsing("data-availability.announcements.subscribe.feeds", k); err != nil {
+		return nil, err
+	}
 
 	var serverConfig DAServerConfig
 	if err := confighelpers.EndCommonParse(k, &serverConfig); err != nil {
 		return nil, err
 	}
+	if err := serverConfig.DataAvailability.Validate(); err != nil {
+		return nil, err
+	}
+	if serverConfig.DataAvailability.Announcements.Enable && !serverConfig.EnableREST {
+		return nil, errors.New("data-availability.announcements.enable requires enable-rest, as the REST server serves the announcement feed")
+	}
 	if serverConfig.Conf.Dump {
 		err = confighelpers.DumpConfig(k, map[string]interface{}{
 			"data-availability.key.priv-key": "",
@@ -267,6 +276,9 @@ func startup() error {
 		if err != nil {
 			return err
 		}
+		if signAfterStoreWriter, ok := daWriter.(*das.SignAfterStoreDASWriter); ok && signAfterStoreWriter.Announcer() != nil {
+			restServer.ServeAnnouncements(signAfterStoreWriter.Announcer())
+		}
 	}
 
 	<-sigint
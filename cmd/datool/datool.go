@@ -35,7 +35,7 @@ import (
 func main() {
 	args := os.Args
 	if len(args) < 2 {
-		panic("Usage: datool [client|keygen|generatehash|dumpkeyset] ...")
+		panic("Usage: datool [client|keygen|generatehash|dumpkeyset|ic] ...")
 	}
 
 	var err error
@@ -48,8 +48,10 @@ func main() {
 		err = generateHash(args[2])
 	case "dumpkeyset":
 		err = dumpKeyset(args[2:])
+	case "ic":
+		err = startIC(args[2:])
 	default:
-		panic(fmt.Sprintf("Unknown tool '%s' specified, valid tools are 'client', 'keygen', 'generatehash'", args[1]))
+		panic(fmt.Sprintf("Unknown tool '%s' specified, valid tools are 'client', 'keygen', 'generatehash', 'dumpkeyset', 'ic'", args[1]))
 	}
 	if err != nil {
 		panic(err)
@@ -187,14 +189,16 @@ func startClientStore(args []string) error {
 // datool client rest getbyhash
 
 type RESTClientGetByHashConfig struct {
-	URL      string `koanf:"url"`
-	DataHash string `koanf:"data-hash"`
+	URL         string                    `koanf:"url"`
+	DataHash    string                    `koanf:"data-hash"`
+	ICCertified das.ICCertifiedReadConfig `koanf:"ic-certified"`
 }
 
 func parseRESTClientGetByHashConfig(args []string) (*RESTClientGetByHashConfig, error) {
 	f := flag.NewFlagSet("datool client retrieve", flag.ContinueOnError)
 	f.String("url", "http://localhost:9877", "URL of DAS server to connect to.")
 	f.String("data-hash", "", "hash of the message to retrieve, if starts with '0x' it's treated as hex encoded, otherwise base64 encoded")
+	das.ICCertifiedReadConfigAddOptions("ic-certified", f)
 
 	k, err := confighelpers.BeginCommonParse(f, args)
 	if err != nil {
@@ -214,27 +218,30 @@ func startRESTClientGetByHash(args []string) error {
 		return err
 	}
 
-	client, err := das.NewRestfulDasClientFromURL(config.URL)
-	if err != nil {
-		return err
-	}
-
-	var decodedHash []byte
-	if strings.HasPrefix(config.DataHash, "0x") {
-		decodedHash, err = hexutil.Decode(config.DataHash)
+	var client *das.RestfulDasClient
+	if config.ICCertified.Enable {
+		keyset, err := config.ICCertified.Keyset()
+		if err != nil {
+			return err
+		}
+		client, err = das.NewCertifiedRestfulDasClientFromURL(config.URL, keyset)
 		if err != nil {
 			return err
 		}
 	} else {
-		hashDecoder := base64.NewDecoder(base64.StdEncoding, bytes.NewReader([]byte(config.DataHash)))
-		decodedHash, err = io.ReadAll(hashDecoder)
+		client, err = das.NewRestfulDasClientFromURL(config.URL)
 		if err != nil {
 			return err
 		}
 	}
 
+	decodedHash, err := decodeDataHash(config.DataHash)
+	if err != nil {
+		return err
+	}
+
 	ctx := context.Background()
-	message, err := client.GetByHash(ctx, common.BytesToHash(decodedHash))
+	message, err := client.GetByHash(ctx, decodedHash)
 	if err != nil {
 		return err
 	}
@@ -242,6 +249,25 @@ func startRESTClientGetByHash(args []string) error {
 	return nil
 }
 
+// decodeDataHash decodes a hash given as hex if it starts with '0x', and as base64 otherwise.
+func decodeDataHash(dataHash string) (common.Hash, error) {
+	var decodedHash []byte
+	var err error
+	if strings.HasPrefix(dataHash, "0x") {
+		decodedHash, err = hexutil.Decode(dataHash)
+		if err != nil {
+			return common.Hash{}, err
+		}
+	} else {
+		hashDecoder := base64.NewDecoder(base64.StdEncoding, bytes.NewReader([]byte(dataHash)))
+		decodedHash, err = io.ReadAll(hashDecoder)
+		if err != nil {
+			return common.Hash{}, err
+		}
+	}
+	return common.BytesToHash(decodedHash), nil
+}
+
 // das keygen
 
 type KeyGenConfig struct {
@@ -363,11 +389,19 @@ func dumpKeyset(args []string) error {
 		return err
 	}
 
-	keysetHash, keysetBytes, err := das.KeysetHashFromServices(services, uint64(config.Keyset.AssumedHonest))
+	assumedHonest, err := config.Keyset.KeysetAssumedHonest()
+	if err != nil {
+		return err
+	}
+	keysetHash, keysetBytes, err := das.KeysetHashFromServices(services, uint64(assumedHonest), config.Keyset.KeysetVersion)
 	if err != nil {
 		return err
 	}
 
+	if daprovider.IsVersionedKeyset(keysetBytes) {
+		fmt.Printf("Keyset Version: %d\n", daprovider.KeysetVersion1)
+		fmt.Printf("Trust Root: %v\n", daprovider.KeysetTrustRootBLSCommittee)
+	}
 	fmt.Printf("Keyset: %s\n", hexutil.Encode(keysetBytes))
 	fmt.Printf("KeysetHash: %s\n", hexutil.Encode(keysetHash[:]))
 
@@ -0,0 +1,625 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/principal"
+	flag "github.com/spf13/pflag"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/cmd/util/confighelpers"
+	"github.com/offchainlabs/nitro/das"
+	"github.com/offchainlabs/nitro/solgen/go/bridgegen"
+)
+
+// datool ic ...
+
+func startIC(args []string) error {
+	if len(args) == 0 {
+		return errors.New("datool ic requires an argument, valid arguments are 'store', 'get', 'verify-cert', 'status', 'keyset' and 'backfill'")
+	}
+	switch strings.ToLower(args[0]) {
+	case "store":
+		return startICStore(args[1:])
+	case "get":
+		return startICGet(args[1:])
+	case "verify-cert":
+		return startICVerifyCert(args[1:])
+	case "status":
+		return startICStatus(args[1:])
+	case "keyset":
+		return startICKeyset(args[1:])
+	case "backfill":
+		return startICBackfill(args[1:])
+	}
+	return fmt.Errorf("datool ic '%s' not supported, valid arguments are 'store', 'get', 'verify-cert', 'status', 'keyset' and 'backfill'", args[0])
+}
+
+func newICStorageService(config das.ICStorageConfig) (*das.ICStorageService, error) {
+	config.Enable = true
+	// datool only makes a few calls, there is nothing to monitor
+	config.Monitor.Interval = 0
+	return das.NewICStorageService(config, nil)
+}
+
+// datool ic store
+
+type ICStoreConfig struct {
+	ICStorage          das.ICStorageConfig `koanf:"ic-storage"`
+	Message            string              `koanf:"message"`
+	RandomMessageSize  int                 `koanf:"random-message-size"`
+	DASRetentionPeriod time.Duration       `koanf:"das-retention-period"`
+}
+
+func parseICStoreConfig(args []string) (*ICStoreConfig, error) {
+	f := flag.NewFlagSet("datool ic store", flag.ContinueOnError)
+	das.ICStorageConfigAddOptions("ic-storage", f)
+	f.String("message", "", "message to store")
+	f.Int("random-message-size", 0, "store a message of a specified number of random bytes")
+	f.Duration("das-retention-period", 24*time.Hour, "the period for which the message is stored, if ic-storage.enable-expiry is set")
+
+	k, err := confighelpers.BeginCommonParse(f, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ICStoreConfig
+	if err := confighelpers.EndCommonParse(k, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func startICStore(args []string) error {
+	config, err := parseICStoreConfig(args)
+	if err != nil {
+		return err
+	}
+
+	var message []byte
+	if config.RandomMessageSize > 0 {
+		message = make([]byte, config.RandomMessageSize)
+		if _, err := rand.Read(message); err != nil {
+			return err
+		}
+	} else if len(config.Message) > 0 {
+		message = []byte(config.Message)
+	} else {
+		return errors.New("--message or --random-message-size must be specified")
+	}
+
+	storageService, err := newICStorageService(config.ICStorage)
+	if err != nil {
+		return err
+	}
+	writer, err := das.NewCertifyAfterStoreICDAWriter(storageService)
+	if err != nil {
+		return err
+	}
+	cert, err := writer.Store(context.Background(), message, uint64(time.Now().Add(config.DASRetentionPeriod).Unix()))
+	if err != nil {
+		return err
+	}
+
+	serializedCert, err := cert.Serialize()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Hex Encoded Cert: %s\n", hexutil.Encode(serializedCert))
+	fmt.Printf("Hex Encoded Data Hash: %s\n", hexutil.Encode(cert.DataHash[:]))
+	fmt.Printf("Hex Encoded Keyset Hash: %s\n", hexutil.Encode(cert.KeysetHash[:]))
+	return nil
+}
+
+// datool ic get
+
+type ICGetConfig struct {
+	ICStorage   das.ICStorageConfig `koanf:"ic-storage"`
+	DataHash    string              `koanf:"data-hash"`
+	DumpWitness bool                `koanf:"dump-witness"`
+}
+
+func parseICGetConfig(args []string) (*ICGetConfig, error) {
+	f := flag.NewFlagSet("datool ic get", flag.ContinueOnError)
+	das.ICStorageConfigAddOptions("ic-storage", f)
+	f.String("data-hash", "", "hash of the message to retrieve, if starts with '0x' it's treated as hex encoded, otherwise base64 encoded")
+	f.Bool("dump-witness", false, "print the certificate and the witness the canister certified the message with")
+
+	k, err := confighelpers.BeginCommonParse(f, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ICGetConfig
+	if err := confighelpers.EndCommonParse(k, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func startICGet(args []string) error {
+	config, err := parseICGetConfig(args)
+	if err != nil {
+		return err
+	}
+	dataHash, err := decodeDataHash(config.DataHash)
+	if err != nil {
+		return err
+	}
+
+	storageService, err := newICStorageService(config.ICStorage)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	// The proof is verified against the root key before it's returned.
+	proof, err := storageService.GetCertifiedByHash(ctx, dataHash)
+	if err != nil {
+		return err
+	}
+	if err := printCertifiedBatch(proof, storageService.RootKey, dataHash, config.DumpWitness); err != nil {
+		return err
+	}
+	message, err := storageService.GetByHash(ctx, dataHash)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Message: %s\n", message)
+	return nil
+}
+
+// printICDAKeyset prints which canisters the keyset trusts, and how many of them must certify a batch.
+func printICDAKeyset(keyset *daprovider.ICDAKeyset) {
+	if len(keyset.Canisters) == 0 {
+		fmt.Println("Keyset Canisters: any (legacy keyset)")
+		return
+	}
+	for _, canister := range keyset.Canisters {
+		fmt.Printf("Keyset Canister: %s\n", principal.Principal{Raw: canister})
+	}
+	fmt.Printf("Keyset Threshold: %d\n", keyset.Threshold)
+}
+
+// printCertifiedBatch prints the metadata that the proof certifies for the batch with the given
+// root, and optionally the certificate and witness themselves.
+func printCertifiedBatch(proof *daprovider.ICCertifiedData, rootKey []byte, root common.Hash, dumpWitness bool) error {
+	size, err := daprovider.VerifyICWitness(proof, rootKey, daprovider.ICBatchPath(root, daprovider.ICLabelSize))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Canister: %s\n", principal.Principal{Raw: proof.Canister})
+	if len(size) == 8 {
+		fmt.Printf("Certified Size: %d\n", binary.BigEndian.Uint64(size))
+	}
+	// The chunk count and the expiry are only revealed by witnesses from the canister's get_batch.
+	if chunkCount, err := daprovider.VerifyICWitness(proof, rootKey, daprovider.ICBatchPath(root, daprovider.ICLabelChunkCount)); err == nil && len(chunkCount) == 4 {
+		fmt.Printf("Certified Chunk Count: %d\n", binary.BigEndian.Uint32(chunkCount))
+	}
+	if expiry, err := daprovider.VerifyICWitness(proof, rootKey, daprovider.ICBatchPath(root, daprovider.ICLabelExpiry)); err == nil && len(expiry) == 8 {
+		if expiryTime := binary.BigEndian.Uint64(expiry); expiryTime == 0 {
+			fmt.Println("Certified Expiry: never")
+		} else {
+			fmt.Printf("Certified Expiry: %s\n", time.Unix(int64(expiryTime), 0).UTC())
+		}
+	}
+	if dumpWitness {
+		witness, err := hashtree.Deserialize(proof.Witness)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Hex Encoded Certificate: %s\n", hexutil.Encode(proof.Certificate))
+		fmt.Printf("Hex Encoded Witness: %s\n", hexutil.Encode(proof.Witness))
+		fmt.Printf("Witness: %s\n", witness)
+	}
+	return nil
+}
+
+// datool ic verify-cert
+
+type ICVerifyCertConfig struct {
+	Message            string `koanf:"message"`
+	TxHash             string `koanf:"tx-hash"`
+	ParentChainNodeURL string `koanf:"parent-chain-node-url"`
+	Keyset             string `koanf:"keyset"`
+	RootKey            string `koanf:"root-key"`
+	DumpWitness        bool   `koanf:"dump-witness"`
+}
+
+func parseICVerifyCertConfig(args []string) (*ICVerifyCertConfig, error) {
+	f := flag.NewFlagSet("datool ic verify-cert", flag.ContinueOnError)
+	f.String("message", "", "hex encoded sequencer message holding the ICDA certificate, as posted to the sequencer inbox")
+	f.String("tx-hash", "", "hash of the parent chain transaction that posted the sequencer message, instead of --message")
+	f.String("parent-chain-node-url", "", "URL of the parent chain node to fetch the transaction and the keyset from")
+	f.String("keyset", "", "hex encoded ICDA keyset to verify the certificate against; if neither this nor --root-key is set, the keyset is fetched from the sequencer inbox the transaction was sent to")
+	f.String("root-key", "", "DER encoded IC root key to verify the certificate against, can be a file or the hex-encoded key beginning with 0x")
+	f.Bool("dump-witness", false, "print the certificate and the witness of the certificate")
+
+	k, err := confighelpers.BeginCommonParse(f, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ICVerifyCertConfig
+	if err := confighelpers.EndCommonParse(k, &config); err != nil {
+		return nil, err
+	}
+	if (config.Message == "") == (config.TxHash == "") {
+		return nil, errors.New("exactly one of --message and --tx-hash must be specified")
+	}
+	if config.TxHash != "" && config.ParentChainNodeURL == "" {
+		return nil, errors.New("--parent-chain-node-url must be specified with --tx-hash")
+	}
+	if config.Keyset != "" && config.RootKey != "" {
+		return nil, errors.New("--keyset and --root-key can't both be specified")
+	}
+	return &config, nil
+}
+
+func startICVerifyCert(args []string) error {
+	config, err := parseICVerifyCertConfig(args)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	var message []byte
+	var keysetFetcher daprovider.DASKeysetFetcher
+	if config.Message != "" {
+		message, err = hexutil.Decode(config.Message)
+		if err != nil {
+			return err
+		}
+	} else {
+		client, err := ethclient.DialContext(ctx, config.ParentChainNodeURL)
+		if err != nil {
+			return err
+		}
+		var sequencerInbox common.Address
+		message, sequencerInbox, err = sequencerMessageFromTx(ctx, client, common.HexToHash(config.TxHash))
+		if err != nil {
+			return err
+		}
+		keysetFetcher, err = das.NewKeysetFetcher(client, sequencerInbox)
+		if err != nil {
+			return err
+		}
+	}
+
+	cert, err := daprovider.DeserializeICDACertFrom(bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Hex Encoded Data Hash: %s\n", hexutil.Encode(cert.DataHash[:]))
+	fmt.Printf("Hex Encoded Keyset Hash: %s\n", hexutil.Encode(cert.KeysetHash[:]))
+	fmt.Printf("Timeout: %s\n", time.Unix(int64(cert.Timeout), 0).UTC())
+	fmt.Printf("Version: %d\n", cert.Version)
+
+	hybrid := cert.Version == daprovider.ICDACertificateVersionHybrid
+	if hybrid {
+		fmt.Printf("Signers Mask: %#x\n", cert.SignersMask)
+	}
+
+	var keyset *daprovider.ICDAKeyset
+	var verifyErr error
+	switch {
+	case config.RootKey != "":
+		if hybrid {
+			return errors.New("the committee signature of a hybrid certificate can't be checked with only --root-key, use --keyset")
+		}
+		rootKey, err := das.ParseICRootKey(config.RootKey)
+		if err != nil {
+			return err
+		}
+		// Without a keyset there's no allow-list, so proofs from any canister are accepted.
+		keyset = &daprovider.ICDAKeyset{RootKey: rootKey}
+		verifyErr = cert.Verify(keyset)
+	case config.Keyset != "" || keysetFetcher != nil:
+		var keysetBytes []byte
+		if config.Keyset != "" {
+			keysetBytes, err = hexutil.Decode(config.Keyset)
+		} else {
+			keysetBytes, err = keysetFetcher.GetKeysetByHash(ctx, cert.KeysetHash)
+		}
+		if err != nil {
+			return err
+		}
+		var keysetHash common.Hash
+		if hybrid {
+			hybridKeyset, err := daprovider.DeserializeHybridKeyset(bytes.NewReader(keysetBytes), false)
+			if err != nil {
+				return err
+			}
+			if keysetHash, err = hybridKeyset.Hash(); err != nil {
+				return err
+			}
+			fmt.Printf("Keyset Committee Size: %d\n", len(hybridKeyset.Committee.PubKeys))
+			fmt.Printf("Keyset Assumed Honest: %d\n", hybridKeyset.Committee.AssumedHonest)
+			fmt.Printf("Keyset Hybrid Policy: %v\n", hybridKeyset.Policy)
+			keyset = hybridKeyset.IC
+			verifyErr = cert.VerifyHybrid(hybridKeyset)
+		} else {
+			if keyset, err = daprovider.DeserializeICDAKeyset(bytes.NewReader(keysetBytes)); err != nil {
+				return err
+			}
+			if keysetHash, err = keyset.Hash(); err != nil {
+				return err
+			}
+			verifyErr = cert.Verify(keyset)
+		}
+		if keysetHash != cert.KeysetHash {
+			return fmt.Errorf("certificate is for keyset %v, not %v", common.Hash(cert.KeysetHash), keysetHash)
+		}
+		printICDAKeyset(keyset)
+	default:
+		return errors.New("--keyset or --root-key must be specified with --message")
+	}
+
+	if verifyErr != nil {
+		return verifyErr
+	}
+	for i := range cert.Proofs {
+		if err := printCertifiedBatch(&cert.Proofs[i], keyset.RootKey, cert.DataHash, config.DumpWitness); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Certificate is valid")
+	return nil
+}
+
+// sequencerMessageFromTx returns the sequencer message posted by a transaction to the sequencer
+// inbox, along with the address of the inbox.
+func sequencerMessageFromTx(ctx context.Context, client *ethclient.Client, txHash common.Hash) ([]byte, common.Address, error) {
+	tx, _, err := client.TransactionByHash(ctx, txHash)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	if tx.To() == nil || len(tx.Data()) < 4 {
+		return nil, common.Address{}, fmt.Errorf("transaction %v isn't a call to the sequencer inbox", txHash)
+	}
+	sequencerInboxABI, err := bridgegen.SequencerInboxMetaData.GetAbi()
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	method, err := sequencerInboxABI.MethodById(tx.Data()[:4])
+	if err != nil {
+		return nil, common.Address{}, fmt.Errorf("transaction %v isn't a call to the sequencer inbox: %w", txHash, err)
+	}
+	args := make(map[string]interface{})
+	if err := method.Inputs.UnpackIntoMap(args, tx.Data()[4:]); err != nil {
+		return nil, common.Address{}, err
+	}
+	message, ok := args["data"].([]byte)
+	if !ok {
+		return nil, common.Address{}, fmt.Errorf("transaction %v calls %s, which doesn't post a sequencer message", txHash, method.Name)
+	}
+	return message, *tx.To(), nil
+}
+
+// datool ic status
+
+type ICStatusConfig struct {
+	ICStorage das.ICStorageConfig `koanf:"ic-storage"`
+}
+
+func parseICStatusConfig(args []string) (*ICStatusConfig, error) {
+	f := flag.NewFlagSet("datool ic status", flag.ContinueOnError)
+	das.ICStorageConfigAddOptions("ic-storage", f)
+
+	k, err := confighelpers.BeginCommonParse(f, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ICStatusConfig
+	if err := confighelpers.EndCommonParse(k, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func startICStatus(args []string) error {
+	config, err := parseICStatusConfig(args)
+	if err != nil {
+		return err
+	}
+	storageService, err := newICStorageService(config.ICStorage)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := storageService.CanisterStatus()
+	for _, status := range statuses {
+		fmt.Printf("Canister: %s\n", status.Canister)
+		fmt.Printf("  Cycles: %v\n", status.Cycles)
+		fmt.Printf("  Stored Bytes: %d\n", status.StoredBytes)
+		fmt.Printf("  Memory Bytes: %d\n", status.MemoryBytes)
+		fmt.Printf("  Memory Limit: %d\n", status.MemoryLimit)
+	}
+	return err
+}
+
+// datool ic keyset
+
+type ICKeysetConfig struct {
+	RootKey         string   `koanf:"root-key"`
+	Canisters       []string `koanf:"canisters"`
+	Threshold       uint64   `koanf:"threshold"`
+	CommitteeKeyset string   `koanf:"committee-keyset"`
+	HybridPolicy    string   `koanf:"hybrid-policy"`
+}
+
+func parseICKeysetConfig(args []string) (*ICKeysetConfig, error) {
+	f := flag.NewFlagSet("datool ic keyset", flag.ContinueOnError)
+	f.String("root-key", "", "DER encoded IC root key to embed in the keyset, can be a file or the hex-encoded key beginning with 0x; defaults to the IC mainnet root key")
+	f.StringSlice("canisters", []string{}, "textual ids of the storage canisters the keyset allows to certify batches")
+	f.Uint64("threshold", 1, "number of the allowed canisters whose proofs an ICDA certificate must carry")
+	f.String("committee-keyset", "", "hex encoded keyset of a DAS committee, as printed by datool dumpkeyset; if set, a hybrid keyset trusting both the committee and the canisters is created")
+	f.String("hybrid-policy", das.HybridPolicyBoth, "whether \"both\" the committee and the canisters, or \"either\" of them, must vouch for a batch under a hybrid keyset")
+
+	k, err := confighelpers.BeginCommonParse(f, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ICKeysetConfig
+	if err := confighelpers.EndCommonParse(k, &config); err != nil {
+		return nil, err
+	}
+	if len(config.Canisters) == 0 {
+		return nil, errors.New("--canisters must be specified")
+	}
+	return &config, nil
+}
+
+func startICKeyset(args []string) error {
+	config, err := parseICKeysetConfig(args)
+	if err != nil {
+		return err
+	}
+	rootKey, err := das.ParseICRootKey(config.RootKey)
+	if err != nil {
+		return err
+	}
+
+	keyset := &daprovider.ICDAKeyset{
+		Threshold: config.Threshold,
+		RootKey:   rootKey,
+	}
+	for _, canister := range config.Canisters {
+		id, err := principal.Decode(canister)
+		if err != nil {
+			return fmt.Errorf("invalid canister id %q: %w", canister, err)
+		}
+		keyset.Canisters = append(keyset.Canisters, id.Raw)
+	}
+	if config.CommitteeKeyset != "" {
+		return printHybridKeyset(config, keyset)
+	}
+	wr := bytes.NewBuffer([]byte{})
+	if err := keyset.Serialize(wr); err != nil {
+		return err
+	}
+	keysetHash, err := keyset.Hash()
+	if err != nil {
+		return err
+	}
+
+	printICDAKeyset(keyset)
+	fmt.Printf("Keyset: %s\n", hexutil.Encode(wr.Bytes()))
+	fmt.Printf("KeysetHash: %s\n", hexutil.Encode(keysetHash[:]))
+	return nil
+}
+
+func printHybridKeyset(config *ICKeysetConfig, icKeyset *daprovider.ICDAKeyset) error {
+	committeeKeysetBytes, err := hexutil.Decode(config.CommitteeKeyset)
+	if err != nil {
+		return err
+	}
+	committee, err := daprovider.DeserializeKeyset(bytes.NewReader(committeeKeysetBytes), false)
+	if err != nil {
+		return fmt.Errorf("invalid committee keyset: %w", err)
+	}
+	policy, err := das.ParseHybridPolicy(config.HybridPolicy)
+	if err != nil {
+		return err
+	}
+	keyset := &daprovider.HybridKeyset{
+		Committee: committee,
+		IC:        icKeyset,
+		Policy:    policy,
+	}
+	wr := bytes.NewBuffer([]byte{})
+	if err := keyset.Serialize(wr); err != nil {
+		return err
+	}
+	keysetHash, err := keyset.Hash()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Keyset Committee Size: %d\n", len(committee.PubKeys))
+	fmt.Printf("Keyset Assumed Honest: %d\n", committee.AssumedHonest)
+	fmt.Printf("Keyset Hybrid Policy: %v\n", policy)
+	printICDAKeyset(icKeyset)
+	fmt.Printf("Keyset: %s\n", hexutil.Encode(wr.Bytes()))
+	fmt.Printf("KeysetHash: %s\n", hexutil.Encode(keysetHash[:]))
+	return nil
+}
+
+// datool ic backfill
+
+type ICBackfillConfig struct {
+	ICStorage        das.ICStorageConfig        `koanf:"ic-storage"`
+	LocalFileStorage das.LocalFileStorageConfig `koanf:"local-file-storage"`
+	S3Storage        das.S3StorageServiceConfig `koanf:"s3-storage"`
+	CheckpointFile   string                     `koanf:"checkpoint-file"`
+}
+
+func parseICBackfillConfig(args []string) (*ICBackfillConfig, error) {
+	f := flag.NewFlagSet("datool ic backfill", flag.ContinueOnError)
+	das.ICStorageConfigAddOptions("ic-storage", f)
+	das.LocalFileStorageConfigAddOptions("local-file-storage", f)
+	das.S3ConfigAddOptions("s3-storage", f)
+	f.String("checkpoint-file", "", "file the hash of the last backfilled batch is written to, so that an interrupted backfill resumes after it")
+
+	k, err := confighelpers.BeginCommonParse(f, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ICBackfillConfig
+	if err := confighelpers.EndCommonParse(k, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func startICBackfill(args []string) error {
+	config, err := parseICBackfillConfig(args)
+	if err != nil {
+		return err
+	}
+	if config.LocalFileStorage.Enable == config.S3Storage.Enable {
+		return errors.New("exactly one of --local-file-storage.enable and --s3-storage.enable must be specified")
+	}
+
+	var source das.StorageService
+	if config.LocalFileStorage.Enable {
+		source, err = das.NewLocalFileStorageService(config.LocalFileStorage)
+	} else {
+		source, err = das.NewS3StorageService(config.S3Storage)
+	}
+	if err != nil {
+		return err
+	}
+	storageService, err := newICStorageService(config.ICStorage)
+	if err != nil {
+		return err
+	}
+	backfiller, err := das.NewICBackfiller(source, storageService, config.CheckpointFile)
+	if err != nil {
+		return err
+	}
+
+	stats, err := backfiller.Backfill(context.Background())
+	fmt.Printf("Batches: %d\n", stats.Batches)
+	fmt.Printf("Uploaded: %d\n", stats.Uploaded)
+	fmt.Printf("Already Certified: %d\n", stats.AlreadyCertified)
+	fmt.Printf("Expired: %d\n", stats.Expired)
+	fmt.Printf("Shards: %d\n", stats.Shards)
+	return err
+}
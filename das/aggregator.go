@@ -6,16 +6,12 @@ package das
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"math/bits"
 	"sync/atomic"
 	"time"
 
-	"github.com/CommoDor64/icdaserver/icutils"
-
 	flag "github.com/spf13/pflag"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -24,8 +20,9 @@ import (
 
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/arbutil"
-
+	"github.com/offchainlabs/nitro/blsSignatures"
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/erasure"
 	"github.com/offchainlabs/nitro/solgen/go/bridgegen"
 	"github.com/offchainlabs/nitro/util/pretty"
 )
@@ -45,12 +42,22 @@ type AggregatorConfig struct {
 	AssumedHonest         int               `koanf:"assumed-honest"`
 	Backends              BackendConfigList `koanf:"backends"`
 	MaxStoreChunkBodySize int               `koanf:"max-store-chunk-body-size"`
+	// KeysetVersion is 0 for the legacy keyset format, or daprovider.KeysetVersion1 for a
+	// versioned keyset declaring a BLS committee trust root. Batches with the DAS header are
+	// only read under legacy keysets, so a versioned keyset only names the committee of hybrid
+	// ICDA batches.
+	KeysetVersion int `koanf:"keyset-version"`
+	// DataShards is the number of data shards batches are erasure coded into, with one shard
+	// per backend, or 0 to send each backend the whole batch.
+	DataShards int `koanf:"data-shards"`
 }
 
 var DefaultAggregatorConfig = AggregatorConfig{
 	AssumedHonest:         0,
 	Backends:              nil,
 	MaxStoreChunkBodySize: 512 * 1024,
+	KeysetVersion:         0,
+	DataShards:            0,
 }
 
 var parsedBackendsConf BackendConfigList
@@ -60,6 +67,25 @@ func AggregatorConfigAddOptions(prefix string, f *flag.FlagSet) {
 	f.Int(prefix+".assumed-honest", DefaultAggregatorConfig.AssumedHonest, "Number of assumed honest backends (H). If there are N backends, K=N+1-H valid responses are required to consider an Store request to be successful.")
 	f.Var(&parsedBackendsConf, prefix+".backends", "JSON RPC backend configuration. This can be specified on the command line as a JSON array, eg: [{\"url\": \"...\", \"pubkey\": \"...\"},...], or as a JSON array in the config file.")
 	f.Int(prefix+".max-store-chunk-body-size", DefaultAggregatorConfig.MaxStoreChunkBodySize, "maximum HTTP POST body size to use for individual batch chunks, including JSON RPC overhead and an estimated overhead of 512B of headers")
+	f.Int(prefix+".keyset-version", DefaultAggregatorConfig.KeysetVersion, "format of the keyset batches are signed under, 0 for the legacy format or 1 for a versioned keyset that declares its trust root, which is only supported for the committee of hybrid ICDA batches; changing it changes the keyset hash, so the new keyset must be registered with the sequencer inbox first")
+	f.Int(prefix+".data-shards", DefaultAggregatorConfig.DataShards, "number of data shards (k) to Reed-Solomon code batches into, sending each backend one shard instead of the whole batch, any k of which rebuild it; 0 disables erasure coding. Must be at most assumed-honest (H), and K=N+k-H valid responses are then required. Batches are then posted with version 2 certificates, which the nodes and the WASM module root of the chain must be upgraded to read first; changing it changes the keyset hash, so the new keyset must be registered with the sequencer inbox first")
+}
+
+// KeysetAssumedHonest is the assumed-honest count of the keyset batches are signed under. An
+// erasure-coded batch is only available if DataShards honest backends hold its shards, so the
+// keyset assumes DataShards-1 fewer honest backends, making readers require as many more
+// signatures.
+func (c *AggregatorConfig) KeysetAssumedHonest() (int, error) {
+	if c.DataShards < 0 {
+		return 0, fmt.Errorf("data-shards must not be negative, got %d", c.DataShards)
+	}
+	if c.DataShards == 0 {
+		return c.AssumedHonest, nil
+	}
+	if c.DataShards > c.AssumedHonest {
+		return 0, fmt.Errorf("data-shards (%d) must be at most assumed-honest (%d), so that the honest backends hold enough shards to rebuild batches", c.DataShards, c.AssumedHonest)
+	}
+	return c.AssumedHonest - c.DataShards + 1, nil
 }
 
 type Aggregator struct {
@@ -76,7 +102,7 @@ type Aggregator struct {
 
 type ServiceDetails struct {
 	service     DataAvailabilityServiceWriter
-	pubKey      []byte
+	pubKey      blsSignatures.PublicKey
 	signersMask uint64
 	metricName  string
 }
@@ -85,7 +111,7 @@ func (s *ServiceDetails) String() string {
 	return fmt.Sprintf("ServiceDetails{service: %v, signersMask %d}", s.service, s.signersMask)
 }
 
-func NewServiceDetails(service DataAvailabilityServiceWriter, pubKey []byte, signersMask uint64, metricName string) (*ServiceDetails, error) {
+func NewServiceDetails(service DataAvailabilityServiceWriter, pubKey blsSignatures.PublicKey, signersMask uint64, metricName string) (*ServiceDetails, error) {
 	if bits.OnesCount64(signersMask) != 1 {
 		return nil, fmt.Errorf("tried to configure backend DAS %v with invalid signersMask %X", service, signersMask)
 	}
@@ -134,7 +160,22 @@ func NewAggregatorWithSeqInboxCaller(
 	seqInboxCaller *bridgegen.SequencerInboxCaller,
 ) (*Aggregator, error) {
 
-	keysetHash, keysetBytes, err := KeysetHashFromServices(services, uint64(config.RPCAggregator.AssumedHonest))
+	assumedHonest, err := config.RPCAggregator.KeysetAssumedHonest()
+	if err != nil {
+		return nil, fmt.Errorf("invalid rpc-aggregator config: %w", err)
+	}
+	if config.RPCAggregator.DataShards > 0 {
+		if len(services) > erasure.MaxShards {
+			return nil, fmt.Errorf("can't erasure code batches for %d backends, at most %d are supported", len(services), erasure.MaxShards)
+		}
+		for _, d := range services {
+			if _, ok := d.service.(DataAvailabilityServiceShardWriter); !ok {
+				return nil, fmt.Errorf("backend %v can't store erasure-coded shards", d.service)
+			}
+		}
+	}
+
+	keysetHash, keysetBytes, err := KeysetHashFromServices(services, uint64(assumedHonest), config.RPCAggregator.KeysetVersion)
 	if err != nil {
 		return nil, err
 	}
@@ -143,8 +184,8 @@ func NewAggregatorWithSeqInboxCaller(
 		config:                         config.RPCAggregator,
 		services:                       services,
 		requestTimeout:                 config.RequestTimeout,
-		requiredServicesForStore:       len(services) + 1 - config.RPCAggregator.AssumedHonest,
-		maxAllowedServiceStoreFailures: config.RPCAggregator.AssumedHonest - 1,
+		requiredServicesForStore:       len(services) + 1 - assumedHonest,
+		maxAllowedServiceStoreFailures: assumedHonest - 1,
 		keysetHash:                     keysetHash,
 		keysetBytes:                    keysetBytes,
 	}, nil
@@ -152,7 +193,7 @@ func NewAggregatorWithSeqInboxCaller(
 
 type storeResponse struct {
 	details ServiceDetails
-	sig     []byte
+	sig     blsSignatures.Signature
 	err     error
 }
 
@@ -169,6 +210,12 @@ type storeResponse struct {
 //
 // If Store gets not enough successful responses by the time its context is canceled
 // (eg via TimeoutWrapper) then it also returns an error.
+//
+// If rpc-aggregator.data-shards is set, the batch is erasure coded and each backend is sent
+// only its shard, with the proof of it against the commitment to all of them, in place of the
+// whole batch. The backends then sign an erasure-coded certificate of the whole batch, which also
+// names the commitment and the code, so a backend's signature only counts towards a certificate
+// for the shards it was sent.
 func (a *Aggregator) Store(ctx context.Context, message []byte, timeout uint64) (*daprovider.DataAvailabilityCertificate, error) {
 	log.Trace("das.Aggregator.Store", "message", pretty.FirstFewBytes(message), "timeout", time.Unix(int64(timeout), 0))
 
@@ -183,9 +230,18 @@ func (a *Aggregator) Store(ctx context.Context, message []byte, timeout uint64)
 
 	responses := make(chan storeResponse, len(a.services))
 
+	var shards []*erasure.Shard
+	if a.config.DataShards > 0 {
+		var err error
+		shards, err = erasure.Split(message, a.config.DataShards, len(a.services))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	expectedHash := dastree.Hash(message)
-	for _, d := range a.services {
-		go func(ctx context.Context, d ServiceDetails) {
+	for i, d := range a.services {
+		go func(ctx context.Context, i int, d ServiceDetails) {
 			storeCtx, cancel := context.WithTimeout(ctx, a.requestTimeout)
 			var metricWithServiceName = metricBase + "/" + d.metricName
 			defer cancel()
@@ -194,7 +250,13 @@ func (a *Aggregator) Store(ctx context.Context, message []byte, timeout uint64)
 				metrics.GetOrRegisterCounter(metricBase+"/error/all/total", nil).Inc(1)
 			}
 
-			cert, err := d.service.Store(storeCtx, message, timeout)
+			var cert *daprovider.DataAvailabilityCertificate
+			var err error
+			if shards != nil {
+				cert, err = d.service.(DataAvailabilityServiceShardWriter).StoreShard(storeCtx, shards[i], timeout)
+			} else {
+				cert, err = d.service.Store(storeCtx, message, timeout)
+			}
 			if err != nil {
 				incFailureMetric()
 				log.Warn("DAS Aggregator failed to store batch to backend", "backend", d.metricName, "err", err)
@@ -202,10 +264,9 @@ func (a *Aggregator) Store(ctx context.Context, message []byte, timeout uint64)
 				return
 			}
 
-			// verified, err := blsSignatures.VerifySignature(
-			// 	cert.Sig, cert.SerializeSignableFields(), d.pubKey,
-			// )
-
+			verified, err := blsSignatures.VerifySignature(
+				cert.Sig, cert.SerializeSignableFields(), d.pubKey,
+			)
 			if err != nil {
 				incFailureMetric()
 				log.Warn("DAS Aggregator couldn't parse backend's store response signature", "backend", d.metricName, "err", err)
@@ -213,12 +274,12 @@ func (a *Aggregator) Store(ctx context.Context, message []byte, timeout uint64)
 				return
 			}
 
-			// if !verified {
-			// 	incFailureMetric()
-			// 	log.Warn("DAS Aggregator failed to verify backend's store response signature", "backend", d.metricName, "err", err)
-			// 	responses <- storeResponse{d, nil, errors.New("signature verification failed")}
-			// 	return
-			// }
+			if !verified {
+				incFailureMetric()
+				log.Warn("DAS Aggregator failed to verify backend's store response signature", "backend", d.metricName, "err", err)
+				responses <- storeResponse{d, nil, errors.New("signature verification failed")}
+				return
+			}
 
 			// SignersMask from backend DAS is ignored.
 			if cert.DataHash != expectedHash {
@@ -228,6 +289,14 @@ func (a *Aggregator) Store(ctx context.Context, message []byte, timeout uint64)
 				return
 			}
 
+			if shards != nil && (cert.Version != daprovider.ErasureCodedDASCertificateVersion || cert.Commitment != shards[i].Commitment ||
+				cert.DataShards != shards[i].DataShards || cert.TotalShards != shards[i].TotalShards) {
+				incFailureMetric()
+				log.Warn("DAS Aggregator got a store response for a shard not certifying the shards it was sent", "backend", d.metricName, "version", cert.Version, "commitment", common.Hash(cert.Commitment), "expectedCommitment", shards[i].Commitment)
+				responses <- storeResponse{d, nil, errors.New("shard commitment verification failed")}
+				return
+			}
+
 			if cert.Timeout != timeout {
 				incFailureMetric()
 				log.Warn("DAS Aggregator got a store response with any expiry time not matching the expected expiry time", "backend", d.metricName, "dataHash", cert.DataHash, "expectedHash", expectedHash, "err", err)
@@ -238,14 +307,14 @@ func (a *Aggregator) Store(ctx context.Context, message []byte, timeout uint64)
 			metrics.GetOrRegisterCounter(metricWithServiceName+"/success/total", nil).Inc(1)
 			metrics.GetOrRegisterCounter(metricBase+"/success/all/total", nil).Inc(1)
 			responses <- storeResponse{d, cert.Sig, nil}
-		}(ctx, d)
+		}(ctx, i, d)
 	}
 
 	var aggCert daprovider.DataAvailabilityCertificate
 
 	type certDetails struct {
-		pubKeys        []byte
-		sigs           [][]byte
+		pubKeys        []blsSignatures.PublicKey
+		sigs           []blsSignatures.Signature
 		aggSignersMask uint64
 		err            error
 	}
@@ -254,8 +323,8 @@ func (a *Aggregator) Store(ctx context.Context, message []byte, timeout uint64)
 	// Collect responses from backends.
 	certDetailsChan := make(chan certDetails)
 	go func() {
-		var pubKeys []byte
-		var sigs [][]byte
+		var pubKeys []blsSignatures.PublicKey
+		var sigs []blsSignatures.Signature
 		var aggSignersMask uint64
 		var successfullyStoredCount int
 		var returned bool
@@ -268,7 +337,7 @@ func (a *Aggregator) Store(ctx context.Context, message []byte, timeout uint64)
 					_ = storeFailures.Add(1)
 					log.Warn("das.Aggregator: Error from backend", "backend", r.details.service, "signerMask", r.details.signersMask, "err", r.err)
 				} else {
-					pubKeys = append(pubKeys, r.details.pubKey...)
+					pubKeys = append(pubKeys, r.details.pubKey)
 					sigs = append(sigs, r.sig)
 					aggSignersMask |= r.details.signersMask
 
@@ -309,29 +378,27 @@ func (a *Aggregator) Store(ctx context.Context, message []byte, timeout uint64)
 		return nil, cd.err
 	}
 
-	aggCert.Sig = cd.sigs[0]
-	rootKey := cd.pubKeys
+	aggCert.Sig = blsSignatures.AggregateSignatures(cd.sigs)
+	aggPubKey := blsSignatures.AggregatePublicKeys(cd.pubKeys)
 	aggCert.SignersMask = cd.aggSignersMask
+
 	aggCert.DataHash = expectedHash
 	aggCert.Timeout = timeout
 	aggCert.KeysetHash = a.keysetHash
 	aggCert.Version = 1
-
-	var cb icutils.CertifiedBlock
-	if err := json.Unmarshal(aggCert.Sig, &cb); err != nil {
-		return nil, err
+	if shards != nil {
+		aggCert.Version = daprovider.ErasureCodedDASCertificateVersion
+		aggCert.Commitment = shards[0].Commitment
+		aggCert.DataShards = shards[0].DataShards
+		aggCert.TotalShards = shards[0].TotalShards
 	}
 
-	decodedLen := base64.StdEncoding.DecodedLen(len(rootKey))
-	k := make([]byte, decodedLen)
-
-	_, err := base64.StdEncoding.Decode(k, rootKey)
+	verified, err := blsSignatures.VerifySignature(aggCert.Sig, aggCert.SerializeSignableFields(), aggPubKey)
 	if err != nil {
 		return nil, err
 	}
-
-	if _, err := icutils.VerifyDataFromIC(cb.Certificate, k, icutils.ToPrincipal(cb.Canister), cb.Witness, aggCert.DataHash[:]); err != nil {
-		return nil, err
+	if !verified {
+		return nil, fmt.Errorf("failed aggregate signature check. %w", daprovider.ErrBatchToDasFailed)
 	}
 
 	if storeFailures.Load() == 0 {
@@ -0,0 +1,303 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/arbmath"
+	"github.com/offchainlabs/nitro/util/metricsutil"
+	"github.com/offchainlabs/nitro/util/pretty"
+	"github.com/offchainlabs/nitro/util/stopwaiter"
+	flag "github.com/spf13/pflag"
+)
+
+const samplingMetricBase = "arb/das/sampling"
+
+var (
+	samplingBatchesCounter = metrics.NewRegisteredCounter(samplingMetricBase+"/batches/total", nil)
+	samplingDroppedCounter = metrics.NewRegisteredCounter(samplingMetricBase+"/batches/dropped/total", nil)
+)
+
+type AvailabilitySamplingConfig struct {
+	Enable          bool          `koanf:"enable"`
+	SamplesPerBatch int           `koanf:"samples-per-batch"`
+	RequestTimeout  time.Duration `koanf:"request-timeout"`
+	ScoreWindow     int           `koanf:"score-window"`
+	QueueSize       int           `koanf:"queue-size"`
+}
+
+var DefaultAvailabilitySamplingConfig = AvailabilitySamplingConfig{
+	Enable:          false,
+	SamplesPerBatch: 4,
+	RequestTimeout:  10 * time.Second,
+	ScoreWindow:     100,
+	QueueSize:       64,
+}
+
+func AvailabilitySamplingConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultAvailabilitySamplingConfig.Enable, "sample random leaves of each new batch from every REST endpoint, with their proofs against the batch's dastree, and keep per-endpoint availability scores")
+	f.Int(prefix+".samples-per-batch", DefaultAvailabilitySamplingConfig.SamplesPerBatch, "number of random leaves of each batch to fetch from each REST endpoint")
+	f.Duration(prefix+".request-timeout", DefaultAvailabilitySamplingConfig.RequestTimeout, "timeout of each sample request, after which the sample counts as unavailable")
+	f.Int(prefix+".score-window", DefaultAvailabilitySamplingConfig.ScoreWindow, "number of most recent samples of each REST endpoint its availability score is computed over")
+	f.Int(prefix+".queue-size", DefaultAvailabilitySamplingConfig.QueueSize, "number of batches waiting to be sampled, beyond which new batches aren't sampled")
+}
+
+// LeafReader is a reader that can serve single leaves of a batch's dastree, with their checked
+// proofs.
+type LeafReader interface {
+	GetLeaf(ctx context.Context, root common.Hash, index uint32) ([]byte, *dastree.Proof, error)
+}
+
+// MemberAvailability is the availability of a committee member's REST endpoint as seen by sampling.
+type MemberAvailability struct {
+	Member string `json:"member"`
+	// Score is the fraction of the samples in the score window the member served.
+	Score       float64 `json:"score"`
+	Samples     uint64  `json:"samples"`
+	Failures    uint64  `json:"failures"`
+	LastSuccess int64   `json:"lastSuccess,omitempty"`
+	LastFailure int64   `json:"lastFailure,omitempty"`
+	LastError   string  `json:"lastError,omitempty"`
+}
+
+type memberSamples struct {
+	// window holds the outcomes of the most recent samples, next being where the next one goes.
+	window    []bool
+	next      int
+	successes int
+
+	samples     uint64
+	failures    uint64
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   error
+}
+
+func (m *memberSamples) record(err error, windowSize int) {
+	success := err == nil
+	if len(m.window) < windowSize {
+		m.window = append(m.window, success)
+	} else {
+		if m.window[m.next] {
+			m.successes--
+		}
+		m.window[m.next] = success
+	}
+	m.next = (m.next + 1) % windowSize
+	m.samples++
+	if success {
+		m.successes++
+		m.lastSuccess = time.Now()
+	} else {
+		m.failures++
+		m.lastFailure = time.Now()
+		m.lastError = err
+	}
+}
+
+func (m *memberSamples) score() float64 {
+	if len(m.window) == 0 {
+		return 0
+	}
+	return float64(m.successes) / float64(len(m.window))
+}
+
+type sampleRequest struct {
+	root common.Hash
+	size uint32
+}
+
+// AvailabilitySampler checks that committee members keep serving the batches they signed for, well
+// before the data is needed, by fetching random leaves of each new batch from each member's REST
+// endpoint and checking them against the batch's dastree. The REST aggregator queues the batches it
+// fetches, which are those the inbox reader and the sync to storage see.
+//
+// Members of an erasure-coded committee only keep their shard of each batch, so they are sampled
+// by fetching and checking that shard instead.
+type AvailabilitySampler struct {
+	stopwaiter.StopWaiter
+
+	config       *AvailabilitySamplingConfig
+	members      func() []daprovider.DASReader
+	erasureCoded bool
+
+	queue chan sampleRequest
+	// seen are the batches sampled recently, which aren't queued again when fetched again.
+	seen *lru.Cache[common.Hash, struct{}]
+
+	scoresMutex sync.Mutex
+	scores      map[string]*memberSamples
+}
+
+func NewAvailabilitySampler(config *AvailabilitySamplingConfig, members func() []daprovider.DASReader, erasureCoded bool) (*AvailabilitySampler, error) {
+	if config.SamplesPerBatch <= 0 {
+		return nil, fmt.Errorf("invalid samples-per-batch %d, must be positive", config.SamplesPerBatch)
+	}
+	if config.ScoreWindow <= 0 {
+		return nil, fmt.Errorf("invalid score-window %d, must be positive", config.ScoreWindow)
+	}
+	return &AvailabilitySampler{
+		config:       config,
+		members:      members,
+		erasureCoded: erasureCoded,
+		queue:        make(chan sampleRequest, config.QueueSize),
+		seen:         lru.NewCache[common.Hash, struct{}](arbmath.MaxInt(config.QueueSize, 1) * 4),
+		scores:       make(map[string]*memberSamples),
+	}, nil
+}
+
+// Queue schedules the batch with the given root and size to be sampled, unless it was recently.
+// It never blocks, and drops the batch if too many are waiting.
+func (s *AvailabilitySampler) Queue(root common.Hash, size int) {
+	if s.seen.Contains(root) {
+		return
+	}
+	s.seen.Add(root, struct{}{})
+	select {
+	case s.queue <- sampleRequest{root, uint32(size)}:
+	default:
+		samplingDroppedCounter.Inc(1)
+		log.Warn("AvailabilitySampler queue is full, not sampling batch", "root", pretty.PrettyHash(root))
+	}
+}
+
+func (s *AvailabilitySampler) Start(ctx context.Context) {
+	s.StopWaiter.Start(ctx, s)
+	s.LaunchThread(func(ctx context.Context) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case request := <-s.queue:
+				s.sampleBatch(ctx, request)
+			}
+		}
+	})
+}
+
+// sampleBatch samples the batch from every member at once, and waits for all of them.
+func (s *AvailabilitySampler) sampleBatch(ctx context.Context, request sampleRequest) {
+	samplingBatchesCounter.Inc(1)
+	var wg sync.WaitGroup
+	for _, member := range s.members() {
+		if s.erasureCoded {
+			shardReader, ok := member.(ShardReader)
+			if !ok {
+				continue
+			}
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				s.record(name, s.sampleShard(ctx, shardReader, request))
+			}(fmt.Sprint(member))
+			continue
+		}
+		leafReader, ok := member.(LeafReader)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			// Each member gets its own leaves, so that members can't serve only the leaves they
+			// expect to be asked for.
+			leaves := dastree.LeafCount(request.size)
+			for _, index := range rand.Perm(int(leaves))[:arbmath.MinInt(s.config.SamplesPerBatch, int(leaves))] {
+				if ctx.Err() != nil {
+					return
+				}
+				s.record(name, s.sampleLeaf(ctx, leafReader, request, uint32(index)))
+			}
+		}(fmt.Sprint(member))
+	}
+	wg.Wait()
+}
+
+func (s *AvailabilitySampler) sampleLeaf(ctx context.Context, reader LeafReader, request sampleRequest, index uint32) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.RequestTimeout)
+	defer cancel()
+	_, proof, err := reader.GetLeaf(ctx, request.root, index)
+	if err != nil {
+		return err
+	}
+	if proof.Size != request.size {
+		return fmt.Errorf("leaf %d of batch %v was proven for a batch of %d bytes rather than %d", index, request.root, proof.Size, request.size)
+	}
+	return nil
+}
+
+func (s *AvailabilitySampler) sampleShard(ctx context.Context, reader ShardReader, request sampleRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.RequestTimeout)
+	defer cancel()
+	shard, err := reader.GetShard(ctx, request.root)
+	if err != nil {
+		return err
+	}
+	if shard.Size != uint64(request.size) {
+		return fmt.Errorf("shard of batch %v is of a batch of %d bytes rather than %d", request.root, shard.Size, request.size)
+	}
+	return nil
+}
+
+func (s *AvailabilitySampler) record(member string, err error) {
+	if err != nil {
+		log.Debug("AvailabilitySampler sample failed", "member", member, "err", err)
+	}
+	s.scoresMutex.Lock()
+	samples, ok := s.scores[member]
+	if !ok {
+		samples = &memberSamples{}
+		s.scores[member] = samples
+	}
+	samples.record(err, s.config.ScoreWindow)
+	score := samples.score()
+	s.scoresMutex.Unlock()
+
+	metricBase := samplingMetricBase + "/" + metricsutil.CanonicalizeMetricName(member)
+	if err == nil {
+		metrics.GetOrRegisterCounter(metricBase+"/success/total", nil).Inc(1)
+	} else {
+		metrics.GetOrRegisterCounter(metricBase+"/failure/total", nil).Inc(1)
+	}
+	metrics.GetOrRegisterGaugeFloat64(metricBase+"/score", nil).Update(score)
+}
+
+// Scores returns the availability of every member sampled so far, ordered by member.
+func (s *AvailabilitySampler) Scores() []MemberAvailability {
+	s.scoresMutex.Lock()
+	defer s.scoresMutex.Unlock()
+	scores := make([]MemberAvailability, 0, len(s.scores))
+	for member, samples := range s.scores {
+		availability := MemberAvailability{
+			Member:   member,
+			Score:    samples.score(),
+			Samples:  samples.samples,
+			Failures: samples.failures,
+		}
+		if !samples.lastSuccess.IsZero() {
+			availability.LastSuccess = samples.lastSuccess.Unix()
+		}
+		if !samples.lastFailure.IsZero() {
+			availability.LastFailure = samples.lastFailure.Unix()
+		}
+		if samples.lastError != nil {
+			availability.LastError = samples.lastError.Error()
+		}
+		scores = append(scores, availability)
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Member < scores[j].Member })
+	return scores
+}
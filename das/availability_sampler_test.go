@@ -0,0 +1,99 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/testhelpers"
+)
+
+func TestRestfulAvailabilitySampling(t *testing.T) {
+	initTest(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data := testhelpers.RandomizeSlice(make([]byte, 3*dastree.BinSize+5))
+	dataHash := dastree.Hash(data)
+	storage := NewMemoryBackedStorageService(ctx)
+	Require(t, storage.Put(ctx, data, uint64(time.Now().Add(time.Hour).Unix())))
+
+	server, port, err := NewRestfulDasServerOnRandomPort(LocalServerAddressForTest, storage)
+	Require(t, err)
+	defer func() { Require(t, server.Shutdown()) }()
+	// A member that signed for the batch but doesn't serve it.
+	emptyServer, emptyPort, err := NewRestfulDasServerOnRandomPort(LocalServerAddressForTest, NewMemoryBackedStorageService(ctx))
+	Require(t, err)
+	defer func() { Require(t, emptyServer.Shutdown()) }()
+
+	client := NewRestfulDasClient("http", LocalServerAddressForTest, port)
+	for index := uint32(0); index < dastree.LeafCount(uint32(len(data))); index++ {
+		bin, proof, err := client.GetLeaf(ctx, dataHash, index)
+		Require(t, err)
+		start := int(index) * dastree.BinSize
+		if !bytes.Equal(bin, data[start:start+len(bin)]) || proof.Size != uint32(len(data)) {
+			Fail(t, "served the wrong leaf", index)
+		}
+	}
+	if _, _, err := client.GetLeaf(ctx, dataHash, 4); err == nil {
+		Fail(t, "expected a leaf out of range to fail")
+	}
+
+	url := func(port int) string { return "http://" + LocalServerAddressForTest + ":" + strconv.Itoa(port) }
+	config := RestfulClientAggregatorConfig{
+		Urls:                   []string{url(port), url(emptyPort)},
+		Strategy:               "testing-sequential",
+		StrategyUpdateInterval: time.Second,
+		WaitBeforeTryNext:      500 * time.Millisecond,
+		MaxPerEndpointStats:    10,
+		Sampling: AvailabilitySamplingConfig{
+			Enable:          true,
+			SamplesPerBatch: 2,
+			RequestTimeout:  time.Second,
+			ScoreWindow:     10,
+			QueueSize:       4,
+		},
+	}
+	agg, err := NewRestfulClientAggregator(ctx, &config)
+	Require(t, err)
+	agg.Start(ctx)
+	defer func() { Require(t, agg.Close(ctx)) }()
+
+	returnedData, err := agg.GetByHash(ctx, dataHash)
+	Require(t, err)
+	if !bytes.Equal(returnedData, data) {
+		Fail(t, "aggregator returned the wrong batch")
+	}
+
+	var scores []MemberAvailability
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		scores = agg.Sampler().Scores()
+		if len(scores) == 2 && scores[0].Samples == 2 && scores[1].Samples == 2 {
+			break
+		}
+	}
+	if len(scores) != 2 {
+		Fail(t, "expected scores of both members, got", scores)
+	}
+	for _, score := range scores {
+		switch score.Member {
+		case url(port):
+			if score.Score != 1 || score.Samples != 2 || score.Failures != 0 {
+				Fail(t, "expected the serving member to be fully available, got", score)
+			}
+		case url(emptyPort):
+			if score.Score != 0 || score.Samples != 2 || score.Failures != 2 || score.LastError == "" {
+				Fail(t, "expected the empty member to be unavailable, got", score)
+			}
+		default:
+			Fail(t, "unexpected member", score.Member)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	flag "github.com/spf13/pflag"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/blsSignatures"
+	"github.com/offchainlabs/nitro/util/arbmath"
+	"github.com/offchainlabs/nitro/util/pretty"
+	"github.com/offchainlabs/nitro/util/stopwaiter"
+)
+
+var (
+	announcementsSentCounter      = metrics.NewRegisteredCounter("arb/das/announcements/sent/total", nil)
+	announcementsDroppedCounter   = metrics.NewRegisteredCounter("arb/das/announcements/dropped/total", nil)
+	announcementsSubscribersGauge = metrics.NewRegisteredGauge("arb/das/announcements/subscribers", nil)
+
+	announcementsReceivedCounter       = metrics.NewRegisteredCounter("arb/das/announcements/received/total", nil)
+	announcementsInvalidCounter        = metrics.NewRegisteredCounter("arb/das/announcements/invalid/total", nil)
+	announcementsPrefetchedCounter     = metrics.NewRegisteredCounter("arb/das/announcements/prefetch/success/total", nil)
+	announcementsPrefetchFailedCounter = metrics.NewRegisteredCounter("arb/das/announcements/prefetch/failure/total", nil)
+	// Announcements arriving while max-concurrent-prefetches batches are being prefetched are dropped.
+	announcementsPrefetchDroppedCounter = metrics.NewRegisteredCounter("arb/das/announcements/prefetch/dropped/total", nil)
+)
+
+type BatchAnnouncementConfig struct {
+	Enable       bool                              `koanf:"enable"`
+	QueueSize    int                               `koanf:"queue-size"`
+	WriteTimeout time.Duration                     `koanf:"write-timeout"`
+	PingInterval time.Duration                     `koanf:"ping-interval"`
+	Subscribe    BatchAnnouncementSubscriberConfig `koanf:"subscribe"`
+}
+
+type BatchAnnouncementSubscriberConfig struct {
+	Enable                  bool              `koanf:"enable"`
+	Feeds                   BackendConfigList `koanf:"feeds"`
+	ReconnectInterval       time.Duration     `koanf:"reconnect-interval"`
+	ReadTimeout             time.Duration     `koanf:"read-timeout"`
+	FetchTimeout            time.Duration     `koanf:"fetch-timeout"`
+	MaxConcurrentPrefetches int               `koanf:"max-concurrent-prefetches"`
+	RetentionPeriod         time.Duration     `koanf:"retention-period"`
+}
+
+var DefaultBatchAnnouncementConfig = BatchAnnouncementConfig{
+	Enable:       false,
+	QueueSize:    128,
+	WriteTimeout: 10 * time.Second,
+	PingInterval: 30 * time.Second,
+	Subscribe:    DefaultBatchAnnouncementSubscriberConfig,
+}
+
+var DefaultBatchAnnouncementSubscriberConfig = BatchAnnouncementSubscriberConfig{
+	Enable:                  false,
+	Feeds:                   nil,
+	ReconnectInterval:       5 * time.Second,
+	ReadTimeout:             2 * time.Minute,
+	FetchTimeout:            30 * time.Second,
+	MaxConcurrentPrefetches: 16,
+	RetentionPeriod:         daprovider.DefaultDASRetentionPeriod,
+}
+
+var parsedAnnouncementFeedsConf BackendConfigList
+
+func BatchAnnouncementConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultBatchAnnouncementConfig.Enable, "announce the hashes of the batches this committee member stores, signed with its BLS key, to the subscribers of the REST server's "+announcementsRequestPath+" websocket feed")
+	f.Int(prefix+".queue-size", DefaultBatchAnnouncementConfig.QueueSize, "number of announcements waiting to be sent to each subscriber, beyond which a slow subscriber misses announcements")
+	f.Duration(prefix+".write-timeout", DefaultBatchAnnouncementConfig.WriteTimeout, "timeout of sending an announcement to a subscriber, after which the subscriber is dropped")
+	f.Duration(prefix+".ping-interval", DefaultBatchAnnouncementConfig.PingInterval, "interval between pings to subscribers, which lets them detect a dead feed")
+	BatchAnnouncementSubscriberConfigAddOptions(prefix+".subscribe", f)
+}
+
+func BatchAnnouncementSubscriberConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultBatchAnnouncementSubscriberConfig.Enable, "subscribe to the batch announcements of committee members, and prefetch the announced batches into this server's storage")
+	f.Var(&parsedAnnouncementFeedsConf, prefix+".feeds", "committee members to subscribe to, with the URLs of their REST servers and their base64 BLS public keys, which announcements must be signed with. This can be specified on the command line as a JSON array, eg: [{\"url\": \"...\", \"pubkey\": \"...\"},...], or as a JSON array in the config file.")
+	f.Duration(prefix+".reconnect-interval", DefaultBatchAnnouncementSubscriberConfig.ReconnectInterval, "time to wait before reconnecting to a feed that failed")
+	f.Duration(prefix+".read-timeout", DefaultBatchAnnouncementSubscriberConfig.ReadTimeout, "time without hearing from a feed, not even a ping, after which it is reconnected to")
+	f.Duration(prefix+".fetch-timeout", DefaultBatchAnnouncementSubscriberConfig.FetchTimeout, "timeout of fetching an announced batch from the member that announced it")
+	f.Int(prefix+".max-concurrent-prefetches", DefaultBatchAnnouncementSubscriberConfig.MaxConcurrentPrefetches, "maximum number of announced batches fetched at once, beyond which announcements are dropped and their batches are only fetched when requested")
+	f.Duration(prefix+".retention-period", DefaultBatchAnnouncementSubscriberConfig.RetentionPeriod, "period to request storage to retain prefetched batches for, which caps the timeout they were announced with")
+}
+
+var batchAnnouncementDomain = []byte("das-batch-announcement")
+
+// BatchAnnouncement is a committee member's announcement that it stored the batch with the given
+// hash until the given timeout.
+type BatchAnnouncement struct {
+	Hash      common.Hash `json:"hash"`
+	Timeout   uint64      `json:"timeout"`
+	Signature []byte      `json:"signature"`
+}
+
+func (a *BatchAnnouncement) signableFields() []byte {
+	return crypto.Keccak256(batchAnnouncementDomain, a.Hash[:], binary.BigEndian.AppendUint64(nil, a.Timeout))
+}
+
+func (a *BatchAnnouncement) verify(pubKey blsSignatures.PublicKey) error {
+	sig, err := blsSignatures.SignatureFromBytes(a.Signature)
+	if err != nil {
+		return err
+	}
+	valid, err := blsSignatures.VerifySignature(sig, a.signableFields(), pubKey)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("announcement signature is invalid")
+	}
+	return nil
+}
+
+// BatchAnnouncer signs the announcements of the batches a committee member stores and sends them
+// to the subscribers of its feed, which the REST server serves as a websocket. Announcements are
+// best effort: subscribers that fall behind miss them rather than slow down stores.
+type BatchAnnouncer struct {
+	config  *BatchAnnouncementConfig
+	privKey blsSignatures.PrivateKey
+
+	subscribersMutex sync.Mutex
+	subscribers      map[chan []byte]struct{}
+	closed           bool
+}
+
+func NewBatchAnnouncer(config *BatchAnnouncementConfig, privKey blsSignatures.PrivateKey) *BatchAnnouncer {
+	return &BatchAnnouncer{
+		config:      config,
+		privKey:     privKey,
+		subscribers: make(map[chan []byte]struct{}),
+	}
+}
+
+// Announce signs the announcement of the batch and sends it to every subscriber.
+func (b *BatchAnnouncer) Announce(hash common.Hash, timeout uint64) error {
+	announcement := BatchAnnouncement{Hash: hash, Timeout: timeout}
+	sig, err := blsSignatures.SignMessage(b.privKey, announcement.signableFields())
+	if err != nil {
+		return err
+	}
+	announcement.Signature = blsSignatures.SignatureToBytes(sig)
+	message, err := json.Marshal(announcement)
+	if err != nil {
+		return err
+	}
+
+	b.subscribersMutex.Lock()
+	defer b.subscribersMutex.Unlock()
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- message:
+			announcementsSentCounter.Inc(1)
+		default:
+			announcementsDroppedCounter.Inc(1)
+			log.Warn("BatchAnnouncer subscriber is backed up, dropping announcement", "hash", pretty.PrettyHash(hash))
+		}
+	}
+	return nil
+}
+
+func (b *BatchAnnouncer) subscribe() (<-chan []byte, func()) {
+	b.subscribersMutex.Lock()
+	defer b.subscribersMutex.Unlock()
+	subscriber := make(chan []byte, b.config.QueueSize)
+	if b.closed {
+		close(subscriber)
+		return subscriber, func() {}
+	}
+	b.subscribers[subscriber] = struct{}{}
+	announcementsSubscribersGauge.Inc(1)
+	unsubscribe := func() {
+		b.subscribersMutex.Lock()
+		defer b.subscribersMutex.Unlock()
+		if _, ok := b.subscribers[subscriber]; ok {
+			delete(b.subscribers, subscriber)
+			announcementsSubscribersGauge.Dec(1)
+			close(subscriber)
+		}
+	}
+	return subscriber, unsubscribe
+}
+
+// ServeHTTP upgrades the request to a websocket, and sends the announcements made from then on
+// over it as text messages.
+func (b *BatchAnnouncer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, _, _, err := ws.UpgradeHTTP(r, w)
+	if err != nil {
+		log.Warn("Failed to upgrade announcement feed request", "err", err, "remoteAddr", r.RemoteAddr)
+		return
+	}
+	defer conn.Close()
+	// The REST server's timeouts are meant for requests, not for the lifetime of the feed.
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		log.Warn("Failed to clear announcement feed deadlines", "err", err, "remoteAddr", r.RemoteAddr)
+		return
+	}
+	announcements, unsubscribe := b.subscribe()
+	defer unsubscribe()
+
+	// Subscribers don't send anything but control frames, which are handled while waiting for
+	// them to hang up.
+	hungUp := make(chan struct{})
+	go func() {
+		defer close(hungUp)
+		for {
+			if _, _, err := wsutil.ReadClientData(conn); err != nil {
+				return
+			}
+		}
+	}()
+
+	pingTicker := time.NewTicker(b.config.PingInterval)
+	defer pingTicker.Stop()
+	for {
+		var op ws.OpCode
+		var message []byte
+		select {
+		case <-hungUp:
+			return
+		case announcement, ok := <-announcements:
+			if !ok {
+				return
+			}
+			op, message = ws.OpText, announcement
+		case <-pingTicker.C:
+			op = ws.OpPing
+		}
+		if err := conn.SetWriteDeadline(time.Now().Add(b.config.WriteTimeout)); err != nil {
+			return
+		}
+		if err := wsutil.WriteServerMessage(conn, op, message); err != nil {
+			log.Info("Dropping announcement feed subscriber", "err", err, "remoteAddr", r.RemoteAddr)
+			return
+		}
+	}
+}
+
+// Close ends the feed of every subscriber.
+func (b *BatchAnnouncer) Close(ctx context.Context) error {
+	b.subscribersMutex.Lock()
+	defer b.subscribersMutex.Unlock()
+	b.closed = true
+	for subscriber := range b.subscribers {
+		delete(b.subscribers, subscriber)
+		announcementsSubscribersGauge.Dec(1)
+		close(subscriber)
+	}
+	return nil
+}
+
+func (b *BatchAnnouncer) String() string {
+	return "BatchAnnouncer"
+}
+
+type announcementFeed struct {
+	url    string
+	pubKey blsSignatures.PublicKey
+	client *RestfulDasClient
+}
+
+// BatchAnnouncementSubscriber follows the announcement feeds of committee members, and fetches
+// the batches they announce from them into the storage as soon as they are announced, so that
+// they are there before anyone asks this server for them.
+type BatchAnnouncementSubscriber struct {
+	stopwaiter.StopWaiter
+
+	config  *BatchAnnouncementSubscriberConfig
+	feeds   []*announcementFeed
+	storage StorageService
+	// seen are the batches announced recently, which another member's announcement of is ignored.
+	seen *lru.Cache[common.Hash, struct{}]
+	// prefetches holds a token for each batch being prefetched.
+	prefetches       chan struct{}
+	retentionSeconds uint64
+}
+
+func NewBatchAnnouncementSubscriber(config *BatchAnnouncementSubscriberConfig, storage StorageService) (*BatchAnnouncementSubscriber, error) {
+	if len(config.Feeds) == 0 {
+		return nil, errors.New("announcements.subscribe is enabled but no feeds were specified")
+	}
+	if config.MaxConcurrentPrefetches <= 0 {
+		return nil, fmt.Errorf("announcements.subscribe.max-concurrent-prefetches must be positive, got %d", config.MaxConcurrentPrefetches)
+	}
+	retentionSeconds := uint64(config.RetentionPeriod.Seconds())
+	if uint64(config.RetentionPeriod) == math.MaxUint64 {
+		retentionSeconds = math.MaxUint64
+	}
+	s := &BatchAnnouncementSubscriber{
+		config:           config,
+		storage:          storage,
+		seen:             lru.NewCache[common.Hash, struct{}](1024),
+		prefetches:       make(chan struct{}, config.MaxConcurrentPrefetches),
+		retentionSeconds: retentionSeconds,
+	}
+	for _, feedConfig := range config.Feeds {
+		client, err := NewRestfulDasClientFromURL(feedConfig.URL)
+		if err != nil {
+			return nil, err
+		}
+		pubKey, err := DecodeBase64BLSPublicKey([]byte(feedConfig.Pubkey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key of announcement feed %s: %w", feedConfig.URL, err)
+		}
+		s.feeds = append(s.feeds, &announcementFeed{
+			url:    strings.TrimSuffix(feedConfig.URL, "/"),
+			pubKey: *pubKey,
+			client: client,
+		})
+	}
+	return s, nil
+}
+
+func (s *BatchAnnouncementSubscriber) Start(ctx context.Context) {
+	s.StopWaiter.Start(ctx, s)
+	for _, feed := range s.feeds {
+		s.LaunchThread(func(ctx context.Context) {
+			for ctx.Err() == nil {
+				err := s.follow(ctx, feed)
+				if ctx.Err() != nil {
+					return
+				}
+				log.Warn("Lost batch announcement feed, reconnecting", "url", feed.url, "err", err, "reconnectInterval", s.config.ReconnectInterval)
+				select {
+				case <-ctx.Done():
+				case <-time.After(s.config.ReconnectInterval):
+				}
+			}
+		})
+	}
+}
+
+// follow connects to the feed and handles its announcements until it fails.
+func (s *BatchAnnouncementSubscriber) follow(ctx context.Context, feed *announcementFeed) error {
+	conn, _, _, err := ws.Dial(ctx, "ws"+strings.TrimPrefix(feed.url, "http")+announcementsRequestPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stopClosing := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopClosing()
+	log.Info("Following batch announcement feed", "url", feed.url)
+
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(s.config.ReadTimeout)); err != nil {
+			return err
+		}
+		messages, err := wsutil.ReadServerMessage(conn, nil)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			switch message.OpCode {
+			case ws.OpPing:
+				if err := wsutil.WriteClientMessage(conn, ws.OpPong, message.Payload); err != nil {
+					return err
+				}
+			case ws.OpClose:
+				return errors.New("feed was closed")
+			case ws.OpText:
+				s.handle(feed, message.Payload)
+			}
+		}
+	}
+}
+
+func (s *BatchAnnouncementSubscriber) handle(feed *announcementFeed, message []byte) {
+	announcementsReceivedCounter.Inc(1)
+	var announcement BatchAnnouncement
+	if err := json.Unmarshal(message, &announcement); err != nil {
+		announcementsInvalidCounter.Inc(1)
+		log.Warn("Failed to decode batch announcement", "url", feed.url, "err", err)
+		return
+	}
+	if err := announcement.verify(feed.pubKey); err != nil {
+		announcementsInvalidCounter.Inc(1)
+		log.Warn("Ignoring batch announcement not signed by the feed's member", "url", feed.url, "hash", pretty.PrettyHash(announcement.Hash), "err", err)
+		return
+	}
+	if announcement.Timeout < uint64(time.Now().Unix()) {
+		return
+	}
+	if s.seen.Contains(announcement.Hash) {
+		return
+	}
+	select {
+	case s.prefetches <- struct{}{}:
+	default:
+		announcementsPrefetchDroppedCounter.Inc(1)
+		log.Debug("Too many announced batches being prefetched, dropping announcement", "url", feed.url, "hash", pretty.PrettyHash(announcement.Hash))
+		return
+	}
+	s.seen.Add(announcement.Hash, struct{}{})
+	s.LaunchThread(func(ctx context.Context) {
+		defer func() { <-s.prefetches }()
+		if err := s.prefetch(ctx, feed, &announcement); err != nil {
+			announcementsPrefetchFailedCounter.Inc(1)
+			log.Warn("Failed to prefetch announced batch", "url", feed.url, "hash", pretty.PrettyHash(announcement.Hash), "err", err)
+			// Let another member's announcement of it try again.
+			s.seen.Remove(announcement.Hash)
+		}
+	})
+}
+
+func (s *BatchAnnouncementSubscriber) prefetch(ctx context.Context, feed *announcementFeed, announcement *BatchAnnouncement) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.FetchTimeout)
+	defer cancel()
+	if _, err := s.storage.GetByHash(ctx, announcement.Hash); err == nil {
+		return nil
+	}
+	// The client checks the batch against its hash.
+	data, err := feed.client.GetByHash(ctx, announcement.Hash)
+	if err != nil {
+		return err
+	}
+	// The member chose the timeout, so it is kept to this server's own retention period.
+	expiry := arbmath.MinInt(announcement.Timeout, arbmath.SaturatingUAdd(uint64(time.Now().Unix()), s.retentionSeconds))
+	if err := s.storage.Put(ctx, data, expiry); err != nil {
+		return err
+	}
+	announcementsPrefetchedCounter.Inc(1)
+	log.Debug("Prefetched announced batch", "url", feed.url, "hash", pretty.PrettyHash(announcement.Hash))
+	return nil
+}
+
+func (s *BatchAnnouncementSubscriber) Close(ctx context.Context) error {
+	s.StopWaiter.StopOnly()
+	waitChan, err := s.StopWaiter.GetWaitChannel()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-waitChan:
+		return nil
+	}
+}
+
+func (s *BatchAnnouncementSubscriber) String() string {
+	return "BatchAnnouncementSubscriber"
+}
@@ -0,0 +1,138 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/blsSignatures"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/testhelpers"
+)
+
+// expiryRecordingStorage records the expiry each batch was last stored with.
+type expiryRecordingStorage struct {
+	StorageService
+	mutex    sync.Mutex
+	expiries map[common.Hash]uint64
+}
+
+func (s *expiryRecordingStorage) Put(ctx context.Context, data []byte, expiry uint64) error {
+	s.mutex.Lock()
+	s.expiries[dastree.Hash(data)] = expiry
+	s.mutex.Unlock()
+	return s.StorageService.Put(ctx, data, expiry)
+}
+
+func TestBatchAnnouncements(t *testing.T) {
+	initTest(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pubKey, privKey, err := blsSignatures.GenerateKeys()
+	Require(t, err)
+	config := DefaultBatchAnnouncementConfig
+	config.Enable = true
+	announcer := NewBatchAnnouncer(&config, privKey)
+	defer func() { Require(t, announcer.Close(ctx)) }()
+
+	memberStorage := NewMemoryBackedStorageService(ctx)
+	server, port, err := NewRestfulDasServerOnRandomPort(LocalServerAddressForTest, memberStorage)
+	Require(t, err)
+	defer func() { Require(t, server.Shutdown()) }()
+	server.ServeAnnouncements(announcer)
+
+	mirrorStorage := &expiryRecordingStorage{
+		StorageService: NewMemoryBackedStorageService(ctx),
+		expiries:       make(map[common.Hash]uint64),
+	}
+	subscriberConfig := DefaultBatchAnnouncementSubscriberConfig
+	subscriberConfig.Enable = true
+	subscriberConfig.ReconnectInterval = 100 * time.Millisecond
+	subscriberConfig.MaxConcurrentPrefetches = 1
+	subscriberConfig.RetentionPeriod = time.Minute
+	subscriberConfig.Feeds = BackendConfigList{{
+		URL:    "http://" + LocalServerAddressForTest + ":" + strconv.Itoa(port),
+		Pubkey: base64.StdEncoding.EncodeToString(blsSignatures.PublicKeyToBytes(pubKey)),
+	}}
+	subscriber, err := NewBatchAnnouncementSubscriber(&subscriberConfig, mirrorStorage)
+	Require(t, err)
+	subscriber.Start(ctx)
+	defer func() { Require(t, subscriber.Close(ctx)) }()
+
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		announcer.subscribersMutex.Lock()
+		subscribed := len(announcer.subscribers) > 0
+		announcer.subscribersMutex.Unlock()
+		if subscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			Fail(t, "mirror didn't subscribe to the announcement feed")
+		}
+	}
+
+	data := testhelpers.RandomizeSlice(make([]byte, 2*dastree.BinSize+7))
+	dataHash := dastree.Hash(data)
+	timeout := uint64(time.Now().Add(time.Hour).Unix())
+	Require(t, memberStorage.Put(ctx, data, timeout))
+	Require(t, announcer.Announce(dataHash, timeout))
+
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		prefetched, err := mirrorStorage.GetByHash(ctx, dataHash)
+		if err == nil {
+			if !bytes.Equal(prefetched, data) {
+				Fail(t, "mirror prefetched the wrong batch")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			Fail(t, "mirror didn't prefetch the announced batch", err)
+		}
+	}
+	mirrorStorage.mutex.Lock()
+	expiry := mirrorStorage.expiries[dataHash]
+	mirrorStorage.mutex.Unlock()
+	if expiry > uint64(time.Now().Add(time.Minute).Unix()) {
+		Fail(t, "mirror stored the batch past its retention period, until", expiry, "announced timeout", timeout)
+	}
+
+	// Announcements arriving while the mirror is prefetching as many batches as it may are dropped.
+	busy := BatchAnnouncement{Hash: dastree.Hash([]byte("busy")), Timeout: timeout}
+	sig, err := blsSignatures.SignMessage(privKey, busy.signableFields())
+	Require(t, err)
+	busy.Signature = blsSignatures.SignatureToBytes(sig)
+	message, err := json.Marshal(busy)
+	Require(t, err)
+	subscriber.prefetches <- struct{}{}
+	subscriber.handle(subscriber.feeds[0], message)
+	<-subscriber.prefetches
+	if subscriber.seen.Contains(busy.Hash) {
+		Fail(t, "mirror prefetched more batches at once than it may")
+	}
+
+	// An announcement signed by someone other than the feed's member is ignored.
+	_, otherPrivKey, err := blsSignatures.GenerateKeys()
+	Require(t, err)
+	forged := BatchAnnouncement{Hash: dastree.Hash([]byte("forged")), Timeout: timeout}
+	sig, err = blsSignatures.SignMessage(otherPrivKey, forged.signableFields())
+	Require(t, err)
+	forged.Signature = blsSignatures.SignatureToBytes(sig)
+	message, err = json.Marshal(forged)
+	Require(t, err)
+	subscriber.handle(subscriber.feeds[0], message)
+	if subscriber.seen.Contains(forged.Hash) {
+		Fail(t, "mirror accepted an announcement signed with the wrong key")
+	}
+}
@@ -64,6 +64,13 @@ func (c *CacheStorageService) GetByHash(ctx context.Context, key common.Hash) ([
 	return val, nil
 }
 
+func (c *CacheStorageService) Has(ctx context.Context, key common.Hash) (bool, error) {
+	if c.cache.Contains(key) {
+		return true, nil
+	}
+	return hasByHash(ctx, c.baseStorageService, key)
+}
+
 func (c *CacheStorageService) Put(ctx context.Context, value []byte, timeout uint64) error {
 	logPut("das.CacheStorageService.Put", value, timeout, c)
 	err := c.baseStorageService.Put(ctx, value, timeout)
@@ -36,6 +36,9 @@ func (c *syncedKeysetCache) put(key [32]byte, value []byte) {
 	c.cache[key] = value
 }
 
+// KeysetFetcher fetches keysets registered with setValidKeyset from the sequencer inbox. It
+// returns the serialized keyset, legacy or versioned, for the reader to deserialize according
+// to the kind of batch it is reading.
 type KeysetFetcher struct {
 	seqInboxCaller   *bridgegen.SequencerInboxCaller
 	seqInboxFilterer *bridgegen.SequencerInboxFilterer
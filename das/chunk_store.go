@@ -0,0 +1,213 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/arbmath"
+)
+
+// Batches stored chunked are split into the leaves of their dastree, the chunks, each of which is
+// stored once under its hash however many batches contain it. In place of the batch a manifest
+// listing its chunks is stored, where the batch would have been stored whole, so that the storage's
+// index of batches, expiry and listing work the same whether batches are chunked or not.
+//
+// A chunk is kept as long as a batch references it. Chunks are stored and referenced before the
+// manifest, and the manifest is removed before its references are, so a failure part way leaks
+// chunks at worst and never leaves a manifest without its chunks.
+
+var (
+	chunksStoredCounter       = metrics.NewRegisteredCounter("arb/das/chunks/stored/total", nil)
+	chunksDeduplicatedCounter = metrics.NewRegisteredCounter("arb/das/chunks/deduplicated/total", nil)
+	chunksDeletedCounter      = metrics.NewRegisteredCounter("arb/das/chunks/deleted/total", nil)
+	// chunksReleaseFailureCounter counts pruned batches whose chunks may have been orphaned.
+	chunksReleaseFailureCounter = metrics.NewRegisteredCounter("arb/das/chunks/release/failure/total", nil)
+)
+
+var chunkManifestMagic = []byte("DASCHUNKS\x00")
+
+// chunkObjects is how a storage keeps chunks and their references by batches.
+type chunkObjects interface {
+	getChunk(ctx context.Context, hash common.Hash) ([]byte, error)
+	hasChunk(ctx context.Context, hash common.Hash) (bool, error)
+	putChunk(ctx context.Context, hash common.Hash, chunk []byte) error
+	deleteChunk(ctx context.Context, hash common.Hash) error
+	// addReference records that the batch references the chunk, which must be stored. Recording
+	// it again has no effect.
+	addReference(ctx context.Context, chunk, batch common.Hash) error
+	removeReference(ctx context.Context, chunk, batch common.Hash) error
+	// referenced is whether any batch references the chunk.
+	referenced(ctx context.Context, chunk common.Hash) (bool, error)
+}
+
+type chunkManifest struct {
+	size   uint32
+	chunks []common.Hash
+}
+
+func (m *chunkManifest) serialize() []byte {
+	manifest := make([]byte, 0, len(chunkManifestMagic)+4+len(m.chunks)*common.HashLength)
+	manifest = append(manifest, chunkManifestMagic...)
+	manifest = binary.BigEndian.AppendUint32(manifest, m.size)
+	for _, chunk := range m.chunks {
+		manifest = append(manifest, chunk[:]...)
+	}
+	return manifest
+}
+
+// parseChunkManifest parses what was stored for the batch with the given root as a manifest, or
+// returns false if it is the batch itself. A batch that happens to look like a manifest is told
+// apart by its hash.
+func parseChunkManifest(root common.Hash, stored []byte) (*chunkManifest, bool) {
+	if !bytes.HasPrefix(stored, chunkManifestMagic) {
+		return nil, false
+	}
+	rest := stored[len(chunkManifestMagic):]
+	if len(rest) < 4 || (len(rest)-4)%common.HashLength != 0 {
+		return nil, false
+	}
+	manifest := &chunkManifest{size: binary.BigEndian.Uint32(rest)}
+	for rest = rest[4:]; len(rest) > 0; rest = rest[common.HashLength:] {
+		manifest.chunks = append(manifest.chunks, common.BytesToHash(rest[:common.HashLength]))
+	}
+	if uint32(len(manifest.chunks)) != dastree.LeafCount(manifest.size) || dastree.Hash(stored) == root {
+		return nil, false
+	}
+	return manifest, true
+}
+
+// storeChunks stores the chunks of the batch that aren't already, references them from the batch,
+// and returns the manifest to store in place of the batch.
+func storeChunks(ctx context.Context, objects chunkObjects, root common.Hash, data []byte) ([]byte, error) {
+	if uint64(len(data)) > uint64(^uint32(0)) {
+		return nil, fmt.Errorf("batch of %d bytes is too large to chunk", len(data))
+	}
+	manifest := chunkManifest{size: uint32(len(data))}
+	referenced := make(map[common.Hash]struct{})
+	for i := uint32(0); i < dastree.LeafCount(manifest.size); i++ {
+		start := int(i) * dastree.BinSize
+		chunk := data[start:arbmath.MinInt(start+dastree.BinSize, len(data))]
+		hash := crypto.Keccak256Hash(chunk)
+		manifest.chunks = append(manifest.chunks, hash)
+		if _, ok := referenced[hash]; ok {
+			chunksDeduplicatedCounter.Inc(1)
+			continue
+		}
+		referenced[hash] = struct{}{}
+
+		stored, err := objects.hasChunk(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if stored {
+			chunksDeduplicatedCounter.Inc(1)
+		} else {
+			if err := objects.putChunk(ctx, hash, chunk); err != nil {
+				return nil, fmt.Errorf("couldn't store chunk %d of batch %v: %w", i, root, err)
+			}
+			chunksStoredCounter.Inc(1)
+		}
+		if err := objects.addReference(ctx, hash, root); err != nil {
+			return nil, fmt.Errorf("couldn't reference chunk %d of batch %v: %w", i, root, err)
+		}
+	}
+	return manifest.serialize(), nil
+}
+
+// loadChunks returns the batch with the given root from what was stored for it, reassembling it
+// from its chunks if that is a manifest.
+func loadChunks(ctx context.Context, objects chunkObjects, root common.Hash, stored []byte) ([]byte, error) {
+	manifest, ok := parseChunkManifest(root, stored)
+	if !ok {
+		return stored, nil
+	}
+	data := make([]byte, 0, manifest.size)
+	for i, hash := range manifest.chunks {
+		chunk, err := objects.getChunk(ctx, hash)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read chunk %d of batch %v: %w", i, root, err)
+		}
+		if crypto.Keccak256Hash(chunk) != hash {
+			return nil, fmt.Errorf("chunk %d of batch %v is corrupt", i, root)
+		}
+		data = append(data, chunk...)
+	}
+	if uint64(len(data)) != uint64(manifest.size) || dastree.Hash(data) != root {
+		return nil, fmt.Errorf("chunks of batch %v don't reassemble into it", root)
+	}
+	return data, nil
+}
+
+// mayBeChunkManifest is whether what was stored for a batch could be a manifest, going by its
+// size, so that telling whether a batch is stored only needs to read the manifests.
+func mayBeChunkManifest(size int64) bool {
+	rest := size - int64(len(chunkManifestMagic)) - 4
+	return rest >= 0 && rest%common.HashLength == 0
+}
+
+// hasChunks reports whether the chunks of the batch with the given root are all stored, if what
+// was stored for it is a manifest.
+func hasChunks(ctx context.Context, objects chunkObjects, root common.Hash, stored []byte) (bool, error) {
+	manifest, ok := parseChunkManifest(root, stored)
+	if !ok {
+		return true, nil
+	}
+	checked := make(map[common.Hash]struct{})
+	for _, hash := range manifest.chunks {
+		if _, ok := checked[hash]; ok {
+			continue
+		}
+		checked[hash] = struct{}{}
+		stored, err := objects.hasChunk(ctx, hash)
+		if err != nil || !stored {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// releaseChunks drops the references of the batch with the given root to its chunks, if what was
+// stored for it is a manifest, and deletes the chunks no other batch references. The manifest
+// must have been removed already, so a failure leaves the chunks it didn't get to orphaned, and is
+// counted as such.
+func releaseChunks(ctx context.Context, objects chunkObjects, root common.Hash, stored []byte) (err error) {
+	defer func() {
+		if err != nil {
+			chunksReleaseFailureCounter.Inc(1)
+		}
+	}()
+	manifest, ok := parseChunkManifest(root, stored)
+	if !ok {
+		return nil
+	}
+	released := make(map[common.Hash]struct{})
+	for _, hash := range manifest.chunks {
+		if _, ok := released[hash]; ok {
+			continue
+		}
+		released[hash] = struct{}{}
+		if err := objects.removeReference(ctx, hash, root); err != nil {
+			return err
+		}
+		referenced, err := objects.referenced(ctx, hash)
+		if err != nil {
+			return err
+		}
+		if !referenced {
+			if err := objects.deleteChunk(ctx, hash); err != nil {
+				return err
+			}
+			chunksDeletedCounter.Inc(1)
+		}
+	}
+	return nil
+}
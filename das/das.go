@@ -16,6 +16,7 @@ import (
 	flag "github.com/spf13/pflag"
 
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/das/erasure"
 )
 
 type DataAvailabilityServiceWriter interface {
@@ -24,6 +25,13 @@ type DataAvailabilityServiceWriter interface {
 	fmt.Stringer
 }
 
+// DataAvailabilityServiceShardWriter is a committee member that can store just its erasure-coded
+// shard of a batch, and signs the certificate of the whole batch once it has.
+type DataAvailabilityServiceShardWriter interface {
+	// StoreShard requests that the shard be stored until timeout (UTC time in unix epoch seconds).
+	StoreShard(ctx context.Context, shard *erasure.Shard, timeout uint64) (*daprovider.DataAvailabilityCertificate, error)
+}
+
 type DataAvailabilityServiceReader interface {
 	daprovider.DASReader
 	fmt.Stringer
@@ -50,9 +58,15 @@ type DataAvailabilityConfig struct {
 
 	Key KeyConfig `koanf:"key"`
 
+	Announcements BatchAnnouncementConfig `koanf:"announcements"`
+
 	RPCAggregator  AggregatorConfig              `koanf:"rpc-aggregator"`
 	RestAggregator RestfulClientAggregatorConfig `koanf:"rest-aggregator"`
 
+	// HybridPolicy is which of the committee and the IC must vouch for a batch when a batch
+	// poster has both rpc-aggregator and ic-storage enabled.
+	HybridPolicy string `koanf:"hybrid-policy"`
+
 	ParentChainNodeURL              string `koanf:"parent-chain-node-url"`
 	ParentChainConnectionAttempts   int    `koanf:"parent-chain-connection-attempts"`
 	SequencerInboxAddress           string `koanf:"sequencer-inbox-address"`
@@ -67,10 +81,36 @@ var DefaultDataAvailabilityConfig = DataAvailabilityConfig{
 	Enable:                        false,
 	RestAggregator:                DefaultRestfulClientAggregatorConfig,
 	RPCAggregator:                 DefaultAggregatorConfig,
+	ICStorage:                     DefaultICStorageConfig,
+	Announcements:                 DefaultBatchAnnouncementConfig,
+	HybridPolicy:                  HybridPolicyBoth,
 	ParentChainConnectionAttempts: 15,
 	PanicOnError:                  false,
 }
 
+const (
+	HybridPolicyBoth   = "both"
+	HybridPolicyEither = "either"
+)
+
+func (c *DataAvailabilityConfig) Validate() error {
+	if err := c.ICStorage.Validate(); err != nil {
+		return fmt.Errorf("invalid data-availability config: %w", err)
+	}
+	return nil
+}
+
+func ParseHybridPolicy(policy string) (daprovider.HybridPolicy, error) {
+	switch policy {
+	case HybridPolicyBoth:
+		return daprovider.HybridPolicyRequireBoth, nil
+	case HybridPolicyEither:
+		return daprovider.HybridPolicyRequireEither, nil
+	default:
+		return 0, fmt.Errorf("invalid hybrid-policy %q: must be %q or %q", policy, HybridPolicyBoth, HybridPolicyEither)
+	}
+}
+
 func OptionalAddressFromString(s string) (*common.Address, error) {
 	if s == "none" {
 		return nil, nil
@@ -112,7 +152,6 @@ func dataAvailabilityConfigAddOptions(prefix string, f *flag.FlagSet, r role) {
 		RedisConfigAddOptions(prefix+".redis-cache", f)
 
 		// Storage options
-		ICStorageConfigAddOptions(prefix+".ic-storage", f)
 		LocalDBStorageConfigAddOptions(prefix+".local-db-storage", f)
 		LocalFileStorageConfigAddOptions(prefix+".local-file-storage", f)
 		S3ConfigAddOptions(prefix+".s3-storage", f)
@@ -121,16 +160,20 @@ func dataAvailabilityConfigAddOptions(prefix string, f *flag.FlagSet, r role) {
 		// Key config for storage
 		KeyConfigAddOptions(prefix+".key", f)
 
+		BatchAnnouncementConfigAddOptions(prefix+".announcements", f)
+
 		f.String(prefix+".extra-signature-checking-public-key", DefaultDataAvailabilityConfig.ExtraSignatureCheckingPublicKey, "public key to use to validate Data Availability Store requests in addition to the Sequencer's public key determined using sequencer-inbox-address, can be a file or the hex-encoded public key beginning with 0x; useful for testing")
 	}
 	if r == roleNode {
 		// These are only for batch poster
 		AggregatorConfigAddOptions(prefix+".rpc-aggregator", f)
+		f.String(prefix+".hybrid-policy", DefaultDataAvailabilityConfig.HybridPolicy, "whether \"both\" the committee and the IC, or \"either\" of them, must vouch for batches posted with hybrid certificates, which is the case when rpc-aggregator and ic-storage are both enabled")
 		f.Duration(prefix+".request-timeout", DefaultDataAvailabilityConfig.RequestTimeout, "Data Availability Service timeout duration for Store requests")
 	}
 
 	// Both the Nitro node and daserver can use these options.
 	RestfulClientAggregatorConfigAddOptions(prefix+".rest-aggregator", f)
+	ICStorageConfigAddOptions(prefix+".ic-storage", f)
 
 	f.String(prefix+".parent-chain-node-url", DefaultDataAvailabilityConfig.ParentChainNodeURL, "URL for parent chain node, only used in standalone daserver; when running as part of a node that node's L1 configuration is used")
 	f.Int(prefix+".parent-chain-connection-attempts", DefaultDataAvailabilityConfig.ParentChainConnectionAttempts, "parent chain RPC connection attempts (spaced out at least 1 second per attempt, 0 to retry infinitely), only used in standalone daserver; when running as part of a node that node's parent chain configuration is used")
@@ -16,6 +16,8 @@ import (
 
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/blsSignatures"
+	"github.com/offchainlabs/nitro/das/erasure"
 	"github.com/offchainlabs/nitro/util/pretty"
 	"github.com/offchainlabs/nitro/util/signature"
 )
@@ -57,6 +59,20 @@ func NewDASRPCClient(target string, signer signature.DataSignerFunc, maxStoreChu
 }
 
 func (c *DASRPCClient) Store(ctx context.Context, message []byte, timeout uint64) (*daprovider.DataAvailabilityCertificate, error) {
+	cert, err := c.chunkedStore(ctx, message, timeout, "das_commitChunkedStore", []byte{})
+	if err != nil && strings.Contains(err.Error(), "the method das_startChunkedStore does not exist") {
+		return c.legacyStore(ctx, message, timeout)
+	}
+	return cert, err
+}
+
+// StoreShard uploads the serialized shard in chunks like a batch, and commits it as a shard.
+func (c *DASRPCClient) StoreShard(ctx context.Context, shard *erasure.Shard, timeout uint64) (*daprovider.DataAvailabilityCertificate, error) {
+	log.Trace("das.DASRPCClient.StoreShard(...)", "root", shard.Root, "index", shard.Index, "timeout", time.Unix(int64(timeout), 0), "this", *c)
+	return c.chunkedStore(ctx, shard.Serialize(), timeout, "das_commitChunkedStoreShard", shardCommitMessage)
+}
+
+func (c *DASRPCClient) chunkedStore(ctx context.Context, message []byte, timeout uint64, commitMethod string, commitMessage []byte) (*daprovider.DataAvailabilityCertificate, error) {
 	timestamp := uint64(time.Now().Unix())
 	nChunks := uint64(len(message)) / c.chunkSize
 	lastChunkSize := uint64(len(message)) % c.chunkSize
@@ -74,9 +90,6 @@ func (c *DASRPCClient) Store(ctx context.Context, message []byte, timeout uint64
 
 	var startChunkedStoreResult StartChunkedStoreResult
 	if err := c.clnt.CallContext(ctx, &startChunkedStoreResult, "das_startChunkedStore", hexutil.Uint64(timestamp), hexutil.Uint64(nChunks), hexutil.Uint64(c.chunkSize), hexutil.Uint64(totalSize), hexutil.Uint64(timeout), hexutil.Bytes(startReqSig)); err != nil {
-		if strings.Contains(err.Error(), "the method das_startChunkedStore does not exist") {
-			return c.legacyStore(ctx, message, timeout)
-		}
 		return nil, err
 	}
 	batchId := uint64(startChunkedStoreResult.BatchId)
@@ -99,28 +112,31 @@ func (c *DASRPCClient) Store(ctx context.Context, message []byte, timeout uint64
 		return nil, err
 	}
 
-	finalReqSig, err := applyDasSigner(c.signer, []byte{}, uint64(startChunkedStoreResult.BatchId))
+	finalReqSig, err := applyDasSigner(c.signer, commitMessage, uint64(startChunkedStoreResult.BatchId))
 	if err != nil {
 		return nil, err
 	}
 
 	var storeResult StoreResult
-	if err := c.clnt.CallContext(ctx, &storeResult, "das_commitChunkedStore", startChunkedStoreResult.BatchId, hexutil.Bytes(finalReqSig)); err != nil {
+	if err := c.clnt.CallContext(ctx, &storeResult, commitMethod, startChunkedStoreResult.BatchId, hexutil.Bytes(finalReqSig)); err != nil {
 		return nil, err
 	}
 
-	// respSig, err := blsSignatures.SignatureFromBytes(storeResult.Sig)
-	// if err != nil {
-	// 	return nil, err
-	// }
+	respSig, err := blsSignatures.SignatureFromBytes(storeResult.Sig)
+	if err != nil {
+		return nil, err
+	}
 
 	return &daprovider.DataAvailabilityCertificate{
 		DataHash:    common.BytesToHash(storeResult.DataHash),
 		Timeout:     uint64(storeResult.Timeout),
 		SignersMask: uint64(storeResult.SignersMask),
-		Sig:         storeResult.Sig,
+		Sig:         respSig,
 		KeysetHash:  common.BytesToHash(storeResult.KeysetHash),
 		Version:     byte(storeResult.Version),
+		Commitment:  common.BytesToHash(storeResult.Commitment),
+		DataShards:  byte(storeResult.DataShards),
+		TotalShards: byte(storeResult.TotalShards),
 	}, nil
 }
 
@@ -149,15 +165,15 @@ func (c *DASRPCClient) legacyStore(ctx context.Context, message []byte, timeout
 		return nil, err
 	}
 
-	// respSig, err := blsSignatures.SignatureFromBytes(ret.Sig)
-	// if err != nil {
-	// 	return nil, err
-	// }
+	respSig, err := blsSignatures.SignatureFromBytes(ret.Sig)
+	if err != nil {
+		return nil, err
+	}
 	return &daprovider.DataAvailabilityCertificate{
 		DataHash:    common.BytesToHash(ret.DataHash),
 		Timeout:     uint64(ret.Timeout),
 		SignersMask: uint64(ret.SignersMask),
-		Sig:         ret.Sig,
+		Sig:         respSig,
 		KeysetHash:  common.BytesToHash(ret.KeysetHash),
 		Version:     byte(ret.Version),
 	}, nil
@@ -20,7 +20,9 @@ import (
 
 	"github.com/ethereum/go-ethereum/rpc"
 
+	"github.com/offchainlabs/nitro/blsSignatures"
 	"github.com/offchainlabs/nitro/cmd/genericconf"
+	"github.com/offchainlabs/nitro/das/erasure"
 	"github.com/offchainlabs/nitro/util/pretty"
 )
 
@@ -104,6 +106,10 @@ type StoreResult struct {
 	KeysetHash  hexutil.Bytes  `json:"keysetHash,omitempty"`
 	Sig         hexutil.Bytes  `json:"sig,omitempty"`
 	Version     hexutil.Uint64 `json:"version,omitempty"`
+	// Commitment, DataShards and TotalShards are only set for erasure-coded certificates.
+	Commitment  hexutil.Bytes  `json:"commitment,omitempty"`
+	DataShards  hexutil.Uint64 `json:"dataShards,omitempty"`
+	TotalShards hexutil.Uint64 `json:"totalShards,omitempty"`
 }
 
 func (s *DASRPCServer) Store(ctx context.Context, message hexutil.Bytes, timeout hexutil.Uint64, sig hexutil.Bytes) (*StoreResult, error) {
@@ -135,7 +141,7 @@ func (s *DASRPCServer) Store(ctx context.Context, message hexutil.Bytes, timeout
 		DataHash:    cert.DataHash[:],
 		Timeout:     hexutil.Uint64(cert.Timeout),
 		SignersMask: hexutil.Uint64(cert.SignersMask),
-		Sig:         nil,
+		Sig:         blsSignatures.SignatureToBytes(cert.Sig),
 		Version:     hexutil.Uint64(cert.Version),
 	}, nil
 }
@@ -344,11 +350,63 @@ func (s *DASRPCServer) CommitChunkedStore(ctx context.Context, batchId hexutil.U
 		DataHash:    cert.DataHash[:],
 		Timeout:     hexutil.Uint64(cert.Timeout),
 		SignersMask: hexutil.Uint64(cert.SignersMask),
-		Sig:         nil,
+		Sig:         blsSignatures.SignatureToBytes(cert.Sig),
 		Version:     hexutil.Uint64(cert.Version),
 	}, nil
 }
 
+// shardCommitMessage is signed, in place of the empty message signed to commit a batch, to commit
+// a chunked store as an erasure-coded shard, so that a commit of one can't be replayed as the other.
+var shardCommitMessage = []byte("das_commitChunkedStoreShard")
+
+// CommitChunkedStoreShard is CommitChunkedStore for a chunked store of a serialized erasure-coded
+// shard, which is stored in place of the batch it belongs to.
+func (s *DASRPCServer) CommitChunkedStoreShard(ctx context.Context, batchId hexutil.Uint64, sig hexutil.Bytes) (*StoreResult, error) {
+	if err := s.signatureVerifier.verify(ctx, shardCommitMessage, sig, uint64(batchId)); err != nil {
+		return nil, err
+	}
+
+	message, timeout, startTime, err := s.batches.close(uint64(batchId))
+	if err != nil {
+		return nil, err
+	}
+
+	success := false
+	defer func() {
+		if success {
+			rpcStoreSuccessGauge.Inc(1)
+		} else {
+			rpcStoreFailureGauge.Inc(1)
+		}
+		rpcStoreDurationHistogram.Update(time.Since(startTime).Nanoseconds())
+	}()
+	shardWriter, ok := s.daWriter.(DataAvailabilityServiceShardWriter)
+	if !ok {
+		return nil, errors.New("DAS writer can't store erasure-coded shards")
+	}
+	shard, err := erasure.DeserializeShard(message)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := shardWriter.StoreShard(ctx, shard, timeout)
+	if err != nil {
+		return nil, err
+	}
+	rpcStoreStoredBytesGauge.Inc(int64(len(message)))
+	success = true
+	return &StoreResult{
+		KeysetHash:  cert.KeysetHash[:],
+		DataHash:    cert.DataHash[:],
+		Timeout:     hexutil.Uint64(cert.Timeout),
+		SignersMask: hexutil.Uint64(cert.SignersMask),
+		Sig:         blsSignatures.SignatureToBytes(cert.Sig),
+		Version:     hexutil.Uint64(cert.Version),
+		Commitment:  cert.Commitment[:],
+		DataShards:  hexutil.Uint64(cert.DataShards),
+		TotalShards: hexutil.Uint64(cert.TotalShards),
+	}, nil
+}
+
 func (serv *DASRPCServer) HealthCheck(ctx context.Context) error {
 	return serv.daHealthChecker.HealthCheck(ctx)
 }
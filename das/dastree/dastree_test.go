@@ -10,6 +10,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/util/arbmath"
 	"github.com/offchainlabs/nitro/util/colors"
 	"github.com/offchainlabs/nitro/util/pretty"
 	"github.com/offchainlabs/nitro/util/testhelpers"
@@ -62,6 +63,89 @@ func TestDASTree(t *testing.T) {
 	}
 }
 
+func TestDASTreeProofs(t *testing.T) {
+	sizes := []int{0, 1, BinSize, BinSize + 1, 3 * BinSize, 5*BinSize + 7, 12*BinSize - 1}
+	for _, size := range sizes {
+		preimage := testhelpers.RandomizeSlice(make([]byte, size))
+		root := Hash(preimage)
+		for index := uint32(0); index < LeafCount(uint32(size)); index++ {
+			bin, proof, err := Prove(preimage, index)
+			Require(t, err, size, index)
+			Require(t, VerifyProof(root, bin, proof), size, index)
+
+			if len(bin) > 0 {
+				tampered := append([]byte{}, bin...)
+				tampered[0] ^= 1
+				if VerifyProof(root, tampered, proof) == nil {
+					Fail(t, "proof of a tampered bin verified", size, index)
+				}
+			}
+			moved := *proof
+			moved.Index = (index + 1) % LeafCount(uint32(size))
+			if moved.Index != index && VerifyProof(root, bin, &moved) == nil {
+				Fail(t, "proof of a moved bin verified", size, index)
+			}
+			resized := *proof
+			resized.Size++
+			if VerifyProof(root, bin, &resized) == nil {
+				Fail(t, "proof with the wrong size verified", size, index)
+			}
+		}
+		if _, _, err := Prove(preimage, LeafCount(uint32(size))); err == nil {
+			Fail(t, "proved a leaf out of range", size)
+		}
+	}
+}
+
+func TestDASTreeRangeProofs(t *testing.T) {
+	size := 5*BinSize + 7
+	preimage := testhelpers.RandomizeSlice(make([]byte, size))
+	root := Hash(preimage)
+	ranges := [][2]uint32{
+		{0, 1}, {0, uint32(size)}, {3, BinSize}, {BinSize - 1, 2}, {BinSize, BinSize},
+		{2*BinSize + 5, 2 * BinSize}, {uint32(size) - 1, 1}, {4 * BinSize, BinSize + 7},
+	}
+	for i := 0; i < 16; i++ {
+		offset := uint32(rand.Intn(size))
+		ranges = append(ranges, [2]uint32{offset, 1 + uint32(rand.Intn(size-int(offset)))})
+	}
+	for _, r := range ranges {
+		offset, length := r[0], r[1]
+		data, proof, err := ProveRange(preimage, offset, length)
+		Require(t, err, offset, length)
+		if !bytes.Equal(data, preimage[offset:offset+length]) {
+			Fail(t, "proved the wrong range", offset, length)
+		}
+		Require(t, VerifyRangeProof(root, data, proof), offset, length)
+
+		tampered := append([]byte{}, data...)
+		tampered[len(tampered)-1] ^= 1
+		if VerifyRangeProof(root, tampered, proof) == nil {
+			Fail(t, "proof of a tampered range verified", offset, length)
+		}
+		if offset > 0 {
+			moved := *proof
+			moved.Offset--
+			moved.Head = moved.Head[:arbmath.MaxInt(len(moved.Head), 1)-1]
+			if VerifyRangeProof(root, data, &moved) == nil {
+				Fail(t, "proof of a moved range verified", offset, length)
+			}
+		}
+		if len(proof.Siblings) > 0 {
+			truncated := *proof
+			truncated.Siblings = truncated.Siblings[1:]
+			if VerifyRangeProof(root, data, &truncated) == nil {
+				Fail(t, "truncated proof verified", offset, length)
+			}
+		}
+	}
+	for _, r := range [][2]uint32{{0, 0}, {uint32(size), 1}, {1, uint32(size)}} {
+		if _, _, err := ProveRange(preimage, r[0], r[1]); err == nil {
+			Fail(t, "proved a range out of bounds", r)
+		}
+	}
+}
+
 func Require(t *testing.T, err error, printables ...interface{}) {
 	t.Helper()
 	testhelpers.RequireImpl(t, err, printables...)
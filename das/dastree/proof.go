@@ -0,0 +1,267 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package dastree
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/offchainlabs/nitro/util/arbmath"
+)
+
+// Proof shows that a bin is the leaf at Index of the dastree of a preimage of Size bytes.
+//
+// Every node's size follows from its position and the size of the preimage, so the proof only
+// needs the hashes of the siblings on the path to the root, from the leaf up. Nodes that bubble
+// up a layer for lack of a sibling have no entry.
+type Proof struct {
+	Size     uint32    `json:"size"`
+	Index    uint32    `json:"index"`
+	Siblings []bytes32 `json:"siblings"`
+}
+
+// LeafCount is the number of leaves of the dastree of a preimage of the given size. An empty
+// preimage still has its one empty leaf.
+func LeafCount(size uint32) uint32 {
+	if size == 0 {
+		return 1
+	}
+	return uint32((uint64(size) + BinSize - 1) / BinSize)
+}
+
+// sizeUnder is the number of bytes under the node at the given index of the given layer, where
+// the leaves are layer 0.
+func sizeUnder(size uint32, layer uint, index uint32) uint32 {
+	start := (uint64(index) << layer) * BinSize
+	end := arbmath.MinInt(((uint64(index)+1)<<layer)*BinSize, uint64(size))
+	if end < start {
+		return 0
+	}
+	return uint32(end - start)
+}
+
+func leafHash(bin []byte) bytes32 {
+	return crypto.Keccak256Hash([]byte{LeafByte}, crypto.Keccak256(bin))
+}
+
+func nodeHash(left, right bytes32, size uint32) bytes32 {
+	return crypto.Keccak256Hash([]byte{NodeByte}, left[:], right[:], arbmath.Uint32ToBytes(size))
+}
+
+// Prove returns the bin at the given index of the preimage along with the proof that it is that
+// leaf of the preimage's dastree.
+func Prove(preimage []byte, index uint32) ([]byte, *Proof, error) {
+	if uint64(len(preimage)) > uint64(^uint32(0)) {
+		return nil, nil, fmt.Errorf("preimage of %d bytes is too large for a dastree", len(preimage))
+	}
+	size := uint32(len(preimage))
+	count := LeafCount(size)
+	if index >= count {
+		return nil, nil, fmt.Errorf("leaf %d is out of range for a dastree of %d leaves", index, count)
+	}
+
+	layer := make([]bytes32, count)
+	for i := range layer {
+		start := uint32(i) * BinSize
+		layer[i] = leafHash(preimage[start:arbmath.MinInt(start+BinSize, size)])
+	}
+	proof := &Proof{Size: size, Index: index}
+	place := index
+	for height := uint(0); len(layer) > 1; height++ {
+		if place^1 < uint32(len(layer)) {
+			proof.Siblings = append(proof.Siblings, layer[place^1])
+		}
+		paired := make([]bytes32, (len(layer)+1)/2)
+		for i := 0; i < len(layer)-1; i += 2 {
+			paired[i/2] = nodeHash(layer[i], layer[i+1], sizeUnder(size, height+1, uint32(i/2)))
+		}
+		if len(layer)%2 == 1 {
+			paired[len(paired)-1] = layer[len(layer)-1]
+		}
+		layer = paired
+		place /= 2
+	}
+
+	start := index * BinSize
+	return preimage[start:arbmath.MinInt(start+BinSize, size)], proof, nil
+}
+
+// VerifyProof checks that the bin is the leaf the proof claims it is of the dastree with the
+// given root. Degenerate single-leaf dastrees and flat hashes can't be proven against, as their
+// leaves aren't the bins of the preimage.
+func VerifyProof(root bytes32, bin []byte, proof *Proof) error {
+	count := LeafCount(proof.Size)
+	if proof.Index >= count {
+		return fmt.Errorf("leaf %d is out of range for a dastree of %d leaves", proof.Index, count)
+	}
+	if expected := sizeUnder(proof.Size, 0, proof.Index); uint64(len(bin)) != uint64(expected) {
+		return fmt.Errorf("leaf %d has an incorrectly sized bin: %d vs %d", proof.Index, len(bin), expected)
+	}
+
+	hash := leafHash(bin)
+	siblings := proof.Siblings
+	place := proof.Index
+	for height := uint(0); count > 1; height++ {
+		if place^1 < count {
+			if len(siblings) == 0 {
+				return fmt.Errorf("proof of leaf %d is too short", proof.Index)
+			}
+			size := sizeUnder(proof.Size, height+1, place/2)
+			if place%2 == 0 {
+				hash = nodeHash(hash, siblings[0], size)
+			} else {
+				hash = nodeHash(siblings[0], hash, size)
+			}
+			siblings = siblings[1:]
+		}
+		count = (count + 1) / 2
+		place /= 2
+	}
+	if len(siblings) != 0 {
+		return fmt.Errorf("proof of leaf %d is too long", proof.Index)
+	}
+	if arbmath.FlipBit(hash, 0) != root {
+		return fmt.Errorf("proof of leaf %d doesn't match root %v", proof.Index, root)
+	}
+	return nil
+}
+
+// RangeProof shows that some bytes are those at Offset of a preimage of Size bytes, by proving the
+// leaves they fall in together. Siblings are the hashes next to the leaves' subtree on the path to
+// the root, from the leaves up and left before right within a layer.
+type RangeProof struct {
+	Size   uint32 `json:"size"`
+	Offset uint32 `json:"offset"`
+	// Head and Tail are the bytes of the first and last leaves before and after the range, which
+	// hashing those leaves needs.
+	Head     []byte    `json:"head"`
+	Tail     []byte    `json:"tail"`
+	Siblings []bytes32 `json:"siblings"`
+}
+
+// leafRange is the first and last index of the leaves the given bytes of a preimage fall in.
+func leafRange(size, offset, length uint32) (uint32, uint32, error) {
+	if length == 0 || uint64(offset)+uint64(length) > uint64(size) {
+		return 0, 0, fmt.Errorf("range of %d bytes at %d is empty or out of range for a preimage of %d bytes", length, offset, size)
+	}
+	return offset / BinSize, uint32((uint64(offset) + uint64(length) - 1) / BinSize), nil
+}
+
+// ProveRange returns the given bytes of the preimage along with the proof that they are those
+// bytes of it.
+func ProveRange(preimage []byte, offset, length uint32) ([]byte, *RangeProof, error) {
+	if uint64(len(preimage)) > uint64(^uint32(0)) {
+		return nil, nil, fmt.Errorf("preimage of %d bytes is too large for a dastree", len(preimage))
+	}
+	size := uint32(len(preimage))
+	first, last, err := leafRange(size, offset, length)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	layer := make([]bytes32, LeafCount(size))
+	for i := range layer {
+		start := uint32(i) * BinSize
+		layer[i] = leafHash(preimage[start:arbmath.MinInt(start+BinSize, size)])
+	}
+	end := offset + length
+	proof := &RangeProof{
+		Size:   size,
+		Offset: offset,
+		Head:   preimage[first*BinSize : offset],
+		Tail:   preimage[end : last*BinSize+sizeUnder(size, 0, last)],
+	}
+	for height := uint(0); len(layer) > 1; height++ {
+		if first%2 == 1 {
+			proof.Siblings = append(proof.Siblings, layer[first-1])
+		}
+		if last%2 == 0 && last+1 < uint32(len(layer)) {
+			proof.Siblings = append(proof.Siblings, layer[last+1])
+		}
+		paired := make([]bytes32, (len(layer)+1)/2)
+		for i := 0; i < len(layer)-1; i += 2 {
+			paired[i/2] = nodeHash(layer[i], layer[i+1], sizeUnder(size, height+1, uint32(i/2)))
+		}
+		if len(layer)%2 == 1 {
+			paired[len(paired)-1] = layer[len(layer)-1]
+		}
+		layer = paired
+		first /= 2
+		last /= 2
+	}
+	return preimage[offset:end], proof, nil
+}
+
+// VerifyRangeProof checks that the data is the bytes the proof claims it is of the preimage of the
+// dastree with the given root.
+func VerifyRangeProof(root bytes32, data []byte, proof *RangeProof) error {
+	if uint64(len(data)) > uint64(^uint32(0)) {
+		return fmt.Errorf("range of %d bytes is too large for a dastree", len(data))
+	}
+	first, last, err := leafRange(proof.Size, proof.Offset, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+	if uint64(len(proof.Head)) != uint64(proof.Offset-first*BinSize) {
+		return fmt.Errorf("range at %d has a head of %d bytes", proof.Offset, len(proof.Head))
+	}
+	end := uint64(first)*BinSize + uint64(len(proof.Head)) + uint64(len(data)) + uint64(len(proof.Tail))
+	if expected := uint64(last)*BinSize + uint64(sizeUnder(proof.Size, 0, last)); end != expected {
+		return fmt.Errorf("range at %d has a tail of %d bytes, which ends it at %d rather than %d", proof.Offset, len(proof.Tail), end, expected)
+	}
+
+	bins := arbmath.ConcatByteSlices(proof.Head, data, proof.Tail)
+	hashes := make([]bytes32, 0, last-first+1)
+	for start := 0; start < len(bins); start += BinSize {
+		hashes = append(hashes, leafHash(bins[start:arbmath.MinInt(start+BinSize, len(bins))]))
+	}
+	siblings := proof.Siblings
+	next := func() (bytes32, error) {
+		if len(siblings) == 0 {
+			return bytes32{}, fmt.Errorf("proof of range at %d is too short", proof.Offset)
+		}
+		sibling := siblings[0]
+		siblings = siblings[1:]
+		return sibling, nil
+	}
+	count := LeafCount(proof.Size)
+	for height := uint(0); count > 1; height++ {
+		// Extend the hashes to whole pairs, except for one that bubbles up.
+		if first%2 == 1 {
+			sibling, err := next()
+			if err != nil {
+				return err
+			}
+			hashes = append([]bytes32{sibling}, hashes...)
+			first--
+		}
+		if last%2 == 0 && last+1 < count {
+			sibling, err := next()
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, sibling)
+			last++
+		}
+		paired := make([]bytes32, 0, (len(hashes)+1)/2)
+		for i := 0; i < len(hashes); i += 2 {
+			if i+1 < len(hashes) {
+				paired = append(paired, nodeHash(hashes[i], hashes[i+1], sizeUnder(proof.Size, height+1, (first+uint32(i))/2)))
+			} else {
+				paired = append(paired, hashes[i])
+			}
+		}
+		hashes = paired
+		count = (count + 1) / 2
+		first /= 2
+		last /= 2
+	}
+	if len(siblings) != 0 {
+		return fmt.Errorf("proof of range at %d is too long", proof.Offset)
+	}
+	if arbmath.FlipBit(hashes[0], 0) != root {
+		return fmt.Errorf("proof of range at %d doesn't match root %v", proof.Offset, root)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/testhelpers"
+)
+
+func TestReedSolomonAnySubset(t *testing.T) {
+	for _, code := range [][2]int{{1, 1}, {1, 3}, {2, 3}, {3, 5}, {4, 10}, {7, 7}} {
+		dataShards, totalShards := code[0], code[1]
+		rs, err := NewReedSolomon(dataShards, totalShards)
+		Require(t, err)
+		for _, size := range []int{0, 1, dataShards, 1000, 3*dastree.BinSize + 7} {
+			data := testhelpers.RandomizeSlice(make([]byte, size))
+			shards, err := rs.Encode(data)
+			Require(t, err)
+			// Drop a random set of all but dataShards shards.
+			for trial := 0; trial < 8; trial++ {
+				subset := make([][]byte, totalShards)
+				for _, i := range rand.Perm(totalShards)[:dataShards] {
+					subset[i] = shards[i]
+				}
+				rebuilt, err := rs.Reconstruct(subset, uint64(size))
+				Require(t, err)
+				if !bytes.Equal(rebuilt, data) {
+					Fail(t, "code", code, "size", size, "reconstructed the wrong data")
+				}
+			}
+			subset := make([][]byte, totalShards)
+			for _, i := range rand.Perm(totalShards)[:dataShards-1] {
+				subset[i] = shards[i]
+			}
+			if _, err := rs.Reconstruct(subset, uint64(size)); !errors.Is(err, ErrTooFewShards) {
+				Fail(t, "expected ErrTooFewShards, got", err)
+			}
+		}
+	}
+
+	for _, code := range [][2]int{{0, 3}, {4, 3}, {1, MaxShards + 1}} {
+		if _, err := NewReedSolomon(code[0], code[1]); err == nil {
+			Fail(t, "expected code", code, "to be invalid")
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	data := testhelpers.RandomizeSlice(make([]byte, 2*dastree.BinSize+11))
+	shards, err := Split(data, 3, 7)
+	Require(t, err)
+	for _, shard := range shards {
+		if shard.Root != dastree.Hash(data) {
+			Fail(t, "shard has the wrong root")
+		}
+		Require(t, shard.Verify())
+		decoded, err := DeserializeShard(shard.Serialize())
+		Require(t, err)
+		Require(t, decoded.Verify())
+		if decoded.Index != shard.Index || !bytes.Equal(decoded.Data, shard.Data) {
+			Fail(t, "shard didn't round trip")
+		}
+	}
+
+	rebuilt, err := Reconstruct([]*Shard{shards[6], shards[1], shards[4]})
+	Require(t, err)
+	if !bytes.Equal(rebuilt, data) {
+		Fail(t, "reconstructed the wrong batch")
+	}
+	if _, err := Reconstruct(shards[:2]); !errors.Is(err, ErrTooFewShards) {
+		Fail(t, "expected ErrTooFewShards, got", err)
+	}
+
+	// A shard whose data was tampered with, or that was moved to another index, fails its proof.
+	tampered := *shards[2]
+	tampered.Data = append([]byte{}, tampered.Data...)
+	tampered.Data[0] ^= 1
+	if err := tampered.Verify(); !errors.Is(err, ErrInvalidShard) {
+		Fail(t, "expected ErrInvalidShard for tampered data, got", err)
+	}
+	moved := *shards[2]
+	moved.Index = 3
+	if err := moved.Verify(); !errors.Is(err, ErrInvalidShard) {
+		Fail(t, "expected ErrInvalidShard for a moved shard, got", err)
+	}
+
+	// Shards committed to consistently but not coding the batch fail the check of its hash.
+	other := testhelpers.RandomizeSlice(make([]byte, len(data)))
+	forged, err := Split(other, 3, 7)
+	Require(t, err)
+	for _, shard := range forged {
+		shard.Root = shards[0].Root
+	}
+	if _, err := Reconstruct(forged[:3]); !errors.Is(err, ErrShardMismatch) {
+		Fail(t, "expected ErrShardMismatch, got", err)
+	}
+	if _, err := Reconstruct([]*Shard{shards[0], shards[1], forged[2]}); !errors.Is(err, ErrInvalidShard) {
+		Fail(t, "expected ErrInvalidShard for mixed commitments, got", err)
+	}
+
+	// Shards of the batch committed to along with parity shards that don't code it rebuild it, but
+	// fail the check of the commitment, as other shards under it wouldn't rebuild the batch.
+	miscoded := make([]*Shard, len(shards))
+	for i, shard := range shards {
+		copied := *shard
+		miscoded[i] = &copied
+	}
+	miscoded[5].Data = testhelpers.RandomizeSlice(make([]byte, len(miscoded[5].Data)))
+	commit(miscoded)
+	for _, shard := range miscoded {
+		Require(t, shard.Verify())
+	}
+	if _, err := Reconstruct(miscoded[:3]); !errors.Is(err, ErrShardMismatch) {
+		Fail(t, "expected ErrShardMismatch for a miscoded commitment, got", err)
+	}
+}
+
+func Require(t *testing.T, err error, printables ...interface{}) {
+	t.Helper()
+	testhelpers.RequireImpl(t, err, printables...)
+}
+
+func Fail(t *testing.T, printables ...interface{}) {
+	t.Helper()
+	testhelpers.FailImpl(t, printables...)
+}
@@ -0,0 +1,112 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package erasure
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// MaxShards is the most shards data can be coded into, as shards are counted and indexed in a
+// byte.
+const MaxShards = 255
+
+var ErrTooFewShards = errors.New("too few shards to reconstruct the data")
+
+// ReedSolomon is a systematic Reed-Solomon code over GF(2^8): the first dataShards of the shards
+// it codes data into are the data itself, and the data can be reconstructed from any dataShards
+// of them. The coding is done by github.com/klauspost/reedsolomon with its default Vandermonde
+// matrix, which must not change, as commitments to the shards depend on it.
+type ReedSolomon struct {
+	dataShards  int
+	totalShards int
+	encoder     reedsolomon.Encoder
+}
+
+func NewReedSolomon(dataShards, totalShards int) (*ReedSolomon, error) {
+	if dataShards <= 0 || totalShards > MaxShards || dataShards > totalShards {
+		return nil, fmt.Errorf("invalid erasure code of %d data shards out of %d, there must be between 1 and %d shards and at least one data shard", dataShards, totalShards, MaxShards)
+	}
+	encoder, err := reedsolomon.New(dataShards, totalShards-dataShards)
+	if err != nil {
+		return nil, err
+	}
+	return &ReedSolomon{
+		dataShards:  dataShards,
+		totalShards: totalShards,
+		encoder:     encoder,
+	}, nil
+}
+
+func (rs *ReedSolomon) DataShards() int {
+	return rs.dataShards
+}
+
+func (rs *ReedSolomon) TotalShards() int {
+	return rs.totalShards
+}
+
+// ShardSize is the size of each shard of data of the given size, which is padded with zeros to a
+// multiple of the number of data shards.
+func (rs *ReedSolomon) ShardSize(size uint64) uint64 {
+	return (size + uint64(rs.dataShards) - 1) / uint64(rs.dataShards)
+}
+
+// Encode codes the data into totalShards shards of equal size.
+func (rs *ReedSolomon) Encode(data []byte) ([][]byte, error) {
+	shardSize := int(rs.ShardSize(uint64(len(data))))
+	padded := make([]byte, shardSize*rs.totalShards)
+	copy(padded, data)
+
+	shards := make([][]byte, rs.totalShards)
+	for i := range shards {
+		shards[i] = padded[i*shardSize : (i+1)*shardSize]
+	}
+	// The library has nothing to code for empty data or a code without parity shards.
+	if shardSize == 0 || rs.totalShards == rs.dataShards {
+		return shards, nil
+	}
+	if err := rs.encoder.Encode(shards); err != nil {
+		return nil, err
+	}
+	return shards, nil
+}
+
+// Reconstruct returns the data of the given size from the shards, indexed by their position in
+// the code and nil where missing. Any dataShards of them suffice, and must all be of the same size.
+func (rs *ReedSolomon) Reconstruct(shards [][]byte, size uint64) ([]byte, error) {
+	if len(shards) != rs.totalShards {
+		return nil, fmt.Errorf("expected %d shards, got %d", rs.totalShards, len(shards))
+	}
+	shardSize := rs.ShardSize(size)
+	present := 0
+	for i, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if uint64(len(shard)) != shardSize {
+			return nil, fmt.Errorf("shard %d has size %d, expected %d", i, len(shard), shardSize)
+		}
+		present++
+	}
+	if present < rs.dataShards {
+		return nil, fmt.Errorf("%w: have %d of the %d needed", ErrTooFewShards, present, rs.dataShards)
+	}
+	if shardSize == 0 {
+		return []byte{}, nil
+	}
+
+	// The library fills in the missing data shards in place.
+	shards = append([][]byte{}, shards...)
+	if err := rs.encoder.ReconstructData(shards); err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, shardSize*uint64(rs.dataShards))
+	for _, shard := range shards[:rs.dataShards] {
+		data = append(data, shard...)
+	}
+	return data[:size], nil
+}
@@ -0,0 +1,228 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package erasure
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/offchainlabs/nitro/das/dastree"
+)
+
+const ShardVersion = byte(1)
+
+// The shards of a batch are the leaves of a Merkle tree whose root is the shards' commitment.
+// The prefixes keep its hashes apart from those of the dastree.
+const (
+	ShardLeafByte = byte(0xfc)
+	ShardNodeByte = byte(0xfd)
+)
+
+const shardHeaderSize = 1 + 32 + 8 + 1 + 1 + 1 + 32 + 1
+
+var (
+	ErrInvalidShard  = errors.New("invalid erasure-coded shard")
+	ErrShardMismatch = errors.New("erasure-coded shards don't reconstruct the batch")
+)
+
+// Shard is one of the erasure-coded shards of a batch, as stored by a committee member, with the
+// proof that it is the shard at its index under the commitment to all of them.
+type Shard struct {
+	// Root is the dastree hash of the batch, which certificates sign.
+	Root        common.Hash
+	Size        uint64
+	DataShards  uint8
+	TotalShards uint8
+	Index       uint8
+	Commitment  common.Hash
+	Proof       []common.Hash
+	Data        []byte
+}
+
+// ShardKey is the key a committee member stores its shard of the batch with the given root
+// under, which is never the dastree hash of a batch.
+func ShardKey(root common.Hash) common.Hash {
+	return crypto.Keccak256Hash([]byte("das-erasure-shard"), root[:])
+}
+
+// shardLeaf commits to the whole header of the shard along with its data, so that shards under
+// the same commitment agree on how the batch was coded.
+func shardLeaf(s *Shard) common.Hash {
+	header := binary.BigEndian.AppendUint64([]byte{ShardLeafByte}, s.Size)
+	header = append(header, s.DataShards, s.TotalShards, s.Index)
+	return crypto.Keccak256Hash(header, s.Root[:], crypto.Keccak256(s.Data))
+}
+
+func shardNode(left, right common.Hash) common.Hash {
+	return crypto.Keccak256Hash([]byte{ShardNodeByte}, left[:], right[:])
+}
+
+// proofLength is the depth of the commitment tree, which is padded with zero leaves to a power
+// of two.
+func proofLength(totalShards uint8) int {
+	return bits.Len(uint(totalShards) - 1)
+}
+
+// Split erasure codes the batch into totalShards shards, any dataShards of which reconstruct it.
+func Split(data []byte, dataShards, totalShards int) ([]*Shard, error) {
+	rs, err := NewReedSolomon(dataShards, totalShards)
+	if err != nil {
+		return nil, err
+	}
+	root := dastree.Hash(data)
+	shardData, err := rs.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	shards := make([]*Shard, totalShards)
+	for i := range shards {
+		shards[i] = &Shard{
+			Root:        root,
+			Size:        uint64(len(data)),
+			DataShards:  uint8(dataShards),
+			TotalShards: uint8(totalShards),
+			Index:       uint8(i),
+			Data:        shardData[i],
+		}
+	}
+	commit(shards)
+	return shards, nil
+}
+
+// commit sets the commitment to all of the shards, and each one's proof against it.
+func commit(shards []*Shard) {
+	depth := proofLength(uint8(len(shards)))
+	level := make([]common.Hash, 1<<depth)
+	for i, shard := range shards {
+		level[i] = shardLeaf(shard)
+	}
+	proofs := make([][]common.Hash, len(shards))
+	for len(level) > 1 {
+		for i := range proofs {
+			proofs[i] = append(proofs[i], level[(i>>len(proofs[i]))^1])
+		}
+		next := make([]common.Hash, len(level)/2)
+		for i := range next {
+			next[i] = shardNode(level[2*i], level[2*i+1])
+		}
+		level = next
+	}
+
+	for i, shard := range shards {
+		shard.Commitment = level[0]
+		shard.Proof = proofs[i]
+	}
+}
+
+// Verify checks that the shard is well formed and that its proof places it at its index under
+// its commitment. Whether the shards under a commitment code the batch can only be checked by
+// reconstructing it.
+func (s *Shard) Verify() error {
+	if s.DataShards == 0 || s.DataShards > s.TotalShards || s.Index >= s.TotalShards {
+		return fmt.Errorf("%w: shard %d of a code of %d data shards out of %d", ErrInvalidShard, s.Index, s.DataShards, s.TotalShards)
+	}
+	shardSize := (s.Size + uint64(s.DataShards) - 1) / uint64(s.DataShards)
+	if uint64(len(s.Data)) != shardSize {
+		return fmt.Errorf("%w: shard has size %d, expected %d", ErrInvalidShard, len(s.Data), shardSize)
+	}
+	if len(s.Proof) != proofLength(s.TotalShards) {
+		return fmt.Errorf("%w: proof has %d hashes, expected %d", ErrInvalidShard, len(s.Proof), proofLength(s.TotalShards))
+	}
+	hash := shardLeaf(s)
+	for i, sibling := range s.Proof {
+		if (s.Index>>i)&1 == 0 {
+			hash = shardNode(hash, sibling)
+		} else {
+			hash = shardNode(sibling, hash)
+		}
+	}
+	if hash != s.Commitment {
+		return fmt.Errorf("%w: proof of shard %d doesn't match the commitment", ErrInvalidShard, s.Index)
+	}
+	return nil
+}
+
+func (s *Shard) Serialize() []byte {
+	buf := make([]byte, 0, shardHeaderSize+32*len(s.Proof)+len(s.Data))
+	buf = append(buf, ShardVersion)
+	buf = append(buf, s.Root[:]...)
+	buf = binary.BigEndian.AppendUint64(buf, s.Size)
+	buf = append(buf, s.DataShards, s.TotalShards, s.Index)
+	buf = append(buf, s.Commitment[:]...)
+	buf = append(buf, uint8(len(s.Proof)))
+	for _, hash := range s.Proof {
+		buf = append(buf, hash[:]...)
+	}
+	return append(buf, s.Data...)
+}
+
+func DeserializeShard(buf []byte) (*Shard, error) {
+	if len(buf) < shardHeaderSize {
+		return nil, fmt.Errorf("%w: record of %d bytes is too short", ErrInvalidShard, len(buf))
+	}
+	if buf[0] != ShardVersion {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrInvalidShard, buf[0])
+	}
+	s := &Shard{
+		Root:        common.BytesToHash(buf[1:33]),
+		Size:        binary.BigEndian.Uint64(buf[33:41]),
+		DataShards:  buf[41],
+		TotalShards: buf[42],
+		Index:       buf[43],
+		Commitment:  common.BytesToHash(buf[44:76]),
+	}
+	proofLen := int(buf[76])
+	rest := buf[shardHeaderSize:]
+	if len(rest) < 32*proofLen {
+		return nil, fmt.Errorf("%w: record is too short for a proof of %d hashes", ErrInvalidShard, proofLen)
+	}
+	for i := 0; i < proofLen; i++ {
+		s.Proof = append(s.Proof, common.BytesToHash(rest[32*i:32*(i+1)]))
+	}
+	s.Data = rest[32*proofLen:]
+	return s, nil
+}
+
+// Reconstruct returns the batch coded into the shards, which must all be verified shards of the
+// same batch under the same commitment. As whoever committed to the shards needn't have coded
+// them correctly, it checks the result against the batch's dastree hash, and that coding it again
+// yields the same commitment, so that any other shards under it would have rebuilt it too.
+func Reconstruct(shards []*Shard) ([]byte, error) {
+	if len(shards) == 0 {
+		return nil, ErrTooFewShards
+	}
+	first := shards[0]
+	rs, err := NewReedSolomon(int(first.DataShards), int(first.TotalShards))
+	if err != nil {
+		return nil, err
+	}
+	byIndex := make([][]byte, first.TotalShards)
+	for _, s := range shards {
+		if s.Commitment != first.Commitment || s.Root != first.Root || s.Size != first.Size ||
+			s.DataShards != first.DataShards || s.TotalShards != first.TotalShards {
+			return nil, fmt.Errorf("%w: shards are under different commitments", ErrInvalidShard)
+		}
+		byIndex[s.Index] = s.Data
+	}
+	data, err := rs.Reconstruct(byIndex, first.Size)
+	if err != nil {
+		return nil, err
+	}
+	if !dastree.ValidHash(first.Root, data) {
+		return nil, fmt.Errorf("%w under commitment %v", ErrShardMismatch, first.Commitment)
+	}
+	recoded, err := Split(data, int(first.DataShards), int(first.TotalShards))
+	if err != nil {
+		return nil, err
+	}
+	if recoded[0].Commitment != first.Commitment {
+		return nil, fmt.Errorf("%w: commitment %v isn't to a coding of the batch", ErrShardMismatch, first.Commitment)
+	}
+	return data, nil
+}
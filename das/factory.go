@@ -11,6 +11,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/arbutil"
 	"github.com/offchainlabs/nitro/solgen/go/bridgegen"
 	"github.com/offchainlabs/nitro/util/headerreader"
@@ -23,28 +24,42 @@ func CreatePersistentStorageService(
 	ctx context.Context,
 	config *DataAvailabilityConfig,
 ) (StorageService, *LifecycleManager, error) {
+	storageService, _, lifecycleManager, err := createPersistentStorageService(ctx, config)
+	return storageService, lifecycleManager, err
+}
+
+// createPersistentStorageService is CreatePersistentStorageService, but also returns the IC storage
+// service if it is enabled, which can serve the certificates of the batches it stores.
+func createPersistentStorageService(
+	ctx context.Context,
+	config *DataAvailabilityConfig,
+) (StorageService, *ICStorageService, *LifecycleManager, error) {
 	storageServices := make([]StorageService, 0, 10)
 	var lifecycleManager LifecycleManager
 	var err error
 
+	var icStorage *ICStorageService
 	if config.ICStorage.Enable {
-		s, err := NewICStorageService(config.ICStorage)
+		icStorage, err = NewICStorageService(config.ICStorage, nil)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
-		lifecycleManager.Register(s)
-		storageServices = append(storageServices, s)
+		if err = icStorage.start(ctx); err != nil {
+			return nil, nil, nil, err
+		}
+		lifecycleManager.Register(icStorage)
+		storageServices = append(storageServices, icStorage)
 	}
 
 	var fs *LocalFileStorageService
 	if config.LocalFileStorage.Enable {
 		fs, err = NewLocalFileStorageService(config.LocalFileStorage)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		err = fs.start(ctx)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		lifecycleManager.Register(fs)
 		storageServices = append(storageServices, fs)
@@ -58,7 +73,7 @@ func CreatePersistentStorageService(
 			s, err = NewDBStorageService(ctx, &config.LocalDBStorage, nil)
 		}
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		if s != nil {
 			lifecycleManager.Register(s)
@@ -69,7 +84,10 @@ func CreatePersistentStorageService(
 	if config.S3Storage.Enable {
 		s, err := NewS3StorageService(config.S3Storage)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
+		}
+		if err = s.start(ctx); err != nil {
+			return nil, nil, nil, err
 		}
 		lifecycleManager.Register(s)
 		storageServices = append(storageServices, s)
@@ -78,19 +96,19 @@ func CreatePersistentStorageService(
 	if len(storageServices) > 1 {
 		s, err := NewRedundantStorageService(ctx, storageServices)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		lifecycleManager.Register(s)
-		return s, &lifecycleManager, nil
+		return s, icStorage, &lifecycleManager, nil
 	}
 	if len(storageServices) == 1 {
-		return storageServices[0], &lifecycleManager, nil
+		return storageServices[0], icStorage, &lifecycleManager, nil
 	}
 	if len(storageServices) == 0 {
-		return nil, nil, errors.New("No data-availability storage backend has been configured")
+		return nil, nil, nil, errors.New("No data-availability storage backend has been configured")
 	}
 
-	return nil, &lifecycleManager, nil
+	return nil, nil, &lifecycleManager, nil
 }
 
 func WrapStorageWithCache(
@@ -133,6 +151,9 @@ func CreateBatchPosterDAS(
 	if !config.RPCAggregator.Enable || !config.RestAggregator.Enable {
 		return nil, nil, nil, nil, errors.New("--node.data-availability.rpc-aggregator.enable and rest-aggregator.enable must be set when running a Batch Poster in AnyTrust mode")
 	}
+	if config.RPCAggregator.KeysetVersion != 0 {
+		return nil, nil, nil, nil, errors.New("--node.data-availability.rpc-aggregator.keyset-version must be 0 when running a Batch Poster in AnyTrust mode, versioned keysets only name the committee of hybrid ICDA batches")
+	}
 	// Done checking config requirements
 
 	var daWriter DataAvailabilityServiceWriter
@@ -157,6 +178,72 @@ func CreateBatchPosterDAS(
 	return daWriter, daReader, keysetFetcher, &lifecycleManager, nil
 }
 
+func CreateBatchPosterICDA(
+	ctx context.Context,
+	config *DataAvailabilityConfig,
+	dataSigner signature.DataSignerFunc,
+	l1Reader arbutil.L1Interface,
+	sequencerInboxAddr common.Address,
+) (daprovider.ICDAWriter, DataAvailabilityServiceReader, *KeysetFetcher, *LifecycleManager, error) {
+	if !config.Enable {
+		return nil, nil, nil, nil, nil
+	}
+
+	// Check config requirements
+	if !config.ICStorage.Enable || !config.RestAggregator.Enable {
+		return nil, nil, nil, nil, errors.New("--node.data-availability.ic-storage.enable and rest-aggregator.enable must be set when running a Batch Poster in ICDA mode")
+	}
+	hybridPolicy, err := ParseHybridPolicy(config.HybridPolicy)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	// Done checking config requirements
+
+	icStorage, err := NewICStorageService(config.ICStorage, dataSigner)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	certifyWriter, err := NewCertifyAfterStoreICDAWriter(icStorage)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var icdaWriter daprovider.ICDAWriter = certifyWriter
+	if config.RPCAggregator.Enable {
+		// With a committee configured too, batches are posted with hybrid certificates.
+		aggregator, err := NewRPCAggregator(ctx, *config, dataSigner)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		icdaWriter, err = NewHybridICDAWriter(aggregator, aggregator.keysetBytes, certifyWriter, hybridPolicy)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	restAgg, err := NewRestfulClientAggregator(ctx, &config.RestAggregator)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	keysetFetcher, err := NewKeysetFetcher(l1Reader, sequencerInboxAddr)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// Nothing is started until everything that can fail has been built.
+	if err = icStorage.start(ctx); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	// Batches are read back from the canisters too, in whichever read mode serves them best.
+	restAgg.AddReaders(icStorage.ReadModeReaders()...)
+	restAgg.Start(ctx)
+	var lifecycleManager LifecycleManager
+	lifecycleManager.Register(icStorage)
+	lifecycleManager.Register(restAgg)
+	var daReader DataAvailabilityServiceReader = restAgg
+
+	return icdaWriter, daReader, keysetFetcher, &lifecycleManager, nil
+}
+
 func CreateDAComponentsForDaserver(
 	ctx context.Context,
 	config *DataAvailabilityConfig,
@@ -173,20 +260,39 @@ func CreateDAComponentsForDaserver(
 		!config.S3Storage.Enable {
 		return nil, nil, nil, nil, nil, errors.New("At least one of --data-availability.(local-db-storage|local-file-storage|s3-storage) must be enabled.")
 	}
+	if config.Announcements.Enable && config.Key.KeyDir == "" && config.Key.PrivKey == "" {
+		return nil, nil, nil, nil, nil, errors.New("--data-availability.announcements.enable requires the BLS key of a committee member, set with --data-availability.key")
+	}
 	// Done checking config requirements
 
-	storageService, dasLifecycleManager, err := CreatePersistentStorageService(ctx, config)
+	storageService, icStorage, dasLifecycleManager, err := createPersistentStorageService(ctx, config)
 	if err != nil {
 		return nil, nil, nil, nil, nil, err
 	}
 
+	// Erasure-coded shards are stored straight to the persistent storage, past the caches that
+	// are meant for whole batches.
+	shardStorage, _ := storageService.(ShardStorageService)
+
 	storageService, err = WrapStorageWithCache(ctx, config, storageService, dasLifecycleManager)
 	if err != nil {
 		return nil, nil, nil, nil, nil, err
 	}
 
+	// Announced batches are prefetched into this server's own storage, rather than through the
+	// fallback to the REST aggregator.
+	if config.Announcements.Subscribe.Enable {
+		subscriber, err := NewBatchAnnouncementSubscriber(&config.Announcements.Subscribe, storageService)
+		if err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+		subscriber.Start(ctx)
+		dasLifecycleManager.Register(subscriber)
+	}
+
 	// The REST aggregator is used as the fallback if requested data is not present
 	// in the storage service.
+	var certifiedFallback CertifiedDASReader
 	if config.RestAggregator.Enable {
 		restAgg, err := NewRestfulClientAggregator(ctx, &config.RestAggregator)
 		if err != nil {
@@ -194,6 +300,9 @@ func CreateDAComponentsForDaserver(
 		}
 		restAgg.Start(ctx)
 		dasLifecycleManager.Register(restAgg)
+		if config.RestAggregator.ICCertified.Enable {
+			certifiedFallback = restAgg
+		}
 
 		syncConf := &config.RestAggregator.SyncToStorage
 		var retentionPeriodSeconds uint64
@@ -230,6 +339,15 @@ func CreateDAComponentsForDaserver(
 	var daWriter DataAvailabilityServiceWriter
 	var daReader DataAvailabilityServiceReader = storageService
 	var daHealthChecker DataAvailabilityServiceHealthChecker = storageService
+	if icStorage != nil || certifiedFallback != nil {
+		// Serve the certificates of batches from IC storage, or pass on the checked ones of the
+		// REST endpoints, so that this server's clients needn't trust it.
+		daReader = &certifiedStorageReader{
+			DataAvailabilityServiceReader: storageService,
+			ic:                            icStorage,
+			fallback:                      certifiedFallback,
+		}
+	}
 	var signatureVerifier *SignatureVerifier
 
 	if config.Key.KeyDir != "" || config.Key.PrivKey != "" {
@@ -246,10 +364,17 @@ func CreateDAComponentsForDaserver(
 			seqInboxCaller = nil
 		}
 
-		daWriter, err = NewSignAfterStoreDASWriter(ctx, *config, storageService)
+		signAfterStoreWriter, err := NewSignAfterStoreDASWriter(ctx, *config, storageService)
 		if err != nil {
 			return nil, nil, nil, nil, nil, err
 		}
+		signAfterStoreWriter.shardStorage = shardStorage
+		if config.Announcements.Enable {
+			announcer := NewBatchAnnouncer(&config.Announcements, signAfterStoreWriter.privKey)
+			dasLifecycleManager.Register(announcer)
+			signAfterStoreWriter.announcer = announcer
+		}
+		daWriter = signAfterStoreWriter
 
 		signatureVerifier, err = NewSignatureVerifierWithSeqInboxCaller(
 			seqInboxCaller,
@@ -291,6 +416,16 @@ func CreateDAReaderForNode(
 		if err != nil {
 			return nil, nil, nil, err
 		}
+		if config.ICStorage.Enable {
+			// The node only reads from the canisters, so the storage isn't started, which
+			// would prune and monitor them.
+			icStorage, err := NewICStorageService(config.ICStorage, nil)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			lifecycleManager.Register(icStorage)
+			restAgg.AddReaders(icStorage.ReadModeReaders()...)
+		}
 		restAgg.Start(ctx)
 		lifecycleManager.Register(restAgg)
 		daReader = restAgg
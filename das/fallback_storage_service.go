@@ -5,6 +5,7 @@ package das
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -73,7 +74,11 @@ func (f *FallbackStorageService) GetByHash(ctx context.Context, key common.Hash)
 			}
 			f.currentlyFetchingMutex.Unlock()
 		}
-		log.Trace("das.FallbackStorageService.GetByHash trying fallback")
+		if errors.Is(err, ErrNotFound) {
+			log.Trace("das.FallbackStorageService.GetByHash trying fallback")
+		} else {
+			log.Warn("das.FallbackStorageService.GetByHash primary failed, trying fallback", "key", pretty.PrettyHash(key), "err", err)
+		}
 		data, err = f.backup.GetByHash(ctx, key)
 		if doDelete {
 			f.currentlyFetchingMutex.Lock()
@@ -88,7 +93,7 @@ func (f *FallbackStorageService) GetByHash(ctx context.Context, key common.Hash)
 				ctx, data, arbmath.SaturatingUAdd(uint64(time.Now().Unix()), f.backupRetentionSeconds),
 			)
 			if putErr != nil && !f.ignoreRetentionWriteErrors {
-				return nil, err
+				return nil, putErr
 			}
 		}
 	}
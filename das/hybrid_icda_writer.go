@@ -0,0 +1,113 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/pretty"
+)
+
+// HybridICDAWriter stores each batch with both a DAS committee and the IC storage canisters, and
+// combines the committee's aggregated signature and the canisters' certified-data proofs into a
+// single hybrid ICDACertificate. Its keyset's policy decides whether the batch is posted when
+// only one of them vouched for it.
+type HybridICDAWriter struct {
+	committee   DataAvailabilityServiceWriter
+	ic          *CertifyAfterStoreICDAWriter
+	keyset      *daprovider.HybridKeyset
+	keysetHash  [32]byte
+	keysetBytes []byte
+}
+
+// NewHybridICDAWriter creates a HybridICDAWriter. committeeKeysetBytes is the serialized keyset of
+// the committee behind the committee writer, usually an Aggregator.
+func NewHybridICDAWriter(
+	committee DataAvailabilityServiceWriter,
+	committeeKeysetBytes []byte,
+	ic *CertifyAfterStoreICDAWriter,
+	policy daprovider.HybridPolicy,
+) (*HybridICDAWriter, error) {
+	committeeKeyset, err := daprovider.DeserializeCommitteeKeyset(bytes.NewReader(committeeKeysetBytes), true)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read committee keyset: %w", err)
+	}
+	keyset := &daprovider.HybridKeyset{
+		Committee: committeeKeyset,
+		IC:        ic.keyset,
+		Policy:    policy,
+	}
+
+	ksBuf := bytes.NewBuffer([]byte{})
+	if err := keyset.Serialize(ksBuf); err != nil {
+		return nil, err
+	}
+	ksHash, err := keyset.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	return &HybridICDAWriter{
+		committee:   committee,
+		ic:          ic,
+		keyset:      keyset,
+		keysetHash:  ksHash,
+		keysetBytes: ksBuf.Bytes(),
+	}, nil
+}
+
+func (w *HybridICDAWriter) Store(ctx context.Context, message []byte, timeout uint64) (*daprovider.ICDACertificate, error) {
+	log.Trace("das.HybridICDAWriter.Store", "message", pretty.FirstFewBytes(message), "timeout", time.Unix(int64(timeout), 0), "this", w)
+
+	var dasCert *daprovider.DataAvailabilityCertificate
+	var committeeErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dasCert, committeeErr = w.committee.Store(ctx, message, timeout)
+		if committeeErr == nil && dasCert.Version != 1 {
+			committeeErr = fmt.Errorf("committee returned a version %d certificate", dasCert.Version)
+		}
+	}()
+	icCert, icErr := w.ic.Store(ctx, message, timeout)
+	<-done
+
+	if committeeErr != nil || icErr != nil {
+		err := errors.Join(committeeErr, icErr)
+		if w.keyset.Policy == daprovider.HybridPolicyRequireBoth || (committeeErr != nil && icErr != nil) {
+			return nil, fmt.Errorf("%w: %w", daprovider.ErrBatchToICDAFailed, err)
+		}
+		log.Warn("Only one of the committee and the IC vouched for the batch, posting it under the either policy", "err", err)
+	}
+
+	cert := &daprovider.ICDACertificate{
+		KeysetHash: w.keysetHash,
+		DataHash:   dastree.Hash(message),
+		Timeout:    timeout,
+		Version:    daprovider.ICDACertificateVersionHybrid,
+	}
+	if committeeErr == nil {
+		cert.SignersMask = dasCert.SignersMask
+		cert.Sig = dasCert.Sig
+	}
+	if icErr == nil {
+		cert.Proofs = icCert.Proofs
+	}
+	if err := cert.VerifyHybrid(w.keyset); err != nil {
+		return nil, fmt.Errorf("%w: %w", daprovider.ErrBatchToICDAFailed, err)
+	}
+	return cert, nil
+}
+
+func (w *HybridICDAWriter) String() string {
+	return fmt.Sprintf("HybridICDAWriter{committee: %v, ic: %v, policy: %v}", w.committee, w.ic, w.keyset.Policy)
+}
@@ -0,0 +1,117 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/blsSignatures"
+	"github.com/offchainlabs/nitro/das/ictest"
+)
+
+type unavailableCommittee struct{}
+
+func (unavailableCommittee) Store(ctx context.Context, message []byte, timeout uint64) (*daprovider.DataAvailabilityCertificate, error) {
+	return nil, errors.New("committee unavailable")
+}
+
+func (unavailableCommittee) String() string {
+	return "unavailableCommittee"
+}
+
+func testCommitteeAggregator(t *testing.T, ctx context.Context, size int) *Aggregator {
+	var backends []ServiceDetails
+	for i := 0; i < size; i++ {
+		privKey, err := blsSignatures.GeneratePrivKeyString()
+		Require(t, err)
+		config := DataAvailabilityConfig{
+			Enable:             true,
+			Key:                KeyConfig{PrivKey: privKey},
+			ParentChainNodeURL: "none",
+		}
+		das, err := NewSignAfterStoreDASWriter(ctx, config, NewMemoryBackedStorageService(ctx))
+		Require(t, err)
+		details, err := NewServiceDetails(das, *das.pubKey, uint64(1<<i), "service"+strconv.Itoa(i))
+		Require(t, err)
+		backends = append(backends, *details)
+	}
+	aggregator, err := NewAggregator(ctx, DataAvailabilityConfig{RPCAggregator: AggregatorConfig{AssumedHonest: 1}, ParentChainNodeURL: "none"}, backends)
+	Require(t, err)
+	return aggregator
+}
+
+func TestHybridICDAWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+
+	storageService, err := NewICStorageService(testICStorageConfig(replica, 1000), nil)
+	Require(t, err)
+	icWriter, err := NewCertifyAfterStoreICDAWriter(storageService)
+	Require(t, err)
+	aggregator := testCommitteeAggregator(t, ctx, 2)
+	writer, err := NewHybridICDAWriter(aggregator, aggregator.keysetBytes, icWriter, daprovider.HybridPolicyRequireBoth)
+	Require(t, err)
+
+	timeout := uint64(time.Now().Add(time.Hour).Unix())
+	cert, err := writer.Store(ctx, []byte("a batch both the committee and the IC vouch for"), timeout)
+	Require(t, err)
+	if cert.Version != daprovider.ICDACertificateVersionHybrid || cert.SignersMask == 0 || len(cert.Proofs) != 1 {
+		Fail(t, "version", cert.Version, "certificate has signers mask", cert.SignersMask, "and", len(cert.Proofs), "proofs")
+	}
+
+	serialized, err := cert.Serialize()
+	Require(t, err)
+	deserialized, err := daprovider.DeserializeICDACertFrom(bytes.NewReader(serialized))
+	Require(t, err)
+	keyset, err := daprovider.DeserializeHybridKeyset(bytes.NewReader(writer.keysetBytes), false)
+	Require(t, err)
+	keysetHash, err := keyset.Hash()
+	Require(t, err)
+	if keysetHash != deserialized.KeysetHash {
+		Fail(t, "certificate is for keyset", deserialized.KeysetHash, "not", keysetHash)
+	}
+	Require(t, deserialized.VerifyHybrid(keyset))
+
+	withoutCommittee := *deserialized
+	withoutCommittee.SignersMask = 0
+	if !errors.Is(withoutCommittee.VerifyHybrid(keyset), daprovider.ErrNoCommitteeSignature) {
+		Fail(t, "certificate without the committee signature verified under the both policy")
+	}
+	withoutIC := *deserialized
+	withoutIC.Proofs = nil
+	if withoutIC.VerifyHybrid(keyset) == nil {
+		Fail(t, "certificate without IC proofs verified under the both policy")
+	}
+	either := *keyset
+	either.Policy = daprovider.HybridPolicyRequireEither
+	Require(t, withoutCommittee.VerifyHybrid(&either))
+	Require(t, withoutIC.VerifyHybrid(&either))
+
+	// The committee alone can't make up for a missing IC certificate under the both policy,
+	// and the other way around, but either is enough under the either policy.
+	bothWriter, err := NewHybridICDAWriter(unavailableCommittee{}, aggregator.keysetBytes, icWriter, daprovider.HybridPolicyRequireBoth)
+	Require(t, err)
+	if _, err := bothWriter.Store(ctx, []byte("a batch only the IC vouches for"), timeout); !errors.Is(err, daprovider.ErrBatchToICDAFailed) {
+		Fail(t, "expected the both policy to fail without the committee, got", err)
+	}
+	eitherWriter, err := NewHybridICDAWriter(unavailableCommittee{}, aggregator.keysetBytes, icWriter, daprovider.HybridPolicyRequireEither)
+	Require(t, err)
+	cert, err = eitherWriter.Store(ctx, []byte("a batch only the IC vouches for"), timeout)
+	Require(t, err)
+	if cert.SignersMask != 0 || len(cert.Proofs) != 1 {
+		Fail(t, "certificate without the committee has signers mask", cert.SignersMask, "and", len(cert.Proofs), "proofs")
+	}
+	if cert.KeysetHash == deserialized.KeysetHash {
+		Fail(t, "both and either policies share a keyset")
+	}
+}
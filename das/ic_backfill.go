@@ -0,0 +1,371 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/erasure"
+)
+
+// ICBackfillStats counts what a backfill did with the batches it visited.
+type ICBackfillStats struct {
+	Batches          int
+	Uploaded         int
+	AlreadyCertified int
+	Expired          int
+	// Shards counts the erasure-coded shards of batches the source keeps as a committee member,
+	// which aren't batches and so are skipped.
+	Shards int
+}
+
+// ICBackfiller uploads the batches held by a LocalFileStorageService or an S3StorageService to
+// the IC storage canisters, so that a chain moving from AnyTrust to ICDA keeps its whole history
+// available. Batches are visited in the order of their hashes, and the hash of the last one that
+// was backfilled is written to the checkpoint file, so that an interrupted backfill resumes where
+// it stopped.
+type ICBackfiller struct {
+	source         StorageService
+	ic             *ICStorageService
+	keyset         *daprovider.ICDAKeyset
+	checkpointFile string
+}
+
+// NewICBackfiller creates a backfiller from source to ic. checkpointFile may be empty, in which
+// case every run starts from the first batch.
+func NewICBackfiller(source StorageService, ic *ICStorageService, checkpointFile string) (*ICBackfiller, error) {
+	switch source.(type) {
+	case *LocalFileStorageService, *S3StorageService:
+	default:
+		return nil, fmt.Errorf("can't list the batches of %v, only local file and S3 storage can be backfilled", source)
+	}
+	keyset := &daprovider.ICDAKeyset{Threshold: uint64(ic.CertificateThreshold), RootKey: ic.RootKey}
+	for _, canister := range ic.Canisters {
+		keyset.Canisters = append(keyset.Canisters, canister.Raw)
+	}
+	return &ICBackfiller{
+		source:         source,
+		ic:             ic,
+		keyset:         keyset,
+		checkpointFile: checkpointFile,
+	}, nil
+}
+
+// backfillBatch is a batch to backfill, with its expiry if the source records one, 0 otherwise.
+type backfillBatch struct {
+	key    common.Hash
+	expiry uint64
+}
+
+type backfillIterator interface {
+	// next returns the batches in the order of their hashes, and io.EOF after the last one.
+	next(ctx context.Context) (backfillBatch, error)
+}
+
+// Backfill uploads the batches after the checkpoint that the canisters haven't certified yet, and
+// checks that the canisters then certify each of them with its hash and size. It stops at the
+// first batch that can't be backfilled, so that rerunning it retries that batch.
+func (b *ICBackfiller) Backfill(ctx context.Context) (ICBackfillStats, error) {
+	var stats ICBackfillStats
+	after, err := b.readCheckpoint()
+	if err != nil {
+		return stats, err
+	}
+	policy, err := b.source.ExpirationPolicy(ctx)
+	if err != nil {
+		return stats, err
+	}
+	it, err := b.batches(after)
+	if err != nil {
+		return stats, err
+	}
+
+	start := time.Now()
+	for {
+		batch, err := it.next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Batches++
+
+		now := time.Now()
+		if policy == daprovider.DiscardAfterDataTimeout && batch.expiry != 0 && batch.expiry <= uint64(now.Unix()) {
+			stats.Expired++
+		} else {
+			uploaded, err := b.backfill(ctx, batch, now)
+			if errors.Is(err, errBackfillShard) {
+				stats.Shards++
+			} else if err != nil {
+				return stats, fmt.Errorf("couldn't backfill batch %v: %w", batch.key, err)
+			} else if uploaded {
+				stats.Uploaded++
+			} else {
+				stats.AlreadyCertified++
+			}
+		}
+		if err := b.writeCheckpoint(batch.key); err != nil {
+			return stats, err
+		}
+		if stats.Batches%1000 == 0 {
+			log.Info("Backfilling IC storage", "batches", stats.Batches, "uploaded", stats.Uploaded, "lastBatch", batch.key, "duration", time.Since(start))
+		}
+	}
+	log.Info("IC storage backfill complete", "batches", stats.Batches, "uploaded", stats.Uploaded, "alreadyCertified", stats.AlreadyCertified, "expired", stats.Expired, "shards", stats.Shards, "duration", time.Since(start))
+	return stats, nil
+}
+
+var errBackfillShard = errors.New("stored entry is an erasure-coded shard")
+
+// backfill uploads the batch unless the canisters already certified it, and returns whether it did.
+func (b *ICBackfiller) backfill(ctx context.Context, batch backfillBatch, now time.Time) (bool, error) {
+	data, err := b.source.GetByHash(ctx, batch.key)
+	if err != nil {
+		return false, err
+	}
+	if !dastree.ValidHash(batch.key, data) {
+		if shard, err := erasure.DeserializeShard(data); err == nil && erasure.ShardKey(shard.Root) == batch.key {
+			return false, errBackfillShard
+		}
+		return false, fmt.Errorf("%w: stored batch doesn't match its hash", daprovider.ErrHashMismatch)
+	}
+
+	if err := b.verifyCertified(ctx, batch.key, data); err == nil {
+		return false, nil
+	} else if errors.Is(err, daprovider.ErrInvalidICDAProof) {
+		return false, err
+	}
+
+	// Batches the source keeps forever, or whose expiry it doesn't record, are kept as long as
+	// the canisters allow.
+	expiry := uint64(now.Add(b.ic.maxRetention).Unix())
+	if batch.expiry != 0 && batch.expiry < expiry {
+		expiry = batch.expiry
+	}
+	if err := b.ic.Put(ctx, data, expiry); err != nil {
+		return false, err
+	}
+	return true, b.verifyCertified(ctx, batch.key, data)
+}
+
+// verifyCertified checks that threshold canisters certified the batch with its hash and size.
+func (b *ICBackfiller) verifyCertified(ctx context.Context, key common.Hash, data []byte) error {
+	proofs, err := b.ic.GetCertifiedProofsByHash(ctx, key, int(b.keyset.Threshold))
+	if err != nil {
+		return err
+	}
+	for i := range proofs {
+		if err := verifyICCertifiedData(b.keyset, key, data, &proofs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *ICBackfiller) readCheckpoint() (*common.Hash, error) {
+	if b.checkpointFile == "" {
+		return nil, nil
+	}
+	contents, err := os.ReadFile(b.checkpointFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := DecodeStorageServiceKey(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IC backfill checkpoint %s: %w", b.checkpointFile, err)
+	}
+	log.Info("Resuming IC storage backfill", "after", key)
+	return &key, nil
+}
+
+// writeCheckpoint records that every batch up to key was backfilled. The file is replaced
+// atomically, so that it's never left half written.
+func (b *ICBackfiller) writeCheckpoint(key common.Hash) error {
+	if b.checkpointFile == "" {
+		return nil
+	}
+	tmp := b.checkpointFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(EncodeStorageServiceKey(key)+"\n"), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.checkpointFile)
+}
+
+// batches returns the batches of the source whose hashes come after the given one.
+func (b *ICBackfiller) batches(after *common.Hash) (backfillIterator, error) {
+	switch source := b.source.(type) {
+	case *LocalFileStorageService:
+		return localFileBackfillBatches(source, after)
+	case *S3StorageService:
+		return s3BackfillBatches(source, after), nil
+	}
+	return nil, fmt.Errorf("can't list the batches of %v", b.source)
+}
+
+type sliceBackfillIterator []backfillBatch
+
+func (it *sliceBackfillIterator) next(ctx context.Context) (backfillBatch, error) {
+	if len(*it) == 0 {
+		return backfillBatch{}, io.EOF
+	}
+	batch := (*it)[0]
+	*it = (*it)[1:]
+	return batch, nil
+}
+
+// localFileBackfillBatches lists the batches of the local file storage with the trie layout
+// iterators, taking their expiries from the by-expiry-timestamp index, or from the legacy flat
+// layout if the storage hasn't been migrated yet. The directories aren't listed in any particular
+// order, so all batches are listed and sorted up front.
+func localFileBackfillBatches(s *LocalFileStorageService, after *common.Hash) (backfillIterator, error) {
+	expiries := map[common.Hash]uint64{}
+	migrated, err := s.layout.migrated()
+	if err != nil {
+		return nil, err
+	}
+	if migrated {
+		it, err := s.layout.iterateBatches()
+		if err != nil {
+			return nil, err
+		}
+		for batchPath, err := it.next(); !errors.Is(err, io.EOF); batchPath, err = it.next() {
+			if err != nil {
+				return nil, err
+			}
+			key, err := DecodeStorageServiceKey(filepath.Base(batchPath))
+			if err != nil {
+				return nil, err
+			}
+			expiries[key] = 0
+		}
+		if err := localFileExpiries(&s.layout, expiries); err != nil {
+			return nil, err
+		}
+	} else {
+		it, err := s.legacyLayout.iterateBatches()
+		if err != nil {
+			return nil, err
+		}
+		for batch, err := it.next(); !errors.Is(err, io.EOF); batch, err = it.next() {
+			if err != nil {
+				return nil, err
+			}
+			expiries[batch.key] = uint64(batch.expiry.Unix())
+		}
+	}
+
+	batches := sliceBackfillIterator{}
+	for key, expiry := range expiries {
+		if after == nil || bytes.Compare(key[:], after[:]) > 0 {
+			batches = append(batches, backfillBatch{key: key, expiry: expiry})
+		}
+	}
+	slices.SortFunc(batches, func(a, b backfillBatch) int {
+		return bytes.Compare(a.key[:], b.key[:])
+	})
+	return &batches, nil
+}
+
+// localFileExpiries sets the expiries of the batches from the by-expiry-timestamp index. A batch
+// that was stored several times has several entries, of which the latest counts.
+func localFileExpiries(l *trieLayout, expiries map[common.Hash]uint64) error {
+	// beyond any expiry
+	it, err := l.iterateBatchesByTimestamp(time.Unix(1<<62, 0))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for expiryPath, err := it.next(); !errors.Is(err, io.EOF); expiryPath, err = it.next() {
+		if err != nil {
+			return err
+		}
+		key, err := DecodeStorageServiceKey(filepath.Base(expiryPath))
+		if err != nil {
+			return err
+		}
+		secondDir := filepath.Dir(expiryPath)
+		firstDir := filepath.Dir(secondDir)
+		expiry, err := strconv.ParseUint(filepath.Base(firstDir)+filepath.Base(secondDir), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid expiry index entry %s: %w", expiryPath, err)
+		}
+		if current, ok := expiries[key]; ok && expiry > current {
+			expiries[key] = expiry
+		}
+	}
+	return nil
+}
+
+// s3BackfillIterator lists the batches of an S3 bucket page by page. S3 lists keys in ascending
+// order, which is the order of the hashes they encode.
+type s3BackfillIterator struct {
+	pages   *s3.ListObjectsV2Paginator
+	prefix  string
+	objects []types.Object
+}
+
+func s3BackfillBatches(s3s *S3StorageService, after *common.Hash) backfillIterator {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s3s.bucket),
+		Prefix: aws.String(s3s.objectPrefix),
+	}
+	if after != nil {
+		input.StartAfter = aws.String(s3s.objectPrefix + EncodeStorageServiceKey(*after))
+	}
+	return &s3BackfillIterator{
+		pages:  s3.NewListObjectsV2Paginator(s3s.client, input),
+		prefix: s3s.objectPrefix,
+	}
+}
+
+func (it *s3BackfillIterator) next(ctx context.Context) (backfillBatch, error) {
+	for {
+		for len(it.objects) > 0 {
+			name := strings.TrimPrefix(aws.ToString(it.objects[0].Key), it.prefix)
+			it.objects = it.objects[1:]
+			if !isStorageServiceKey(name) {
+				continue
+			}
+			key, err := DecodeStorageServiceKey(name)
+			if err != nil {
+				return backfillBatch{}, err
+			}
+			// S3 doesn't list the expiry of the objects.
+			return backfillBatch{key: key}, nil
+		}
+		if !it.pages.HasMorePages() {
+			return backfillBatch{}, io.EOF
+		}
+		page, err := it.pages.NextPage(ctx)
+		if err != nil {
+			return backfillBatch{}, err
+		}
+		it.objects = page.Contents
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/ictest"
+)
+
+func TestICBackfillFromLocalFileStorage(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+	icConfig := testICStorageConfig(replica, 1000)
+	icConfig.EnableExpiry = true
+	icConfig.MaxRetention = time.Hour * 24
+	storageService, err := NewICStorageService(icConfig, nil)
+	Require(t, err)
+
+	dir := t.TempDir()
+	local, err := NewLocalFileStorageService(LocalFileStorageConfig{
+		Enable:       true,
+		DataDir:      dir,
+		EnableExpiry: true,
+		MaxRetention: time.Hour * 24,
+	})
+	Require(t, err)
+	timeout := uint64(time.Now().Add(time.Hour).Unix())
+	batches := [][]byte{[]byte("first batch"), []byte("second batch"), []byte("third batch")}
+	for _, batch := range batches {
+		Require(t, local.Put(ctx, batch, timeout))
+	}
+	expired := []byte("expired batch")
+	Require(t, local.Put(ctx, expired, uint64(time.Now().Add(-time.Hour).Unix())))
+	// One of the batches already made it to IC storage.
+	Require(t, storageService.Put(ctx, batches[0], timeout))
+
+	checkpoint := filepath.Join(dir, "ic-backfill-checkpoint")
+	backfiller, err := NewICBackfiller(local, storageService, checkpoint)
+	Require(t, err)
+	stats, err := backfiller.Backfill(ctx)
+	Require(t, err)
+	if stats != (ICBackfillStats{Batches: 4, Uploaded: 2, AlreadyCertified: 1, Expired: 1}) {
+		Fail(t, "unexpected backfill stats", stats)
+	}
+	for _, batch := range batches {
+		data, err := storageService.GetByHash(ctx, dastree.Hash(batch))
+		Require(t, err)
+		if !bytes.Equal(data, batch) {
+			Fail(t, "IC storage returned different data")
+		}
+	}
+	if _, err := storageService.GetCertifiedByHash(ctx, dastree.Hash(expired)); !errors.Is(err, ErrNotFound) {
+		Fail(t, "expected the expired batch not to be backfilled, got", err)
+	}
+
+	// A rerun resumes after the last batch, so there is nothing left to do.
+	stats, err = backfiller.Backfill(ctx)
+	Require(t, err)
+	if stats != (ICBackfillStats{}) {
+		Fail(t, "rerun visited batches again", stats)
+	}
+
+	// Without the checkpoint, everything is visited again, but nothing is uploaded twice.
+	Require(t, os.Remove(checkpoint))
+	uploads := replica.Calls("upload_chunk")
+	stats, err = backfiller.Backfill(ctx)
+	Require(t, err)
+	if stats != (ICBackfillStats{Batches: 4, AlreadyCertified: 3, Expired: 1}) {
+		Fail(t, "unexpected backfill stats without checkpoint", stats)
+	}
+	if replica.Calls("upload_chunk") != uploads {
+		Fail(t, "batches were uploaded again")
+	}
+
+	if _, err := NewICBackfiller(NewMemoryBackedStorageService(ctx), storageService, ""); err == nil {
+		Fail(t, "expected storage that can't be listed to be rejected")
+	}
+}
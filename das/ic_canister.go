@@ -0,0 +1,249 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/CommoDor64/icdaserver/icutils"
+
+	"github.com/aviate-labs/agent-go"
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+)
+
+// icCanister is a client for the ICDA storage canister.
+//
+// Batches are uploaded in chunks that each fit in a single ingress message, and are then
+// finalized under their dastree root. The canister checks the root of the reassembled batch
+// on finalize and only then certifies it, see daprovider.VerifyICWitness for the layout of
+// the certified tree. Chunks of a batch that hasn't been finalized yet are kept by the canister,
+// so an interrupted upload can be resumed by only uploading the chunks it is missing.
+//
+// Canisters deployed before this API only implement the store and fetch methods of icutils,
+// which keep whole batches under their hex encoded root. They are still stored to and read
+// from through icutils, but can't certify batches for ICDA certificates, see apiVersion.
+type icCanister struct {
+	agent *agent.Agent
+	id    principal.Principal
+	// queryBackoff is set while reads from the canister skip queries, see ICStorageConfig.ReadMode.
+	queryBackoff icQueryBackoff
+
+	// legacyConfig is what the icutils agent is created with for a canister that predates the API.
+	legacyConfig agent.Config
+	apiMutex     sync.Mutex
+	api          *uint32
+	legacy       *icutils.Agent
+}
+
+// icStorageAPIVersion is the version of the storage API this client implements.
+const icStorageAPIVersion = 1
+
+// icBatch is the canister's answer to "get_batch", the witness proves the size, chunk count and expiry.
+type icBatch struct {
+	Size       uint64 `ic:"size"`
+	ChunkCount uint32 `ic:"chunk_count"`
+	// Expiry is the unix time after which the batch may be pruned, 0 if it's kept forever.
+	Expiry      uint64 `ic:"expiry"`
+	Certificate []byte `ic:"certificate"`
+	Witness     []byte `ic:"witness"`
+}
+
+// icChunk is the canister's answer to "get_chunk", the witness proves the hash of the chunk.
+type icChunk struct {
+	Chunk       []byte `ic:"chunk"`
+	Certificate []byte `ic:"certificate"`
+	Witness     []byte `ic:"witness"`
+}
+
+// icStatus is the canister's answer to "status", it isn't certified and is only used for monitoring.
+type icStatus struct {
+	Cycles idl.Nat `ic:"cycles"`
+	// StoredBytes is the size of the finalized batches.
+	StoredBytes uint64 `ic:"stored_bytes"`
+	// MemoryBytes is all the memory used by the canister, including pending uploads.
+	MemoryBytes uint64 `ic:"memory_bytes"`
+	// MemoryLimit is the memory the canister may use, 0 if it has no limit.
+	MemoryLimit uint64 `ic:"memory_limit"`
+}
+
+func (c *icCanister) proof(certificate, witness []byte) *daprovider.ICCertifiedData {
+	return &daprovider.ICCertifiedData{
+		Certificate: certificate,
+		Witness:     witness,
+		Canister:    c.id.Raw,
+	}
+}
+
+// query and call classify the errors of the agent, see classifyICError. The agent panics on
+// some malformed responses, which must not take the node down either.
+func (c *icCanister) query(method string, in, out []any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %s: agent panicked: %v", ErrICRejected, method, r)
+		}
+	}()
+	return classifyICError(method, c.agent.Query(c.id, method, in, out))
+}
+
+func (c *icCanister) call(method string, in, out []any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %s: agent panicked: %v", ErrICRejected, method, r)
+		}
+	}()
+	return classifyICError(method, c.agent.Call(c.id, method, in, out))
+}
+
+// apiVersion returns the version of the storage API the canister implements, which it is asked
+// for once. Canisters that don't implement "api_version" predate the API and are version 0. It's
+// asked for with a replicated call, as the agent fails to check the signature of query rejects.
+func (c *icCanister) apiVersion() (uint32, error) {
+	c.apiMutex.Lock()
+	defer c.apiMutex.Unlock()
+	if c.api != nil {
+		return *c.api, nil
+	}
+	var version uint32
+	if err := c.call("api_version", []any{}, []any{&version}); err != nil {
+		if !isICMethodNotFound(err) {
+			return 0, err
+		}
+		legacy, err := icutils.NewAgent(c.id, c.legacyConfig)
+		if err != nil {
+			return 0, fmt.Errorf("couldn't create the icutils agent for canister %s: %w", c.id, err)
+		}
+		log.Warn("IC storage canister predates the storage API, batches are stored whole and it can't certify them for ICDA certificates", "canister", c.id)
+		c.legacy = legacy
+	} else if version > icStorageAPIVersion {
+		log.Warn("IC storage canister implements a newer storage API, your node software is probably out of date", "canister", c.id, "version", version)
+	}
+	c.api = &version
+	return version, nil
+}
+
+// storeLegacy stores a batch whole on a canister that predates the storage API.
+func (c *icCanister) storeLegacy(root common.Hash, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: store: agent panicked: %v", ErrICRejected, r)
+		}
+	}()
+	_, err = c.legacy.Store(root.Hex(), data)
+	return classifyICError("store", err)
+}
+
+// fetchLegacy fetches a batch from a canister that predates the storage API, and checks it
+// against the canister's certified data.
+func (c *icCanister) fetchLegacy(root common.Hash, rootKey []byte) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			data, err = nil, fmt.Errorf("%w: fetch: agent panicked: %v", ErrICRejected, r)
+		}
+	}()
+	cb, err := c.legacy.Fetch(root.Hex())
+	if err != nil {
+		return nil, classifyICError("fetch", err)
+	}
+	if _, err := icutils.VerifyDataFromIC(cb.Certificate, rootKey, c.id, cb.Witness, cb.Data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrICCertificateInvalid, err)
+	}
+	return cb.Data, nil
+}
+
+// uploadedChunks returns the indexes of the chunks of a pending batch the canister already has.
+func (c *icCanister) uploadedChunks(root common.Hash) ([]uint32, error) {
+	var indexes []uint32
+	if err := c.query("upload_status", []any{root.Bytes()}, []any{&indexes}); err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+func (c *icCanister) uploadChunk(root common.Hash, index uint32, chunk []byte) error {
+	return c.call("upload_chunk", []any{root.Bytes(), index, chunk}, []any{})
+}
+
+// finalize asks the canister to reassemble the chunks of a batch and to certify it under its root.
+// Finalizing a batch that is already stored extends its expiry if the new one is later.
+func (c *icCanister) finalize(root common.Hash, chunkCount uint32, size uint64, expiry uint64) error {
+	return c.call("finalize", []any{root.Bytes(), chunkCount, size, expiry}, []any{})
+}
+
+// prune asks the canister to delete the batches that have expired by its own clock, and
+// returns how many it deleted.
+func (c *icCanister) prune() (uint32, error) {
+	var pruned uint32
+	if err := c.call("prune", []any{}, []any{&pruned}); err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
+
+// allowedWriters returns the principals the canister accepts uploads from, any principal if it's empty.
+func (c *icCanister) allowedWriters() ([]principal.Principal, error) {
+	var writers []principal.Principal
+	if err := c.query("allowed_writers", []any{}, []any{&writers}); err != nil {
+		return nil, err
+	}
+	return writers, nil
+}
+
+// status returns the cycles balance and memory usage of the canister.
+func (c *icCanister) status() (*icStatus, error) {
+	var status icStatus
+	if err := c.query("status", []any{}, []any{&status}); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// getBatch returns the certified metadata of a finalized batch, or ErrNotFound if the canister doesn't have it.
+func (c *icCanister) getBatch(root common.Hash) (*icBatch, error) {
+	return c.readBatch(c.query, root)
+}
+
+// getBatchReplicated is getBatch as a replicated update call. Only queries get a data certificate,
+// so the reply comes without a certificate and witness, but the agent checks it against the
+// certified state of the subnet.
+func (c *icCanister) getBatchReplicated(root common.Hash) (*icBatch, error) {
+	return c.readBatch(c.call, root)
+}
+
+func (c *icCanister) readBatch(read func(method string, in, out []any) error, root common.Hash) (*icBatch, error) {
+	var batch *icBatch
+	if err := read("get_batch", []any{root.Bytes()}, []any{&batch}); err != nil {
+		return nil, err
+	}
+	if batch == nil {
+		return nil, ErrNotFound
+	}
+	return batch, nil
+}
+
+// getChunk returns a chunk of a finalized batch with its proof, or ErrNotFound if the canister doesn't have it.
+func (c *icCanister) getChunk(root common.Hash, index uint32) (*icChunk, error) {
+	return c.readChunk(c.query, root, index)
+}
+
+// getChunkReplicated is getChunk as a replicated update call, see getBatchReplicated.
+func (c *icCanister) getChunkReplicated(root common.Hash, index uint32) (*icChunk, error) {
+	return c.readChunk(c.call, root, index)
+}
+
+func (c *icCanister) readChunk(read func(method string, in, out []any) error, root common.Hash, index uint32) (*icChunk, error) {
+	var chunk *icChunk
+	if err := read("get_chunk", []any{root.Bytes(), index}, []any{&chunk}); err != nil {
+		return nil, err
+	}
+	if chunk == nil {
+		return nil, ErrNotFound
+	}
+	return chunk, nil
+}
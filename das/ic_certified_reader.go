@@ -0,0 +1,110 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	flag "github.com/spf13/pflag"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/das/dastree"
+)
+
+// CertifiedDASReader is a reader that returns a batch together with the proof that an IC storage
+// canister certified it, so that a batch served by an untrusted mirror can be checked against the
+// IC root key without running an IC agent.
+type CertifiedDASReader interface {
+	GetCertifiedDataByHash(ctx context.Context, hash common.Hash) ([]byte, *daprovider.ICCertifiedData, error)
+}
+
+// ICCertifiedReadConfig configures REST clients to fetch batches with their IC certificates and
+// check them client-side.
+type ICCertifiedReadConfig struct {
+	Enable    bool     `koanf:"enable"`
+	RootKey   string   `koanf:"root-key"`
+	Canisters []string `koanf:"canisters"`
+}
+
+var DefaultICCertifiedReadConfig = ICCertifiedReadConfig{
+	Canisters: []string{},
+}
+
+func ICCertifiedReadConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultICCertifiedReadConfig.Enable, "fetch batches from REST endpoints together with the proof that an IC storage canister certified them, and reject batches whose proof doesn't check out, so that the endpoints needn't be trusted")
+	f.String(prefix+".root-key", DefaultICCertifiedReadConfig.RootKey, "DER encoded IC root key the proofs are checked against, can be a file or the hex-encoded key beginning with 0x; defaults to the IC mainnet root key")
+	f.StringSlice(prefix+".canisters", DefaultICCertifiedReadConfig.Canisters, "textual ids of the storage canisters whose proofs are accepted; if empty, proofs from any canister are accepted")
+}
+
+// Keyset returns the keyset the proofs are checked against, which needs a single proof from any
+// of the configured canisters.
+func (c *ICCertifiedReadConfig) Keyset() (*daprovider.ICDAKeyset, error) {
+	rootKey, err := ParseICRootKey(c.RootKey)
+	if err != nil {
+		return nil, err
+	}
+	keyset := &daprovider.ICDAKeyset{Threshold: 1, RootKey: rootKey}
+	for _, canister := range c.Canisters {
+		id, err := principal.Decode(canister)
+		if err != nil {
+			return nil, fmt.Errorf("invalid canister id %q: %w", canister, err)
+		}
+		keyset.Canisters = append(keyset.Canisters, id.Raw)
+	}
+	return keyset, nil
+}
+
+// verifyICCertifiedData checks that an allowed canister certified the batch with the given
+// dastree root, and that the certified size is the size of the data.
+func verifyICCertifiedData(keyset *daprovider.ICDAKeyset, hash common.Hash, data []byte, proof *daprovider.ICCertifiedData) error {
+	if !dastree.ValidHash(hash, data) {
+		return daprovider.ErrHashMismatch
+	}
+	if !keyset.AllowsCanister(proof.Canister) {
+		return fmt.Errorf("%w: canister %x isn't allowed", daprovider.ErrInvalidICDAProof, proof.Canister)
+	}
+	size, err := daprovider.VerifyICWitness(proof, keyset.RootKey, daprovider.ICBatchPath(hash, daprovider.ICLabelSize))
+	if err != nil {
+		return fmt.Errorf("%w: %w", daprovider.ErrInvalidICDAProof, err)
+	}
+	if len(size) != 8 || binary.BigEndian.Uint64(size) != uint64(len(data)) {
+		return fmt.Errorf("%w: certified batch size doesn't match the %d bytes served", daprovider.ErrInvalidICDAProof, len(data))
+	}
+	return nil
+}
+
+// certifiedStorageReader serves a daserver's storage together with the proofs of its IC storage,
+// or of the REST endpoints it falls back to, so that its REST server can serve certified data.
+type certifiedStorageReader struct {
+	DataAvailabilityServiceReader
+	ic       *ICStorageService
+	fallback CertifiedDASReader
+}
+
+func (r *certifiedStorageReader) Has(ctx context.Context, hash common.Hash) (bool, error) {
+	return hasByHash(ctx, r.DataAvailabilityServiceReader, hash)
+}
+
+func (r *certifiedStorageReader) GetCertifiedDataByHash(ctx context.Context, hash common.Hash) ([]byte, *daprovider.ICCertifiedData, error) {
+	if r.ic != nil {
+		proof, err := r.ic.GetCertifiedByHash(ctx, hash)
+		if err == nil {
+			var data []byte
+			data, err = r.GetByHash(ctx, hash)
+			if err == nil {
+				return data, proof, nil
+			}
+		}
+		if r.fallback == nil {
+			return nil, nil, err
+		}
+		log.Debug("Couldn't serve certified batch from IC storage, trying REST endpoints", "hash", hash, "err", err)
+	}
+	return r.fallback.GetCertifiedDataByHash(ctx, hash)
+}
@@ -0,0 +1,96 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by the IC storage backend wrap one of these, or ErrNotFound if the
+// canister doesn't have the requested data.
+var (
+	// ErrICTransient is a failure that may go away on retry, such as a network error,
+	// an overloaded boundary node or a system transient reject.
+	ErrICTransient = errors.New("transient IC error")
+	// ErrICRejected is a request the canister or the IC refused to execute.
+	ErrICRejected = errors.New("rejected by IC")
+	// ErrICCertificateInvalid means data returned by the IC doesn't match its certificate.
+	ErrICCertificateInvalid = errors.New("invalid IC certificate")
+	// ErrICOverQuota means the canister ran out of cycles, memory or storage quota.
+	ErrICOverQuota = errors.New("IC canister over quota")
+)
+
+// IC reject codes, see the "Reject codes" section of the IC interface specification.
+const (
+	icRejectSysFatal     = 1
+	icRejectSysTransient = 2
+)
+
+// The agent reports rejects and HTTP errors as "(<code>) <message>", where the message of an
+// HTTP error ends with the response body, which may span several lines.
+var icCodedErrorRegexp = regexp.MustCompile(`(?s)^\((\d+)\) (.*)$`)
+
+// The IC rejects calls of methods a canister doesn't export with IC0302 for queries and IC0536
+// for updates.
+var icMethodNotFoundRegexp = regexp.MustCompile(`IC0302|IC0536|has no (query|update) method`)
+
+// isICMethodNotFound reports whether the canister rejected a call because it has no such method.
+func isICMethodNotFound(err error) bool {
+	return errors.Is(err, ErrICRejected) && icMethodNotFoundRegexp.MatchString(err.Error())
+}
+
+// classifyICError wraps an error returned by the agent with the kind of failure it represents.
+func classifyICError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	for _, kind := range []error{ErrNotFound, ErrICTransient, ErrICRejected, ErrICCertificateInvalid, ErrICOverQuota} {
+		if errors.Is(err, kind) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: %s: %w", icErrorKind(err), op, err)
+}
+
+func icErrorKind(err error) error {
+	message := err.Error()
+	lowerMessage := strings.ToLower(message)
+	if strings.Contains(lowerMessage, "out of cycles") || strings.Contains(lowerMessage, "out of memory") || strings.Contains(lowerMessage, "quota") {
+		return ErrICOverQuota
+	}
+	if match := icCodedErrorRegexp.FindStringSubmatch(message); match != nil {
+		code, _ := strconv.Atoi(match[1])
+		switch {
+		case code == icRejectSysTransient:
+			return ErrICTransient
+		case code == icRejectSysFatal:
+			return ErrICRejected
+		case code == 429 || code >= 500:
+			// HTTP errors from the boundary node or replica
+			return ErrICTransient
+		default:
+			return ErrICRejected
+		}
+	}
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) {
+		return ErrICTransient
+	}
+	if strings.HasPrefix(message, "out of time") {
+		// the agent gave up polling for the result of an update call
+		return ErrICTransient
+	}
+	return ErrICRejected
+}
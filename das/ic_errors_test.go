@@ -0,0 +1,55 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestClassifyICError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		kind error
+	}{
+		{errors.New("(2) IC0504: subnet is overloaded"), ErrICTransient},
+		{errors.New("(503) 503 Service Unavailable: try again"), ErrICTransient},
+		{errors.New("(503) 503 Service Unavailable: canister is unavailable\n"), ErrICTransient},
+		{errors.New("(429) 429 Too Many Requests: slow down"), ErrICTransient},
+		{&url.Error{Op: "Post", URL: "https://icp-api.io", Err: errors.New("connection refused")}, ErrICTransient},
+		{errors.New("out of time... waited 10 seconds"), ErrICTransient},
+		{errors.New("(5) IC0503: Canister trapped explicitly: chunk index out of range"), ErrICRejected},
+		{errors.New("(400) 400 Bad Request: invalid request"), ErrICRejected},
+		{errors.New("(5) IC0207: Canister bkyz2-fmaaa-aaaaa-qaaaq-cai is out of cycles"), ErrICOverQuota},
+		{errors.New("(5) IC0503: Canister trapped explicitly: storage quota exceeded"), ErrICOverQuota},
+		{errors.New("candid: unexpected type"), ErrICRejected},
+	}
+	for _, tc := range testCases {
+		err := classifyICError("get_batch", tc.err)
+		if !errors.Is(err, tc.kind) {
+			Fail(t, "expected", tc.err, "to be classified as", tc.kind, "got", err)
+		}
+		if !errors.Is(err, tc.err) {
+			Fail(t, "classified error doesn't wrap the original error", err)
+		}
+	}
+
+	// Already classified errors and context errors are passed through unchanged.
+	for _, err := range []error{
+		ErrNotFound,
+		fmt.Errorf("%w: bad chunk", ErrICCertificateInvalid),
+		context.Canceled,
+		context.DeadlineExceeded,
+	} {
+		if classified := classifyICError("get_chunk", err); classified != err {
+			Fail(t, "expected", err, "to be passed through, got", classified)
+		}
+	}
+	if classifyICError("get_chunk", nil) != nil {
+		Fail(t, "nil error should stay nil")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aviate-labs/agent-go/identity"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/util/signature"
+)
+
+var (
+	ecPublicKeyOID = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	secp256k1OID   = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// icIdentity returns the identity the canisters are called with. Calls are anonymous, so that
+// the canisters only accept them if they don't restrict their writers, unless a key is configured.
+func (c *ICStorageConfig) icIdentity(dataSigner signature.DataSignerFunc) (identity.Identity, error) {
+	switch {
+	case c.UseBatchPosterKey:
+		if dataSigner == nil {
+			return nil, errors.New("ic-storage.use-batch-poster-key is set, but there is no batch poster wallet")
+		}
+		return NewICIdentityFromDataSigner(dataSigner)
+	case c.Identity != "":
+		return LoadICIdentity(c.Identity)
+	default:
+		return new(identity.AnonymousIdentity), nil
+	}
+}
+
+// LoadICIdentity reads an identity from a PEM file holding either an Ed25519 key, as generated
+// by dfx, or a secp256k1 key, with or without its EC parameters.
+func LoadICIdentity(pemFile string) (identity.Identity, error) {
+	data, err := os.ReadFile(pemFile)
+	if err != nil {
+		return nil, err
+	}
+	if id, err := identity.NewEd25519IdentityFromPEM(data); err == nil {
+		return id, nil
+	}
+	if id, err := identity.NewSecp256k1IdentityFromPEM(data); err == nil {
+		return id, nil
+	}
+	if id, err := identity.NewSecp256k1IdentityFromPEMWithoutParameters(data); err == nil {
+		return id, nil
+	}
+	return nil, fmt.Errorf("%s doesn't hold an Ed25519 or secp256k1 private key", pemFile)
+}
+
+// dataSignerIdentity is a secp256k1 identity whose key is only reachable through a signer,
+// such as the wallet of the batch poster.
+type dataSignerIdentity struct {
+	signer    signature.DataSignerFunc
+	publicKey *ecdsa.PublicKey
+	der       []byte
+}
+
+type icECPublicKey struct {
+	Metadata  []asn1.ObjectIdentifier
+	PublicKey asn1.BitString
+}
+
+// NewICIdentityFromDataSigner returns the IC identity of the secp256k1 key behind signer.
+func NewICIdentityFromDataSigner(signer signature.DataSignerFunc) (identity.Identity, error) {
+	// The signer doesn't expose the public key, so it is recovered from a signature.
+	hash := sha256.Sum256([]byte("IC identity"))
+	sig, err := signer(hash[:])
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := crypto.SigToPub(hash[:], sig)
+	if err != nil {
+		return nil, err
+	}
+	encoded := crypto.FromECDSAPub(publicKey)
+	der, err := asn1.Marshal(icECPublicKey{
+		Metadata:  []asn1.ObjectIdentifier{ecPublicKeyOID, secp256k1OID},
+		PublicKey: asn1.BitString{Bytes: encoded, BitLength: len(encoded) * 8},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &dataSignerIdentity{signer: signer, publicKey: publicKey, der: der}, nil
+}
+
+func (id *dataSignerIdentity) Sender() principal.Principal {
+	return principal.NewSelfAuthenticating(id.der)
+}
+
+// Sign returns the signature of the sha256 hash of msg as r || s. The identity interface has no
+// way to report errors, a request that couldn't be signed is rejected by the IC.
+func (id *dataSignerIdentity) Sign(msg []byte) []byte {
+	hash := sha256.Sum256(msg)
+	sig, err := id.signer(hash[:])
+	if err != nil {
+		log.Error("couldn't sign IC request", "err", err)
+		return nil
+	}
+	if len(sig) != crypto.SignatureLength {
+		log.Error("couldn't sign IC request", "err", fmt.Errorf("unexpected signature length %d", len(sig)))
+		return nil
+	}
+	return sig[:crypto.RecoveryIDOffset]
+}
+
+func (id *dataSignerIdentity) PublicKey() []byte {
+	return id.der
+}
+
+func (id *dataSignerIdentity) Verify(msg, sig []byte) bool {
+	hash := sha256.Sum256(msg)
+	return crypto.VerifySignature(crypto.FromECDSAPub(id.publicKey), hash[:], sig)
+}
+
+func (id *dataSignerIdentity) ToPEM() ([]byte, error) {
+	return nil, errors.New("the key of a signer identity can't be exported")
+}
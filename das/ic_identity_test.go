@@ -0,0 +1,108 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aviate-labs/agent-go/identity"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/offchainlabs/nitro/das/ictest"
+	"github.com/offchainlabs/nitro/util/signature"
+)
+
+func writeICIdentity(t *testing.T, id identity.Identity) string {
+	t.Helper()
+	encoded, err := id.ToPEM()
+	Require(t, err)
+	pemFile := filepath.Join(t.TempDir(), "identity.pem")
+	Require(t, os.WriteFile(pemFile, encoded, 0600))
+	return pemFile
+}
+
+func TestLoadICIdentity(t *testing.T) {
+	ed25519Identity, err := identity.NewRandomEd25519Identity()
+	Require(t, err)
+	secp256k1Identity, err := identity.NewRandomSecp256k1Identity()
+	Require(t, err)
+	for _, expected := range []identity.Identity{ed25519Identity, secp256k1Identity} {
+		loaded, err := LoadICIdentity(writeICIdentity(t, expected))
+		Require(t, err)
+		if !loaded.Sender().Equal(expected.Sender()) {
+			Fail(t, "loaded identity", loaded.Sender(), "expected", expected.Sender())
+		}
+	}
+
+	garbage := filepath.Join(t.TempDir(), "garbage.pem")
+	Require(t, os.WriteFile(garbage, []byte("not a key"), 0600))
+	if _, err := LoadICIdentity(garbage); err == nil {
+		Fail(t, "expected a file without a key to be rejected")
+	}
+}
+
+func TestICIdentityFromDataSigner(t *testing.T) {
+	privateKey, err := crypto.GenerateKey()
+	Require(t, err)
+	id, err := NewICIdentityFromDataSigner(signature.DataSignerFromPrivateKey(privateKey))
+	Require(t, err)
+	message := []byte("an IC request")
+	if !id.Verify(message, id.Sign(message)) {
+		Fail(t, "signature of the batch poster key doesn't verify")
+	}
+	if id.Verify([]byte("another IC request"), id.Sign(message)) {
+		Fail(t, "signature verified for a different message")
+	}
+}
+
+func TestICStorageServiceWriters(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+	timeout := uint64(time.Now().Add(time.Hour).Unix())
+
+	dasIdentity, err := identity.NewRandomEd25519Identity()
+	Require(t, err)
+	batchPosterKey, err := crypto.GenerateKey()
+	Require(t, err)
+	dataSigner := signature.DataSignerFromPrivateKey(batchPosterKey)
+	batchPosterIdentity, err := NewICIdentityFromDataSigner(dataSigner)
+	Require(t, err)
+	Require(t, replica.SetWriters(replica.CanisterID(), dasIdentity.Sender(), batchPosterIdentity.Sender()))
+
+	anonymous, err := NewICStorageService(testICStorageConfig(replica, 1000), nil)
+	Require(t, err)
+	if anonymous.CheckWriteAccess() == nil {
+		Fail(t, "expected anonymous writes to be refused")
+	}
+	if err := anonymous.Put(ctx, []byte("anonymous batch"), timeout); !errors.Is(err, ErrICRejected) {
+		Fail(t, "expected anonymous batch to be rejected, got", err)
+	}
+
+	config := testICStorageConfig(replica, 1000)
+	config.Identity = writeICIdentity(t, dasIdentity)
+	das, err := NewICStorageService(config, nil)
+	Require(t, err)
+	Require(t, das.CheckWriteAccess())
+	Require(t, das.Put(ctx, []byte("batch stored by the committee"), timeout))
+
+	config = testICStorageConfig(replica, 1000)
+	config.UseBatchPosterKey = true
+	if _, err := NewICStorageService(config, nil); err == nil {
+		Fail(t, "expected use-batch-poster-key to require a signer")
+	}
+	batchPoster, err := NewICStorageService(config, dataSigner)
+	Require(t, err)
+	if !batchPoster.Principal.Equal(batchPosterIdentity.Sender()) {
+		Fail(t, "storage service calls as", batchPoster.Principal, "expected", batchPosterIdentity.Sender())
+	}
+	Require(t, batchPoster.CheckWriteAccess())
+	Require(t, batchPoster.Put(ctx, []byte("batch stored by the batch poster"), timeout))
+}
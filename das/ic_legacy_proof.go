@@ -0,0 +1,34 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"encoding/json"
+
+	"github.com/CommoDor64/icdaserver/icutils"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+)
+
+// DeserializeICCertifiedDataJSON decodes a proof in the JSON encoding of icutils.CertifiedBlock,
+// which DAS headed ICDA batches carried before proofs had a binary encoding. The replay binary
+// doesn't decode these proofs, so this lives here rather than next to the binary encoding in
+// daprovider, which must not depend on the IC agent.
+func DeserializeICCertifiedDataJSON(data []byte) (*daprovider.ICCertifiedData, error) {
+	var cb icutils.CertifiedBlock
+	if err := json.Unmarshal(data, &cb); err != nil {
+		return nil, err
+	}
+	return ICCertifiedDataFromCertifiedBlock(&cb), nil
+}
+
+// ICCertifiedDataFromCertifiedBlock extracts the proof from a CertifiedBlock returned by a storage
+// canister that predates the storage API.
+func ICCertifiedDataFromCertifiedBlock(cb *icutils.CertifiedBlock) *daprovider.ICCertifiedData {
+	return &daprovider.ICCertifiedData{
+		Certificate: cb.Certificate,
+		Witness:     cb.Witness,
+		Canister:    icutils.ToPrincipal(cb.Canister).Raw,
+	}
+}
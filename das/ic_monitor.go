@@ -0,0 +1,181 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	icStoreSuccessCounter    = metrics.NewRegisteredCounter("arb/das/ic/store/success/total", nil)
+	icStoreFailureCounter    = metrics.NewRegisteredCounter("arb/das/ic/store/failure/total", nil)
+	icStoreBytesCounter      = metrics.NewRegisteredCounter("arb/das/ic/store/bytes/total", nil)
+	icStoreDurationHistogram = metrics.NewRegisteredHistogram("arb/das/ic/store/duration", nil, metrics.NewBoundedHistogramSample())
+	icFetchSuccessCounter    = metrics.NewRegisteredCounter("arb/das/ic/fetch/success/total", nil)
+	icFetchFailureCounter    = metrics.NewRegisteredCounter("arb/das/ic/fetch/failure/total", nil)
+	icFetchBytesCounter      = metrics.NewRegisteredCounter("arb/das/ic/fetch/bytes/total", nil)
+	icFetchDurationHistogram = metrics.NewRegisteredHistogram("arb/das/ic/fetch/duration", nil, metrics.NewBoundedHistogramSample())
+	// The latency of each read mode is reported separately, see ICStorageConfig.ReadMode.
+	icReadQueryDurationHistogram  = metrics.NewRegisteredHistogram("arb/das/ic/read/query/duration", nil, metrics.NewBoundedHistogramSample())
+	icReadQueryFailureCounter     = metrics.NewRegisteredCounter("arb/das/ic/read/query/failure/total", nil)
+	icReadUpdateDurationHistogram = metrics.NewRegisteredHistogram("arb/das/ic/read/update/duration", nil, metrics.NewBoundedHistogramSample())
+	icReadUpdateFailureCounter    = metrics.NewRegisteredCounter("arb/das/ic/read/update/failure/total", nil)
+	icReadFallbackCounter         = metrics.NewRegisteredCounter("arb/das/ic/read/fallback/total", nil)
+
+	icCertifyRetryCounter      = metrics.NewRegisteredCounter("arb/das/ic/certify/retry/total", nil)
+	icCertifyTimeoutCounter    = metrics.NewRegisteredCounter("arb/das/ic/certify/timeout/total", nil)
+	icCertifyDurationHistogram = metrics.NewRegisteredHistogram("arb/das/ic/certify/duration", nil, metrics.NewBoundedHistogramSample())
+
+	// This metric shows 1 while any canister is degraded, see ICStorageService.HealthCheck.
+	// The status of each canister is reported under arb/das/ic/canister/<canister id>/.
+	icDegradedGauge = metrics.NewRegisteredGauge("arb/das/ic/degraded", nil)
+)
+
+// ErrICDegraded is reported by the health check of an IC storage service while one of its
+// canisters is running low on cycles or memory, or its status can't be queried.
+var ErrICDegraded = errors.New("IC storage degraded")
+
+type ICStorageMonitorConfig struct {
+	// Interval is how often the canisters are checked, 0 disables the monitor.
+	Interval time.Duration `koanf:"interval"`
+	// MinCycles is the cycles balance below which a canister is degraded.
+	MinCycles uint64 `koanf:"min-cycles"`
+	// MaxMemoryUsage is the fraction of its memory limit above which a canister is degraded.
+	MaxMemoryUsage float64 `koanf:"max-memory-usage"`
+}
+
+var DefaultICStorageMonitorConfig = ICStorageMonitorConfig{
+	Interval:       time.Minute,
+	MinCycles:      1_000_000_000_000,
+	MaxMemoryUsage: 0.9,
+}
+
+func ICStorageMonitorConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Duration(prefix+".interval", DefaultICStorageMonitorConfig.Interval, "interval at which the cycles balance and memory usage of the ic storage canisters are checked, 0 to disable")
+	f.Uint64(prefix+".min-cycles", DefaultICStorageMonitorConfig.MinCycles, "cycles balance below which an ic storage canister is reported as degraded")
+	f.Float64(prefix+".max-memory-usage", DefaultICStorageMonitorConfig.MaxMemoryUsage, "fraction of its memory limit above which an ic storage canister is reported as degraded")
+}
+
+func (c *ICStorageMonitorConfig) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("ic-storage.monitor.interval can't be negative")
+	}
+	if c.Interval == 0 {
+		return nil
+	}
+	if c.MaxMemoryUsage <= 0 || c.MaxMemoryUsage > 1 {
+		return fmt.Errorf("ic-storage.monitor.max-memory-usage must be above 0 and at most 1, got %v", c.MaxMemoryUsage)
+	}
+	return nil
+}
+
+// monitorCanisters queries the status of every canister, updates their metrics and records
+// which of them are degraded for the health check.
+func (s *ICStorageService) monitorCanisters(ctx context.Context) time.Duration {
+	var problems []error
+	for _, canister := range s.canisters {
+		if ctx.Err() != nil {
+			return 0
+		}
+		if err := s.checkCanister(canister); err != nil {
+			log.Warn("IC storage canister is degraded", "canister", canister.id, "err", err)
+			problems = append(problems, err)
+		}
+	}
+	degraded := errors.Join(problems...)
+	if degraded != nil {
+		icDegradedGauge.Update(1)
+	} else {
+		icDegradedGauge.Update(0)
+	}
+	s.degradedMutex.Lock()
+	s.degraded = degraded
+	s.degradedMutex.Unlock()
+	return s.monitor.Interval
+}
+
+func (s *ICStorageService) checkCanister(canister *icCanister) error {
+	metricBase := "arb/das/ic/canister/" + canister.id.Encode()
+	if version, err := canister.apiVersion(); err == nil && version == 0 {
+		// Canisters that predate the storage API don't report their status.
+		return nil
+	}
+	status, err := canister.status()
+	if err != nil {
+		metrics.GetOrRegisterCounter(metricBase+"/status/error/total", nil).Inc(1)
+		return fmt.Errorf("couldn't get the status of canister %s: %w", canister.id, err)
+	}
+	cycles := status.Cycles.BigInt()
+	cyclesGauge := int64(math.MaxInt64)
+	if cycles.IsInt64() {
+		cyclesGauge = cycles.Int64()
+	}
+	metrics.GetOrRegisterGauge(metricBase+"/cycles", nil).Update(cyclesGauge)
+	metrics.GetOrRegisterGauge(metricBase+"/stored_bytes", nil).Update(int64(status.StoredBytes))
+	metrics.GetOrRegisterGauge(metricBase+"/memory_bytes", nil).Update(int64(status.MemoryBytes))
+	metrics.GetOrRegisterGauge(metricBase+"/memory_limit", nil).Update(int64(status.MemoryLimit))
+
+	if cycles.Cmp(new(big.Int).SetUint64(s.monitor.MinCycles)) < 0 {
+		return fmt.Errorf("canister %s has %v cycles left, below the minimum of %d", canister.id, cycles, s.monitor.MinCycles)
+	}
+	if status.MemoryLimit > 0 && float64(status.MemoryBytes) > s.monitor.MaxMemoryUsage*float64(status.MemoryLimit) {
+		return fmt.Errorf("canister %s uses %d of its %d bytes of memory", canister.id, status.MemoryBytes, status.MemoryLimit)
+	}
+	return nil
+}
+
+// ICCanisterStatus is the status reported by a storage canister.
+type ICCanisterStatus struct {
+	Canister    principal.Principal
+	Cycles      *big.Int
+	StoredBytes uint64
+	MemoryBytes uint64
+	MemoryLimit uint64
+}
+
+// CanisterStatus queries the status of every canister. It returns the statuses of the canisters
+// that replied, in the order the canisters were configured, and an error for the others.
+func (s *ICStorageService) CanisterStatus() ([]ICCanisterStatus, error) {
+	var statuses []ICCanisterStatus
+	var errs []error
+	for _, canister := range s.canisters {
+		if version, err := canister.apiVersion(); err == nil && version == 0 {
+			errs = append(errs, fmt.Errorf("canister %s predates the storage API and doesn't report its status", canister.id))
+			continue
+		}
+		status, err := canister.status()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't get the status of canister %s: %w", canister.id, err))
+			continue
+		}
+		statuses = append(statuses, ICCanisterStatus{
+			Canister:    canister.id,
+			Cycles:      status.Cycles.BigInt(),
+			StoredBytes: status.StoredBytes,
+			MemoryBytes: status.MemoryBytes,
+			MemoryLimit: status.MemoryLimit,
+		})
+	}
+	return statuses, errors.Join(errs...)
+}
+
+// degradedError returns why the canisters were degraded when the monitor last checked them, if they were.
+func (s *ICStorageService) degradedError() error {
+	s.degradedMutex.Lock()
+	defer s.degradedMutex.Unlock()
+	if s.degraded == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrICDegraded, s.degraded)
+}
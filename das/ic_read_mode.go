@@ -0,0 +1,150 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+)
+
+const (
+	// ICReadModeQuery reads with queries, which are answered by a single replica and are fast.
+	// Their replies are checked against the certified data of the canister.
+	ICReadModeQuery = "query"
+	// ICReadModeUpdate reads with replicated update calls, which go through consensus and are
+	// slow, but are answered by a subnet that is up to date. Their replies are checked against
+	// the certified state of the subnet.
+	ICReadModeUpdate = "update"
+	// ICReadModeQueryWithFallback reads with queries, and falls back to update calls for the
+	// reads whose query fails, or for which the replica that answered doesn't have the batch
+	// because it lags behind.
+	ICReadModeQueryWithFallback = "query-with-fallback"
+)
+
+var errICQueryBackoff = errors.New("backing off from queries to IC storage canister")
+
+// icQueryBackoff tracks the failed queries to a canister. While it's active, reads from the
+// canister skip queries.
+type icQueryBackoff struct {
+	mutex    sync.Mutex
+	until    time.Time
+	duration time.Duration
+}
+
+func (b *icQueryBackoff) active() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return time.Now().Before(b.until)
+}
+
+// failed starts the backoff, or doubles it if the query that failed was the first after a backoff.
+func (b *icQueryBackoff) failed(initial, maxDuration time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.duration == 0 {
+		b.duration = initial
+	} else {
+		b.duration = min(2*b.duration, maxDuration)
+	}
+	b.until = time.Now().Add(b.duration)
+}
+
+func (b *icQueryBackoff) succeeded() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.duration = 0
+	b.until = time.Time{}
+}
+
+// readInMode reads from the canister with query, with update, or with update if query fails,
+// depending on the read mode. A query that fails for any reason but the canister not having the
+// batch backs the canister off from queries. The latency of each mode is recorded separately.
+func readInMode[T any](s *ICStorageService, canister *icCanister, mode string, query, update func() (T, error)) (T, error) {
+	var queryErr error
+	if mode != ICReadModeUpdate {
+		if canister.queryBackoff.active() {
+			queryErr = errICQueryBackoff
+		} else {
+			start := time.Now()
+			value, err := query()
+			icReadQueryDurationHistogram.Update(time.Since(start).Nanoseconds())
+			if err == nil {
+				canister.queryBackoff.succeeded()
+				return value, nil
+			}
+			icReadQueryFailureCounter.Inc(1)
+			if !errors.Is(err, ErrNotFound) && s.queryBackoff > 0 {
+				canister.queryBackoff.failed(s.queryBackoff, s.maxQueryBackoff)
+			}
+			queryErr = err
+		}
+		if mode == ICReadModeQuery {
+			var zero T
+			return zero, queryErr
+		}
+		icReadFallbackCounter.Inc(1)
+		log.Debug("falling back to an update call to read from an IC storage canister", "canister", canister.id, "err", queryErr)
+	}
+
+	start := time.Now()
+	value, err := update()
+	icReadUpdateDurationHistogram.Update(time.Since(start).Nanoseconds())
+	if err != nil {
+		icReadUpdateFailureCounter.Inc(1)
+		if queryErr != nil && !errors.Is(queryErr, errICQueryBackoff) {
+			err = moreInformativeICError(queryErr, err)
+		}
+		return value, err
+	}
+	return value, nil
+}
+
+// icModeReader reads from IC storage in a single read mode, so that an aggregator that has a
+// reader for each mode keeps separate stats for them and picks the mode that serves it best.
+type icModeReader struct {
+	s    *ICStorageService
+	mode string
+}
+
+// ReadModeReaders returns a reader of the storage for each of the query and update read modes.
+func (s *ICStorageService) ReadModeReaders() []daprovider.DASReader {
+	return []daprovider.DASReader{
+		&icModeReader{s: s, mode: ICReadModeQuery},
+		&icModeReader{s: s, mode: ICReadModeUpdate},
+	}
+}
+
+func (r *icModeReader) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	return r.s.getByHash(ctx, hash, r.mode)
+}
+
+// GetCertifiedDataByHash reads the batch in the reader's mode, and the proof that a canister
+// certified it, which can only be queried.
+func (r *icModeReader) GetCertifiedDataByHash(ctx context.Context, hash common.Hash) ([]byte, *daprovider.ICCertifiedData, error) {
+	data, err := r.GetByHash(ctx, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	proof, err := r.s.GetCertifiedByHash(ctx, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, proof, nil
+}
+
+func (r *icModeReader) ExpirationPolicy(ctx context.Context) (daprovider.ExpirationPolicy, error) {
+	return r.s.ExpirationPolicy(ctx)
+}
+
+func (r *icModeReader) String() string {
+	return fmt.Sprintf("ICStorageService(%s)", r.mode)
+}
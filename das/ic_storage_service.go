@@ -3,132 +3,895 @@ package das
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/CommoDor64/icdaserver/icutils"
-
 	"github.com/aviate-labs/agent-go"
+	"github.com/aviate-labs/agent-go/certification"
 	"github.com/aviate-labs/agent-go/principal"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/signature"
+	"github.com/offchainlabs/nitro/util/stopwaiter"
 	flag "github.com/spf13/pflag"
 )
 
-type ExpirationPolicy struct{}
-type CanisterId string
-
 type ICStorageConfig struct {
-	Enable   bool       `koanf:"enable"`
-	Network  string     `konaf:"network"`
-	Canister CanisterId `konaf:"canister"`
+	Enable bool `koanf:"enable"`
+	// Network is either the name of a known IC network ("mainnet" or "local")
+	// or the URL of a custom IC network.
+	Network string `koanf:"network"`
+	// Canisters are the textual ids of the storage canisters, they may be on different subnets.
+	Canisters []string `koanf:"canisters"`
+	// WritePolicy is how batches are spread over the canisters, either ICWritePolicyReplicate
+	// or ICWritePolicyShard.
+	WritePolicy string `koanf:"write-policy"`
+	// ReplicationFactor is the number of canisters a batch must be stored on for a put to succeed.
+	ReplicationFactor int `koanf:"replication-factor"`
+	// CertificateThreshold is the number of canisters whose proofs an ICDA certificate carries,
+	// it is the threshold of the keyset the batch poster registers.
+	CertificateThreshold int `koanf:"certificate-threshold"`
+	// Identity is a PEM file with the Ed25519 or secp256k1 key the canisters are called with.
+	Identity string `koanf:"identity"`
+	// UseBatchPosterKey calls the canisters with the secp256k1 key of the batch poster's wallet instead.
+	UseBatchPosterKey bool `koanf:"use-batch-poster-key"`
+	// RootKey is the DER encoded IC root key that certificates are verified against, either
+	// hex-encoded beginning with 0x or a file containing the hex-encoded key.
+	// If empty, the IC mainnet root key is used.
+	RootKey string `koanf:"root-key"`
+	// MaxChunkSize is the size of the chunks batches are uploaded in, each chunk is sent
+	// to the canister in its own ingress message.
+	MaxChunkSize int `koanf:"max-chunk-size"`
+	// EnableExpiry stores batches with the expiry time they are put with, and has the canister
+	// periodically prune the expired ones. Otherwise batches are kept forever.
+	EnableExpiry bool          `koanf:"enable-expiry"`
+	MaxRetention time.Duration `koanf:"max-retention"`
+	// ReadMode is how batches are read from the canisters, one of ICReadModeQuery,
+	// ICReadModeUpdate and ICReadModeQueryWithFallback, which is used if it's empty.
+	ReadMode string `koanf:"read-mode"`
+	// QueryBackoff is how long reads from a canister skip queries after one failed, doubling
+	// with each failure up to MaxQueryBackoff. Queries are never skipped if it's 0.
+	QueryBackoff    time.Duration `koanf:"query-backoff"`
+	MaxQueryBackoff time.Duration `koanf:"max-query-backoff"`
+	// CertifyTimeout is how long the ICDA writer keeps retrying to store a batch and collect
+	// certificates for it after transient IC errors, before it gives up and the batch poster falls
+	// back to posting the batch on chain. It makes a single attempt if it's 0.
+	CertifyTimeout       time.Duration            `koanf:"certify-timeout"`
+	CertifyRetryInterval time.Duration            `koanf:"certify-retry-interval"`
+	Monitor              ICStorageMonitorConfig   `koanf:"monitor"`
+	Dangerous            ICStorageDangerousConfig `koanf:"dangerous"`
+}
+
+type ICStorageDangerousConfig struct {
+	FetchRootKey                   bool `koanf:"fetch-root-key"`
+	DisableSignedQueryVerification bool `koanf:"disable-signed-query-verification"`
+}
+
+const (
+	ICNetworkMainnet = "mainnet"
+	ICNetworkLocal   = "local"
+)
+
+const (
+	// ICWritePolicyReplicate stores every batch on all canisters.
+	ICWritePolicyReplicate = "replicate"
+	// ICWritePolicyShard stores every batch on replication-factor canisters, starting from the one
+	// selected by the prefix of its hash and moving on to the next ones if a canister fails.
+	ICWritePolicyShard = "shard"
+)
+
+// IC ingress messages are limited to 2MiB, leave room for the candid encoding and the envelope.
+const maxICChunkSize = 2*1024*1024 - 64*1024
+
+const icPruneInterval = 5 * time.Minute
+
+type icNetwork struct {
+	url string
+	// fetchRootKey is set for networks which generate their own root key, such as a local replica.
+	fetchRootKey bool
 }
 
-var DefaultTestStorageConfig = ICStorageConfig{
-	Enable:   true,
-	Network:  "http://172.17.0.1:4943/",
-	Canister: "bkyz2-fmaaa-aaaaa-qaaaq-cai",
+var icNetworks = map[string]icNetwork{
+	ICNetworkMainnet: {url: "https://icp-api.io"},
+	ICNetworkLocal:   {url: "http://127.0.0.1:4943", fetchRootKey: true},
+}
+
+var DefaultICStorageDangerousConfig = ICStorageDangerousConfig{
+	FetchRootKey:                   false,
+	DisableSignedQueryVerification: false,
+}
+
+var DefaultICStorageConfig = ICStorageConfig{
+	Enable:               false,
+	Network:              ICNetworkMainnet,
+	Canisters:            []string{},
+	WritePolicy:          ICWritePolicyReplicate,
+	ReplicationFactor:    1,
+	CertificateThreshold: 1,
+	Identity:             "",
+	UseBatchPosterKey:    false,
+	RootKey:              "",
+	MaxChunkSize:         1024 * 1024,
+	EnableExpiry:         false,
+	MaxRetention:         defaultStorageRetention,
+	ReadMode:             ICReadModeQueryWithFallback,
+	QueryBackoff:         10 * time.Second,
+	MaxQueryBackoff:      5 * time.Minute,
+	CertifyTimeout:       2 * time.Minute,
+	CertifyRetryInterval: 3 * time.Second,
+	Monitor:              DefaultICStorageMonitorConfig,
+	Dangerous:            DefaultICStorageDangerousConfig,
 }
 
 func ICStorageConfigAddOptions(prefix string, f *flag.FlagSet) {
-	f.Bool(prefix+".enable", false, "enable the internet computer(ic) as a da layer")
-	f.String(prefix+".network", DefaultTestStorageConfig.Network, "url of the ic network")
-	f.String(prefix+".canister", string(DefaultTestStorageConfig.Canister), "readable canister ids")
+	f.Bool(prefix+".enable", DefaultICStorageConfig.Enable, "enable the internet computer(ic) as a da layer")
+	f.String(prefix+".network", DefaultICStorageConfig.Network, "ic network to use, either \"mainnet\", \"local\" for a local replica, or the url of a custom ic network")
+	f.StringSlice(prefix+".canisters", DefaultICStorageConfig.Canisters, "textual ids of the ic storage canisters, which may be on different subnets")
+	f.String(prefix+".write-policy", DefaultICStorageConfig.WritePolicy, "how batches are spread over the canisters, either \"replicate\" to store them on every canister, or \"shard\" to store them on replication-factor canisters selected by the prefix of their hash")
+	f.Int(prefix+".replication-factor", DefaultICStorageConfig.ReplicationFactor, "number of canisters a batch must be stored on for a put to succeed")
+	f.Int(prefix+".certificate-threshold", DefaultICStorageConfig.CertificateThreshold, "number of canisters whose proofs that they certified a batch are posted in its ICDA certificate; this is the threshold of the keyset the batch poster signs batches under")
+	f.String(prefix+".identity", DefaultICStorageConfig.Identity, "PEM file with the Ed25519 or secp256k1 key the ic storage canisters are called with; calls are anonymous if neither this nor use-batch-poster-key is set")
+	f.Bool(prefix+".use-batch-poster-key", DefaultICStorageConfig.UseBatchPosterKey, "call the ic storage canisters with the secp256k1 key of the batch poster's wallet")
+	f.String(prefix+".root-key", DefaultICStorageConfig.RootKey, "DER encoded IC root key to verify certificates against, can be a file or the hex-encoded key beginning with 0x; defaults to the IC mainnet root key")
+	f.Int(prefix+".max-chunk-size", DefaultICStorageConfig.MaxChunkSize, "maximum size of the chunks batches are uploaded to the ic storage canister in")
+	f.Bool(prefix+".enable-expiry", DefaultICStorageConfig.EnableExpiry, "enable expiry of batches, expired batches are periodically pruned from the ic storage canister")
+	f.Duration(prefix+".max-retention", DefaultICStorageConfig.MaxRetention, "store requests with expiry times farther in the future than max-retention will be rejected")
+	f.String(prefix+".read-mode", DefaultICStorageConfig.ReadMode, "how batches are read from the ic storage canisters, either \"query\" for fast queries checked against the certified data of the canister, \"update\" for slow replicated calls, or \"query-with-fallback\" to fall back to replicated calls when a query fails or the replica that answered lags behind")
+	f.Duration(prefix+".query-backoff", DefaultICStorageConfig.QueryBackoff, "how long reads from an ic storage canister skip queries after one failed, doubling with each failure; 0 to never skip them")
+	f.Duration(prefix+".max-query-backoff", DefaultICStorageConfig.MaxQueryBackoff, "maximum time reads from an ic storage canister skip queries after repeated failures")
+	f.Duration(prefix+".certify-timeout", DefaultICStorageConfig.CertifyTimeout, "how long the batch poster keeps retrying to store a batch in ic storage and get it certified after transient ic errors, before falling back to posting it on chain; 0 to try once")
+	f.Duration(prefix+".certify-retry-interval", DefaultICStorageConfig.CertifyRetryInterval, "how long the batch poster waits before retrying to store a batch in ic storage and get it certified")
+	ICStorageMonitorConfigAddOptions(prefix+".monitor", f)
+	ICStorageDangerousConfigAddOptions(prefix+".dangerous", f)
+}
+
+func ICStorageDangerousConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".fetch-root-key", DefaultICStorageDangerousConfig.FetchRootKey, "DANGEROUS! trust the root key advertised by the IC network instead of the pinned root key. To be used with a local replica only")
+	f.Bool(prefix+".disable-signed-query-verification", DefaultICStorageDangerousConfig.DisableSignedQueryVerification, "DANGEROUS! disables verification of the replica signatures on query responses")
+}
+
+func (c *ICStorageConfig) Validate() error {
+	if !c.Enable {
+		return nil
+	}
+	if len(c.Canisters) == 0 {
+		return errors.New("ic-storage.canisters must be set when ic-storage is enabled")
+	}
+	if _, err := c.canisterIDs(); err != nil {
+		return err
+	}
+	if c.WritePolicy != ICWritePolicyReplicate && c.WritePolicy != ICWritePolicyShard {
+		return fmt.Errorf("invalid ic-storage.write-policy %q: must be %q or %q", c.WritePolicy, ICWritePolicyReplicate, ICWritePolicyShard)
+	}
+	if c.ReplicationFactor < 1 || c.ReplicationFactor > len(c.Canisters) {
+		return fmt.Errorf("ic-storage.replication-factor must be between 1 and the number of canisters %d, got %d", len(c.Canisters), c.ReplicationFactor)
+	}
+	if c.CertificateThreshold < 1 || c.CertificateThreshold > c.ReplicationFactor {
+		return fmt.Errorf("ic-storage.certificate-threshold must be between 1 and the replication factor %d, got %d", c.ReplicationFactor, c.CertificateThreshold)
+	}
+	if c.Identity != "" && c.UseBatchPosterKey {
+		return errors.New("ic-storage.identity and ic-storage.use-batch-poster-key can't both be set")
+	}
+	if _, err := c.networkURL(); err != nil {
+		return err
+	}
+	if _, err := ParseICRootKey(c.RootKey); err != nil {
+		return fmt.Errorf("invalid ic-storage.root-key: %w", err)
+	}
+	if c.MaxChunkSize <= 0 || c.MaxChunkSize > maxICChunkSize {
+		return fmt.Errorf("ic-storage.max-chunk-size must be between 1 and %d, got %d", maxICChunkSize, c.MaxChunkSize)
+	}
+	if c.EnableExpiry && c.MaxRetention <= 0 {
+		return errors.New("ic-storage.max-retention must be positive when ic-storage.enable-expiry is set")
+	}
+	if mode := c.readMode(); mode != ICReadModeQuery && mode != ICReadModeUpdate && mode != ICReadModeQueryWithFallback {
+		return fmt.Errorf("invalid ic-storage.read-mode %q: must be %q, %q or %q", c.ReadMode, ICReadModeQuery, ICReadModeUpdate, ICReadModeQueryWithFallback)
+	}
+	if c.QueryBackoff < 0 || c.MaxQueryBackoff < c.QueryBackoff {
+		return fmt.Errorf("ic-storage.query-backoff must be between 0 and ic-storage.max-query-backoff %v, got %v", c.MaxQueryBackoff, c.QueryBackoff)
+	}
+	if c.CertifyTimeout < 0 {
+		return fmt.Errorf("ic-storage.certify-timeout must not be negative, got %v", c.CertifyTimeout)
+	}
+	if c.CertifyTimeout > 0 && c.CertifyRetryInterval <= 0 {
+		return errors.New("ic-storage.certify-retry-interval must be positive when ic-storage.certify-timeout is set")
+	}
+	if err := c.Monitor.Validate(); err != nil {
+		return err
+	}
+	if c.Network == ICNetworkMainnet {
+		if c.Dangerous.FetchRootKey {
+			return errors.New("ic-storage.dangerous.fetch-root-key cannot be used with the ic mainnet")
+		}
+		if c.RootKey != "" {
+			return errors.New("ic-storage.root-key cannot be overridden for the ic mainnet")
+		}
+	}
+	return nil
+}
+
+func (c *ICStorageConfig) canisterIDs() ([]principal.Principal, error) {
+	ids := make([]principal.Principal, 0, len(c.Canisters))
+	for _, canister := range c.Canisters {
+		id, err := principal.Decode(canister)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ic-storage.canisters entry %q: %w", canister, err)
+		}
+		if slices.ContainsFunc(ids, id.Equal) {
+			return nil, fmt.Errorf("canister %s is listed twice in ic-storage.canisters", id)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (c *ICStorageConfig) networkURL() (*url.URL, error) {
+	rawURL := c.Network
+	if network, ok := icNetworks[c.Network]; ok {
+		rawURL = network.url
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ic-storage.network %q: %w", c.Network, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid ic-storage.network %q: must be \"mainnet\", \"local\" or an http(s) url", c.Network)
+	}
+	return u, nil
+}
+
+// readMode is the configured read mode, defaulting to querying with a fallback to updates.
+func (c *ICStorageConfig) readMode() string {
+	if c.ReadMode == "" {
+		return ICReadModeQueryWithFallback
+	}
+	return c.ReadMode
+}
+
+// fetchRootKey reports whether the root key advertised by the network is trusted. A root key
+// pinned by the operator is always checked, even on networks that generate their own.
+func (c *ICStorageConfig) fetchRootKey() bool {
+	return c.Dangerous.FetchRootKey || (icNetworks[c.Network].fetchRootKey && c.RootKey == "")
+}
+
+// ParseICRootKey decodes a root key given either as hex beginning with 0x or as the path
+// of a file containing the hex-encoded key. An empty string yields the IC mainnet root key.
+func ParseICRootKey(rootKey string) ([]byte, error) {
+	var encoded string
+	if rootKey == "" {
+		encoded = certification.RootKey
+	} else if strings.HasPrefix(rootKey, "0x") {
+		encoded = rootKey[2:]
+	} else {
+		contents, err := os.ReadFile(rootKey)
+		if err != nil {
+			return nil, err
+		}
+		encoded = strings.TrimPrefix(strings.TrimSpace(string(contents)), "0x")
+	}
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode IC root key: %w", err)
+	}
+	if _, err := certification.PublicBLSKeyFromDER(key); err != nil {
+		return nil, fmt.Errorf("invalid IC root key: %w", err)
+	}
+	return key, nil
 }
 
 type ICStorageService struct {
-	Agent    *icutils.Agent
-	Canister principal.Principal
-	Cache    map[string]string
+	// Canisters are the storage canisters, in the order they were configured.
+	Canisters []principal.Principal
+	// Principal is the principal the canisters are called as.
+	Principal principal.Principal
+	// RootKey is the IC root key used to verify certified data.
+	RootKey []byte
+	// CertificateThreshold is the number of canisters the batch poster collects proofs from.
+	CertificateThreshold int
+
+	canisters            []*icCanister
+	writePolicy          string
+	replicationFactor    int
+	maxChunkSize         int
+	enableExpiry         bool
+	maxRetention         time.Duration
+	readMode             string
+	queryBackoff         time.Duration
+	maxQueryBackoff      time.Duration
+	certifyTimeout       time.Duration
+	certifyRetryInterval time.Duration
+	monitor              ICStorageMonitorConfig
+
+	// degraded is why the canisters were degraded when the monitor last checked them
+	degraded      error
+	degradedMutex sync.Mutex
+
+	stopWaiter stopwaiter.StopWaiterSafe
 }
 
-func NewICStorageService(config ICStorageConfig) (StorageService, error) {
-	u, err := url.Parse(config.Network)
+// NewICStorageService creates a storage service over the configured canisters. dataSigner is
+// the batch poster's signer, it may be nil unless the canisters are called with its key.
+func NewICStorageService(config ICStorageConfig, dataSigner signature.DataSignerFunc) (*ICStorageService, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	u, err := config.networkURL()
+	if err != nil {
+		return nil, err
+	}
+
+	pinnedRootKey, err := ParseICRootKey(config.RootKey)
+	if err != nil {
+		return nil, err
+	}
+	id, err := config.icIdentity(dataSigner)
 	if err != nil {
 		return nil, err
 	}
+	mainnetRootKey, _ := hex.DecodeString(certification.RootKey)
+	pinnedIsMainnet := bytes.Equal(pinnedRootKey, mainnetRootKey)
 
 	aconfig := agent.Config{
-		ClientConfig:                   &agent.ClientConfig{Host: u},
-		FetchRootKey:                   true,
-		DisableSignedQueryVerification: true,
+		ClientConfig: &agent.ClientConfig{Host: u},
+		Identity:     id,
+		// The agent only knows the mainnet root key, so any other pinned key has to be
+		// fetched and is then checked against the pinned one below.
+		FetchRootKey:                   config.fetchRootKey() || !pinnedIsMainnet,
+		DisableSignedQueryVerification: config.Dangerous.DisableSignedQueryVerification,
+	}
+	if config.Dangerous.DisableSignedQueryVerification {
+		log.Warn("signed query verification is disabled for the IC storage service")
 	}
 
-	p := principal.MustDecode(string(config.Canister))
+	canisterIDs, err := config.canisterIDs()
+	if err != nil {
+		return nil, err
+	}
 
-	a, err := icutils.NewAgent(p, aconfig)
+	a, err := agent.New(aconfig)
 	if err != nil {
 		return nil, err
 	}
 
-	return ICStorageService{
-		Cache:    map[string]string{},
-		Canister: p,
-		Agent:    a,
+	rootKey := pinnedRootKey
+	if config.fetchRootKey() {
+		rootKey = a.GetRootKey()
+		log.Warn("trusting the root key advertised by the IC network", "network", config.Network, "rootKey", hex.EncodeToString(rootKey))
+	} else if !bytes.Equal(a.GetRootKey(), pinnedRootKey) {
+		return nil, fmt.Errorf("root key advertised by IC network %s doesn't match the pinned root key", config.Network)
+	}
+
+	canisters := make([]*icCanister, len(canisterIDs))
+	for i, id := range canisterIDs {
+		canisters[i] = &icCanister{agent: a, id: id, legacyConfig: aconfig}
+	}
+
+	return &ICStorageService{
+		Canisters:            canisterIDs,
+		Principal:            id.Sender(),
+		RootKey:              rootKey,
+		CertificateThreshold: config.CertificateThreshold,
+		canisters:            canisters,
+		writePolicy:          config.WritePolicy,
+		replicationFactor:    config.ReplicationFactor,
+		maxChunkSize:         config.MaxChunkSize,
+		enableExpiry:         config.EnableExpiry,
+		maxRetention:         config.MaxRetention,
+		readMode:             config.readMode(),
+		queryBackoff:         config.QueryBackoff,
+		maxQueryBackoff:      config.MaxQueryBackoff,
+		certifyTimeout:       config.CertifyTimeout,
+		certifyRetryInterval: config.CertifyRetryInterval,
+		monitor:              config.Monitor,
 	}, nil
 }
 
+// start launches the monitoring of the canisters, and the periodic pruning of expired batches
+// when expiry is enabled.
+func (s *ICStorageService) start(ctx context.Context) error {
+	if err := s.stopWaiter.Start(ctx, s); err != nil {
+		return err
+	}
+	if s.monitor.Interval > 0 {
+		if err := s.stopWaiter.CallIterativelySafe(s.monitorCanisters); err != nil {
+			return err
+		}
+	}
+	if !s.enableExpiry {
+		return nil
+	}
+	return s.stopWaiter.CallIterativelySafe(func(ctx context.Context) time.Duration {
+		for _, canister := range s.canisters {
+			if version, err := canister.apiVersion(); err != nil || version == 0 {
+				// Canisters that predate the storage API keep batches forever.
+				continue
+			}
+			pruned, err := canister.prune()
+			if err != nil {
+				log.Error("error pruning expired batches from an IC storage canister", "canister", canister.id, "err", err)
+			} else if pruned > 0 {
+				log.Info("IC storage canister pruned expired batches", "canister", canister.id, "count", pruned)
+			}
+		}
+		return icPruneInterval
+	})
+}
+
 func (s *ICStorageService) Read(ctx context.Context) error {
 	return nil
 }
 
-func (s ICStorageService) Put(ctx context.Context, data []byte, expirationTime uint64) error {
-	_, err := s.Agent.Store(
-		dastree.Hash(data).Hex(),
-		data)
+// Put stores the data on replication-factor canisters at least. Canisters are tried in the order
+// of the write policy, as many at a time as are still needed, so with ICWritePolicyShard a failed
+// canister is made up for by the next one, while ICWritePolicyReplicate stores on all of them at once.
+func (s *ICStorageService) Put(ctx context.Context, data []byte, expirationTime uint64) error {
+	start := time.Now()
+	success := false
+	defer func() {
+		if success {
+			icStoreSuccessCounter.Inc(1)
+			icStoreBytesCounter.Inc(int64(len(data)))
+		} else {
+			icStoreFailureCounter.Inc(1)
+		}
+		icStoreDurationHistogram.Update(time.Since(start).Nanoseconds())
+	}()
 
+	expiry, err := s.batchExpiry(expirationTime)
 	if err != nil {
-		return nil
+		return err
+	}
+	root := dastree.Hash(data)
+	canisters := s.canisters
+	wanted := len(canisters)
+	if s.writePolicy == ICWritePolicyShard {
+		canisters = s.shard(root)
+		wanted = s.replicationFactor
 	}
 
+	stored := 0
+	var errs []error
+	for stored < wanted && len(canisters) > 0 {
+		batch := canisters[:min(wanted-stored, len(canisters))]
+		canisters = canisters[len(batch):]
+		for i, err := range s.putToCanisters(ctx, batch, data, root, expiry) {
+			if err != nil {
+				errs = append(errs, fmt.Errorf("canister %s: %w", batch[i].id, err))
+				continue
+			}
+			stored++
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if stored < s.replicationFactor {
+		return fmt.Errorf("batch %v was stored on %d canisters, %d are required: %w", root, stored, s.replicationFactor, errors.Join(errs...))
+	}
+	for _, err := range errs {
+		log.Warn("couldn't store batch on an IC storage canister", "root", root, "err", err)
+	}
+	success = true
 	return nil
 }
 
-func (s ICStorageService) Sync(ctx context.Context) error {
+// CheckWriteAccess checks that the service's principal is allowed to write to every canister,
+// so that a misconfigured identity is noticed before the first batch. Canisters that can't be
+// reached are skipped, they may well be back by the time a batch is stored.
+func (s *ICStorageService) CheckWriteAccess() error {
+	for _, canister := range s.canisters {
+		version, err := canister.apiVersion()
+		if err != nil {
+			log.Warn("couldn't get the storage API version of an IC storage canister", "canister", canister.id, "err", err)
+			continue
+		}
+		if version == 0 {
+			log.Warn("IC storage canister predates the storage API, its writers can't be checked", "canister", canister.id)
+			continue
+		}
+		writers, err := canister.allowedWriters()
+		if err != nil {
+			log.Warn("couldn't check the writers allowed by an IC storage canister", "canister", canister.id, "err", err)
+			continue
+		}
+		if len(writers) == 0 {
+			log.Warn("IC storage canister accepts batches from anyone", "canister", canister.id)
+			continue
+		}
+		if !slices.ContainsFunc(writers, s.Principal.Equal) {
+			return fmt.Errorf("principal %s isn't allowed to write to IC storage canister %s", s.Principal, canister.id)
+		}
+	}
 	return nil
 }
 
-func (s ICStorageService) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+// shard returns the canisters in the order a batch is stored on them under ICWritePolicyShard,
+// starting from the one selected by the prefix of its root.
+func (s *ICStorageService) shard(root common.Hash) []*icCanister {
+	first := binary.BigEndian.Uint64(root[:8]) % uint64(len(s.canisters))
+	return append(slices.Clone(s.canisters[first:]), s.canisters[:first]...)
+}
 
-	blockHash := hash.Hex()
-	if hash == [32]byte{} {
-		return nil, errors.New(fmt.Sprintf("expected well formed hash, got %v", blockHash))
+// putToCanisters stores the batch on the canisters concurrently, and returns their errors in the same order.
+func (s *ICStorageService) putToCanisters(ctx context.Context, canisters []*icCanister, data []byte, root common.Hash, expiry uint64) []error {
+	errs := make([]error, len(canisters))
+	var wg sync.WaitGroup
+	for i, canister := range canisters {
+		wg.Add(1)
+		go func(i int, canister *icCanister) {
+			defer wg.Done()
+			errs[i] = s.putToCanister(ctx, canister, data, root, expiry)
+		}(i, canister)
 	}
+	wg.Wait()
+	return errs
+}
 
-	cb, err := s.Agent.Fetch(blockHash)
+// putToCanister uploads the data to the canister in chunks and then finalizes it under its dastree
+// root. Chunks the canister already has from an earlier, interrupted upload are not sent again.
+func (s *ICStorageService) putToCanister(ctx context.Context, canister *icCanister, data []byte, root common.Hash, expiry uint64) error {
+	version, err := canister.apiVersion()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if version == 0 {
+		return canister.storeLegacy(root, data)
+	}
+	batch, err := canister.getBatch(root)
+	if err == nil {
+		if expiryCovers(batch.Expiry, expiry) {
+			// already stored
+			return nil
+		}
+		// Finalizing a stored batch again extends its expiry.
+		if err := canister.finalize(root, batch.ChunkCount, batch.Size, expiry); err != nil {
+			return fmt.Errorf("failed to extend the expiry of batch %v: %w", root, err)
+		}
+		return nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	chunks := splitICChunks(data, s.maxChunkSize)
+	uploaded, err := canister.uploadedChunks(root)
+	if err != nil {
+		return err
+	}
+	for i, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		index := uint32(i)
+		if slices.Contains(uploaded, index) {
+			continue
+		}
+		if err := canister.uploadChunk(root, index, chunk); err != nil {
+			return fmt.Errorf("failed to upload chunk %d of %d of batch %v: %w", index, len(chunks), root, err)
+		}
+	}
+	if err := canister.finalize(root, uint32(len(chunks)), uint64(len(data)), expiry); err != nil {
+		return fmt.Errorf("failed to finalize batch %v: %w", root, err)
+	}
+	return nil
+}
+
+// batchExpiry returns the expiry a batch put with expirationTime is stored with, 0 if it's kept forever.
+func (s *ICStorageService) batchExpiry(expirationTime uint64) (uint64, error) {
+	if !s.enableExpiry {
+		return 0, nil
+	}
+	if expirationTime == 0 {
+		return 0, errors.New("an expiry time is required when expiry is enabled")
+	}
+	expiryTime := time.Unix(int64(expirationTime), 0)
+	currentTimePlusRetention := time.Now().Add(s.maxRetention)
+	if expiryTime.After(currentTimePlusRetention) {
+		return 0, fmt.Errorf("requested expiry time (%v) exceeds current time plus maximum allowed retention period(%v)", expiryTime, currentTimePlusRetention)
 	}
+	return expirationTime, nil
+}
+
+// expiryCovers reports whether a batch stored until the stored expiry is kept at least until
+// the requested one, where 0 means forever.
+func expiryCovers(stored, requested uint64) bool {
+	return stored == 0 || (requested != 0 && stored >= requested)
+}
+
+// splitICChunks splits data into chunks of at most maxChunkSize bytes. Empty data is a single empty chunk.
+func splitICChunks(data []byte, maxChunkSize int) [][]byte {
+	chunks := [][]byte{}
+	for len(data) > maxChunkSize {
+		chunks = append(chunks, data[:maxChunkSize])
+		data = data[maxChunkSize:]
+	}
+	return append(chunks, data)
+}
 
-	if _, err := icutils.VerifyDataFromIC(cb.Certificate, s.Agent.GetRootKey(), s.Canister, cb.Witness, cb.Data); err != nil {
+func (s *ICStorageService) Sync(ctx context.Context) error {
+	return nil
+}
+
+// icReadResponse is the result of reading from one of the canisters.
+type icReadResponse[T any] struct {
+	canister *icCanister
+	value    T
+	err      error
+}
+
+// readFromCanisters runs read against all canisters concurrently. Responses are delivered as they
+// arrive, so that the first certified reply can be used without waiting for the other canisters.
+func readFromCanisters[T any](canisters []*icCanister, read func(*icCanister) (T, error)) <-chan icReadResponse[T] {
+	responses := make(chan icReadResponse[T], len(canisters))
+	for _, canister := range canisters {
+		go func(c *icCanister) {
+			value, err := read(c)
+			responses <- icReadResponse[T]{canister: c, value: value, err: err}
+		}(canister)
+	}
+	return responses
+}
+
+// moreInformativeICError picks the error to report for a read from several canisters. ErrNotFound
+// is only reported if every canister reported it, as the data may well be on a canister that failed.
+func moreInformativeICError(current, err error) error {
+	if !errors.Is(err, ErrNotFound) || errors.Is(current, ErrNotFound) {
+		return err
+	}
+	return current
+}
+
+// GetCertifiedByHash returns the proof that a canister certified the batch with the given dastree
+// root, for use in an ICDA certificate. All canisters are asked, and the first reply that is checked
+// against the root key and the principal of the canister that sent it is returned. Unlike GetByHash
+// it doesn't fetch the batch itself.
+func (s *ICStorageService) GetCertifiedByHash(ctx context.Context, hash common.Hash) (*daprovider.ICCertifiedData, error) {
+	proofs, err := s.GetCertifiedProofsByHash(ctx, hash, 1)
+	if err != nil {
 		return nil, err
 	}
+	return &proofs[0], nil
+}
+
+// GetCertifiedProofsByHash is like GetCertifiedByHash, but returns the proofs of count different
+// canisters, in the order they replied.
+func (s *ICStorageService) GetCertifiedProofsByHash(ctx context.Context, hash common.Hash, count int) ([]daprovider.ICCertifiedData, error) {
+	if count < 1 || count > len(s.canisters) {
+		return nil, fmt.Errorf("can't collect proofs from %d of %d canisters", count, len(s.canisters))
+	}
+	responses := readFromCanisters(s.canisters, func(c *icCanister) (*daprovider.ICCertifiedData, error) {
+		proof, _, err := s.certifiedBatch(c, hash)
+		return proof, err
+	})
+	var proofs []daprovider.ICCertifiedData
+	var anyError error = ErrNotFound
+	for range s.canisters {
+		select {
+		case response := <-responses:
+			if response.err != nil {
+				anyError = moreInformativeICError(anyError, response.err)
+				continue
+			}
+			proofs = append(proofs, *response.value)
+			if len(proofs) == count {
+				return proofs, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if len(proofs) > 0 {
+		return nil, fmt.Errorf("only %d of %d canisters certified batch %v: %w", len(proofs), count, hash, anyError)
+	}
+	return nil, anyError
+}
+
+// certifiedBatch returns the proof that the canister certified the batch with the given root,
+// along with its metadata, which is checked against the proof.
+func (s *ICStorageService) certifiedBatch(canister *icCanister, root common.Hash) (*daprovider.ICCertifiedData, *icBatch, error) {
+	version, err := canister.apiVersion()
+	if err != nil {
+		return nil, nil, err
+	}
+	if version == 0 {
+		return nil, nil, fmt.Errorf("%w: canister %s predates certified batches", ErrICRejected, canister.id)
+	}
+	batch, err := canister.getBatch(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	proof := canister.proof(batch.Certificate, batch.Witness)
+	size, err := daprovider.VerifyICWitness(proof, s.RootKey, daprovider.ICBatchPath(root, daprovider.ICLabelSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrICCertificateInvalid, err)
+	}
+	chunkCount, err := daprovider.VerifyICWitness(proof, s.RootKey, daprovider.ICBatchPath(root, daprovider.ICLabelChunkCount))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrICCertificateInvalid, err)
+	}
+	expiry, err := daprovider.VerifyICWitness(proof, s.RootKey, daprovider.ICBatchPath(root, daprovider.ICLabelExpiry))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrICCertificateInvalid, err)
+	}
+	if len(size) != 8 || len(chunkCount) != 4 || len(expiry) != 8 {
+		return nil, nil, fmt.Errorf("%w: malformed certified metadata for batch %v", ErrICCertificateInvalid, root)
+	}
+	if binary.BigEndian.Uint64(size) != batch.Size || binary.BigEndian.Uint32(chunkCount) != batch.ChunkCount || binary.BigEndian.Uint64(expiry) != batch.Expiry {
+		return nil, nil, fmt.Errorf("%w: %w: metadata of batch %v", ErrICCertificateInvalid, daprovider.ErrICWitnessMismatch, root)
+	}
+	return proof, batch, nil
+}
+
+// GetByHash returns the batch with the given dastree root. All canisters are asked for the
+// metadata of the batch, and it is read from the first one that replies, falling back to the
+// others if that fails. The canisters are read from in the configured read mode.
+func (s *ICStorageService) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	return s.getByHash(ctx, hash, s.readMode)
+}
+
+func (s *ICStorageService) getByHash(ctx context.Context, hash common.Hash, mode string) ([]byte, error) {
+	if hash == (common.Hash{}) {
+		return nil, fmt.Errorf("expected well formed hash, got %v", hash)
+	}
+	start := time.Now()
+	success := false
+	defer func() {
+		if success {
+			icFetchSuccessCounter.Inc(1)
+		} else {
+			icFetchFailureCounter.Inc(1)
+		}
+		icFetchDurationHistogram.Update(time.Since(start).Nanoseconds())
+	}()
+
+	responses := readFromCanisters(s.canisters, func(c *icCanister) (*icBatch, error) {
+		version, err := c.apiVersion()
+		if err != nil || version == 0 {
+			// Canisters that predate the storage API have no metadata, the batch is fetched whole.
+			return nil, err
+		}
+		return s.readBatch(c, hash, mode)
+	})
+	var anyError error = ErrNotFound
+	for range s.canisters {
+		select {
+		case response := <-responses:
+			err := response.err
+			if err == nil {
+				var data []byte
+				if response.value == nil {
+					data, err = s.fetchLegacy(response.canister, hash)
+				} else {
+					data, err = s.getChunks(ctx, response.canister, hash, response.value, mode)
+				}
+				if err == nil {
+					success = true
+					icFetchBytesCounter.Inc(int64(len(data)))
+					return data, nil
+				}
+			}
+			if !errors.Is(err, ErrNotFound) {
+				log.Warn("couldn't read batch from an IC storage canister", "canister", response.canister.id, "root", hash, "err", err)
+			}
+			anyError = moreInformativeICError(anyError, err)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, anyError
+}
+
+// readBatch reads the metadata of a batch from the canister in the given read mode. Metadata that
+// is queried is checked against the certified data of the canister.
+func (s *ICStorageService) readBatch(canister *icCanister, hash common.Hash, mode string) (*icBatch, error) {
+	return readInMode(s, canister, mode, func() (*icBatch, error) {
+		_, batch, err := s.certifiedBatch(canister, hash)
+		return batch, err
+	}, func() (*icBatch, error) {
+		return canister.getBatchReplicated(hash)
+	})
+}
+
+// getChunks reassembles a batch from its chunks on the canister. Each chunk that is queried is
+// checked against the certified witness, and the whole batch against its root, so like any other
+// StorageService it returns exactly the stored preimage.
+func (s *ICStorageService) getChunks(ctx context.Context, canister *icCanister, hash common.Hash, batch *icBatch, mode string) ([]byte, error) {
+	size, chunkCount := batch.Size, batch.ChunkCount
+	// Chunks may have been uploaded with a different max-chunk-size, but never above the ingress limit.
+	if size > uint64(chunkCount)*maxICChunkSize {
+		return nil, fmt.Errorf("%w: certified size %d of batch %v doesn't fit in %d chunks", ErrICCertificateInvalid, size, hash, chunkCount)
+	}
+
+	data := make([]byte, 0, size)
+	for index := uint32(0); index < chunkCount; index++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		chunk, err := readInMode(s, canister, mode, func() ([]byte, error) {
+			return s.certifiedChunk(canister, hash, index)
+		}, func() ([]byte, error) {
+			chunk, err := canister.getChunkReplicated(hash, index)
+			if err != nil {
+				return nil, err
+			}
+			return chunk.Chunk, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get chunk %d of batch %v: %w", index, hash, err)
+		}
+		data = append(data, chunk...)
+	}
+
+	if uint64(len(data)) != size {
+		return nil, fmt.Errorf("%w: batch %v is %d bytes long, but %d bytes were certified", ErrICCertificateInvalid, hash, len(data), size)
+	}
+	if dastree.Hash(data) != hash {
+		return nil, fmt.Errorf("%w: %w", ErrICCertificateInvalid, daprovider.ErrHashMismatch)
+	}
+	return data, nil
+}
 
-	b, err := json.Marshal(cb)
+// fetchLegacy fetches a batch whole from a canister that predates the storage API.
+func (s *ICStorageService) fetchLegacy(canister *icCanister, hash common.Hash) ([]byte, error) {
+	data, err := canister.fetchLegacy(hash, s.RootKey)
 	if err != nil {
 		return nil, err
 	}
+	if dastree.Hash(data) != hash {
+		return nil, fmt.Errorf("%w: %w", ErrICCertificateInvalid, daprovider.ErrHashMismatch)
+	}
+	return data, nil
+}
 
-	return b, nil
+// certifiedChunk queries a chunk of a batch and checks it against the certified witness.
+func (s *ICStorageService) certifiedChunk(canister *icCanister, hash common.Hash, index uint32) ([]byte, error) {
+	chunk, err := canister.getChunk(hash, index)
+	if err != nil {
+		return nil, err
+	}
+	proof := canister.proof(chunk.Certificate, chunk.Witness)
+	chunkHash, err := daprovider.VerifyICWitness(proof, s.RootKey, daprovider.ICChunkPath(hash, index))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrICCertificateInvalid, err)
+	}
+	if sum := sha256.Sum256(chunk.Chunk); !bytes.Equal(sum[:], chunkHash) {
+		return nil, fmt.Errorf("%w: %w: chunk %d of batch %v", ErrICCertificateInvalid, daprovider.ErrICWitnessMismatch, index, hash)
+	}
+	return chunk.Chunk, nil
 }
 
-func (s ICStorageService) ExpirationPolicy(ctx context.Context) (daprovider.ExpirationPolicy, error) {
+func (s *ICStorageService) ExpirationPolicy(ctx context.Context) (daprovider.ExpirationPolicy, error) {
+	if s.enableExpiry {
+		return daprovider.DiscardAfterDataTimeout, nil
+	}
 	return daprovider.KeepForever, nil
 }
 
-func (s ICStorageService) Close(ctx context.Context) error {
-	return nil
+func (s *ICStorageService) Close(ctx context.Context) error {
+	return s.stopWaiter.StopAndWait()
 }
 
-func (s ICStorageService) String() string {
+func (s *ICStorageService) String() string {
 	return "ICStorageService"
 }
 
-func (s ICStorageService) HealthCheck(ctx context.Context) error {
+// HealthCheck stores and fetches test data, and fails with ErrICDegraded if the monitor found a
+// canister running low on cycles or memory, so that it can be topped up before it stops accepting batches.
+func (s *ICStorageService) HealthCheck(ctx context.Context) error {
+	if err := s.degradedError(); err != nil {
+		return err
+	}
 	testData := []byte("Test-Data")
 	err := s.Put(ctx, testData, uint64(time.Now().Add(time.Minute).Unix()))
 	if err != nil {
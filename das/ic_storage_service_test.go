@@ -0,0 +1,620 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aviate-labs/agent-go/certification"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/metrics"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/ictest"
+	"github.com/offchainlabs/nitro/util/testhelpers"
+)
+
+func TestParseICRootKey(t *testing.T) {
+	mainnetRootKey, err := hex.DecodeString(certification.RootKey)
+	Require(t, err)
+
+	rootKey, err := ParseICRootKey("")
+	Require(t, err)
+	if !bytes.Equal(rootKey, mainnetRootKey) {
+		Fail(t, "empty root key didn't default to the mainnet root key")
+	}
+
+	rootKey, err = ParseICRootKey("0x" + certification.RootKey)
+	Require(t, err)
+	if !bytes.Equal(rootKey, mainnetRootKey) {
+		Fail(t, "hex root key wasn't decoded correctly")
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "root_key")
+	Require(t, os.WriteFile(keyFile, []byte(certification.RootKey+"\n"), 0600))
+	rootKey, err = ParseICRootKey(keyFile)
+	Require(t, err)
+	if !bytes.Equal(rootKey, mainnetRootKey) {
+		Fail(t, "root key file wasn't decoded correctly")
+	}
+
+	if _, err := ParseICRootKey("0x" + certification.RootKey[:len(certification.RootKey)-2]); err == nil {
+		Fail(t, "truncated root key should be rejected")
+	}
+	if _, err := ParseICRootKey("0xzz"); err == nil {
+		Fail(t, "non-hex root key should be rejected")
+	}
+}
+
+// defaultTestICStorageConfig is the config of a local replica reached from a docker container.
+var defaultTestICStorageConfig = ICStorageConfig{
+	Enable:               true,
+	Network:              "http://172.17.0.1:4943/",
+	Canisters:            []string{"bkyz2-fmaaa-aaaaa-qaaaq-cai"},
+	WritePolicy:          DefaultICStorageConfig.WritePolicy,
+	ReplicationFactor:    DefaultICStorageConfig.ReplicationFactor,
+	CertificateThreshold: DefaultICStorageConfig.CertificateThreshold,
+	MaxChunkSize:         DefaultICStorageConfig.MaxChunkSize,
+	MaxRetention:         DefaultICStorageConfig.MaxRetention,
+	ReadMode:             DefaultICStorageConfig.ReadMode,
+	QueryBackoff:         DefaultICStorageConfig.QueryBackoff,
+	MaxQueryBackoff:      DefaultICStorageConfig.MaxQueryBackoff,
+	CertifyTimeout:       DefaultICStorageConfig.CertifyTimeout,
+	CertifyRetryInterval: DefaultICStorageConfig.CertifyRetryInterval,
+	Monitor:              DefaultICStorageConfig.Monitor,
+	// A local replica generates its own root key on startup.
+	Dangerous: ICStorageDangerousConfig{
+		FetchRootKey: true,
+	},
+}
+
+func TestICStorageConfigValidate(t *testing.T) {
+	disabled := DefaultICStorageConfig
+	Require(t, disabled.Validate())
+
+	canister := []string{"bkyz2-fmaaa-aaaaa-qaaaq-cai"}
+	canisters := []string{"bkyz2-fmaaa-aaaaa-qaaaq-cai", "bd3sg-teaaa-aaaaa-qaaba-cai", "rrkah-fqaaa-aaaaa-aaaaq-cai"}
+
+	valid := []ICStorageConfig{
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkLocal, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024},
+		{Enable: true, Network: "https://ic.example.com", Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, RootKey: "0x" + certification.RootKey, MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canisters, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 3, CertificateThreshold: 1, MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canisters, WritePolicy: ICWritePolicyShard, ReplicationFactor: 2, CertificateThreshold: 1, MaxChunkSize: 1024},
+		defaultTestICStorageConfig,
+	}
+	for _, config := range valid {
+		if err := config.Validate(); err != nil {
+			Fail(t, "expected config to be valid", config, err)
+		}
+	}
+
+	invalid := []ICStorageConfig{
+		{Enable: true, Network: ICNetworkMainnet},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: []string{"not-a-canister"}},
+		{Enable: true, Network: "testnet", Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024},
+		{Enable: true, Network: "ftp://ic.example.com", Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkLocal, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, RootKey: "0x1234", MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024, Dangerous: ICStorageDangerousConfig{FetchRootKey: true}},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 4 * 1024 * 1024},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024, EnableExpiry: true},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: append(canister, canister...), WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canisters, WritePolicy: "mirror", ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canisters, WritePolicy: ICWritePolicyShard, ReplicationFactor: 0, CertificateThreshold: 1, MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canisters, WritePolicy: ICWritePolicyShard, ReplicationFactor: 4, CertificateThreshold: 1, MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024, Monitor: ICStorageMonitorConfig{Interval: time.Minute, MaxMemoryUsage: 1.5}},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024, Identity: "das.pem", UseBatchPosterKey: true},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canisters, WritePolicy: ICWritePolicyShard, ReplicationFactor: 2, CertificateThreshold: 0, MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canisters, WritePolicy: ICWritePolicyShard, ReplicationFactor: 2, CertificateThreshold: 3, MaxChunkSize: 1024},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024, ReadMode: "fast"},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024, QueryBackoff: time.Minute, MaxQueryBackoff: time.Second},
+		{Enable: true, Network: ICNetworkMainnet, Canisters: canister, WritePolicy: ICWritePolicyReplicate, ReplicationFactor: 1, CertificateThreshold: 1, MaxChunkSize: 1024, CertifyTimeout: time.Minute},
+	}
+	for _, config := range invalid {
+		if err := config.Validate(); err == nil {
+			Fail(t, "expected config to be rejected", config)
+		}
+	}
+}
+
+func TestSplitICChunks(t *testing.T) {
+	for _, size := range []int{0, 1, 99, 100, 101, 1000, 1001} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		chunks := splitICChunks(data, 100)
+		expectedChunks := (size + 99) / 100
+		if expectedChunks == 0 {
+			expectedChunks = 1
+		}
+		if len(chunks) != expectedChunks {
+			Fail(t, "expected", expectedChunks, "chunks for", size, "bytes, got", len(chunks))
+		}
+		for _, chunk := range chunks {
+			if len(chunk) > 100 {
+				Fail(t, "chunk too large", len(chunk))
+			}
+		}
+		if !bytes.Equal(bytes.Join(chunks, nil), data) {
+			Fail(t, "chunks don't reassemble into the data for", size, "bytes")
+		}
+	}
+}
+
+func testICStorageConfig(replica *ictest.Replica, maxChunkSize int) ICStorageConfig {
+	canisters := []string{}
+	for _, id := range replica.CanisterIDs() {
+		canisters = append(canisters, id.Encode())
+	}
+	return ICStorageConfig{
+		Enable:               true,
+		Network:              replica.URL(),
+		Canisters:            canisters,
+		WritePolicy:          ICWritePolicyReplicate,
+		ReplicationFactor:    1,
+		CertificateThreshold: 1,
+		RootKey:              "0x" + hex.EncodeToString(replica.RootKey()),
+		MaxChunkSize:         maxChunkSize,
+	}
+}
+
+func TestICStorageServiceWithReplica(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+
+	storageService, err := NewICStorageService(testICStorageConfig(replica, 1000), nil)
+	Require(t, err)
+	Require(t, storageService.HealthCheck(ctx))
+
+	data := testhelpers.RandomizeSlice(make([]byte, 2500))
+	root := dastree.Hash(data)
+	timeout := uint64(time.Now().Add(time.Hour).Unix())
+
+	// Upload the first chunk only, as if an earlier upload was interrupted.
+	Require(t, storageService.canisters[0].uploadChunk(root, 0, data[:1000]))
+	uploads := replica.Calls("upload_chunk")
+	Require(t, storageService.Put(ctx, data, timeout))
+	if replica.Calls("upload_chunk")-uploads != 2 {
+		Fail(t, "expected only the missing chunks to be uploaded, got", replica.Calls("upload_chunk")-uploads, "uploads")
+	}
+
+	// Storing the same batch again doesn't upload it again.
+	uploads = replica.Calls("upload_chunk")
+	Require(t, storageService.Put(ctx, data, timeout))
+	if replica.Calls("upload_chunk") != uploads {
+		Fail(t, "batch was uploaded again")
+	}
+
+	result, err := storageService.GetByHash(ctx, root)
+	Require(t, err)
+	if !bytes.Equal(result, data) {
+		Fail(t, "GetByHash returned different data")
+	}
+
+	proof, err := storageService.GetCertifiedByHash(ctx, root)
+	Require(t, err)
+	if !bytes.Equal(proof.Canister, replica.CanisterID().Raw) {
+		Fail(t, "proof is for the wrong canister")
+	}
+
+	_, err = storageService.GetByHash(ctx, dastree.Hash([]byte("absent data")))
+	if !errors.Is(err, ErrNotFound) {
+		Fail(t, "expected ErrNotFound for absent data, got", err)
+	}
+
+	// The canister rejects batches that don't match their root.
+	err = storageService.canisters[0].finalize(common.Hash{1}, 1, 0, 0)
+	if !errors.Is(err, ErrICRejected) {
+		Fail(t, "expected finalize to be rejected, got", err)
+	}
+
+	// Chunks that don't match the certified data are refused.
+	Require(t, replica.CorruptChunk(replica.CanisterID(), root, 1))
+	_, err = storageService.GetByHash(ctx, root)
+	if !errors.Is(err, ErrICCertificateInvalid) {
+		Fail(t, "expected corrupted chunk to be refused, got", err)
+	}
+}
+
+func TestICStorageServiceRejectsUnpinnedRootKey(t *testing.T) {
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+	otherReplica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, otherReplica.Shutdown()) }()
+
+	config := testICStorageConfig(replica, 1000)
+	config.RootKey = "0x" + hex.EncodeToString(otherReplica.RootKey())
+	if _, err := NewICStorageService(config, nil); err == nil {
+		Fail(t, "expected a replica with a different root key to be refused")
+	}
+}
+
+func TestICStorageServiceExpiry(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+
+	config := testICStorageConfig(replica, 1000)
+	config.EnableExpiry = true
+	config.MaxRetention = time.Hour
+	storageService, err := NewICStorageService(config, nil)
+	Require(t, err)
+
+	policy, err := storageService.ExpirationPolicy(ctx)
+	Require(t, err)
+	if policy != daprovider.DiscardAfterDataTimeout {
+		Fail(t, "expected DiscardAfterDataTimeout when expiry is enabled, got", policy)
+	}
+
+	now := time.Now()
+	shortLived := []byte("short lived batch")
+	longLived := []byte("long lived batch")
+	if storageService.Put(ctx, shortLived, uint64(now.Add(2*time.Hour).Unix())) == nil {
+		Fail(t, "expected an expiry beyond max-retention to be rejected")
+	}
+	Require(t, storageService.Put(ctx, shortLived, uint64(now.Add(10*time.Minute).Unix())))
+	Require(t, storageService.Put(ctx, longLived, uint64(now.Add(30*time.Minute).Unix())))
+
+	// Storing a batch again with a later expiry extends it, an earlier one doesn't.
+	extended := uint64(now.Add(20 * time.Minute).Unix())
+	Require(t, storageService.Put(ctx, shortLived, extended))
+	Require(t, storageService.Put(ctx, shortLived, uint64(now.Add(15*time.Minute).Unix())))
+	_, batch, err := storageService.certifiedBatch(storageService.canisters[0], dastree.Hash(shortLived))
+	Require(t, err)
+	if batch.Expiry != extended {
+		Fail(t, "expected certified expiry", extended, "got", batch.Expiry)
+	}
+
+	replica.AdvanceCanisterTime(25 * time.Minute)
+	pruned, err := storageService.canisters[0].prune()
+	Require(t, err)
+	if pruned != 1 {
+		Fail(t, "expected 1 batch to be pruned, got", pruned)
+	}
+	if _, err := storageService.GetByHash(ctx, dastree.Hash(shortLived)); !errors.Is(err, ErrNotFound) {
+		Fail(t, "expected expired batch to be pruned, got", err)
+	}
+	result, err := storageService.GetByHash(ctx, dastree.Hash(longLived))
+	Require(t, err)
+	if !bytes.Equal(result, longLived) {
+		Fail(t, "GetByHash returned different data")
+	}
+}
+
+func TestICStorageServiceMultipleCanisters(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort(
+		principal.MustDecode("bkyz2-fmaaa-aaaaa-qaaaq-cai"),
+		principal.MustDecode("bd3sg-teaaa-aaaaa-qaaba-cai"),
+		principal.MustDecode("be2us-64aaa-aaaaa-qaabq-cai"),
+	)
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+	canisters := replica.CanisterIDs()
+	timeout := uint64(time.Now().Add(time.Hour).Unix())
+
+	// A replicated batch is stored as long as replication-factor canisters store it.
+	config := testICStorageConfig(replica, 1000)
+	config.ReplicationFactor = 2
+	replicated, err := NewICStorageService(config, nil)
+	Require(t, err)
+	Require(t, replica.SetOutOfCycles(canisters[0], true))
+	data := testhelpers.RandomizeSlice(make([]byte, 1500))
+	root := dastree.Hash(data)
+	Require(t, replicated.Put(ctx, data, timeout))
+	for i, canister := range canisters {
+		if finalized := replica.CanisterCalls(canister, "finalize"); finalized != min(i, 1) {
+			Fail(t, "canister", i, "finalized", finalized, "batches")
+		}
+	}
+
+	// Reads use the first canister with a certified copy.
+	Require(t, replica.CorruptChunk(canisters[1], root, 1))
+	result, err := replicated.GetByHash(ctx, root)
+	Require(t, err)
+	if !bytes.Equal(result, data) {
+		Fail(t, "GetByHash returned different data")
+	}
+	proof, err := replicated.GetCertifiedByHash(ctx, root)
+	Require(t, err)
+	_, err = daprovider.VerifyICWitness(proof, replicated.RootKey, daprovider.ICBatchPath(root, daprovider.ICLabelSize))
+	Require(t, err)
+	// The proof only verifies for the canister that certified it.
+	for _, canister := range canisters {
+		if bytes.Equal(canister.Raw, proof.Canister) {
+			continue
+		}
+		otherProof := *proof
+		otherProof.Canister = canister.Raw
+		if _, err := daprovider.VerifyICWitness(&otherProof, replicated.RootKey, daprovider.ICBatchPath(root, daprovider.ICLabelSize)); err == nil {
+			Fail(t, "proof verified for canister", canister)
+		}
+	}
+
+	Require(t, replica.SetOutOfCycles(canisters[2], true))
+	if replicated.Put(ctx, []byte("under-replicated batch"), timeout) == nil {
+		Fail(t, "expected a batch stored on a single canister to be refused")
+	}
+	Require(t, replica.SetOutOfCycles(canisters[2], false))
+
+	// A sharded batch is stored on the canister selected by its hash, or the next one if that one fails.
+	config.WritePolicy = ICWritePolicyShard
+	config.ReplicationFactor = 1
+	sharded, err := NewICStorageService(config, nil)
+	Require(t, err)
+	for i := 0; i < 4; i++ {
+		data := []byte(fmt.Sprintf("sharded batch %d", i))
+		root := dastree.Hash(data)
+		Require(t, sharded.Put(ctx, data, timeout))
+		// canisters[0] is still out of cycles
+		order := slices.DeleteFunc(sharded.shard(root), func(c *icCanister) bool { return c.id.Equal(canisters[0]) })
+		for j, canister := range order {
+			_, err := canister.getBatch(root)
+			if j == 0 {
+				Require(t, err)
+			} else if !errors.Is(err, ErrNotFound) {
+				Fail(t, "expected batch", i, "to be stored on a single canister, got", err)
+			}
+		}
+		result, err := sharded.GetByHash(ctx, root)
+		Require(t, err)
+		if !bytes.Equal(result, data) {
+			Fail(t, "GetByHash returned different data")
+		}
+	}
+}
+
+func TestICStorageServiceLegacyCanister(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort(
+		principal.MustDecode("bkyz2-fmaaa-aaaaa-qaaaq-cai"),
+		principal.MustDecode("bd3sg-teaaa-aaaaa-qaaba-cai"),
+	)
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+	canisters := replica.CanisterIDs()
+	Require(t, replica.SetLegacy(canisters[1], true))
+
+	storageService, err := NewICStorageService(testICStorageConfig(replica, 1000), nil)
+	Require(t, err)
+	for i, want := range []uint32{icStorageAPIVersion, 0} {
+		version, err := storageService.canisters[i].apiVersion()
+		Require(t, err)
+		if version != want {
+			Fail(t, "canister", i, "reported storage API version", version, "expected", want)
+		}
+	}
+	if replica.CanisterCalls(canisters[1], "api_version") != 1 {
+		Fail(t, "storage API version wasn't asked for once")
+	}
+
+	// The batch isn't uploaded in chunks to the legacy canister, and only the other one certifies it.
+	data := testhelpers.RandomizeSlice(make([]byte, 1500))
+	root := dastree.Hash(data)
+	Require(t, storageService.Put(ctx, data, uint64(time.Now().Add(time.Hour).Unix())))
+	if replica.CanisterCalls(canisters[1], "upload_chunk") != 0 {
+		Fail(t, "batch was uploaded in chunks to the legacy canister")
+	}
+	result, err := storageService.GetByHash(ctx, root)
+	Require(t, err)
+	if !bytes.Equal(result, data) {
+		Fail(t, "GetByHash returned different data")
+	}
+	proof, err := storageService.GetCertifiedByHash(ctx, root)
+	Require(t, err)
+	if !bytes.Equal(proof.Canister, canisters[0].Raw) {
+		Fail(t, "proof is for the legacy canister")
+	}
+	if _, err := storageService.GetCertifiedProofsByHash(ctx, root, 2); !errors.Is(err, ErrICRejected) {
+		Fail(t, "expected the legacy canister to refuse to certify the batch, got", err)
+	}
+	if _, err := storageService.CanisterStatus(); err == nil {
+		Fail(t, "expected the legacy canister not to report its status")
+	}
+}
+
+func TestICStorageServiceMonitor(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort(
+		principal.MustDecode("bkyz2-fmaaa-aaaaa-qaaaq-cai"),
+		principal.MustDecode("bd3sg-teaaa-aaaaa-qaaba-cai"),
+	)
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+	canisters := replica.CanisterIDs()
+
+	config := testICStorageConfig(replica, 1000)
+	config.Monitor.MinCycles = 1_000_000_000_000
+	config.Monitor.MaxMemoryUsage = 0.5
+	storageService, err := NewICStorageService(config, nil)
+	Require(t, err)
+	storageService.monitorCanisters(ctx)
+	Require(t, storageService.HealthCheck(ctx))
+
+	expectDegraded := func(reason string) {
+		t.Helper()
+		storageService.monitorCanisters(ctx)
+		if err := storageService.HealthCheck(ctx); !errors.Is(err, ErrICDegraded) {
+			Fail(t, "expected health check to report", reason, "got", err)
+		}
+		if icDegradedGauge.Snapshot().Value() != 1 {
+			Fail(t, "expected degraded metric to be set for", reason)
+		}
+	}
+
+	Require(t, replica.SetCycles(canisters[1], 500_000_000_000))
+	expectDegraded("low cycles")
+	cyclesGauge := metrics.GetOrRegisterGauge("arb/das/ic/canister/"+canisters[1].Encode()+"/cycles", nil)
+	if cyclesGauge.Snapshot().Value() != 500_000_000_000 {
+		Fail(t, "unexpected cycles metric", cyclesGauge.Snapshot().Value())
+	}
+	Require(t, replica.SetCycles(canisters[1], 2_000_000_000_000))
+
+	// The health check stored 9 bytes of test data on the first canister.
+	Require(t, replica.SetMemoryLimit(canisters[0], 16))
+	expectDegraded("high memory usage")
+	Require(t, replica.SetMemoryLimit(canisters[0], 0))
+
+	Require(t, replica.SetOutOfCycles(canisters[0], true))
+	expectDegraded("an unreachable canister")
+	Require(t, replica.SetOutOfCycles(canisters[0], false))
+
+	storageService.monitorCanisters(ctx)
+	Require(t, storageService.HealthCheck(ctx))
+	if icDegradedGauge.Snapshot().Value() != 0 {
+		Fail(t, "expected degraded metric to be cleared")
+	}
+}
+
+func TestICStorageServiceCanisterStatus(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort(
+		principal.MustDecode("bkyz2-fmaaa-aaaaa-qaaaq-cai"),
+		principal.MustDecode("bd3sg-teaaa-aaaaa-qaaba-cai"),
+	)
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+	canisters := replica.CanisterIDs()
+
+	storageService, err := NewICStorageService(testICStorageConfig(replica, 1000), nil)
+	Require(t, err)
+	data := []byte("a batch taking up some space")
+	Require(t, storageService.Put(ctx, data, uint64(time.Now().Add(time.Hour).Unix())))
+	Require(t, replica.SetCycles(canisters[1], 42))
+
+	statuses, err := storageService.CanisterStatus()
+	Require(t, err)
+	if len(statuses) != 2 {
+		Fail(t, "expected the status of both canisters, got", statuses)
+	}
+	for i, status := range statuses {
+		if !status.Canister.Equal(canisters[i]) || status.StoredBytes != uint64(len(data)) {
+			Fail(t, "unexpected status", status, "of canister", canisters[i])
+		}
+	}
+	if statuses[1].Cycles.Uint64() != 42 {
+		Fail(t, "unexpected cycles", statuses[1].Cycles)
+	}
+
+	Require(t, replica.SetOutOfCycles(canisters[0], true))
+	statuses, err = storageService.CanisterStatus()
+	if err == nil || len(statuses) != 1 || !statuses[0].Canister.Equal(canisters[1]) {
+		Fail(t, "expected only the second canister to report its status, got", statuses, err)
+	}
+}
+
+func TestICStorageServiceReadModes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+
+	config := testICStorageConfig(replica, 1000)
+	config.QueryBackoff = time.Hour
+	config.MaxQueryBackoff = time.Hour
+	storageService, err := NewICStorageService(config, nil)
+	Require(t, err)
+	data := testhelpers.RandomizeSlice(make([]byte, 2500))
+	root := dastree.Hash(data)
+	Require(t, storageService.Put(ctx, data, uint64(time.Now().Add(time.Hour).Unix())))
+	readers := storageService.ReadModeReaders()
+	queryReader, updateReader := readers[0], readers[1]
+
+	for _, reader := range []daprovider.DASReader{queryReader, updateReader, storageService} {
+		result, err := reader.GetByHash(ctx, root)
+		Require(t, err)
+		if !bytes.Equal(result, data) {
+			Fail(t, reader, "returned different data")
+		}
+	}
+
+	// Queries answered by a lagging replica don't find the batch, but the subnet has it.
+	Require(t, replica.SetLagging(replica.CanisterID(), true))
+	if _, err := queryReader.GetByHash(ctx, root); !errors.Is(err, ErrNotFound) {
+		Fail(t, "expected the lagging replica not to have the batch, got", err)
+	}
+	fallbacks := icReadFallbackCounter.Snapshot().Count()
+	for _, reader := range []daprovider.DASReader{updateReader, storageService} {
+		result, err := reader.GetByHash(ctx, root)
+		Require(t, err)
+		if !bytes.Equal(result, data) {
+			Fail(t, reader, "returned different data from a lagging replica")
+		}
+	}
+	if icReadFallbackCounter.Snapshot().Count() == fallbacks {
+		Fail(t, "read from a lagging replica didn't fall back to an update call")
+	}
+	// A lagging replica isn't a reason to back off from queries.
+	if storageService.canisters[0].queryBackoff.active() {
+		Fail(t, "canister is backing off from queries after a lagging replica")
+	}
+
+	// The aggregator keeps separate stats for the read modes, so it can tell that the query
+	// mode fails and settle on the update mode.
+	emptyServer, port, err := NewRestfulDasServerOnRandomPort(LocalServerAddressForTest, NewMemoryBackedStorageService(ctx))
+	Require(t, err)
+	defer func() { Require(t, emptyServer.Shutdown()) }()
+	aggregator, err := NewRestfulClientAggregator(ctx, &RestfulClientAggregatorConfig{
+		Urls:                   []string{"http://localhost:" + strconv.Itoa(port)},
+		Strategy:               "testing-sequential",
+		StrategyUpdateInterval: time.Second,
+		WaitBeforeTryNext:      time.Second,
+		MaxPerEndpointStats:    10,
+	})
+	Require(t, err)
+	aggregator.AddReaders(readers...)
+	result, err := aggregator.GetByHash(ctx, root)
+	Require(t, err)
+	if !bytes.Equal(result, data) {
+		Fail(t, "aggregator returned different data")
+	}
+	Require(t, replica.SetLagging(replica.CanisterID(), false))
+
+	// While the canister is backing off, the query mode skips it and the fallback mode goes
+	// straight to update calls.
+	storageService.canisters[0].queryBackoff.failed(time.Hour, time.Hour)
+	if _, err := queryReader.GetByHash(ctx, root); !errors.Is(err, errICQueryBackoff) {
+		Fail(t, "expected queries to be skipped while backing off, got", err)
+	}
+	if !storageService.canisters[0].queryBackoff.active() {
+		Fail(t, "backoff ended early")
+	}
+	result, err = storageService.GetByHash(ctx, root)
+	Require(t, err)
+	if !bytes.Equal(result, data) {
+		Fail(t, "GetByHash returned different data while backing off from queries")
+	}
+
+	var backoff icQueryBackoff
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		backoff.failed(time.Second, 3*time.Second)
+		if backoff.duration != expected {
+			Fail(t, "expected a backoff of", expected, "got", backoff.duration)
+		}
+	}
+	backoff.succeeded()
+	if backoff.active() {
+		Fail(t, "backoff is still active after a successful query")
+	}
+}
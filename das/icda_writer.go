@@ -0,0 +1,163 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/pretty"
+)
+
+// CertifyAfterStoreICDAWriter provides the ICDA writer functionality over an ICStorageService.
+// It stores the batch in the IC storage canisters, then fetches the certificates and witnesses
+// the canisters produced for it and assembles them into an ICDACertificate. Its keyset allows
+// all the configured canisters, and requires proofs from certificate-threshold of them.
+type CertifyAfterStoreICDAWriter struct {
+	storageService *ICStorageService
+	keyset         *daprovider.ICDAKeyset
+	keysetHash     [32]byte
+	keysetBytes    []byte
+}
+
+func NewCertifyAfterStoreICDAWriter(storageService *ICStorageService) (*CertifyAfterStoreICDAWriter, error) {
+	if err := storageService.CheckWriteAccess(); err != nil {
+		return nil, err
+	}
+	keyset := &daprovider.ICDAKeyset{
+		Threshold: uint64(storageService.CertificateThreshold),
+		RootKey:   storageService.RootKey,
+	}
+	for _, canister := range storageService.Canisters {
+		keyset.Canisters = append(keyset.Canisters, canister.Raw)
+	}
+
+	ksBuf := bytes.NewBuffer([]byte{})
+	if err := keyset.Serialize(ksBuf); err != nil {
+		return nil, err
+	}
+	ksHash, err := keyset.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertifyAfterStoreICDAWriter{
+		storageService: storageService,
+		keyset:         keyset,
+		keysetHash:     ksHash,
+		keysetBytes:    ksBuf.Bytes(),
+	}, nil
+}
+
+// errICNotYetCertified is returned for a batch the canisters haven't certified since it was stored,
+// which happens while the replica that answered the query lags behind the subnet.
+var errICNotYetCertified = errors.New("batch not yet certified by IC storage")
+
+// Store stores the batch and returns a certificate for it once threshold canisters certified it,
+// in certificates the subnet signed no earlier than the store began. Transient IC errors are
+// retried until the certify timeout, uploading only what's missing since the batch's dastree root
+// is the idempotency key of the canisters. Errors that leave the batch uncertified wrap
+// daprovider.ErrBatchToICDAFailed, so that the batch poster can fall back to posting on chain.
+func (w *CertifyAfterStoreICDAWriter) Store(ctx context.Context, message []byte, timeout uint64) (*daprovider.ICDACertificate, error) {
+	log.Trace("das.CertifyAfterStoreICDAWriter.Store", "message", pretty.FirstFewBytes(message), "timeout", time.Unix(int64(timeout), 0), "this", w)
+	start := time.Now()
+	defer func() {
+		icCertifyDurationHistogram.Update(time.Since(start).Nanoseconds())
+	}()
+
+	storeCtx := ctx
+	if w.storageService.certifyTimeout > 0 {
+		var cancel context.CancelFunc
+		storeCtx, cancel = context.WithTimeout(ctx, w.storageService.certifyTimeout)
+		defer cancel()
+	}
+	// lastErr is the error of the last attempt that wasn't cut short by the timeout, which tells
+	// more about why the batch wasn't certified than the timeout does.
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		cert, err := w.storeAndCertify(storeCtx, message, timeout, start)
+		if err == nil {
+			return cert, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if storeCtx.Err() == nil {
+			lastErr = err
+			if w.storageService.certifyTimeout == 0 || !retryableICStoreError(err) {
+				return nil, fmt.Errorf("%w: %w", daprovider.ErrBatchToICDAFailed, err)
+			}
+			log.Warn("Couldn't store batch in IC storage and get it certified, retrying", "attempt", attempt, "err", err)
+			icCertifyRetryCounter.Inc(1)
+			select {
+			case <-time.After(w.storageService.certifyRetryInterval):
+				continue
+			case <-storeCtx.Done():
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+			}
+		}
+		if lastErr != nil {
+			err = lastErr
+		}
+		icCertifyTimeoutCounter.Inc(1)
+		return nil, fmt.Errorf("%w: IC storage didn't certify the batch within %v: %w", daprovider.ErrBatchToICDAFailed, w.storageService.certifyTimeout, err)
+	}
+}
+
+// retryableICStoreError reports whether storing a batch and getting it certified may succeed
+// if it's tried again.
+func retryableICStoreError(err error) bool {
+	return errors.Is(err, ErrICTransient) || errors.Is(err, ErrNotFound) || errors.Is(err, errICNotYetCertified)
+}
+
+// storeAndCertify makes a single attempt at storing the batch and collecting the proofs that
+// threshold canisters certified it no earlier than storeTime.
+func (w *CertifyAfterStoreICDAWriter) storeAndCertify(ctx context.Context, message []byte, timeout uint64, storeTime time.Time) (*daprovider.ICDACertificate, error) {
+	if err := w.storageService.Put(ctx, message, timeout); err != nil {
+		return nil, err
+	}
+
+	dataHash := dastree.Hash(message)
+	proofs, err := w.storageService.GetCertifiedProofsByHash(ctx, dataHash, int(w.keyset.Threshold))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch IC certificate: %w", err)
+	}
+
+	cert := &daprovider.ICDACertificate{
+		KeysetHash: w.keysetHash,
+		DataHash:   dataHash,
+		Timeout:    timeout,
+		Version:    daprovider.ICDACertificateVersion1,
+		Proofs:     proofs,
+	}
+	if len(proofs) > 1 {
+		cert.Version = daprovider.ICDACertificateVersion2
+	}
+	if err := cert.Verify(w.keyset); err != nil {
+		return nil, err
+	}
+	for i := range proofs {
+		certified, err := daprovider.ICCertificateTime(&proofs[i])
+		if err != nil {
+			return nil, err
+		}
+		if certified.Before(storeTime) {
+			return nil, fmt.Errorf("%w: canister %x certified batch %v at %v, before it was stored at %v", errICNotYetCertified, proofs[i].Canister, dataHash, certified, storeTime)
+		}
+	}
+	return cert, nil
+}
+
+func (w *CertifyAfterStoreICDAWriter) String() string {
+	return fmt.Sprintf("CertifyAfterStoreICDAWriter{%v}", w.storageService)
+}
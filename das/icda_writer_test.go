@@ -0,0 +1,278 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/arbutil"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/ictest"
+)
+
+func TestCertifyAfterStoreICDAWriter(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+
+	storageService, err := NewICStorageService(testICStorageConfig(replica, 1000), nil)
+	Require(t, err)
+	writer, err := NewCertifyAfterStoreICDAWriter(storageService)
+	Require(t, err)
+
+	message := []byte("a batch certified by the IC")
+	cert, err := writer.Store(ctx, message, uint64(time.Now().Add(time.Hour).Unix()))
+	Require(t, err)
+	if cert.DataHash != dastree.Hash(message) {
+		Fail(t, "certificate is for the wrong data")
+	}
+
+	serialized, err := cert.Serialize()
+	Require(t, err)
+	deserialized, err := daprovider.DeserializeICDACertFrom(bytes.NewReader(serialized))
+	Require(t, err)
+	keyset, err := daprovider.DeserializeICDAKeyset(bytes.NewReader(writer.keysetBytes))
+	Require(t, err)
+	Require(t, deserialized.Verify(keyset))
+
+	otherReplica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, otherReplica.Shutdown()) }()
+	otherRootKey := *keyset
+	otherRootKey.RootKey = otherReplica.RootKey()
+	if deserialized.Verify(&otherRootKey) == nil {
+		Fail(t, "certificate verified under a different root key")
+	}
+	otherCanister := *keyset
+	otherCanister.Canisters = [][]byte{principal.MustDecode("bd3sg-teaaa-aaaaa-qaaba-cai").Raw}
+	if deserialized.Verify(&otherCanister) == nil {
+		Fail(t, "certificate verified for a canister the keyset doesn't allow")
+	}
+
+	// The canisters must keep the batch at least until the certificate's timeout.
+	config := testICStorageConfig(replica, 1000)
+	config.EnableExpiry = true
+	config.MaxRetention = 2 * time.Hour
+	expiringStorage, err := NewICStorageService(config, nil)
+	Require(t, err)
+	expiringWriter, err := NewCertifyAfterStoreICDAWriter(expiringStorage)
+	Require(t, err)
+	expiringCert, err := expiringWriter.Store(ctx, []byte("a batch kept for an hour"), uint64(time.Now().Add(time.Hour).Unix()))
+	Require(t, err)
+	Require(t, expiringCert.Verify(keyset))
+	outlived := *expiringCert
+	outlived.Timeout++
+	if err := outlived.Verify(keyset); !errors.Is(err, daprovider.ErrInvalidICDAProof) {
+		Fail(t, "expected a certificate outliving the certified expiry to be refused, got", err)
+	}
+}
+
+func TestCertifyAfterStoreICDAWriterThreshold(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort(
+		principal.MustDecode("bkyz2-fmaaa-aaaaa-qaaaq-cai"),
+		principal.MustDecode("bd3sg-teaaa-aaaaa-qaaba-cai"),
+		principal.MustDecode("be2us-64aaa-aaaaa-qaabq-cai"),
+	)
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+
+	config := testICStorageConfig(replica, 1000)
+	config.ReplicationFactor = 3
+	config.CertificateThreshold = 2
+	storageService, err := NewICStorageService(config, nil)
+	Require(t, err)
+	writer, err := NewCertifyAfterStoreICDAWriter(storageService)
+	Require(t, err)
+	keyset, err := daprovider.DeserializeICDAKeyset(bytes.NewReader(writer.keysetBytes))
+	Require(t, err)
+	if keyset.Threshold != 2 || len(keyset.Canisters) != 3 {
+		Fail(t, "writer registers keyset with threshold", keyset.Threshold, "over", len(keyset.Canisters), "canisters")
+	}
+
+	cert, err := writer.Store(ctx, []byte("a batch certified by two canisters"), uint64(time.Now().Add(time.Hour).Unix()))
+	Require(t, err)
+	if cert.Version != daprovider.ICDACertificateVersion2 || len(cert.Proofs) != 2 {
+		Fail(t, "version", cert.Version, "certificate carries", len(cert.Proofs), "proofs")
+	}
+	serialized, err := cert.Serialize()
+	Require(t, err)
+	deserialized, err := daprovider.DeserializeICDACertFrom(bytes.NewReader(serialized))
+	Require(t, err)
+	Require(t, deserialized.Verify(keyset))
+
+	oneProof := *deserialized
+	oneProof.Proofs = oneProof.Proofs[:1]
+	if oneProof.Verify(keyset) == nil {
+		Fail(t, "certificate with a single proof met a threshold of two")
+	}
+	sameProofTwice := *deserialized
+	sameProofTwice.Proofs = []daprovider.ICCertifiedData{deserialized.Proofs[0], deserialized.Proofs[0]}
+	if sameProofTwice.Verify(keyset) == nil {
+		Fail(t, "certificate met the threshold with the same canister twice")
+	}
+}
+
+func TestCertifyAfterStoreICDAWriterRetries(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+
+	config := testICStorageConfig(replica, 1000)
+	config.CertifyTimeout = 30 * time.Second
+	config.CertifyRetryInterval = 100 * time.Millisecond
+	storageService, err := NewICStorageService(config, nil)
+	Require(t, err)
+	writer, err := NewCertifyAfterStoreICDAWriter(storageService)
+	Require(t, err)
+	timeout := uint64(time.Now().Add(time.Hour).Unix())
+
+	// The canister becomes available again while the writer retries.
+	Require(t, replica.SetUnavailable(replica.CanisterID(), true))
+	available := make(chan error, 1)
+	go func() {
+		time.Sleep(time.Second)
+		available <- replica.SetUnavailable(replica.CanisterID(), false)
+	}()
+	message := []byte("a batch stored once the canister is back")
+	cert, err := writer.Store(ctx, message, timeout)
+	Require(t, err)
+	Require(t, <-available)
+	if cert.DataHash != dastree.Hash(message) {
+		Fail(t, "certificate is for the wrong data")
+	}
+	if uploads := replica.Calls("upload_chunk"); uploads != 1 {
+		Fail(t, "batch was uploaded", uploads, "times")
+	}
+
+	// Certificates signed before the store began don't certify the batch, even if it's in them.
+	replica.SetCertificateLag(time.Hour)
+	storageService.certifyTimeout = 3 * time.Second
+	_, err = writer.Store(ctx, message, timeout)
+	if !errors.Is(err, daprovider.ErrBatchToICDAFailed) || !errors.Is(err, errICNotYetCertified) {
+		Fail(t, "expected stale certificates to be rejected until the timeout, got", err)
+	}
+	fallback, err := daprovider.NewWriterForICDA(writer).Store(ctx, message, timeout, false)
+	Require(t, err)
+	if !bytes.Equal(fallback, message) {
+		Fail(t, "expected the batch poster to fall back to posting the batch on chain")
+	}
+	if _, err := daprovider.NewWriterForICDA(writer).Store(ctx, message, timeout, true); err == nil {
+		Fail(t, "expected an error with the on chain fallback disabled")
+	}
+	replica.SetCertificateLag(0)
+
+	// Errors that won't go away are not retried.
+	Require(t, replica.SetOutOfCycles(replica.CanisterID(), true))
+	start := time.Now()
+	_, err = writer.Store(ctx, []byte("a batch for a frozen canister"), timeout)
+	if !errors.Is(err, daprovider.ErrBatchToICDAFailed) || errors.Is(err, ErrICTransient) {
+		Fail(t, "expected the batch to fail for good, got", err)
+	}
+	if time.Since(start) >= storageService.certifyTimeout {
+		Fail(t, "writer retried an error that won't go away")
+	}
+	Require(t, replica.SetOutOfCycles(replica.CanisterID(), false))
+
+	// A batch poster that's shutting down doesn't fall back to posting on chain.
+	Require(t, replica.SetUnavailable(replica.CanisterID(), true))
+	cancelCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, err = writer.Store(cancelCtx, []byte("a batch posted while shutting down"), timeout)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, daprovider.ErrBatchToICDAFailed) {
+		Fail(t, "expected the batch poster's context error, got", err)
+	}
+}
+
+// preimageReader serves batches and keysets from recorded preimages only.
+type preimageReader struct {
+	preimages map[common.Hash][]byte
+}
+
+func (r *preimageReader) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	return dastree.Content(hash, func(key common.Hash) ([]byte, error) {
+		preimage, ok := r.preimages[key]
+		if !ok {
+			return nil, fmt.Errorf("preimage %v wasn't recorded", key)
+		}
+		return preimage, nil
+	})
+}
+
+func (r *preimageReader) GetKeysetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	return r.GetByHash(ctx, hash)
+}
+
+func (r *preimageReader) ExpirationPolicy(ctx context.Context) (daprovider.ExpirationPolicy, error) {
+	return daprovider.DiscardImmediately, nil
+}
+
+// This only recovers the batches in Go from the preimages the native node recorded. It doesn't run
+// the replay binary, so it doesn't show that WAVM reaches the same verdict.
+func TestICDABatchRecoveryFromRecordedPreimages(t *testing.T) {
+	ctx := context.Background()
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+
+	storageService, err := NewICStorageService(testICStorageConfig(replica, 1000), nil)
+	Require(t, err)
+	writer, err := NewCertifyAfterStoreICDAWriter(storageService)
+	Require(t, err)
+	keysets := &preimageReader{preimages: map[common.Hash][]byte{}}
+	dastree.RecordHash(func(key common.Hash, value []byte, ty arbutil.PreimageType) {
+		keysets.preimages[key] = value
+	}, writer.keysetBytes)
+
+	message := []byte("a batch recovered from its preimages")
+	timeout := time.Now().Add(2 * daprovider.MinLifetimeSecondsForDataAvailabilityCert * time.Second)
+	cert, err := writer.Store(ctx, message, uint64(timeout.Unix()))
+	Require(t, err)
+	valid, err := cert.Serialize()
+	Require(t, err)
+	forged := bytes.Clone(valid)
+	// flip a bit of the certificate's BLS signature, at the end of the CBOR encoded certificate
+	forged[len(forged)-len(cert.Proofs[0].Witness)-3] ^= 1
+
+	header := make([]byte, 40)
+	binary.BigEndian.PutUint64(header[8:16], uint64(time.Now().Unix()))
+	batches := [][]byte{append(bytes.Clone(header), valid...), append(bytes.Clone(header), forged...)}
+
+	// the native node reads the batches from the IC and records the preimages it relied on
+	preimages := make(map[arbutil.PreimageType]map[common.Hash][]byte)
+	var native [][]byte
+	for i, batch := range batches {
+		payload, err := daprovider.RecoverPayloadFromICDABatch(ctx, uint64(i), batch, storageService, keysets, daprovider.RecordPreimagesTo(preimages), true)
+		Require(t, err)
+		native = append(native, payload)
+	}
+	if !bytes.Equal(native[0], message) {
+		Fail(t, "native node recovered", native[0], "expected", message)
+	}
+	if native[1] != nil {
+		Fail(t, "native node accepted a forged certificate")
+	}
+
+	// without the IC, only the sequencer messages and the recorded preimages are left
+	Require(t, replica.Shutdown())
+	recorded := &preimageReader{preimages: preimages[arbutil.Keccak256PreimageType]}
+	for i, batch := range batches {
+		payload, err := daprovider.RecoverPayloadFromICDABatch(ctx, uint64(i), batch, recorded, recorded, nil, true)
+		Require(t, err)
+		if !bytes.Equal(payload, native[i]) {
+			Fail(t, "batch", i, "recovered from preimages as", payload, "native node recovered", native[i])
+		}
+	}
+}
@@ -0,0 +1,379 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package ictest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/aviate-labs/agent-go/candid/idl"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/das/dastree"
+)
+
+// storageCanister emulates the ICDA storage canister, see das.icCanister for the client side
+// and daprovider.VerifyICWitness for the layout of its certified tree.
+type storageCanister struct {
+	// pending holds the chunks of batches that haven't been finalized yet
+	pending map[common.Hash]map[uint32][]byte
+	batches map[common.Hash]*storedBatch
+	// calls counts the executions of each method
+	calls map[string]int
+	// timeOffset is added to the time the canister prunes expired batches by
+	timeOffset time.Duration
+	// outOfCycles makes the canister reject everything, as the IC does once a canister is frozen
+	outOfCycles bool
+	// lagging makes queries answer as a replica that hasn't caught up with any batch yet would
+	lagging bool
+	// unavailable makes the replica answer every request to the canister with a 503, as a boundary
+	// node does while the canister's subnet can't be reached
+	unavailable bool
+	// writers are the principals allowed to upload and prune batches, anyone if it's empty
+	writers []principal.Principal
+	// cycles and memoryLimit are reported by "status", they don't affect the canister otherwise
+	cycles      uint64
+	memoryLimit uint64
+	// legacy makes the canister answer "api_version" as one deployed before the storage API would
+	legacy bool
+}
+
+// storageAPIVersion is the version of the storage API the canister implements.
+const storageAPIVersion = 1
+
+type storedBatch struct {
+	size   uint64
+	chunks [][]byte
+	// expiry is the unix time after which the batch may be pruned, 0 if it's kept forever
+	expiry uint64
+}
+
+// canisterReject is a reject of a call or query by the canister, with the IC reject and error codes.
+type canisterReject struct {
+	code      uint64
+	errorCode string
+	message   string
+}
+
+// IC reject codes, see the "Reject codes" section of the IC interface specification.
+const (
+	icRejectSysTransient       = 2
+	icRejectDestinationInvalid = 3
+	icRejectCanisterError      = 5
+)
+
+func (r *canisterReject) Error() string {
+	return fmt.Sprintf("(%d) %s: %s", r.code, r.errorCode, r.message)
+}
+
+type batchReply struct {
+	Size        uint64 `ic:"size"`
+	ChunkCount  uint32 `ic:"chunk_count"`
+	Expiry      uint64 `ic:"expiry"`
+	Certificate []byte `ic:"certificate"`
+	Witness     []byte `ic:"witness"`
+}
+
+type statusReply struct {
+	Cycles      idl.Nat `ic:"cycles"`
+	StoredBytes uint64  `ic:"stored_bytes"`
+	MemoryBytes uint64  `ic:"memory_bytes"`
+	MemoryLimit uint64  `ic:"memory_limit"`
+}
+
+type chunkReply struct {
+	Chunk       []byte `ic:"chunk"`
+	Certificate []byte `ic:"certificate"`
+	Witness     []byte `ic:"witness"`
+}
+
+// A canister starts with 10T cycles and may use 4GiB of memory.
+const (
+	defaultCycles      = 10_000_000_000_000
+	defaultMemoryLimit = 4 * 1024 * 1024 * 1024
+)
+
+func newStorageCanister() *storageCanister {
+	return &storageCanister{
+		pending:     make(map[common.Hash]map[uint32][]byte),
+		batches:     make(map[common.Hash]*storedBatch),
+		calls:       make(map[string]int),
+		cycles:      defaultCycles,
+		memoryLimit: defaultMemoryLimit,
+	}
+}
+
+// certifiedTree returns the tree whose root hash is the canister's certified data.
+func (c *storageCanister) certifiedTree() labeledTree {
+	batches := labeledTree{}
+	for root, batch := range c.batches {
+		chunks := labeledTree{}
+		for i, chunk := range batch.chunks {
+			hash := sha256.Sum256(chunk)
+			chunks[string(binary.BigEndian.AppendUint32(nil, uint32(i)))] = hash[:]
+		}
+		batches[string(root.Bytes())] = labeledTree{
+			string(daprovider.ICLabelSize):       binary.BigEndian.AppendUint64(nil, batch.size),
+			string(daprovider.ICLabelChunkCount): binary.BigEndian.AppendUint32(nil, uint32(len(batch.chunks))),
+			string(daprovider.ICLabelExpiry):     binary.BigEndian.AppendUint64(nil, batch.expiry),
+			string(daprovider.ICLabelChunks):     chunks,
+		}
+	}
+	return labeledTree{string(daprovider.ICLabelBatches): batches}
+}
+
+// certifier signs a data certificate for the canister's current certified data.
+type certifier func(certifiedData []byte) ([]byte, error)
+
+// query executes a query method and returns its candid encoded reply. sign is nil if the query
+// method is called as a replicated update, which doesn't get a data certificate.
+func (c *storageCanister) query(method string, arg []byte, sign certifier) ([]byte, error) {
+	if c.outOfCycles {
+		return nil, errOutOfCycles
+	}
+	c.calls[method]++
+	// A lagging replica answers queries, while replicated calls are executed by the whole subnet.
+	batches := c.batches
+	if c.lagging && sign != nil {
+		batches = nil
+	}
+	switch method {
+	case "api_version":
+		if c.legacy {
+			kind := "query"
+			if sign == nil {
+				kind = "update"
+			}
+			return nil, methodNotFound(kind, method)
+		}
+		if err := idl.Unmarshal(arg, []any{}); err != nil {
+			return nil, rejectf("couldn't decode arguments: %v", err)
+		}
+		return idl.Marshal([]any{uint32(storageAPIVersion)})
+	case "upload_status":
+		var root []byte
+		if err := idl.Unmarshal(arg, []any{&root}); err != nil {
+			return nil, rejectf("couldn't decode arguments: %v", err)
+		}
+		indexes := []uint32{}
+		for index := range c.pending[common.BytesToHash(root)] {
+			indexes = append(indexes, index)
+		}
+		sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+		return idl.Encode([]idl.Type{idl.NewVectorType(idl.Nat32Type())}, []any{indexes})
+	case "get_batch":
+		var root []byte
+		if err := idl.Unmarshal(arg, []any{&root}); err != nil {
+			return nil, rejectf("couldn't decode arguments: %v", err)
+		}
+		hash := common.BytesToHash(root)
+		var reply *batchReply
+		if batch, ok := batches[hash]; ok {
+			certificate, witness, err := c.certify(sign,
+				daprovider.ICBatchPath(hash, daprovider.ICLabelSize),
+				daprovider.ICBatchPath(hash, daprovider.ICLabelChunkCount),
+				daprovider.ICBatchPath(hash, daprovider.ICLabelExpiry),
+			)
+			if err != nil {
+				return nil, err
+			}
+			reply = &batchReply{
+				Size:        batch.size,
+				ChunkCount:  uint32(len(batch.chunks)),
+				Expiry:      batch.expiry,
+				Certificate: certificate,
+				Witness:     witness,
+			}
+		}
+		return encodeOpt(reply)
+	case "get_chunk":
+		var root []byte
+		var index uint32
+		if err := idl.Unmarshal(arg, []any{&root, &index}); err != nil {
+			return nil, rejectf("couldn't decode arguments: %v", err)
+		}
+		hash := common.BytesToHash(root)
+		var reply *chunkReply
+		if batch, ok := batches[hash]; ok && int(index) < len(batch.chunks) {
+			certificate, witness, err := c.certify(sign, daprovider.ICChunkPath(hash, index))
+			if err != nil {
+				return nil, err
+			}
+			reply = &chunkReply{
+				Chunk:       batch.chunks[index],
+				Certificate: certificate,
+				Witness:     witness,
+			}
+		}
+		return encodeOpt(reply)
+	case "status":
+		if err := idl.Unmarshal(arg, []any{}); err != nil {
+			return nil, rejectf("couldn't decode arguments: %v", err)
+		}
+		reply := statusReply{Cycles: idl.NewNat(c.cycles), MemoryLimit: c.memoryLimit}
+		for _, batch := range c.batches {
+			reply.StoredBytes += batch.size
+		}
+		reply.MemoryBytes = reply.StoredBytes
+		for _, chunks := range c.pending {
+			for _, chunk := range chunks {
+				reply.MemoryBytes += uint64(len(chunk))
+			}
+		}
+		return idl.Marshal([]any{reply})
+	case "allowed_writers":
+		if err := idl.Unmarshal(arg, []any{}); err != nil {
+			return nil, rejectf("couldn't decode arguments: %v", err)
+		}
+		return idl.Encode([]idl.Type{idl.NewVectorType(new(idl.PrincipalType))}, []any{c.writers})
+	default:
+		return nil, methodNotFound("query", method)
+	}
+}
+
+// update executes an update method called by caller and returns its candid encoded reply.
+func (c *storageCanister) update(method string, arg []byte, caller principal.Principal) ([]byte, error) {
+	if method == "get_batch" || method == "get_chunk" || method == "api_version" {
+		// Query methods can be called as updates too, which anyone may do.
+		return c.query(method, arg, nil)
+	}
+	if c.outOfCycles {
+		return nil, errOutOfCycles
+	}
+	c.calls[method]++
+	if len(c.writers) > 0 && !slices.ContainsFunc(c.writers, caller.Equal) {
+		return nil, rejectf("%s isn't allowed to call %s", caller, method)
+	}
+	switch method {
+	case "upload_chunk":
+		var root, chunk []byte
+		var index uint32
+		if err := idl.Unmarshal(arg, []any{&root, &index, &chunk}); err != nil {
+			return nil, rejectf("couldn't decode arguments: %v", err)
+		}
+		hash := common.BytesToHash(root)
+		if _, ok := c.batches[hash]; ok {
+			// already finalized, uploads are idempotent
+			return idl.Marshal([]any{})
+		}
+		if c.pending[hash] == nil {
+			c.pending[hash] = make(map[uint32][]byte)
+		}
+		c.pending[hash][index] = chunk
+		return idl.Marshal([]any{})
+	case "finalize":
+		var root []byte
+		var chunkCount uint32
+		var size, expiry uint64
+		if err := idl.Unmarshal(arg, []any{&root, &chunkCount, &size, &expiry}); err != nil {
+			return nil, rejectf("couldn't decode arguments: %v", err)
+		}
+		hash := common.BytesToHash(root)
+		if batch, ok := c.batches[hash]; ok {
+			// finalizing again extends the expiry
+			if batch.expiry != 0 && (expiry == 0 || expiry > batch.expiry) {
+				batch.expiry = expiry
+			}
+			return idl.Marshal([]any{})
+		}
+		if int(chunkCount) > len(c.pending[hash]) {
+			return nil, rejectf("batch %v has %d chunks uploaded, expected %d", hash, len(c.pending[hash]), chunkCount)
+		}
+		chunks := make([][]byte, chunkCount)
+		var data []byte
+		for i := range chunks {
+			chunk, ok := c.pending[hash][uint32(i)]
+			if !ok {
+				return nil, rejectf("chunk %d of batch %v is missing", i, hash)
+			}
+			chunks[i] = chunk
+			data = append(data, chunk...)
+		}
+		if uint64(len(data)) != size {
+			return nil, rejectf("batch %v is %d bytes long, expected %d", hash, len(data), size)
+		}
+		if dastree.Hash(data) != hash {
+			return nil, rejectf("batch doesn't match its root %v", hash)
+		}
+		c.batches[hash] = &storedBatch{size: size, chunks: chunks, expiry: expiry}
+		delete(c.pending, hash)
+		return idl.Marshal([]any{})
+	case "prune":
+		if err := idl.Unmarshal(arg, []any{}); err != nil {
+			return nil, rejectf("couldn't decode arguments: %v", err)
+		}
+		now := uint64(time.Now().Add(c.timeOffset).Unix())
+		var pruned uint32
+		for root, batch := range c.batches {
+			if batch.expiry != 0 && batch.expiry <= now {
+				delete(c.batches, root)
+				pruned++
+			}
+		}
+		return idl.Marshal([]any{pruned})
+	default:
+		return nil, methodNotFound("update", method)
+	}
+}
+
+// certify returns the data certificate and a witness of the certified tree revealing paths.
+// Both are empty if there is no certifier.
+func (c *storageCanister) certify(sign certifier, paths ...[]hashtree.Label) ([]byte, []byte, error) {
+	if sign == nil {
+		return nil, nil, nil
+	}
+	tree := c.certifiedTree()
+	certifiedData := prune(tree, nil, true).Reconstruct()
+	certificate, err := sign(certifiedData[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	encodedWitness, err := hashtree.Serialize(witness(tree, paths...))
+	if err != nil {
+		return nil, nil, err
+	}
+	return certificate, encodedWitness, nil
+}
+
+// encodeOpt encodes a possibly nil record pointer as a candid opt value.
+func encodeOpt[T any](value *T) ([]byte, error) {
+	typ, err := idl.TypeOf(new(T))
+	if err != nil {
+		return nil, err
+	}
+	return idl.Encode([]idl.Type{typ}, []any{value})
+}
+
+var errOutOfCycles = &canisterReject{
+	code:      icRejectSysTransient,
+	errorCode: "IC0207",
+	message:   "Canister is out of cycles",
+}
+
+func methodNotFound(kind, method string) error {
+	errorCode := "IC0302"
+	if kind == "update" {
+		errorCode = "IC0536"
+	}
+	return &canisterReject{
+		code:      icRejectDestinationInvalid,
+		errorCode: errorCode,
+		message:   fmt.Sprintf("Canister has no %s method '%s'", kind, method),
+	}
+}
+
+func rejectf(format string, args ...any) error {
+	return &canisterReject{
+		code:      icRejectCanisterError,
+		errorCode: "IC0503",
+		message:   "Canister trapped explicitly: " + fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package ictest
+
+import (
+	"sort"
+
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+)
+
+// labeledTree is the emulated state of a replica or a canister. Values are either leaves
+// ([]byte) or nested labeledTrees.
+type labeledTree map[string]any
+
+// witness returns t as a hash tree that only reveals the given paths, everything else
+// is pruned. A path that ends at a subtree reveals all of it.
+func witness(t labeledTree, paths ...[]hashtree.Label) hashtree.Node {
+	return prune(t, paths, false)
+}
+
+func prune(value any, paths [][]hashtree.Label, revealAll bool) hashtree.Node {
+	switch v := value.(type) {
+	case []byte:
+		if !revealAll && len(paths) == 0 {
+			return hashtree.Pruned(hashtree.Leaf(v).Reconstruct())
+		}
+		return hashtree.Leaf(v)
+	case labeledTree:
+		labels := make([]string, 0, len(v))
+		for label := range v {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		nodes := make([]hashtree.Node, 0, len(labels))
+		for _, label := range labels {
+			var childPaths [][]hashtree.Label
+			childRevealAll := revealAll
+			for _, path := range paths {
+				if len(path) == 0 || string(path[0]) != label {
+					continue
+				}
+				if len(path) == 1 {
+					childRevealAll = true
+				} else {
+					childPaths = append(childPaths, path[1:])
+				}
+			}
+			// The label slice is copied since Labeled.Reconstruct appends to it.
+			node := hashtree.Node(hashtree.Labeled{Label: hashtree.Label(label), Tree: prune(v[label], childPaths, childRevealAll)})
+			if !childRevealAll && len(childPaths) == 0 {
+				node = hashtree.Pruned(node.Reconstruct())
+			}
+			nodes = append(nodes, node)
+		}
+		return fork(nodes)
+	default:
+		panic("unexpected value in labeled tree")
+	}
+}
+
+// fork arranges sorted labeled nodes in a balanced tree of forks, collapsing forks
+// that have nothing revealed so that witnesses stay logarithmic in the size of the state.
+func fork(nodes []hashtree.Node) hashtree.Node {
+	switch len(nodes) {
+	case 0:
+		return hashtree.Empty{}
+	case 1:
+		return nodes[0]
+	}
+	middle := len(nodes) / 2
+	node := hashtree.Fork{LeftTree: fork(nodes[:middle]), RightTree: fork(nodes[middle:])}
+	_, leftPruned := node.LeftTree.(hashtree.Pruned)
+	_, rightPruned := node.RightTree.(hashtree.Pruned)
+	if leftPruned && rightPruned {
+		return hashtree.Pruned(node.Reconstruct())
+	}
+	return node
+}
@@ -0,0 +1,57 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package ictest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+)
+
+func TestWitness(t *testing.T) {
+	entries := labeledTree{}
+	for i := 0; i < 1000; i++ {
+		entries[fmt.Sprintf("entry-%04d", i)] = labeledTree{
+			"value": []byte(fmt.Sprintf("value-%d", i)),
+			"other": []byte("other"),
+		}
+	}
+	tree := labeledTree{"entries": entries, "time": []byte{1}}
+	full := prune(tree, nil, true)
+
+	path := []hashtree.Label{hashtree.Label("entries"), hashtree.Label("entry-0421"), hashtree.Label("value")}
+	node := witness(tree, path)
+	if node.Reconstruct() != full.Reconstruct() {
+		t.Fatal("witness doesn't have the same root hash as the tree")
+	}
+	value, err := hashtree.Lookup(node, path...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, []byte("value-421")) {
+		t.Fatalf("unexpected value %q", value)
+	}
+
+	var lookupErr hashtree.LookupError
+	_, err = hashtree.Lookup(node, hashtree.Label("entries"), hashtree.Label("entry-0422"), hashtree.Label("value"))
+	if !errors.As(err, &lookupErr) || lookupErr.Type != hashtree.LookupResultUnknown {
+		t.Fatal("expected pruned entry to be unknown, got", err)
+	}
+	_, err = hashtree.Lookup(node, hashtree.Label("absent"))
+	if !errors.As(err, &lookupErr) || lookupErr.Type != hashtree.LookupResultAbsent {
+		t.Fatal("expected label before the first one to be absent, got", err)
+	}
+
+	// Pruned siblings are collapsed, so the witness stays small however large the tree is.
+	encoded, err := hashtree.Serialize(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) > 1024 {
+		t.Fatal("witness is too large:", len(encoded), "bytes")
+	}
+}
@@ -0,0 +1,632 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+// Package ictest provides an in-process emulator of an IC replica hosting the ICDA storage
+// canister, for tests and local devnets that shouldn't depend on a live replica.
+package ictest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aviate-labs/agent-go"
+	"github.com/aviate-labs/agent-go/certification"
+	"github.com/aviate-labs/agent-go/certification/bls"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/aviate-labs/agent-go/principal"
+	"github.com/aviate-labs/secp256k1"
+	bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381"
+	"github.com/consensys/gnark-crypto/ecc/bls12-381/fr"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// DefaultCanisterID is the id of the first canister created on a local replica.
+const DefaultCanisterID = "bkyz2-fmaaa-aaaaa-qaaaq-cai"
+
+// IC ingress messages, including their envelope, are limited to 2MiB.
+const maxIngressSize = 2 * 1024 * 1024
+
+// Replica emulates the HTTP interface of an IC replica hosting one or more storage canisters.
+//
+// Certificates are signed with a BLS root key generated for the replica, and witnesses are
+// built from the actual state of the canister, so clients verify them exactly as they would
+// on the IC. Query responses are signed by an emulated node of the root subnet. Requests are
+// authenticated with Ed25519 or secp256k1 keys, and calls are executed before the replica
+// answers them.
+type Replica struct {
+	canisterIDs []principal.Principal
+	rootKey     *bls.SecretKey
+	rootKeyDER  []byte
+	nodeKey     ed25519.PrivateKey
+	nodeKeyDER  []byte
+	nodeID      principal.Principal
+
+	mutex sync.Mutex
+	// canisters is keyed by the raw canister id
+	canisters map[string]*storageCanister
+	// requests holds the request_status subtree of each call
+	requests map[agent.RequestID]labeledTree
+	// certificateLag is how far behind the actual time the data certificates of queries are
+	certificateLag time.Duration
+
+	url                  string
+	server               *http.Server
+	httpServerExitedChan chan interface{}
+	httpServerError      error
+}
+
+func NewReplica(address string, port uint64, canisterIDs ...principal.Principal) (*Replica, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", address, port))
+	if err != nil {
+		return nil, err
+	}
+	return NewReplicaOnListener(listener, canisterIDs...)
+}
+
+// NewReplicaOnRandomPort starts a replica on a free local port, hosting the given canisters
+// or only DefaultCanisterID if there are none.
+func NewReplicaOnRandomPort(canisterIDs ...principal.Principal) (*Replica, error) {
+	return NewReplica("127.0.0.1", 0, canisterIDs...)
+}
+
+// NewReplicaOnListener starts a replica hosting the given canisters, or only DefaultCanisterID
+// if there are none.
+func NewReplicaOnListener(listener net.Listener, canisterIDs ...principal.Principal) (*Replica, error) {
+	if len(canisterIDs) == 0 {
+		canisterIDs = []principal.Principal{principal.MustDecode(DefaultCanisterID)}
+	}
+	canisters := make(map[string]*storageCanister, len(canisterIDs))
+	for _, canisterID := range canisterIDs {
+		if _, ok := canisters[string(canisterID.Raw)]; ok {
+			return nil, fmt.Errorf("canister %s is hosted twice", canisterID)
+		}
+		canisters[string(canisterID.Raw)] = newStorageCanister()
+	}
+	rootKey := bls.NewSecretKeyByCSPRNG()
+	if rootKey == nil {
+		return nil, errors.New("couldn't generate IC root key")
+	}
+	publicKey := blsPublicKey(rootKey).Bytes()
+	rootKeyDER, err := certification.PublicBLSKeyToDER(publicKey[:])
+	if err != nil {
+		return nil, err
+	}
+	nodePublicKey, nodeKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	nodeKeyDER, err := x509.MarshalPKIXPublicKey(nodePublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replica{
+		canisterIDs:          canisterIDs,
+		rootKey:              rootKey,
+		rootKeyDER:           rootKeyDER,
+		nodeKey:              nodeKey,
+		nodeKeyDER:           nodeKeyDER,
+		nodeID:               principal.NewSelfAuthenticating(nodeKeyDER),
+		canisters:            canisters,
+		requests:             make(map[agent.RequestID]labeledTree),
+		url:                  "http://" + listener.Addr().String(),
+		httpServerExitedChan: make(chan interface{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v2/status", r.handleStatus)
+	mux.HandleFunc("POST /api/v2/canister/{canister}/{endpoint}", r.handleCanister)
+	r.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		err := r.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.httpServerError = err
+		}
+		close(r.httpServerExitedChan)
+	}()
+
+	return r, nil
+}
+
+// URL is the url of the replica, for use as the network of an IC storage service.
+func (r *Replica) URL() string {
+	return r.url
+}
+
+// RootKey is the DER encoded root key the replica's certificates are signed with.
+func (r *Replica) RootKey() []byte {
+	return r.rootKeyDER
+}
+
+// CanisterID is the first canister hosted by the replica.
+func (r *Replica) CanisterID() principal.Principal {
+	return r.canisterIDs[0]
+}
+
+func (r *Replica) CanisterIDs() []principal.Principal {
+	return append([]principal.Principal{}, r.canisterIDs...)
+}
+
+// Calls returns how many times the method has been executed, summed over all canisters.
+func (r *Replica) Calls(method string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	calls := 0
+	for _, canister := range r.canisters {
+		calls += canister.calls[method]
+	}
+	return calls
+}
+
+// CanisterCalls returns how many times the method has been executed by one canister.
+func (r *Replica) CanisterCalls(canisterID principal.Principal, method string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	canister, ok := r.canisters[string(canisterID.Raw)]
+	if !ok {
+		return 0
+	}
+	return canister.calls[method]
+}
+
+// CorruptChunk flips a bit of a chunk stored by the canister without updating its certified
+// data, so that it no longer matches its certificate.
+func (r *Replica) CorruptChunk(canisterID principal.Principal, root common.Hash, index uint32) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	canister, ok := r.canisters[string(canisterID.Raw)]
+	if !ok {
+		return fmt.Errorf("canister %s not found", canisterID)
+	}
+	batch, ok := canister.batches[root]
+	if !ok || int(index) >= len(batch.chunks) || len(batch.chunks[index]) == 0 {
+		return fmt.Errorf("no chunk %d in batch %v", index, root)
+	}
+	chunk := append([]byte{}, batch.chunks[index]...)
+	chunk[0] ^= 1
+	batch.chunks[index] = chunk
+	return nil
+}
+
+// AdvanceCanisterTime moves the clock the canister prunes expired batches by forward. Certificates
+// keep using the actual time, since clients check that they are recent.
+func (r *Replica) AdvanceCanisterTime(d time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, canister := range r.canisters {
+		canister.timeOffset += d
+	}
+}
+
+// SetOutOfCycles makes the canister reject every query and call as having run out of cycles,
+// until it is called again with outOfCycles false.
+func (r *Replica) SetOutOfCycles(canisterID principal.Principal, outOfCycles bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	canister, ok := r.canisters[string(canisterID.Raw)]
+	if !ok {
+		return fmt.Errorf("canister %s not found", canisterID)
+	}
+	canister.outOfCycles = outOfCycles
+	return nil
+}
+
+// SetLagging makes queries to the canister be answered as by a replica that lags behind and
+// doesn't have any batch yet, while replicated calls still see all of them.
+func (r *Replica) SetLagging(canisterID principal.Principal, lagging bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	canister, ok := r.canisters[string(canisterID.Raw)]
+	if !ok {
+		return fmt.Errorf("canister %s not found", canisterID)
+	}
+	canister.lagging = lagging
+	return nil
+}
+
+// SetUnavailable makes the replica answer every request to the canister with a 503 Service
+// Unavailable, until it is called again with unavailable false.
+func (r *Replica) SetUnavailable(canisterID principal.Principal, unavailable bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	canister, ok := r.canisters[string(canisterID.Raw)]
+	if !ok {
+		return fmt.Errorf("canister %s not found", canisterID)
+	}
+	canister.unavailable = unavailable
+	return nil
+}
+
+// SetCertificateLag makes queries return data certificates signed lag before the actual time,
+// as a replica whose certified state lags behind the subnet does.
+func (r *Replica) SetCertificateLag(lag time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.certificateLag = lag
+}
+
+// SetWriters restricts the principals the canister accepts uploads from, anyone may upload
+// if there are none.
+func (r *Replica) SetWriters(canisterID principal.Principal, writers ...principal.Principal) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	canister, ok := r.canisters[string(canisterID.Raw)]
+	if !ok {
+		return fmt.Errorf("canister %s not found", canisterID)
+	}
+	canister.writers = writers
+	return nil
+}
+
+// SetCycles sets the cycles balance the canister reports.
+func (r *Replica) SetCycles(canisterID principal.Principal, cycles uint64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	canister, ok := r.canisters[string(canisterID.Raw)]
+	if !ok {
+		return fmt.Errorf("canister %s not found", canisterID)
+	}
+	canister.cycles = cycles
+	return nil
+}
+
+// SetLegacy makes the canister answer as one deployed before the storage API, which doesn't
+// implement "api_version". It still implements the rest of the API.
+func (r *Replica) SetLegacy(canisterID principal.Principal, legacy bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	canister, ok := r.canisters[string(canisterID.Raw)]
+	if !ok {
+		return fmt.Errorf("canister %s not found", canisterID)
+	}
+	canister.legacy = legacy
+	return nil
+}
+
+// SetMemoryLimit sets the memory limit the canister reports, 0 for none.
+func (r *Replica) SetMemoryLimit(canisterID principal.Principal, limit uint64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	canister, ok := r.canisters[string(canisterID.Raw)]
+	if !ok {
+		return fmt.Errorf("canister %s not found", canisterID)
+	}
+	canister.memoryLimit = limit
+	return nil
+}
+
+func (r *Replica) Shutdown() error {
+	err := r.server.Close()
+	if err != nil {
+		return err
+	}
+	<-r.httpServerExitedChan
+	return r.httpServerError
+}
+
+// requestEnvelope is the body of query, call and read_state requests.
+type requestEnvelope struct {
+	Content      requestContent `cbor:"content"`
+	SenderPubKey []byte         `cbor:"sender_pubkey"`
+	SenderSig    []byte         `cbor:"sender_sig"`
+}
+
+type requestContent struct {
+	Type          string             `cbor:"request_type"`
+	CanisterID    []byte             `cbor:"canister_id"`
+	MethodName    string             `cbor:"method_name"`
+	Arg           []byte             `cbor:"arg"`
+	Sender        []byte             `cbor:"sender"`
+	IngressExpiry uint64             `cbor:"ingress_expiry"`
+	Nonce         []byte             `cbor:"nonce"`
+	Paths         [][]hashtree.Label `cbor:"paths"`
+}
+
+func (c *requestContent) requestID() agent.RequestID {
+	return agent.NewRequestID(agent.Request{
+		Type:          c.Type,
+		Sender:        principal.Principal{Raw: c.Sender},
+		Nonce:         c.Nonce,
+		IngressExpiry: c.IngressExpiry,
+		CanisterID:    principal.Principal{Raw: c.CanisterID},
+		MethodName:    c.MethodName,
+		Arguments:     c.Arg,
+		Paths:         c.Paths,
+	})
+}
+
+// verifySender checks that the request is signed by the key its sender is derived from,
+// unless it's sent anonymously.
+func (e *requestEnvelope) verifySender() error {
+	sender := principal.Principal{Raw: e.Content.Sender}
+	if sender.Equal(principal.AnonymousID) {
+		return nil
+	}
+	if !sender.Equal(principal.NewSelfAuthenticating(e.SenderPubKey)) {
+		return fmt.Errorf("sender %s isn't derived from the public key", sender)
+	}
+	requestID := e.Content.requestID()
+	if !verifySignature(e.SenderPubKey, append([]byte("\x0Aic-request"), requestID[:]...), e.SenderSig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+var (
+	ecPublicKeyOID = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	secp256k1OID   = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// verifySignature checks an Ed25519 signature, or a secp256k1 signature of the sha256 hash of the
+// message, against a DER encoded public key.
+func verifySignature(publicKeyDER, message, signature []byte) bool {
+	if publicKey, err := certification.PublicED25519KeyFromDER(publicKeyDER); err == nil {
+		return ed25519.Verify(*publicKey, message, signature)
+	}
+	var ecKey struct {
+		Metadata  []asn1.ObjectIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(publicKeyDER, &ecKey); err != nil {
+		return false
+	}
+	if len(ecKey.Metadata) != 2 || !ecKey.Metadata[0].Equal(ecPublicKeyOID) || !ecKey.Metadata[1].Equal(secp256k1OID) {
+		return false
+	}
+	publicKey, err := secp256k1.ParsePubKey(ecKey.PublicKey.Bytes, secp256k1.S256())
+	if err != nil || len(signature) != 64 {
+		return false
+	}
+	hash := sha256.Sum256(message)
+	ecSignature := secp256k1.Signature{R: new(big.Int).SetBytes(signature[:32]), S: new(big.Int).SetBytes(signature[32:])}
+	return ecSignature.Verify(hash[:], publicKey)
+}
+
+type certificate struct {
+	Tree      hashtree.HashTree `cbor:"tree"`
+	Signature []byte            `cbor:"signature"`
+}
+
+func (r *Replica) handleStatus(w http.ResponseWriter, req *http.Request) {
+	writeCBOR(w, &agent.Status{Version: "0.18.0", RootKey: r.rootKeyDER})
+}
+
+func (r *Replica) handleCanister(w http.ResponseWriter, req *http.Request) {
+	canisterID, err := principal.Decode(req.PathValue("canister"))
+	canister, ok := r.canisters[string(canisterID.Raw)]
+	if err != nil || !ok {
+		http.Error(w, fmt.Sprintf("canister %s not found", req.PathValue("canister")), http.StatusNotFound)
+		return
+	}
+	r.mutex.Lock()
+	unavailable := canister.unavailable
+	r.mutex.Unlock()
+	if unavailable {
+		http.Error(w, "canister is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxIngressSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxIngressSize {
+		http.Error(w, "request is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var envelope requestEnvelope
+	if err := cbor.Unmarshal(body, &envelope); err != nil {
+		http.Error(w, fmt.Sprintf("couldn't decode request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := envelope.verifySender(); err != nil {
+		http.Error(w, fmt.Sprintf("couldn't authenticate request: %v", err), http.StatusBadRequest)
+		return
+	}
+	content := &envelope.Content
+	endpoint := req.PathValue("endpoint")
+	if content.Type != endpoint {
+		http.Error(w, fmt.Sprintf("%s request sent to the %s endpoint", content.Type, endpoint), http.StatusBadRequest)
+		return
+	}
+	switch endpoint {
+	case agent.RequestTypeQuery:
+		r.query(w, canisterID, canister, content)
+	case agent.RequestTypeCall:
+		r.call(w, canister, content)
+	case agent.RequestTypeReadState:
+		r.readState(w, content)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *Replica) query(w http.ResponseWriter, canisterID principal.Principal, canister *storageCanister, content *requestContent) {
+	r.mutex.Lock()
+	reply, err := canister.query(content.MethodName, content.Arg, func(certifiedData []byte) ([]byte, error) {
+		return r.dataCertificate(canisterID, certifiedData)
+	})
+	r.mutex.Unlock()
+
+	var response agent.Response
+	var reject *canisterReject
+	timestamp := time.Now().UnixNano()
+	requestID := content.requestID()
+	var signed []certification.KeyValuePair
+	switch {
+	case errors.As(err, &reject):
+		response.Status = "rejected"
+		response.RejectCode = reject.code
+		response.RejectMsg = reject.message
+		response.ErrorCode = reject.errorCode
+		signed = []certification.KeyValuePair{
+			{Key: "status", Value: response.Status},
+			{Key: "reject_code", Value: binary.AppendUvarint(nil, reject.code)},
+			{Key: "reject_message", Value: response.RejectMsg},
+			{Key: "error_code", Value: response.ErrorCode},
+			{Key: "timestamp", Value: timestamp},
+			{Key: "request_id", Value: requestID[:]},
+		}
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	default:
+		response.Status = "replied"
+		response.Reply, err = cbor.Marshal(map[string][]byte{"arg": reply})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		signed = []certification.KeyValuePair{
+			{Key: "status", Value: response.Status},
+			{Key: "reply", Value: response.Reply},
+			{Key: "timestamp", Value: timestamp},
+			{Key: "request_id", Value: requestID[:]},
+		}
+	}
+	hash, err := certification.RepresentationIndependentHash(signed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response.Signatures = []agent.ResponseSignature{{
+		Timestamp: timestamp,
+		Signature: ed25519.Sign(r.nodeKey, append([]byte("\x0Bic-response"), hash[:]...)),
+		Identity:  r.nodeID,
+	}}
+	writeCBOR(w, &response)
+}
+
+// call executes the update right away, the client then polls its status with read_state.
+func (r *Replica) call(w http.ResponseWriter, canister *storageCanister, content *requestContent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	reply, err := canister.update(content.MethodName, content.Arg, principal.Principal{Raw: content.Sender})
+	var reject *canisterReject
+	switch {
+	case errors.As(err, &reject):
+		r.requests[content.requestID()] = labeledTree{
+			"status":         []byte("rejected"),
+			"reject_code":    binary.AppendUvarint(nil, reject.code),
+			"reject_message": []byte(reject.message),
+			"error_code":     []byte(reject.errorCode),
+		}
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	default:
+		r.requests[content.requestID()] = labeledTree{
+			"status": []byte("replied"),
+			"reply":  reply,
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (r *Replica) readState(w http.ResponseWriter, content *requestContent) {
+	r.mutex.Lock()
+	tree := r.stateTree()
+	r.mutex.Unlock()
+
+	paths := append([][]hashtree.Label{{hashtree.Label("time")}}, content.Paths...)
+	encoded, err := r.certificate(witness(tree, paths...))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeCBOR(w, map[string][]byte{"certificate": encoded})
+}
+
+// stateTree returns the replicated state of the subnet, as far as the replica emulates it.
+func (r *Replica) stateTree() labeledTree {
+	canisters := labeledTree{}
+	for canisterID, canister := range r.canisters {
+		certifiedData := prune(canister.certifiedTree(), nil, true).Reconstruct()
+		canisters[canisterID] = labeledTree{"certified_data": certifiedData[:]}
+	}
+	requests := labeledTree{}
+	for id, status := range r.requests {
+		requests[string(id[:])] = status
+	}
+	subnetID := principal.MustDecode(certification.RootSubnetID)
+	return labeledTree{
+		"canister":       canisters,
+		"request_status": requests,
+		"subnet": labeledTree{
+			string(subnetID.Raw): labeledTree{
+				"node": labeledTree{
+					string(r.nodeID.Raw): labeledTree{"public_key": r.nodeKeyDER},
+				},
+			},
+		},
+		"time": timeLeaf(),
+	}
+}
+
+// dataCertificate returns a certificate of the canister's certified data, as the canister
+// would get from ic0.data_certificate during a query. It must be called with the mutex held.
+func (r *Replica) dataCertificate(canisterID principal.Principal, certifiedData []byte) ([]byte, error) {
+	tree := labeledTree{
+		"canister": labeledTree{
+			string(canisterID.Raw): labeledTree{"certified_data": certifiedData},
+		},
+		"time": binary.AppendUvarint(nil, uint64(time.Now().Add(-r.certificateLag).UnixNano())),
+	}
+	return r.certificate(prune(tree, nil, true))
+}
+
+// certificate signs the root hash of the tree with the root key.
+func (r *Replica) certificate(tree hashtree.Node) ([]byte, error) {
+	digest := tree.Reconstruct()
+	signature, err := r.rootKey.Sign(append(hashtree.DomainSeparator("ic-state-root"), digest[:]...))
+	if err != nil {
+		return nil, err
+	}
+	signatureBytes := (*bls12381.G1Affine)(signature).Bytes()
+	return cbor.Marshal(certificate{
+		Tree:      hashtree.NewHashTree(tree),
+		Signature: signatureBytes[:],
+	})
+}
+
+// blsPublicKey computes the public key of a secret key. SecretKey.PublicKey isn't used since
+// it scales the package's shared generator in place, which breaks every later call.
+func blsPublicKey(secretKey *bls.SecretKey) *bls12381.G2Affine {
+	_, _, _, generator := bls12381.Generators()
+	scalar := fr.Element(*secretKey)
+	var publicKey bls12381.G2Affine
+	publicKey.ScalarMultiplication(&generator, scalar.BigInt(new(big.Int)))
+	return &publicKey
+}
+
+// timeLeaf is the current time in nanoseconds, LEB128 encoded.
+func timeLeaf() []byte {
+	return binary.AppendUvarint(nil, uint64(time.Now().UnixNano()))
+}
+
+func writeCBOR(w http.ResponseWriter, value any) {
+	data, err := cbor.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/cbor")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,39 @@
+// Copyright 2024, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package ictest
+
+import (
+	"testing"
+
+	"github.com/aviate-labs/agent-go/certification"
+	"github.com/aviate-labs/agent-go/certification/hashtree"
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestReplicaCertificates(t *testing.T) {
+	// Several replicas run in the same process, each with its own root key.
+	for i := 0; i < 3; i++ {
+		replica, err := NewReplicaOnRandomPort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		replica.mutex.Lock()
+		tree := replica.stateTree()
+		replica.mutex.Unlock()
+		encoded, err := replica.certificate(witness(tree, []hashtree.Label{hashtree.Label("time")}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var certificate certification.Certificate
+		if err := cbor.Unmarshal(encoded, &certificate); err != nil {
+			t.Fatal(err)
+		}
+		if err := certification.VerifyCertificate(certificate, replica.CanisterID(), replica.RootKey()); err != nil {
+			t.Fatal("certificate of replica", i, "doesn't verify:", err)
+		}
+		if err := replica.Shutdown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
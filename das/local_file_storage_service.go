@@ -23,6 +23,7 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/erasure"
 	"github.com/offchainlabs/nitro/util/pretty"
 	"github.com/offchainlabs/nitro/util/stopwaiter"
 	flag "github.com/spf13/pflag"
@@ -30,10 +31,11 @@ import (
 )
 
 type LocalFileStorageConfig struct {
-	Enable       bool          `koanf:"enable"`
-	DataDir      string        `koanf:"data-dir"`
-	EnableExpiry bool          `koanf:"enable-expiry"`
-	MaxRetention time.Duration `koanf:"max-retention"`
+	Enable         bool          `koanf:"enable"`
+	DataDir        string        `koanf:"data-dir"`
+	EnableExpiry   bool          `koanf:"enable-expiry"`
+	MaxRetention   time.Duration `koanf:"max-retention"`
+	EnableChunking bool          `koanf:"enable-chunking"`
 }
 
 var DefaultLocalFileStorageConfig = LocalFileStorageConfig{
@@ -46,6 +48,7 @@ func LocalFileStorageConfigAddOptions(prefix string, f *flag.FlagSet) {
 	f.String(prefix+".data-dir", DefaultLocalFileStorageConfig.DataDir, "local data directory")
 	f.Bool(prefix+".enable-expiry", DefaultLocalFileStorageConfig.EnableExpiry, "enable expiry of batches")
 	f.Duration(prefix+".max-retention", DefaultLocalFileStorageConfig.MaxRetention, "store requests with expiry times farther in the future than max-retention will be rejected")
+	f.Bool(prefix+".enable-chunking", DefaultLocalFileStorageConfig.EnableChunking, "store new batches as the 64 KiB leaves of their dastree, each leaf once however many batches contain it, and delete leaves once no batch references them; batches already stored whole stay readable")
 }
 
 type LocalFileStorageService struct {
@@ -128,23 +131,68 @@ func (s *LocalFileStorageService) GetByHash(ctx context.Context, key common.Hash
 		}
 		return nil, err
 	}
-	return data, nil
+	return loadChunks(ctx, &s.layout, key, data)
+}
+
+// Has stats the batch's file, and only reads it if it may be the manifest of a chunked batch, to
+// check its chunks are there.
+func (s *LocalFileStorageService) Has(ctx context.Context, key common.Hash) (bool, error) {
+	log.Trace("das.LocalFileStorageService.Has", "key", pretty.PrettyHash(key), "this", s)
+
+	batchPath := s.layout.batchPath(key)
+	info, err := os.Stat(batchPath)
+	if errors.Is(err, os.ErrNotExist) {
+		_, err = os.Stat(s.legacyLayout.batchPath(key))
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if !mayBeChunkManifest(info.Size()) {
+		return true, nil
+	}
+	stored, err := os.ReadFile(batchPath)
+	if err != nil {
+		return false, err
+	}
+	return hasChunks(ctx, &s.layout, key, stored)
 }
 
 func (s *LocalFileStorageService) Put(ctx context.Context, data []byte, expiry uint64) error {
 	logPut("das.LocalFileStorageService.Store", data, expiry, s)
+	return s.put(ctx, dastree.Hash(data), data, expiry, s.config.EnableChunking)
+}
+
+// PutShard stores the shard like a batch under its key, so that it is pruned with the batches
+// when it expires.
+func (s *LocalFileStorageService) PutShard(ctx context.Context, shard *erasure.Shard, expiry uint64) error {
+	log.Trace("das.LocalFileStorageService.PutShard", "root", pretty.PrettyHash(shard.Root), "index", shard.Index, "this", s)
+	return s.put(ctx, erasure.ShardKey(shard.Root), shard.Serialize(), expiry, false)
+}
+
+// put stores the data under the key, or the manifest of its chunks if it is to be chunked.
+func (s *LocalFileStorageService) put(ctx context.Context, key common.Hash, data []byte, expiry uint64, chunk bool) error {
 	expiryTime := time.Unix(int64(expiry), 0)
 	currentTimePlusRetention := time.Now().Add(s.config.MaxRetention)
 	if expiryTime.After(currentTimePlusRetention) {
 		return fmt.Errorf("requested expiry time (%v) exceeds current time plus maximum allowed retention period(%v)", expiryTime, currentTimePlusRetention)
 	}
 
-	key := dastree.Hash(data)
 	var batchPath string
 	if !s.enableLegacyLayout {
 		s.layout.writeMutex.Lock()
 		defer s.layout.writeMutex.Unlock()
 		batchPath = s.layout.batchPath(key)
+		// A batch already stored, whole or chunked, only gets its new expiry index entry.
+		if _, err := os.Stat(batchPath); chunk && os.IsNotExist(err) {
+			data, err = storeChunks(ctx, &s.layout, key, data)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
 		batchPath = s.legacyLayout.batchPath(key)
 	}
@@ -415,6 +463,8 @@ func (tl *trieLayout) prune(pruneTil time.Time) error {
 	}
 	pruned := 0
 	pruningStart := time.Now()
+	// Chunks that couldn't be released are reported once the other batches are pruned.
+	var releaseErr error
 	for pathByTimestamp, err := it.next(); !errors.Is(err, io.EOF); pathByTimestamp, err = it.next() {
 		if err != nil {
 			return err
@@ -444,10 +494,18 @@ func (tl *trieLayout) prune(pruneTil time.Time) error {
 			continue
 		}
 		if stat.Nlink == 1 {
+			stored, err := os.ReadFile(pathByHash)
+			if err != nil {
+				return err
+			}
 			err = recursivelyDeleteUntil(pathByHash, byDataHash)
 			if err != nil {
 				return err
 			}
+			if err := releaseChunks(context.Background(), tl, key, stored); err != nil {
+				log.Error("Couldn't release the chunks of pruned batch, they may be orphaned, continuing trying to prune others", "path", pathByHash, "err", err)
+				releaseErr = errors.Join(releaseErr, fmt.Errorf("couldn't release the chunks of pruned batch %s: %w", pathByHash, err))
+			}
 		}
 
 		pruned++
@@ -455,7 +513,7 @@ func (tl *trieLayout) prune(pruneTil time.Time) error {
 	if pruned > 0 {
 		log.Info("Local file store pruned expired batches", "count", pruned, "pruneTil", pruneTil, "duration", time.Since(pruningStart))
 	}
-	return nil
+	return releaseErr
 }
 
 func recursivelyDeleteUntil(filePath, until string) error {
@@ -541,6 +599,8 @@ func (i *flatLayoutIterator) next() (batchIdentifier, error) {
 const (
 	byDataHash        = "by-data-hash"
 	byExpiryTimestamp = "by-expiry-timestamp"
+	byChunkHash       = "by-chunk-hash"
+	chunkReferences   = "chunk-references"
 	migratingSuffix   = "-migrating"
 	expiryDivisor     = 10_000
 )
@@ -595,6 +655,87 @@ func (l *trieLayout) expiryPath(key common.Hash, expiry uint64) string {
 	return filepath.Join(l.root, topDir, firstDir, secondDir, encodedKey)
 }
 
+func (l *trieLayout) chunkPath(hash common.Hash) string {
+	encodedKey := EncodeStorageServiceKey(hash)
+	return filepath.Join(l.root, byChunkHash, encodedKey[:2], encodedKey[2:4], encodedKey)
+}
+
+// referencePath is where the batch's hard link to the chunk is, so that the chunk's link count is
+// the number of batches referencing it plus one.
+func (l *trieLayout) referencePath(chunk, batch common.Hash) string {
+	encodedBatch := EncodeStorageServiceKey(batch)
+	return filepath.Join(l.root, chunkReferences, encodedBatch[:2], encodedBatch[2:4], encodedBatch, EncodeStorageServiceKey(chunk))
+}
+
+func (l *trieLayout) getChunk(ctx context.Context, hash common.Hash) ([]byte, error) {
+	chunk, err := os.ReadFile(l.chunkPath(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return chunk, err
+}
+
+func (l *trieLayout) hasChunk(ctx context.Context, hash common.Hash) (bool, error) {
+	_, err := os.Stat(l.chunkPath(hash))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *trieLayout) putChunk(ctx context.Context, hash common.Hash, chunk []byte) error {
+	chunkPath := l.chunkPath(hash)
+	if err := os.MkdirAll(path.Dir(chunkPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", path.Dir(chunkPath), err)
+	}
+	// Use a temp file and rename to achieve atomic writes.
+	f, err := os.CreateTemp(path.Dir(chunkPath), path.Base(chunkPath))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		if err := os.Remove(f.Name()); err != nil && !os.IsNotExist(err) {
+			log.Error("Couldn't clean up temporary file", "file", f.Name())
+		}
+	}()
+	if err := f.Chmod(0o600); err != nil {
+		return err
+	}
+	if _, err := f.Write(chunk); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), chunkPath)
+}
+
+func (l *trieLayout) deleteChunk(ctx context.Context, hash common.Hash) error {
+	return recursivelyDeleteUntil(l.chunkPath(hash), byChunkHash)
+}
+
+func (l *trieLayout) addReference(ctx context.Context, chunk, batch common.Hash) error {
+	return createHardLink(l.chunkPath(chunk), l.referencePath(chunk, batch))
+}
+
+func (l *trieLayout) removeReference(ctx context.Context, chunk, batch common.Hash) error {
+	err := recursivelyDeleteUntil(l.referencePath(chunk, batch), chunkReferences)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *trieLayout) referenced(ctx context.Context, chunk common.Hash) (bool, error) {
+	info, err := os.Stat(l.chunkPath(chunk))
+	if err != nil {
+		return false, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, errors.New("couldn't convert file stats to Stat_t struct, possible OS or filesystem incompatibility")
+	}
+	return stat.Nlink > 1, nil
+}
+
 func (l *trieLayout) iterateBatches() (*trieLayoutIterator, error) {
 	var firstLevel, secondLevel, files []string
 	var err error
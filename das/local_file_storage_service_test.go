@@ -8,10 +8,13 @@ import (
 	"context"
 	"errors"
 	"io"
+	"io/fs"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/arbmath"
 )
 
 func getByHashAndCheck(t *testing.T, s *LocalFileStorageService, xs ...string) {
@@ -212,3 +215,87 @@ func TestExpiryDuplicates(t *testing.T) {
 	pruneCountRemaining(t, &s.layout, afterNow.Add(3*time.Second*expiryDivisor), 0)
 	countTimestampEntries(t, &s.layout, afterNow.Add(1000*time.Hour), 0)
 }
+
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+	count := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			count++
+		}
+		return nil
+	})
+	Require(t, err)
+	return count
+}
+
+func TestChunkedStorage(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	config := LocalFileStorageConfig{
+		Enable:       true,
+		DataDir:      dir,
+		EnableExpiry: true,
+		MaxRetention: time.Hour * 10,
+	}
+	s, err := NewLocalFileStorageService(config)
+	Require(t, err)
+
+	now := time.Now()
+	bin := func(b byte) []byte { return bytes.Repeat([]byte{b}, dastree.BinSize) }
+
+	// Stored whole before chunking is enabled.
+	whole := append(bin('w'), 'w')
+	Require(t, s.Put(ctx, whole, uint64(now.Add(time.Second*expiryDivisor).Unix())))
+	s.config.EnableChunking = true
+
+	// "x" repeats within the first batch, and "y" is in both.
+	first := arbmath.ConcatByteSlices(bin('x'), bin('y'), bin('x'), []byte("first"))
+	second := arbmath.ConcatByteSlices(bin('y'), bin('z'))
+	Require(t, s.Put(ctx, first, uint64(now.Add(-time.Second*expiryDivisor).Unix())))
+	Require(t, s.Put(ctx, second, uint64(now.Add(time.Second*expiryDivisor).Unix())))
+	// Storing a batch again only adds an expiry.
+	Require(t, s.Put(ctx, second, uint64(now.Add(2*time.Second*expiryDivisor).Unix())))
+
+	getByHashAndCheck(t, s, string(whole), string(first), string(second))
+	countEntries(t, &s.layout, 3)
+	for _, batch := range [][]byte{whole, first, second} {
+		has, err := s.Has(ctx, dastree.Hash(batch))
+		Require(t, err)
+		if !has {
+			Fail(t, "expected Has to find the stored batch")
+		}
+	}
+	if chunks := countFiles(t, filepath.Join(dir, byChunkHash)); chunks != 4 {
+		Fail(t, "expected the 4 distinct chunks to be stored once, got", chunks)
+	}
+
+	afterNow := now.Add(time.Second)
+	pruneCountRemaining(t, &s.layout, afterNow, 2)
+	getByHashAndCheck(t, s, string(whole), string(second))
+	if has, err := s.Has(ctx, dastree.Hash(first)); err != nil || has {
+		Fail(t, "expected Has not to find the pruned batch", err)
+	}
+	if chunks := countFiles(t, filepath.Join(dir, byChunkHash)); chunks != 2 {
+		Fail(t, "expected only the chunks of the second batch to remain, got", chunks)
+	}
+
+	// The second batch's first expiry passed, but not its second.
+	pruneCountRemaining(t, &s.layout, afterNow.Add(time.Second*expiryDivisor), 1)
+	getByHashAndCheck(t, s, string(second))
+
+	pruneCountRemaining(t, &s.layout, afterNow.Add(2*time.Second*expiryDivisor), 0)
+	if chunks := countFiles(t, filepath.Join(dir, byChunkHash)); chunks != 0 {
+		Fail(t, "expected all chunks to be deleted, got", chunks)
+	}
+	if references := countFiles(t, filepath.Join(dir, chunkReferences)); references != 0 {
+		Fail(t, "expected all chunk references to be deleted, got", references)
+	}
+}
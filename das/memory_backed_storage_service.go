@@ -12,6 +12,7 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/erasure"
 )
 
 type MemoryBackedStorageService struct { // intended for testing and debugging
@@ -42,6 +43,16 @@ func (m *MemoryBackedStorageService) GetByHash(ctx context.Context, key common.H
 	return res, nil
 }
 
+func (m *MemoryBackedStorageService) Has(ctx context.Context, key common.Hash) (bool, error) {
+	m.rwmutex.RLock()
+	defer m.rwmutex.RUnlock()
+	if m.closed {
+		return false, ErrClosed
+	}
+	_, found := m.contents[key]
+	return found, nil
+}
+
 func (m *MemoryBackedStorageService) Put(ctx context.Context, data []byte, expirationTime uint64) error {
 	logPut("das.MemoryBackedStorageService.Store", data, expirationTime, m)
 	m.rwmutex.Lock()
@@ -53,6 +64,17 @@ func (m *MemoryBackedStorageService) Put(ctx context.Context, data []byte, expir
 	return nil
 }
 
+func (m *MemoryBackedStorageService) PutShard(ctx context.Context, shard *erasure.Shard, expirationTime uint64) error {
+	log.Trace("das.MemoryBackedStorageService.PutShard", "root", shard.Root, "index", shard.Index, "this", m)
+	m.rwmutex.Lock()
+	defer m.rwmutex.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.contents[erasure.ShardKey(shard.Root)] = shard.Serialize()
+	return nil
+}
+
 func (m *MemoryBackedStorageService) Sync(ctx context.Context) error {
 	m.rwmutex.RLock()
 	defer m.rwmutex.RUnlock()
@@ -11,6 +11,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/das/erasure"
 	"github.com/offchainlabs/nitro/util/pretty"
 )
 
@@ -36,7 +37,9 @@ func (r *RedundantStorageService) GetByHash(ctx context.Context, key common.Hash
 	log.Trace("das.RedundantStorageService.GetByHash", "key", pretty.PrettyHash(key), "this", r)
 	subCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	var anyError error
+	// Only report not found if every replica reported it, any other error is more
+	// informative as the data may well be there once that replica recovers.
+	var anyError error = ErrNotFound
 	responsesExpected := len(r.innerServices)
 	resultChan := make(chan readResponse, responsesExpected)
 	for _, serv := range r.innerServices {
@@ -51,7 +54,9 @@ func (r *RedundantStorageService) GetByHash(ctx context.Context, key common.Hash
 			if resp.err == nil {
 				return resp.data, nil
 			}
-			anyError = resp.err
+			if !errors.Is(resp.err, ErrNotFound) || errors.Is(anyError, ErrNotFound) {
+				anyError = resp.err
+			}
 			responsesExpected--
 		case <-ctx.Done():
 			return nil, ctx.Err()
@@ -60,6 +65,21 @@ func (r *RedundantStorageService) GetByHash(ctx context.Context, key common.Hash
 	return nil, anyError
 }
 
+// Has reports whether any replica holds the data, or the first error if none is known to.
+func (r *RedundantStorageService) Has(ctx context.Context, key common.Hash) (bool, error) {
+	var anyError error
+	for _, serv := range r.innerServices {
+		has, err := hasByHash(ctx, serv, key)
+		if has {
+			return true, nil
+		}
+		if err != nil && anyError == nil {
+			anyError = err
+		}
+	}
+	return false, anyError
+}
+
 func (r *RedundantStorageService) Put(ctx context.Context, data []byte, expirationTime uint64) error {
 	logPut("das.RedundantStorageService.Store", data, expirationTime, r)
 	var wg sync.WaitGroup
@@ -81,6 +101,37 @@ func (r *RedundantStorageService) Put(ctx context.Context, data []byte, expirati
 	return anyError
 }
 
+// PutShard stores the shard to those of the inner services that can keep shards.
+func (r *RedundantStorageService) PutShard(ctx context.Context, shard *erasure.Shard, expirationTime uint64) error {
+	log.Trace("das.RedundantStorageService.PutShard", "root", pretty.PrettyHash(shard.Root), "index", shard.Index, "this", r)
+	var wg sync.WaitGroup
+	var errorMutex sync.Mutex
+	var anyError error
+	stored := false
+	for _, serv := range r.innerServices {
+		shardStorage, ok := serv.(ShardStorageService)
+		if !ok {
+			continue
+		}
+		stored = true
+		wg.Add(1)
+		go func(s ShardStorageService) {
+			err := s.PutShard(ctx, shard, expirationTime)
+			if err != nil {
+				errorMutex.Lock()
+				anyError = err
+				errorMutex.Unlock()
+			}
+			wg.Done()
+		}(shardStorage)
+	}
+	wg.Wait()
+	if !stored {
+		return errors.New("none of the storage services can keep erasure-coded shards")
+	}
+	return anyError
+}
+
 func (r *RedundantStorageService) Sync(ctx context.Context) error {
 	var wg sync.WaitGroup
 	var errorMutex sync.Mutex
@@ -16,11 +16,15 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/erasure"
 )
 
 // RestfulDasClient implements daprovider.DASReader
 type RestfulDasClient struct {
 	url string
+	// icKeyset is set if batches are fetched with their IC certificates, which are checked
+	// against it.
+	icKeyset *daprovider.ICDAKeyset
 }
 
 func NewRestfulDasClient(protocol string, host string, port int) *RestfulDasClient {
@@ -39,7 +43,26 @@ func NewRestfulDasClientFromURL(url string) (*RestfulDasClient, error) {
 	}, nil
 }
 
+// NewCertifiedRestfulDasClientFromURL creates a client that only accepts batches served with the
+// proof that a canister allowed by the keyset certified them.
+func NewCertifiedRestfulDasClientFromURL(url string, icKeyset *daprovider.ICDAKeyset) (*RestfulDasClient, error) {
+	c, err := NewRestfulDasClientFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+	c.icKeyset = icKeyset
+	return c, nil
+}
+
+func (c *RestfulDasClient) String() string {
+	return c.url
+}
+
 func (c *RestfulDasClient) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+	if c.icKeyset != nil {
+		data, _, err := c.GetCertifiedDataByHash(ctx, hash)
+		return data, err
+	}
 	fmt.Println(c.url + getByHashRequestPath + hash.Hex())
 	prefixHash := hash.Hex()
 	if len(prefixHash) == 64 {
@@ -78,6 +101,181 @@ func (c *RestfulDasClient) GetByHash(ctx context.Context, hash common.Hash) ([]b
 	return decodedBytes, nil
 }
 
+// GetCertifiedDataByHash fetches a batch with the proof that a canister certified it. If the client
+// was created with a keyset the proof is checked against it, otherwise only the data is checked
+// against the hash and the proof is returned as the server sent it.
+func (c *RestfulDasClient) GetCertifiedDataByHash(ctx context.Context, hash common.Hash) ([]byte, *daprovider.ICCertifiedData, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+getByHashCertifiedRequestPath+hash.Hex(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("HTTP error with status %d returned by server: %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+
+	var response RestfulDasServerResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(response.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	proof := &daprovider.ICCertifiedData{
+		Certificate: response.Certificate,
+		Witness:     response.Witness,
+		Canister:    response.Canister,
+	}
+
+	if c.icKeyset == nil {
+		if !dastree.ValidHash(hash, data) {
+			return nil, nil, daprovider.ErrHashMismatch
+		}
+		return data, proof, nil
+	}
+	if err := verifyICCertifiedData(c.icKeyset, hash, data, proof); err != nil {
+		return nil, nil, fmt.Errorf("%s served an uncertified batch: %w", c.url, err)
+	}
+	return data, proof, nil
+}
+
+// GetShard fetches the erasure-coded shard the server keeps of the batch with the given root, and
+// checks its proof.
+func (c *RestfulDasClient) GetShard(ctx context.Context, root common.Hash) (*erasure.Shard, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+getShardRequestPath+root.Hex(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error with status %d returned by server: %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+
+	var response RestfulDasServerResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	record, err := base64.StdEncoding.DecodeString(response.Data)
+	if err != nil {
+		return nil, err
+	}
+	shard, err := erasure.DeserializeShard(record)
+	if err != nil {
+		return nil, err
+	}
+	if shard.Root != root {
+		return nil, fmt.Errorf("%w: %s served a shard of batch %v", erasure.ErrInvalidShard, c.url, shard.Root)
+	}
+	if err := shard.Verify(); err != nil {
+		return nil, fmt.Errorf("%s served an invalid shard: %w", c.url, err)
+	}
+	return shard, nil
+}
+
+// GetLeaf fetches the bin at the given index of the batch with the given root, and checks its
+// proof against the root. The proof also gives the size of the batch.
+func (c *RestfulDasClient) GetLeaf(ctx context.Context, root common.Hash, index uint32) ([]byte, *dastree.Proof, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s%s%s/%d", c.url, proofRequestPath, root.Hex(), index), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("HTTP error with status %d returned by server: %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+
+	var response RestfulDasServerResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, nil, err
+	}
+	bin, err := base64.StdEncoding.DecodeString(response.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.Proof == nil {
+		return nil, nil, fmt.Errorf("%s served leaf %d of batch %v without a proof", c.url, index, root)
+	}
+	if response.Proof.Index != index {
+		return nil, nil, fmt.Errorf("%s served leaf %d of batch %v when asked for leaf %d", c.url, response.Proof.Index, root, index)
+	}
+	if err := dastree.VerifyProof(root, bin, response.Proof); err != nil {
+		return nil, nil, fmt.Errorf("%s served an invalid leaf: %w", c.url, err)
+	}
+	return bin, response.Proof, nil
+}
+
+// GetRange fetches the given bytes of the batch with the given root, and checks their proof
+// against the root.
+func (c *RestfulDasClient) GetRange(ctx context.Context, root common.Hash, offset, length uint32) ([]byte, *dastree.RangeProof, error) {
+	rangeUrl := fmt.Sprintf("%s%s%s%s?offset=%d&len=%d", c.url, getByHashRequestPath, root.Hex(), rangeRequestSuffix, offset, length)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rangeUrl, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("HTTP error with status %d returned by server: %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+
+	var response RestfulDasServerResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(response.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.RangeProof == nil {
+		return nil, nil, fmt.Errorf("%s served a range of batch %v without a proof", c.url, root)
+	}
+	if response.RangeProof.Offset != offset || uint64(len(data)) != uint64(length) {
+		return nil, nil, fmt.Errorf("%s served %d bytes at %d of batch %v when asked for %d at %d", c.url, len(data), response.RangeProof.Offset, root, length, offset)
+	}
+	if err := dastree.VerifyRangeProof(root, data, response.RangeProof); err != nil {
+		return nil, nil, fmt.Errorf("%s served an invalid range: %w", c.url, err)
+	}
+	return data, response.RangeProof, nil
+}
+
+// Has asks the server whether it can serve the data with the given hash, without fetching it.
+// The answer can't be checked, so it is only a hint.
+func (c *RestfulDasClient) Has(ctx context.Context, hash common.Hash) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+hasRequestPath+hash.Hex(), nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("HTTP error with status %d returned by server: %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+}
+
 func (c *RestfulDasClient) HealthCheck(ctx context.Context) error {
 	res, err := http.Get(c.url + healthRequestPath)
 	if err != nil {
@@ -11,7 +11,9 @@ import (
 	"net"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -19,6 +21,8 @@ import (
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/cmd/genericconf"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/erasure"
 	"github.com/offchainlabs/nitro/util/pretty"
 )
 
@@ -28,14 +32,38 @@ var (
 	restGetByHashFailureGauge       = metrics.NewRegisteredGauge("arb/das/rest/getbyhash/failure", nil)
 	restGetByHashReturnedBytesGauge = metrics.NewRegisteredGauge("arb/das/rest/getbyhash/bytes", nil)
 	restGetByHashDurationHistogram  = metrics.NewRegisteredHistogram("arb/das/rest/getbyhash/duration", nil, metrics.NewBoundedHistogramSample())
+
+	restGetByHashCertifiedRequestGauge = metrics.NewRegisteredGauge("arb/das/rest/getbyhashcertified/requests", nil)
+	restGetByHashCertifiedSuccessGauge = metrics.NewRegisteredGauge("arb/das/rest/getbyhashcertified/success", nil)
+	restGetByHashCertifiedFailureGauge = metrics.NewRegisteredGauge("arb/das/rest/getbyhashcertified/failure", nil)
+
+	restGetShardRequestGauge = metrics.NewRegisteredGauge("arb/das/rest/getshard/requests", nil)
+	restGetShardSuccessGauge = metrics.NewRegisteredGauge("arb/das/rest/getshard/success", nil)
+	restGetShardFailureGauge = metrics.NewRegisteredGauge("arb/das/rest/getshard/failure", nil)
+
+	restProofRequestGauge = metrics.NewRegisteredGauge("arb/das/rest/proof/requests", nil)
+	restProofSuccessGauge = metrics.NewRegisteredGauge("arb/das/rest/proof/success", nil)
+	restProofFailureGauge = metrics.NewRegisteredGauge("arb/das/rest/proof/failure", nil)
+
+	restGetRangeRequestGauge       = metrics.NewRegisteredGauge("arb/das/rest/getrange/requests", nil)
+	restGetRangeSuccessGauge       = metrics.NewRegisteredGauge("arb/das/rest/getrange/success", nil)
+	restGetRangeFailureGauge       = metrics.NewRegisteredGauge("arb/das/rest/getrange/failure", nil)
+	restGetRangeReturnedBytesGauge = metrics.NewRegisteredGauge("arb/das/rest/getrange/bytes", nil)
+
+	restHasRequestGauge = metrics.NewRegisteredGauge("arb/das/rest/has/requests", nil)
+	restHasFoundGauge   = metrics.NewRegisteredGauge("arb/das/rest/has/found", nil)
 )
 
 type RestfulDasServer struct {
-	server               *http.Server
-	daReader             daprovider.DASReader
+	server   *http.Server
+	daReader daprovider.DASReader
+	// certifiedReader is set if daReader can also serve the IC certificates of batches.
+	certifiedReader      CertifiedDASReader
 	daHealthChecker      DataAvailabilityServiceHealthChecker
 	httpServerExitedChan chan interface{}
 	httpServerError      error
+	// announcer is set if the server serves the feed of the batches its committee member stores.
+	announcer atomic.Pointer[BatchAnnouncer]
 }
 
 func NewRestfulDasServer(address string, port uint64, restServerTimeouts genericconf.HTTPServerTimeoutConfig, daReader daprovider.DASReader, daHealthChecker DataAvailabilityServiceHealthChecker) (*RestfulDasServer, error) {
@@ -53,6 +81,7 @@ func NewRestfulDasServerOnListener(listener net.Listener, restServerTimeouts gen
 		daHealthChecker:      daHealthChecker,
 		httpServerExitedChan: make(chan interface{}),
 	}
+	ret.certifiedReader, _ = daReader.(CertifiedDASReader)
 
 	ret.server = &http.Server{
 		Handler:           ret,
@@ -74,10 +103,14 @@ func NewRestfulDasServerOnListener(listener net.Listener, restServerTimeouts gen
 }
 
 type RestfulDasServerResponse struct {
-	Certificate      []byte `json:"certificate,omitempty"`
-	Witness          []byte `json:"witness,omitempty"`
-	Data             string `json:"data,omitempty"`
-	ExpirationPolicy string `json:"expirationPolicy,omitempty"`
+	Certificate      []byte         `json:"certificate,omitempty"`
+	Witness          []byte         `json:"witness,omitempty"`
+	Canister         []byte         `json:"canister,omitempty"`
+	Data             string         `json:"data,omitempty"`
+	Proof            *dastree.Proof `json:"proof,omitempty"`
+	ExpirationPolicy string         `json:"expirationPolicy,omitempty"`
+
+	RangeProof *dastree.RangeProof `json:"rangeProof,omitempty"`
 }
 
 var cacheControlKey = http.CanonicalHeaderKey("cache-control")
@@ -87,6 +120,12 @@ const cacheControlValueForSuccessfulGetByHash = "public, max-age=2419200, immuta
 const healthRequestPath = "/health"
 const expirationPolicyRequestPath = "/expiration-policy/"
 const getByHashRequestPath = "/get-by-hash/"
+const getByHashCertifiedRequestPath = "/get-by-hash-certified/"
+const getShardRequestPath = "/get-shard/"
+const proofRequestPath = "/proof/"
+const hasRequestPath = "/has/"
+const rangeRequestSuffix = "/range"
+const announcementsRequestPath = "/announcements"
 
 func (rds *RestfulDasServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header()[cacheControlKey] = []string{cacheControlValueDefault}
@@ -97,8 +136,20 @@ func (rds *RestfulDasServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		rds.HealthHandler(w, r, requestPath)
 	case strings.HasPrefix(requestPath, expirationPolicyRequestPath):
 		rds.ExpirationPolicyHandler(w, r, requestPath)
+	case strings.HasPrefix(requestPath, getByHashRequestPath) && strings.HasSuffix(requestPath, rangeRequestSuffix):
+		rds.GetByHashRangeHandler(w, r, requestPath)
 	case strings.HasPrefix(requestPath, getByHashRequestPath):
 		rds.GetByHashHandler(w, r, requestPath)
+	case strings.HasPrefix(requestPath, getByHashCertifiedRequestPath):
+		rds.GetByHashCertifiedHandler(w, r, requestPath)
+	case strings.HasPrefix(requestPath, getShardRequestPath):
+		rds.GetShardHandler(w, r, requestPath)
+	case strings.HasPrefix(requestPath, proofRequestPath):
+		rds.ProofHandler(w, r, requestPath)
+	case strings.HasPrefix(requestPath, hasRequestPath):
+		rds.HasHandler(w, r, requestPath)
+	case requestPath == announcementsRequestPath:
+		rds.AnnouncementsHandler(w, r, requestPath)
 	default:
 		log.Warn("Unknown requestPath", "requestPath", requestPath)
 		w.WriteHeader(http.StatusBadRequest)
@@ -189,6 +240,282 @@ func (rds *RestfulDasServer) GetByHashHandler(w http.ResponseWriter, r *http.Req
 	success = true
 }
 
+// GetByHashCertifiedHandler serves a batch together with the certificate, witness and canister
+// of an IC storage canister that certified it, which clients check against the IC root key.
+func (rds *RestfulDasServer) GetByHashCertifiedHandler(w http.ResponseWriter, r *http.Request, requestPath string) {
+	log.Debug("Got request", "requestPath", requestPath)
+	restGetByHashCertifiedRequestGauge.Inc(1)
+	success := false
+	defer func() {
+		if success {
+			restGetByHashCertifiedSuccessGauge.Inc(1)
+		} else {
+			restGetByHashCertifiedFailureGauge.Inc(1)
+		}
+	}()
+
+	if rds.certifiedReader == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	hashBytes, err := DecodeStorageServiceKey(strings.TrimPrefix(requestPath, getByHashCertifiedRequestPath))
+	if err != nil {
+		log.Warn("Failed to decode hex-encoded hash", "path", requestPath, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(hashBytes) < 32 {
+		log.Warn("Decoded hash was too short", "path", requestPath, "len(hashBytes)", len(hashBytes))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	responseData, proof, err := rds.certifiedReader.GetCertifiedDataByHash(r.Context(), common.BytesToHash(hashBytes[:32]))
+	if err != nil {
+		log.Warn("Unable to find certified data", "path", requestPath, "err", err, "remoteAddr", r.RemoteAddr)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	response := RestfulDasServerResponse{
+		Data:        base64.StdEncoding.EncodeToString(responseData),
+		Certificate: proof.Certificate,
+		Witness:     proof.Witness,
+		Canister:    proof.Canister,
+	}
+	// Proofs are checked without regard to the time of the certificate, so the response can be
+	// cached like an uncertified one.
+	w.Header()[cacheControlKey] = []string{cacheControlValueForSuccessfulGetByHash}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Warn("Failed encoding and writing response", "path", requestPath, "err", err)
+		return
+	}
+	success = true
+}
+
+// GetShardHandler serves the erasure-coded shard a committee member keeps of the batch with the
+// given root, which clients rebuild the batch from.
+func (rds *RestfulDasServer) GetShardHandler(w http.ResponseWriter, r *http.Request, requestPath string) {
+	log.Debug("Got request", "requestPath", requestPath)
+	restGetShardRequestGauge.Inc(1)
+	success := false
+	defer func() {
+		if success {
+			restGetShardSuccessGauge.Inc(1)
+		} else {
+			restGetShardFailureGauge.Inc(1)
+		}
+	}()
+
+	hashBytes, err := DecodeStorageServiceKey(strings.TrimPrefix(requestPath, getShardRequestPath))
+	if err != nil {
+		log.Warn("Failed to decode hex-encoded hash", "path", requestPath, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(hashBytes) < 32 {
+		log.Warn("Decoded hash was too short", "path", requestPath, "len(hashBytes)", len(hashBytes))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	shard, err := rds.daReader.GetByHash(r.Context(), erasure.ShardKey(common.BytesToHash(hashBytes[:32])))
+	if err != nil {
+		log.Debug("Unable to find shard", "path", requestPath, "err", err, "remoteAddr", r.RemoteAddr)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header()[cacheControlKey] = []string{cacheControlValueForSuccessfulGetByHash}
+	err = json.NewEncoder(w).Encode(RestfulDasServerResponse{Data: base64.StdEncoding.EncodeToString(shard)})
+	if err != nil {
+		log.Warn("Failed encoding and writing response", "path", requestPath, "err", err)
+		return
+	}
+	success = true
+}
+
+// ProofHandler serves a single bin of a batch along with the proof that it is the leaf at the
+// requested index of the batch's dastree, which lets clients sample batches without fetching them.
+func (rds *RestfulDasServer) ProofHandler(w http.ResponseWriter, r *http.Request, requestPath string) {
+	log.Debug("Got request", "requestPath", requestPath)
+	restProofRequestGauge.Inc(1)
+	success := false
+	defer func() {
+		if success {
+			restProofSuccessGauge.Inc(1)
+		} else {
+			restProofFailureGauge.Inc(1)
+		}
+	}()
+
+	rootAndIndex := strings.Split(strings.TrimPrefix(requestPath, proofRequestPath), "/")
+	if len(rootAndIndex) != 2 {
+		log.Warn("Expected a root and a leaf index", "path", requestPath)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	hashBytes, err := DecodeStorageServiceKey(rootAndIndex[0])
+	if err != nil {
+		log.Warn("Failed to decode hex-encoded hash", "path", requestPath, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(hashBytes) < 32 {
+		log.Warn("Decoded hash was too short", "path", requestPath, "len(hashBytes)", len(hashBytes))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.ParseUint(rootAndIndex[1], 10, 32)
+	if err != nil {
+		log.Warn("Failed to parse leaf index", "path", requestPath, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	root := common.BytesToHash(hashBytes[:32])
+	data, err := rds.daReader.GetByHash(r.Context(), root)
+	if err != nil {
+		log.Warn("Unable to find data", "path", requestPath, "err", err, "remoteAddr", r.RemoteAddr)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if dastree.Hash(data) != root {
+		// Batches stored under a flat hash or a degenerate dastree have no bins to prove.
+		log.Warn("Data isn't stored under its dastree hash", "path", requestPath)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	bin, proof, err := dastree.Prove(data, uint32(index))
+	if err != nil {
+		log.Warn("Unable to prove leaf", "path", requestPath, "err", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header()[cacheControlKey] = []string{cacheControlValueForSuccessfulGetByHash}
+	err = json.NewEncoder(w).Encode(RestfulDasServerResponse{Data: base64.StdEncoding.EncodeToString(bin), Proof: proof})
+	if err != nil {
+		log.Warn("Failed encoding and writing response", "path", requestPath, "err", err)
+		return
+	}
+	success = true
+}
+
+// GetByHashRangeHandler serves the bytes of a batch at the offset and of the length given by the
+// query, along with the proof that they are those bytes of the batch with the requested root.
+func (rds *RestfulDasServer) GetByHashRangeHandler(w http.ResponseWriter, r *http.Request, requestPath string) {
+	log.Debug("Got request", "requestPath", requestPath)
+	restGetRangeRequestGauge.Inc(1)
+	success := false
+	defer func() {
+		if success {
+			restGetRangeSuccessGauge.Inc(1)
+		} else {
+			restGetRangeFailureGauge.Inc(1)
+		}
+	}()
+
+	hashBytes, err := DecodeStorageServiceKey(strings.TrimSuffix(strings.TrimPrefix(requestPath, getByHashRequestPath), rangeRequestSuffix))
+	if err != nil {
+		log.Warn("Failed to decode hex-encoded hash", "path", requestPath, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(hashBytes) < 32 {
+		log.Warn("Decoded hash was too short", "path", requestPath, "len(hashBytes)", len(hashBytes))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	offset, err := strconv.ParseUint(query.Get("offset"), 10, 32)
+	if err != nil {
+		log.Warn("Failed to parse range offset", "path", requestPath, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseUint(query.Get("len"), 10, 32)
+	if err != nil {
+		log.Warn("Failed to parse range length", "path", requestPath, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	root := common.BytesToHash(hashBytes[:32])
+	data, err := rds.daReader.GetByHash(r.Context(), root)
+	if err != nil {
+		log.Warn("Unable to find data", "path", requestPath, "err", err, "remoteAddr", r.RemoteAddr)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if dastree.Hash(data) != root {
+		log.Warn("Data isn't stored under its dastree hash", "path", requestPath)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rangeData, proof, err := dastree.ProveRange(data, uint32(offset), uint32(length))
+	if err != nil {
+		log.Warn("Unable to prove range", "path", requestPath, "err", err)
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	response := RestfulDasServerResponse{Data: base64.StdEncoding.EncodeToString(rangeData), RangeProof: proof}
+	restGetRangeReturnedBytesGauge.Inc(int64(len(response.Data)))
+	w.Header()[cacheControlKey] = []string{cacheControlValueForSuccessfulGetByHash}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Warn("Failed encoding and writing response", "path", requestPath, "err", err)
+		return
+	}
+	success = true
+}
+
+// HasHandler replies whether the data with the given hash can be served, without sending it.
+func (rds *RestfulDasServer) HasHandler(w http.ResponseWriter, r *http.Request, requestPath string) {
+	log.Debug("Got request", "requestPath", requestPath)
+	restHasRequestGauge.Inc(1)
+
+	hashBytes, err := DecodeStorageServiceKey(strings.TrimPrefix(requestPath, hasRequestPath))
+	if err != nil {
+		log.Warn("Failed to decode hex-encoded hash", "path", requestPath, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(hashBytes) < 32 {
+		log.Warn("Decoded hash was too short", "path", requestPath, "len(hashBytes)", len(hashBytes))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Data that isn't there yet may be soon, so only its presence is cached for long.
+	if has, err := hasByHash(r.Context(), rds.daReader, common.BytesToHash(hashBytes[:32])); !has {
+		log.Debug("Unable to find data", "path", requestPath, "err", err, "remoteAddr", r.RemoteAddr)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	restHasFoundGauge.Inc(1)
+	w.Header()[cacheControlKey] = []string{cacheControlValueForSuccessfulGetByHash}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ServeAnnouncements makes the server serve the announcer's feed.
+func (rds *RestfulDasServer) ServeAnnouncements(announcer *BatchAnnouncer) {
+	rds.announcer.Store(announcer)
+}
+
+// AnnouncementsHandler upgrades the request to a websocket over which the batches the server's
+// committee member stores are announced.
+func (rds *RestfulDasServer) AnnouncementsHandler(w http.ResponseWriter, r *http.Request, requestPath string) {
+	announcer := rds.announcer.Load()
+	if announcer == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	announcer.ServeHTTP(w, r)
+}
+
 func (rds *RestfulDasServer) GetServerExitedChan() <-chan interface{} { // channel will close when server terminates
 	return rds.httpServerExitedChan
 }
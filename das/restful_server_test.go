@@ -6,20 +6,29 @@ package das
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/cmd/genericconf"
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/ictest"
+	"github.com/offchainlabs/nitro/util/testhelpers"
 )
 
 const LocalServerAddressForTest = "localhost"
 
 func NewRestfulDasServerOnRandomPort(address string, storageService StorageService) (*RestfulDasServer, int, error) {
+	return newRestfulDasServerOnRandomPort(address, storageService, storageService)
+}
+
+func newRestfulDasServerOnRandomPort(address string, daReader DataAvailabilityServiceReader, daHealthChecker DataAvailabilityServiceHealthChecker) (*RestfulDasServer, int, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf("%s:0", address))
 	if err != nil {
 		return nil, 0, err
@@ -28,7 +37,7 @@ func NewRestfulDasServerOnRandomPort(address string, storageService StorageServi
 	if !ok {
 		return nil, 0, errors.New("attempt to listen on TCP returned non-TCP address")
 	}
-	rds, err := NewRestfulDasServerOnListener(listener, genericconf.HTTPServerTimeoutConfigDefault, storageService, storageService)
+	rds, err := NewRestfulDasServerOnListener(listener, genericconf.HTTPServerTimeoutConfigDefault, daReader, daHealthChecker)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -68,3 +77,122 @@ func TestRestfulClientServer(t *testing.T) {
 	err = server.Shutdown()
 	Require(t, err)
 }
+
+func TestRestfulClientServerRanges(t *testing.T) {
+	initTest(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := NewMemoryBackedStorageService(ctx)
+	data := testhelpers.RandomizeSlice(make([]byte, 3*dastree.BinSize+100))
+	dataHash := dastree.Hash(data)
+	Require(t, storage.Put(ctx, data, uint64(time.Now().Add(time.Hour).Unix())))
+
+	server, port, err := NewRestfulDasServerOnRandomPort(LocalServerAddressForTest, storage)
+	Require(t, err)
+	defer func() { Require(t, server.Shutdown()) }()
+
+	client := NewRestfulDasClient("http", LocalServerAddressForTest, port)
+	for _, r := range [][2]uint32{{0, 1}, {10, dastree.BinSize}, {dastree.BinSize - 3, dastree.BinSize + 6}, {0, uint32(len(data))}} {
+		offset, length := r[0], r[1]
+		returnedData, proof, err := client.GetRange(ctx, dataHash, offset, length)
+		Require(t, err, offset, length)
+		if !bytes.Equal(returnedData, data[offset:offset+length]) || proof.Size != uint32(len(data)) {
+			Fail(t, "returned the wrong range", offset, length)
+		}
+	}
+	if _, _, err := client.GetRange(ctx, dataHash, uint32(len(data))-1, 2); err == nil || !strings.Contains(err.Error(), "416") {
+		Fail(t, "expected a range past the end to fail with 416, got", err)
+	}
+	if _, _, err := client.GetRange(ctx, dastree.Hash([]byte("absent data")), 0, 1); err == nil || !strings.Contains(err.Error(), "404") {
+		Fail(t, "expected a range of absent data to fail with 404, got", err)
+	}
+
+	has, err := client.Has(ctx, dataHash)
+	Require(t, err)
+	if !has {
+		Fail(t, "expected the server to have the batch")
+	}
+	has, err = client.Has(ctx, dastree.Hash([]byte("absent data")))
+	Require(t, err)
+	if has {
+		Fail(t, "expected the server not to have absent data")
+	}
+}
+
+func TestRestfulClientServerICCertified(t *testing.T) {
+	initTest(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	replica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, replica.Shutdown()) }()
+	// A mirror whose proofs are certified, but under a root key the clients don't trust.
+	otherReplica, err := ictest.NewReplicaOnRandomPort()
+	Require(t, err)
+	defer func() { Require(t, otherReplica.Shutdown()) }()
+
+	data := []byte("Testing a certified restful server now.")
+	dataHash := dastree.Hash(data)
+	timeout := uint64(time.Now().Add(time.Hour).Unix())
+	var ports []int
+	for _, r := range []*ictest.Replica{otherReplica, replica} {
+		storage, err := NewICStorageService(testICStorageConfig(r, 1000), nil)
+		Require(t, err)
+		Require(t, storage.Put(ctx, data, timeout))
+		server, port, err := newRestfulDasServerOnRandomPort(LocalServerAddressForTest, &certifiedStorageReader{DataAvailabilityServiceReader: storage, ic: storage}, storage)
+		Require(t, err)
+		defer func() { Require(t, server.Shutdown()) }()
+		ports = append(ports, port)
+	}
+	uncertifiedServer, uncertifiedPort, err := NewRestfulDasServerOnRandomPort(LocalServerAddressForTest, NewMemoryBackedStorageService(ctx))
+	Require(t, err)
+	defer func() { Require(t, uncertifiedServer.Shutdown()) }()
+
+	readConfig := ICCertifiedReadConfig{
+		Enable:    true,
+		RootKey:   "0x" + hex.EncodeToString(replica.RootKey()),
+		Canisters: []string{replica.CanisterID().Encode()},
+	}
+	keyset, err := readConfig.Keyset()
+	Require(t, err)
+
+	url := func(port int) string { return "http://" + LocalServerAddressForTest + ":" + strconv.Itoa(port) }
+	client, err := NewCertifiedRestfulDasClientFromURL(url(ports[1]), keyset)
+	Require(t, err)
+	returnedData, err := client.GetByHash(ctx, dataHash)
+	Require(t, err)
+	if !bytes.Equal(data, returnedData) {
+		Fail(t, fmt.Sprintf("Returned data '%s' does not match expected '%s'", returnedData, data))
+	}
+
+	untrustedClient, err := NewCertifiedRestfulDasClientFromURL(url(ports[0]), keyset)
+	Require(t, err)
+	if _, err := untrustedClient.GetByHash(ctx, dataHash); !errors.Is(err, daprovider.ErrInvalidICDAProof) {
+		Fail(t, "expected a batch certified under another root key to be rejected, got", err)
+	}
+	uncertifiedClient, err := NewCertifiedRestfulDasClientFromURL(url(uncertifiedPort), keyset)
+	Require(t, err)
+	if _, err := uncertifiedClient.GetByHash(ctx, dataHash); err == nil || !strings.Contains(err.Error(), "501") {
+		Fail(t, "expected a server without certified data to reply 501, got", err)
+	}
+
+	config := RestfulClientAggregatorConfig{
+		Urls:                   []string{url(ports[0]), url(uncertifiedPort), url(ports[1])},
+		Strategy:               "testing-sequential",
+		StrategyUpdateInterval: time.Second,
+		WaitBeforeTryNext:      500 * time.Millisecond,
+		MaxPerEndpointStats:    10,
+		ICCertified:            readConfig,
+	}
+	agg, err := NewRestfulClientAggregator(ctx, &config)
+	Require(t, err)
+	returnedData, proof, err := agg.GetCertifiedDataByHash(ctx, dataHash)
+	Require(t, err)
+	if !bytes.Equal(data, returnedData) || !bytes.Equal(proof.Canister, replica.CanisterID().Raw) {
+		Fail(t, "aggregator returned a batch that isn't certified by the trusted replica")
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/providers/confmap"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/blsSignatures"
 
 	"github.com/offchainlabs/nitro/solgen/go/bridgegen"
 	"github.com/offchainlabs/nitro/util/metricsutil"
@@ -114,7 +115,12 @@ func ParseServices(config AggregatorConfig, signer signature.DataSignerFunc) ([]
 			return nil, err
 		}
 
-		d, err := NewServiceDetails(service, []byte(b.Pubkey), 1<<uint64(i), metricName)
+		pubKey, err := DecodeBase64BLSPublicKey([]byte(b.Pubkey))
+		if err != nil {
+			return nil, err
+		}
+
+		d, err := NewServiceDetails(service, *pubKey, 1<<uint64(i), metricName)
 		if err != nil {
 			return nil, err
 		}
@@ -125,9 +131,12 @@ func ParseServices(config AggregatorConfig, signer signature.DataSignerFunc) ([]
 	return services, nil
 }
 
-func KeysetHashFromServices(services []ServiceDetails, assumedHonest uint64) ([32]byte, []byte, error) {
+func KeysetHashFromServices(services []ServiceDetails, assumedHonest uint64, keysetVersion int) ([32]byte, []byte, error) {
+	if keysetVersion != 0 && keysetVersion != int(daprovider.KeysetVersion1) {
+		return [32]byte{}, nil, fmt.Errorf("unsupported keyset version %d", keysetVersion)
+	}
 	var aggSignersMask uint64
-	pubKeys := [][]byte{}
+	var pubKeys []blsSignatures.PublicKey
 	for _, d := range services {
 		if bits.OnesCount64(d.signersMask) != 1 {
 			return [32]byte{}, nil, fmt.Errorf("tried to configure backend DAS %v with invalid signersMask %X", d.service, d.signersMask)
@@ -143,6 +152,18 @@ func KeysetHashFromServices(services []ServiceDetails, assumedHonest uint64) ([3
 		AssumedHonest: uint64(assumedHonest),
 		PubKeys:       pubKeys,
 	}
+	if keysetVersion == int(daprovider.KeysetVersion1) {
+		versioned := daprovider.VersionedCommitteeKeyset(keyset)
+		ksBuf := bytes.NewBuffer([]byte{})
+		if err := versioned.Serialize(ksBuf); err != nil {
+			return [32]byte{}, nil, err
+		}
+		keysetHash, err := versioned.Hash()
+		if err != nil {
+			return [32]byte{}, nil, err
+		}
+		return keysetHash, ksBuf.Bytes(), nil
+	}
 
 	ksBuf := bytes.NewBuffer([]byte{})
 	if err := keyset.Serialize(ksBuf); err != nil {
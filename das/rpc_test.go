@@ -8,14 +8,19 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/blsSignatures"
 	"github.com/offchainlabs/nitro/cmd/genericconf"
+	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/erasure"
 	"github.com/offchainlabs/nitro/util/signature"
 	"github.com/offchainlabs/nitro/util/testhelpers"
 )
@@ -144,3 +149,131 @@ func TestRPCStore(t *testing.T) {
 		})
 	}
 }
+
+func TestRPCStoreErasureCoded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPrivateKey, err := crypto.GenerateKey()
+	testhelpers.RequireImpl(t, err)
+	signatureVerifier, err := NewSignatureVerifierWithSeqInboxCaller(nil, "0x"+hex.EncodeToString(crypto.FromECDSAPub(&testPrivateKey.PublicKey)))
+	testhelpers.RequireImpl(t, err)
+	signer := signature.DataSignerFromPrivateKey(testPrivateKey)
+
+	// Four members, three of them assumed honest, each keeping one of the shards any two of which
+	// rebuild a batch.
+	numBackends := 4
+	var beConfigs BackendConfigList
+	var storageServices []StorageService
+	for i := 0; i < numBackends; i++ {
+		lis, err := net.Listen("tcp", "localhost:0")
+		testhelpers.RequireImpl(t, err)
+		keyDir := t.TempDir()
+		pubkey, _, err := GenerateAndStoreKeys(keyDir)
+		testhelpers.RequireImpl(t, err)
+		config := DataAvailabilityConfig{
+			Enable: true,
+			Key: KeyConfig{
+				KeyDir: keyDir,
+			},
+			LocalFileStorage: LocalFileStorageConfig{
+				Enable:       true,
+				DataDir:      t.TempDir(),
+				MaxRetention: defaultStorageRetention,
+			},
+			ParentChainNodeURL: "none",
+		}
+		storageService, lifecycleManager, err := CreatePersistentStorageService(ctx, &config)
+		testhelpers.RequireImpl(t, err)
+		defer lifecycleManager.StopAndWaitUntil(time.Second)
+		localDas, err := NewSignAfterStoreDASWriter(ctx, config, storageService)
+		testhelpers.RequireImpl(t, err)
+		_, err = StartDASRPCServerOnListener(ctx, lis, genericconf.HTTPServerTimeoutConfigDefault, genericconf.HTTPServerBodyLimitDefault, storageService, localDas, storageService, signatureVerifier)
+		testhelpers.RequireImpl(t, err)
+
+		storageServices = append(storageServices, storageService)
+		beConfigs = append(beConfigs, BackendConfig{
+			URL:    "http://" + lis.Addr().String(),
+			Pubkey: blsPubToBase64(pubkey),
+		})
+	}
+
+	aggConf := DataAvailabilityConfig{
+		RPCAggregator: AggregatorConfig{
+			AssumedHonest:         3,
+			Backends:              beConfigs,
+			MaxStoreChunkBodySize: (chunkSize * 2) + len(sendChunkJSONBoilerplate),
+			DataShards:            4,
+		},
+		RequestTimeout: time.Minute,
+	}
+	if _, err := NewRPCAggregatorWithSeqInboxCaller(aggConf, nil, signer); err == nil {
+		testhelpers.FailImpl(t, "expected more data shards than assumed honest members to be rejected")
+	}
+	aggConf.RPCAggregator.DataShards = 2
+	rpcAgg, err := NewRPCAggregatorWithSeqInboxCaller(aggConf, nil, signer)
+	testhelpers.RequireImpl(t, err)
+	if rpcAgg.requiredServicesForStore != 3 {
+		testhelpers.FailImpl(t, "expected N+k-H=3 signatures to be required, got", rpcAgg.requiredServicesForStore)
+	}
+
+	msg := testhelpers.RandomizeSlice(make([]byte, 3*chunkSize+17))
+	cert, err := rpcAgg.Store(ctx, msg, uint64(time.Now().Add(time.Hour).Unix()))
+	testhelpers.RequireImpl(t, err)
+	if cert.DataHash != dastree.Hash(msg) {
+		testhelpers.FailImpl(t, "certificate isn't of the whole batch")
+	}
+	if cert.Version != daprovider.ErasureCodedDASCertificateVersion || cert.DataShards != 2 || cert.TotalShards != 4 {
+		testhelpers.FailImpl(t, "certificate doesn't name the code of the batch")
+	}
+	// The certificate round trips, including the commitment the members signed.
+	decoded, err := daprovider.DeserializeDASCertFrom(bytes.NewReader(daprovider.Serialize(cert)))
+	testhelpers.RequireImpl(t, err)
+	if decoded.Commitment != cert.Commitment || decoded.DataShards != 2 || decoded.TotalShards != 4 ||
+		!bytes.Equal(decoded.SerializeSignableFields(), cert.SerializeSignableFields()) {
+		testhelpers.FailImpl(t, "erasure-coded certificate didn't round trip")
+	}
+	for i, storageService := range storageServices {
+		if _, err := storageService.GetByHash(ctx, cert.DataHash); !errors.Is(err, ErrNotFound) {
+			testhelpers.FailImpl(t, "member", i, "kept the whole batch")
+		}
+		record, err := storageService.GetByHash(ctx, erasure.ShardKey(cert.DataHash))
+		testhelpers.RequireImpl(t, err)
+		shard, err := erasure.DeserializeShard(record)
+		testhelpers.RequireImpl(t, err)
+		if int(shard.Index) != i || len(shard.Data) != (len(msg)+1)/2 || shard.Commitment != cert.Commitment {
+			testhelpers.FailImpl(t, "member", i, "kept the wrong shard")
+		}
+	}
+
+	// Only two members serve their shards, the others are down or lost theirs.
+	var urls []string
+	for i, storageService := range storageServices {
+		if i == 0 || i == 2 {
+			storageService = NewMemoryBackedStorageService(ctx)
+		}
+		server, port, err := NewRestfulDasServerOnRandomPort(LocalServerAddressForTest, storageService)
+		testhelpers.RequireImpl(t, err)
+		defer func() {
+			_ = server.Shutdown()
+		}()
+		urls = append(urls, fmt.Sprintf("http://%s:%d", LocalServerAddressForTest, port))
+	}
+	readerConfig := DefaultRestfulClientAggregatorConfig
+	readerConfig.Urls = urls
+	readerConfig.Strategy = "testing-sequential"
+	readerConfig.WaitBeforeTryNext = 100 * time.Millisecond
+	reader, err := NewRestfulClientAggregator(ctx, &readerConfig)
+	testhelpers.RequireImpl(t, err)
+	if _, err := reader.GetByHash(ctx, cert.DataHash); err == nil {
+		testhelpers.FailImpl(t, "expected batch not to be served whole")
+	}
+	readerConfig.ErasureCoded = true
+	reader, err = NewRestfulClientAggregator(ctx, &readerConfig)
+	testhelpers.RequireImpl(t, err)
+	rebuilt, err := reader.GetByHash(ctx, cert.DataHash)
+	testhelpers.RequireImpl(t, err)
+	if !bytes.Equal(rebuilt, msg) {
+		testhelpers.FailImpl(t, "rebuilt the wrong batch")
+	}
+}
@@ -6,8 +6,15 @@ package das
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -15,9 +22,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/erasure"
 	"github.com/offchainlabs/nitro/util/pretty"
+	"github.com/offchainlabs/nitro/util/stopwaiter"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
@@ -33,6 +43,14 @@ type S3Downloader interface {
 	Download(ctx context.Context, w io.WriterAt, input *s3.GetObjectInput, options ...func(*manager.Downloader)) (n int64, err error)
 }
 
+// S3Client is the part of the S3 client that isn't uploading or downloading objects.
+type S3Client interface {
+	HeadBucket(ctx context.Context, input *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	HeadObject(ctx context.Context, input *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
 type S3StorageServiceConfig struct {
 	Enable              bool   `koanf:"enable"`
 	AccessKey           string `koanf:"access-key"`
@@ -41,6 +59,7 @@ type S3StorageServiceConfig struct {
 	Region              string `koanf:"region"`
 	SecretKey           string `koanf:"secret-key"`
 	DiscardAfterTimeout bool   `koanf:"discard-after-timeout"`
+	EnableChunking      bool   `koanf:"enable-chunking"`
 }
 
 var DefaultS3StorageServiceConfig = S3StorageServiceConfig{}
@@ -53,18 +72,47 @@ func S3ConfigAddOptions(prefix string, f *flag.FlagSet) {
 	f.String(prefix+".region", DefaultS3StorageServiceConfig.Region, "S3 region")
 	f.String(prefix+".secret-key", DefaultS3StorageServiceConfig.SecretKey, "S3 secret key")
 	f.Bool(prefix+".discard-after-timeout", DefaultS3StorageServiceConfig.DiscardAfterTimeout, "discard data after its expiry timeout")
+	f.Bool(prefix+".enable-chunking", DefaultS3StorageServiceConfig.EnableChunking, "store new batches as the 64 KiB leaves of their dastree, each leaf once however many batches contain it; with discard-after-timeout, expired batches are pruned by this server rather than by the bucket's lifecycle rules, deleting leaves once no batch references them. Only one server may write to the object prefix, which holds an advisory lease on it and fails to start if another server does; as S3 writes aren't conditional, servers started at the same time must not share a prefix")
 }
 
+// The objects of chunked batches, under the object prefix. Batch manifests are where the batches
+// would be, so that they are listed like them.
+const (
+	s3ChunksPrefix     = "chunks/"
+	s3ReferencesPrefix = "chunk-references/"
+	// by-expiry-timestamp/<timestamp>/<batch> lists the batches in the order they expire, and
+	// expiries/<batch>/<timestamp> the expiries of each batch, as a batch stored several times
+	// has several.
+	s3ByExpiryPrefix       = "by-expiry-timestamp/"
+	s3ExpiriesPrefix       = "expiries/"
+	s3ExpiryTimestampWidth = 20
+	// writer-lease holds the id of the server writing chunked batches under the prefix and when
+	// its lease expires, as "<id> <unix seconds>".
+	s3WriterLeaseName     = "writer-lease"
+	s3WriterLeaseDuration = time.Minute
+)
+
+var errNoS3WriterLease = errors.New("S3 storage doesn't hold the writer lease of its object prefix")
+
 type S3StorageService struct {
-	client              *s3.Client
+	client              S3Client
 	bucket              string
 	objectPrefix        string
 	uploader            S3Uploader
 	downloader          S3Downloader
 	discardAfterTimeout bool
+	enableChunking      bool
+
+	// chunksMutex keeps pruning from deleting chunks that batches being stored reference. Other
+	// servers are kept from writing to the object prefix by the writer lease.
+	chunksMutex sync.Mutex
+	writerID    string
+	// leaseExpiry is the unix time the writer lease held expires at, or 0 if it isn't held.
+	leaseExpiry atomic.Int64
+	stopWaiter  stopwaiter.StopWaiterSafe
 }
 
-func NewS3StorageService(config S3StorageServiceConfig) (StorageService, error) {
+func NewS3StorageService(config S3StorageServiceConfig) (*S3StorageService, error) {
 	client, err := buildS3Client(config.AccessKey, config.SecretKey, config.Region)
 	if err != nil {
 		return nil, err
@@ -76,9 +124,42 @@ func NewS3StorageService(config S3StorageServiceConfig) (StorageService, error)
 		uploader:            manager.NewUploader(client),
 		downloader:          manager.NewDownloader(client),
 		discardAfterTimeout: config.DiscardAfterTimeout,
+		enableChunking:      config.EnableChunking,
 	}, nil
 }
 
+// start acquires the writer lease of the object prefix and keeps it renewed if batches are stored
+// chunked, failing if another server holds it, and prunes expired batches periodically if they
+// are, which the bucket's lifecycle rules can't do without leaving their chunks behind.
+func (s3s *S3StorageService) start(ctx context.Context) error {
+	if s3s.enableChunking {
+		if err := s3s.acquireWriterLease(ctx); err != nil {
+			return err
+		}
+	}
+	if err := s3s.stopWaiter.Start(ctx, s3s); err != nil {
+		return err
+	}
+	if !s3s.enableChunking {
+		return nil
+	}
+	err := s3s.stopWaiter.CallIterativelySafe(func(ctx context.Context) time.Duration {
+		if err := s3s.renewWriterLease(ctx); err != nil {
+			log.Error("error renewing S3 writer lease", "error", err)
+		}
+		return s3WriterLeaseDuration / 3
+	})
+	if err != nil || !s3s.discardAfterTimeout {
+		return err
+	}
+	return s3s.stopWaiter.CallIterativelySafe(func(ctx context.Context) time.Duration {
+		if err := s3s.prune(ctx, time.Now()); err != nil {
+			log.Error("error pruning expired batches", "error", err)
+		}
+		return time.Minute * 5
+	})
+}
+
 func buildS3Client(accessKey, secretKey, region string) (*s3.Client, error) {
 	cfg, err := awsConfig.LoadDefaultConfig(context.TODO(), awsConfig.WithRegion(region), func(options *awsConfig.LoadOptions) error {
 		// remain backward compatible with accessKey and secretKey credentials provided via cli flags
@@ -101,14 +182,60 @@ func (s3s *S3StorageService) GetByHash(ctx context.Context, key common.Hash) ([]
 		Bucket: aws.String(s3s.bucket),
 		Key:    aws.String(s3s.objectPrefix + EncodeStorageServiceKey(key)),
 	})
-	return buf.Bytes(), err
+	if err != nil {
+		return buf.Bytes(), err
+	}
+	return loadChunks(ctx, s3s, key, buf.Bytes())
+}
+
+// Has heads the batch's object, and only gets it if it may be the manifest of a chunked batch, to
+// check its chunks are there.
+func (s3s *S3StorageService) Has(ctx context.Context, key common.Hash) (bool, error) {
+	log.Trace("das.S3StorageService.Has", "key", pretty.PrettyHash(key), "this", s3s)
+
+	name := s3s.objectPrefix + EncodeStorageServiceKey(key)
+	head, err := s3s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    aws.String(name),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !mayBeChunkManifest(head.ContentLength) {
+		return true, nil
+	}
+	buf := manager.NewWriteAtBuffer([]byte{})
+	_, err = s3s.downloader.Download(ctx, buf, &s3.GetObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return false, err
+	}
+	return hasChunks(ctx, s3s, key, buf.Bytes())
 }
 
 func (s3s *S3StorageService) Put(ctx context.Context, value []byte, timeout uint64) error {
 	logPut("das.S3StorageService.Store", value, timeout, s3s)
+	if s3s.enableChunking {
+		return s3s.putChunked(ctx, dastree.Hash(value), value, timeout)
+	}
+	return s3s.put(ctx, dastree.Hash(value), value, timeout)
+}
+
+func (s3s *S3StorageService) PutShard(ctx context.Context, shard *erasure.Shard, timeout uint64) error {
+	log.Trace("das.S3StorageService.PutShard", "root", pretty.PrettyHash(shard.Root), "index", shard.Index, "this", s3s)
+	return s3s.put(ctx, erasure.ShardKey(shard.Root), shard.Serialize(), timeout)
+}
+
+func (s3s *S3StorageService) put(ctx context.Context, key common.Hash, value []byte, timeout uint64) error {
 	putObjectInput := s3.PutObjectInput{
 		Bucket: aws.String(s3s.bucket),
-		Key:    aws.String(s3s.objectPrefix + EncodeStorageServiceKey(dastree.Hash(value))),
+		Key:    aws.String(s3s.objectPrefix + EncodeStorageServiceKey(key)),
 		Body:   bytes.NewReader(value)}
 	if !s3s.discardAfterTimeout {
 		expires := time.Unix(int64(timeout), 0)
@@ -121,12 +248,312 @@ func (s3s *S3StorageService) Put(ctx context.Context, value []byte, timeout uint
 	return err
 }
 
+// putChunked stores the batch's chunks and manifest, none of which expire in the bucket, and
+// indexes its expiry if expired batches are pruned.
+func (s3s *S3StorageService) putChunked(ctx context.Context, key common.Hash, value []byte, timeout uint64) error {
+	s3s.chunksMutex.Lock()
+	defer s3s.chunksMutex.Unlock()
+	if !s3s.holdsWriterLease() {
+		return errNoS3WriterLease
+	}
+
+	batchName := s3s.objectPrefix + EncodeStorageServiceKey(key)
+	// A batch already stored, whole or chunked, only gets its new expiry index entries.
+	stored, err := s3s.hasObject(ctx, batchName)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		manifest, err := storeChunks(ctx, s3s, key, value)
+		if err != nil {
+			return err
+		}
+		if err := s3s.putObject(ctx, batchName, manifest); err != nil {
+			return err
+		}
+	}
+	if !s3s.discardAfterTimeout {
+		return nil
+	}
+	encodedTimeout := fmt.Sprintf("%0*d", s3ExpiryTimestampWidth, timeout)
+	if err := s3s.putObject(ctx, s3s.objectPrefix+s3ExpiriesPrefix+EncodeStorageServiceKey(key)+"/"+encodedTimeout, nil); err != nil {
+		return err
+	}
+	return s3s.putObject(ctx, s3s.objectPrefix+s3ByExpiryPrefix+encodedTimeout+"/"+EncodeStorageServiceKey(key), nil)
+}
+
+// prune deletes the chunked batches that expired before pruneTil, and the chunks only they
+// referenced. A batch stored several times is deleted once its latest expiry has passed.
+func (s3s *S3StorageService) prune(ctx context.Context, pruneTil time.Time) error {
+	s3s.chunksMutex.Lock()
+	defer s3s.chunksMutex.Unlock()
+	if !s3s.holdsWriterLease() {
+		return errNoS3WriterLease
+	}
+
+	pages := s3.NewListObjectsV2Paginator(s3s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s3s.bucket),
+		Prefix: aws.String(s3s.objectPrefix + s3ByExpiryPrefix),
+	})
+	pruned := 0
+	pruningStart := time.Now()
+	defer func() {
+		if pruned > 0 {
+			log.Info("S3 storage pruned expired batches", "count", pruned, "pruneTil", pruneTil, "duration", time.Since(pruningStart))
+		}
+	}()
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, object := range page.Contents {
+			entry := strings.TrimPrefix(aws.ToString(object.Key), s3s.objectPrefix+s3ByExpiryPrefix)
+			encodedTimeout, encodedKey, found := strings.Cut(entry, "/")
+			timeout, err := strconv.ParseUint(encodedTimeout, 10, 64)
+			if !found || err != nil || !isStorageServiceKey(encodedKey) {
+				log.Warn("Skipping malformed S3 expiry index entry", "key", aws.ToString(object.Key))
+				continue
+			}
+			// Entries are listed in the order of their timestamps.
+			if timeout >= uint64(pruneTil.Unix()) {
+				return nil
+			}
+			key, err := DecodeStorageServiceKey(encodedKey)
+			if err != nil {
+				return err
+			}
+			if err := s3s.pruneExpiry(ctx, key, encodedTimeout); err != nil {
+				return err
+			}
+			pruned++
+		}
+	}
+	return nil
+}
+
+// pruneExpiry deletes the expiry index entries of the batch with the given timestamp, and the
+// batch if it has no later ones.
+func (s3s *S3StorageService) pruneExpiry(ctx context.Context, key common.Hash, encodedTimeout string) error {
+	encodedKey := EncodeStorageServiceKey(key)
+	if err := s3s.deleteObject(ctx, s3s.objectPrefix+s3ExpiriesPrefix+encodedKey+"/"+encodedTimeout); err != nil {
+		return err
+	}
+	if err := s3s.deleteObject(ctx, s3s.objectPrefix+s3ByExpiryPrefix+encodedTimeout+"/"+encodedKey); err != nil {
+		return err
+	}
+	unexpired, err := s3s.hasObjectsUnder(ctx, s3s.objectPrefix+s3ExpiriesPrefix+encodedKey+"/")
+	if err != nil || unexpired {
+		return err
+	}
+
+	batchName := s3s.objectPrefix + encodedKey
+	buf := manager.NewWriteAtBuffer([]byte{})
+	if _, err := s3s.downloader.Download(ctx, buf, &s3.GetObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    aws.String(batchName),
+	}); err != nil {
+		log.Warn("Couldn't find batch to expire, it may have been previously deleted but its expiry index entry still existed", "key", batchName, "err", err)
+		return nil
+	}
+	if err := s3s.deleteObject(ctx, batchName); err != nil {
+		return err
+	}
+	// Another server may have taken the lease since pruning started, and be storing batches that
+	// reference these chunks. Leaving them orphaned is better than deleting them from under it.
+	if err := s3s.checkWriterLease(ctx); err != nil {
+		return fmt.Errorf("not releasing the chunks of pruned batch %s, they may be orphaned: %w", batchName, err)
+	}
+	if err := releaseChunks(ctx, s3s, key, buf.Bytes()); err != nil {
+		return fmt.Errorf("couldn't release the chunks of pruned batch %s, they may be orphaned: %w", batchName, err)
+	}
+	return nil
+}
+
+// acquireWriterLease takes the writer lease of the object prefix, unless another server holds it.
+// The lease is only advisory: S3 writes aren't conditional, so two servers starting at once may
+// both take it. The one whose write was overwritten finds out when it next renews or checks the
+// lease, and stops writing, but until then both may write.
+func (s3s *S3StorageService) acquireWriterLease(ctx context.Context) error {
+	if s3s.writerID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		s3s.writerID = hex.EncodeToString(id)
+	}
+	holder, expiry, err := s3s.readWriterLease(ctx)
+	if err != nil {
+		return err
+	}
+	if holder != "" && holder != s3s.writerID && time.Now().Before(expiry) {
+		return fmt.Errorf("another server (%s) holds the writer lease of s3://%s/%s until %v, only one server may store chunked batches under an object prefix", holder, s3s.bucket, s3s.objectPrefix, expiry)
+	}
+	return s3s.writeWriterLease(ctx)
+}
+
+// renewWriterLease extends the writer lease, or gives it up if another server has taken it.
+func (s3s *S3StorageService) renewWriterLease(ctx context.Context) error {
+	holder, _, err := s3s.readWriterLease(ctx)
+	if err != nil {
+		return err
+	}
+	if holder != s3s.writerID {
+		s3s.leaseExpiry.Store(0)
+		return fmt.Errorf("%w, another server (%s) took it", errNoS3WriterLease, holder)
+	}
+	return s3s.writeWriterLease(ctx)
+}
+
+// checkWriterLease reads the writer lease back, as holdsWriterLease only knows of it as of the last
+// renewal and another server may have overwritten it since.
+func (s3s *S3StorageService) checkWriterLease(ctx context.Context) error {
+	holder, _, err := s3s.readWriterLease(ctx)
+	if err != nil {
+		return err
+	}
+	if holder != s3s.writerID {
+		s3s.leaseExpiry.Store(0)
+		return fmt.Errorf("%w, another server (%s) took it", errNoS3WriterLease, holder)
+	}
+	if !s3s.holdsWriterLease() {
+		return errNoS3WriterLease
+	}
+	return nil
+}
+
+func (s3s *S3StorageService) holdsWriterLease() bool {
+	return time.Now().Unix() < s3s.leaseExpiry.Load()
+}
+
+func (s3s *S3StorageService) readWriterLease(ctx context.Context) (string, time.Time, error) {
+	name := s3s.objectPrefix + s3WriterLeaseName
+	exists, err := s3s.hasObject(ctx, name)
+	if err != nil || !exists {
+		return "", time.Time{}, err
+	}
+	buf := manager.NewWriteAtBuffer([]byte{})
+	if _, err := s3s.downloader.Download(ctx, buf, &s3.GetObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    aws.String(name),
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+	holder, encodedExpiry, found := strings.Cut(string(buf.Bytes()), " ")
+	expiry, err := strconv.ParseInt(encodedExpiry, 10, 64)
+	if !found || err != nil {
+		return "", time.Time{}, fmt.Errorf("malformed S3 writer lease %q", buf.Bytes())
+	}
+	return holder, time.Unix(expiry, 0), nil
+}
+
+func (s3s *S3StorageService) writeWriterLease(ctx context.Context) error {
+	expiry := time.Now().Add(s3WriterLeaseDuration).Unix()
+	lease := fmt.Sprintf("%s %d", s3s.writerID, expiry)
+	if err := s3s.putObject(ctx, s3s.objectPrefix+s3WriterLeaseName, []byte(lease)); err != nil {
+		return err
+	}
+	s3s.leaseExpiry.Store(expiry)
+	return nil
+}
+
+func (s3s *S3StorageService) putObject(ctx context.Context, name string, value []byte) error {
+	_, err := s3s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    aws.String(name),
+		Body:   bytes.NewReader(value),
+	})
+	return err
+}
+
+func (s3s *S3StorageService) hasObject(ctx context.Context, name string) (bool, error) {
+	_, err := s3s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    aws.String(name),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s3s *S3StorageService) hasObjectsUnder(ctx context.Context, prefix string) (bool, error) {
+	output, err := s3s.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s3s.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: 1,
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(output.Contents) > 0, nil
+}
+
+func (s3s *S3StorageService) deleteObject(ctx context.Context, name string) error {
+	_, err := s3s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    aws.String(name),
+	})
+	return err
+}
+
+func (s3s *S3StorageService) getChunk(ctx context.Context, hash common.Hash) ([]byte, error) {
+	buf := manager.NewWriteAtBuffer([]byte{})
+	_, err := s3s.downloader.Download(ctx, buf, &s3.GetObjectInput{
+		Bucket: aws.String(s3s.bucket),
+		Key:    aws.String(s3s.objectPrefix + s3ChunksPrefix + EncodeStorageServiceKey(hash)),
+	})
+	return buf.Bytes(), err
+}
+
+func (s3s *S3StorageService) hasChunk(ctx context.Context, hash common.Hash) (bool, error) {
+	return s3s.hasObject(ctx, s3s.objectPrefix+s3ChunksPrefix+EncodeStorageServiceKey(hash))
+}
+
+func (s3s *S3StorageService) putChunk(ctx context.Context, hash common.Hash, chunk []byte) error {
+	return s3s.putObject(ctx, s3s.objectPrefix+s3ChunksPrefix+EncodeStorageServiceKey(hash), chunk)
+}
+
+func (s3s *S3StorageService) deleteChunk(ctx context.Context, hash common.Hash) error {
+	return s3s.deleteObject(ctx, s3s.objectPrefix+s3ChunksPrefix+EncodeStorageServiceKey(hash))
+}
+
+// References are empty objects under the chunk's references prefix, one per batch.
+func (s3s *S3StorageService) referenceName(chunk, batch common.Hash) string {
+	return s3s.objectPrefix + s3ReferencesPrefix + EncodeStorageServiceKey(chunk) + "/" + EncodeStorageServiceKey(batch)
+}
+
+func (s3s *S3StorageService) addReference(ctx context.Context, chunk, batch common.Hash) error {
+	return s3s.putObject(ctx, s3s.referenceName(chunk, batch), nil)
+}
+
+func (s3s *S3StorageService) removeReference(ctx context.Context, chunk, batch common.Hash) error {
+	return s3s.deleteObject(ctx, s3s.referenceName(chunk, batch))
+}
+
+func (s3s *S3StorageService) referenced(ctx context.Context, chunk common.Hash) (bool, error) {
+	return s3s.hasObjectsUnder(ctx, s3s.objectPrefix+s3ReferencesPrefix+EncodeStorageServiceKey(chunk)+"/")
+}
+
 func (s3s *S3StorageService) Sync(ctx context.Context) error {
 	return nil
 }
 
+// Close gives up the writer lease if it holds it, so that another server can take over at once.
 func (s3s *S3StorageService) Close(ctx context.Context) error {
-	return nil
+	if err := s3s.stopWaiter.StopAndWait(); err != nil {
+		return err
+	}
+	if !s3s.holdsWriterLease() {
+		return nil
+	}
+	s3s.leaseExpiry.Store(0)
+	holder, _, err := s3s.readWriterLease(ctx)
+	if err != nil || holder != s3s.writerID {
+		return err
+	}
+	return s3s.deleteObject(ctx, s3s.objectPrefix+s3WriterLeaseName)
 }
 
 func (s3s *S3StorageService) ExpirationPolicy(ctx context.Context) (daprovider.ExpirationPolicy, error) {
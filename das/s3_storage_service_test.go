@@ -7,15 +7,23 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/ethereum/go-ethereum/crypto"
 
 	"github.com/offchainlabs/nitro/cmd/genericconf"
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/util/arbmath"
 )
 
 type mockS3Uploader struct {
@@ -90,3 +98,214 @@ func TestS3StorageService(t *testing.T) {
 		t.Fatal(val, val1)
 	}
 }
+
+// mockS3Bucket keeps objects by key, serving as the uploader, downloader and client of an
+// S3StorageService.
+type mockS3Bucket struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *mockS3Bucket) Upload(ctx context.Context, input *s3.PutObjectInput, opts ...func(*manager.Uploader)) (*manager.UploadOutput, error) {
+	body, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.objects[*input.Key] = body
+	return &manager.UploadOutput{}, nil
+}
+
+func (m *mockS3Bucket) Download(ctx context.Context, w io.WriterAt, input *s3.GetObjectInput, options ...func(*manager.Downloader)) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	object, ok := m.objects[*input.Key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	n, err := w.WriteAt(object, 0)
+	return int64(n), err
+}
+
+func (m *mockS3Bucket) HeadBucket(ctx context.Context, input *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (m *mockS3Bucket) HeadObject(ctx context.Context, input *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	object, ok := m.objects[*input.Key]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{ContentLength: int64(len(object))}, nil
+}
+
+func (m *mockS3Bucket) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, aws.ToString(input.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if input.MaxKeys > 0 && len(keys) > int(input.MaxKeys) {
+		keys = keys[:input.MaxKeys]
+	}
+	output := &s3.ListObjectsV2Output{KeyCount: int32(len(keys))}
+	for _, key := range keys {
+		output.Contents = append(output.Contents, types.Object{Key: aws.String(key)})
+	}
+	return output, nil
+}
+
+func (m *mockS3Bucket) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.objects, *input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *mockS3Bucket) count(prefix string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	count := 0
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestS3StorageServiceChunked(t *testing.T) {
+	ctx := context.Background()
+	bucket := &mockS3Bucket{objects: make(map[string][]byte)}
+	s3Service := &S3StorageService{
+		client:              bucket,
+		objectPrefix:        "batches/",
+		uploader:            bucket,
+		downloader:          bucket,
+		discardAfterTimeout: true,
+		enableChunking:      true,
+	}
+	Require(t, s3Service.acquireWriterLease(ctx))
+
+	now := time.Now()
+	bin := func(b byte) []byte { return bytes.Repeat([]byte{b}, dastree.BinSize) }
+	first := arbmath.ConcatByteSlices(bin('x'), bin('y'), bin('x'), []byte("first"))
+	second := arbmath.ConcatByteSlices(bin('y'), bin('z'))
+	Require(t, s3Service.Put(ctx, first, uint64(now.Add(-time.Hour).Unix())))
+	Require(t, s3Service.Put(ctx, second, uint64(now.Add(time.Hour).Unix())))
+	Require(t, s3Service.Put(ctx, second, uint64(now.Add(2*time.Hour).Unix())))
+
+	for _, batch := range [][]byte{first, second} {
+		val, err := s3Service.GetByHash(ctx, dastree.Hash(batch))
+		Require(t, err)
+		if !bytes.Equal(val, batch) {
+			Fail(t, "chunked batch didn't reassemble")
+		}
+	}
+	if chunks := bucket.count("batches/" + s3ChunksPrefix); chunks != 4 {
+		Fail(t, "expected the 4 distinct chunks to be stored once, got", chunks)
+	}
+
+	// A chunked batch is only had while all its chunks are.
+	checkHas := func(batch []byte, expected bool) {
+		t.Helper()
+		has, err := s3Service.Has(ctx, dastree.Hash(batch))
+		Require(t, err)
+		if has != expected {
+			Fail(t, "expected Has to be", expected)
+		}
+	}
+	checkHas(first, true)
+	checkHas(second, true)
+	checkHas([]byte("absent"), false)
+	chunkName := "batches/" + s3ChunksPrefix + EncodeStorageServiceKey(crypto.Keccak256Hash(bin('z')))
+	chunk := bucket.objects[chunkName]
+	delete(bucket.objects, chunkName)
+	checkHas(first, true)
+	checkHas(second, false)
+	bucket.objects[chunkName] = chunk
+
+	Require(t, s3Service.prune(ctx, now))
+	if _, err := s3Service.GetByHash(ctx, dastree.Hash(first)); !errors.Is(err, ErrNotFound) {
+		Fail(t, "expected the expired batch to be pruned, got", err)
+	}
+	if chunks := bucket.count("batches/" + s3ChunksPrefix); chunks != 2 {
+		Fail(t, "expected only the chunks of the second batch to remain, got", chunks)
+	}
+
+	// The second batch's first expiry passed, but not its second.
+	Require(t, s3Service.prune(ctx, now.Add(time.Hour+time.Second)))
+	val, err := s3Service.GetByHash(ctx, dastree.Hash(second))
+	Require(t, err)
+	if !bytes.Equal(val, second) {
+		Fail(t, "chunked batch didn't reassemble")
+	}
+
+	Require(t, s3Service.prune(ctx, now.Add(2*time.Hour+time.Second)))
+	if remaining := bucket.count(""); remaining != 1 {
+		Fail(t, "expected all objects but the writer lease to be deleted, got", remaining)
+	}
+	checkHas(second, false)
+}
+
+func TestS3StorageServiceWriterLease(t *testing.T) {
+	ctx := context.Background()
+	bucket := &mockS3Bucket{objects: make(map[string][]byte)}
+	newService := func() *S3StorageService {
+		return &S3StorageService{
+			client:         bucket,
+			objectPrefix:   "batches/",
+			uploader:       bucket,
+			downloader:     bucket,
+			enableChunking: true,
+		}
+	}
+	timeout := uint64(time.Now().Add(time.Hour).Unix())
+
+	first, second := newService(), newService()
+	Require(t, first.start(ctx))
+	if err := second.start(ctx); err == nil {
+		Fail(t, "expected a second writer to the object prefix to fail to start")
+	}
+	if err := second.Put(ctx, []byte("batch"), timeout); !errors.Is(err, errNoS3WriterLease) {
+		Fail(t, "expected a server without the writer lease not to store, got", err)
+	}
+	Require(t, first.Put(ctx, []byte("batch"), timeout))
+
+	// Once the first server stops, the second can take over.
+	Require(t, first.Close(ctx))
+	Require(t, second.start(ctx))
+	Require(t, second.Put(ctx, []byte("another batch"), timeout))
+	// Set after start, so that only the test prunes.
+	second.discardAfterTimeout = true
+	expired := bytes.Repeat([]byte{'x'}, 2*dastree.BinSize)
+	Require(t, second.Put(ctx, expired, uint64(time.Now().Add(-time.Hour).Unix())))
+	chunks := bucket.count("batches/" + s3ChunksPrefix)
+
+	// A server whose lease was taken stops writing once it notices, and reads the lease back
+	// before deleting chunks the other server may be storing batches with.
+	bucket.objects["batches/"+s3WriterLeaseName] = []byte(fmt.Sprintf("other %d", time.Now().Add(time.Hour).Unix()))
+	if err := second.prune(ctx, time.Now()); !errors.Is(err, errNoS3WriterLease) {
+		Fail(t, "expected a server that lost the writer lease not to release chunks, got", err)
+	}
+	if remaining := bucket.count("batches/" + s3ChunksPrefix); remaining != chunks {
+		Fail(t, "expected a server that lost the writer lease not to delete chunks, got", remaining, "of", chunks)
+	}
+	if err := second.renewWriterLease(ctx); !errors.Is(err, errNoS3WriterLease) {
+		Fail(t, "expected the taken lease not to be renewed, got", err)
+	}
+	if err := second.Put(ctx, []byte("a third batch"), timeout); !errors.Is(err, errNoS3WriterLease) {
+		Fail(t, "expected a server that lost the writer lease not to store, got", err)
+	}
+	Require(t, second.Close(ctx))
+	if _, ok := bucket.objects["batches/"+s3WriterLeaseName]; !ok {
+		Fail(t, "server deleted a lease it didn't hold")
+	}
+}
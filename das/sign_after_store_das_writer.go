@@ -19,6 +19,7 @@ import (
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/blsSignatures"
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/erasure"
 	"github.com/offchainlabs/nitro/util/pretty"
 )
 
@@ -63,12 +64,20 @@ func KeyConfigAddOptions(prefix string, f *flag.FlagSet) {
 //
 // 1) SignAfterStoreDASWriter.Store(...) assembles the returned hash into a
 // DataAvailabilityCertificate and signs it with its BLS private key.
+//
+// 2) SignAfterStoreDASWriter.StoreShard(...) does the same for the batch an erasure-coded
+// shard belongs to, having stored only the shard, with a certificate that also names the
+// commitment the shard is under.
 type SignAfterStoreDASWriter struct {
 	privKey        blsSignatures.PrivateKey
 	pubKey         *blsSignatures.PublicKey
 	keysetHash     [32]byte
 	keysetBytes    []byte
 	storageService StorageService
+	// shardStorage is set if the storage can keep erasure-coded shards.
+	shardStorage ShardStorageService
+	// announcer is set if the batches stored are announced to mirrors.
+	announcer *BatchAnnouncer
 }
 
 func NewSignAfterStoreDASWriter(ctx context.Context, config DataAvailabilityConfig, storageService StorageService) (*SignAfterStoreDASWriter, error) {
@@ -84,7 +93,7 @@ func NewSignAfterStoreDASWriter(ctx context.Context, config DataAvailabilityConf
 
 	keyset := &daprovider.DataAvailabilityKeyset{
 		AssumedHonest: 1,
-		PubKeys:       [][]byte{}, //FIXME
+		PubKeys:       []blsSignatures.PublicKey{publicKey},
 	}
 
 	ksBuf := bytes.NewBuffer([]byte{})
@@ -96,12 +105,14 @@ func NewSignAfterStoreDASWriter(ctx context.Context, config DataAvailabilityConf
 		return nil, err
 	}
 
+	shardStorage, _ := storageService.(ShardStorageService)
 	return &SignAfterStoreDASWriter{
 		privKey:        privKey,
 		pubKey:         &publicKey,
 		keysetHash:     ksHash,
 		keysetBytes:    ksBuf.Bytes(),
 		storageService: storageService,
+		shardStorage:   shardStorage,
 	}, nil
 }
 
@@ -114,13 +125,11 @@ func (d *SignAfterStoreDASWriter) Store(ctx context.Context, message []byte, tim
 		SignersMask: 1, // The aggregator will override this if we're part of a committee.
 	}
 
-	_ = c.SerializeSignableFields()
-	// c.Sig, err = blsSignatures.SignMessage(d.privKey, fields)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	c.Sig = nil
+	fields := c.SerializeSignableFields()
+	c.Sig, err = blsSignatures.SignMessage(d.privKey, fields)
+	if err != nil {
+		return nil, err
+	}
 
 	err = d.storageService.Put(ctx, message, timeout)
 	if err != nil {
@@ -130,6 +139,60 @@ func (d *SignAfterStoreDASWriter) Store(ctx context.Context, message []byte, tim
 	if err != nil {
 		return nil, err
 	}
+	if d.announcer != nil {
+		// Announcing is best effort, as mirrors can still sync the batch from L1.
+		if err := d.announcer.Announce(c.DataHash, timeout); err != nil {
+			log.Warn("Failed to announce stored batch", "hash", pretty.PrettyHash(c.DataHash), "err", err)
+		}
+	}
+
+	c.KeysetHash = d.keysetHash
+
+	return c, nil
+}
+
+// Announcer returns the announcer of the batches stored, or nil if announcements aren't enabled.
+func (d *SignAfterStoreDASWriter) Announcer() *BatchAnnouncer {
+	return d.announcer
+}
+
+// StoreShard stores the shard and signs the erasure-coded certificate of its batch, which names the
+// commitment the shard is under and how the batch was coded, so that every member whose signature
+// is aggregated into the certificate holds a shard under that commitment. The shard's proof is
+// checked, but whether the shards under its commitment code the batch can't be without the others;
+// readers check that when they rebuild the batch.
+func (d *SignAfterStoreDASWriter) StoreShard(ctx context.Context, shard *erasure.Shard, timeout uint64) (c *daprovider.DataAvailabilityCertificate, err error) {
+	log.Trace("das.SignAfterStoreDASWriter.StoreShard", "root", pretty.PrettyHash(shard.Root), "index", shard.Index, "timeout", time.Unix(int64(timeout), 0), "this", d)
+	if d.shardStorage == nil {
+		return nil, errors.New("storage can't keep erasure-coded shards, enable local-file-storage or s3-storage")
+	}
+	if err := shard.Verify(); err != nil {
+		return nil, err
+	}
+	c = &daprovider.DataAvailabilityCertificate{
+		Timeout:     timeout,
+		DataHash:    shard.Root,
+		Version:     daprovider.ErasureCodedDASCertificateVersion,
+		SignersMask: 1, // The aggregator will override this if we're part of a committee.
+		Commitment:  shard.Commitment,
+		DataShards:  shard.DataShards,
+		TotalShards: shard.TotalShards,
+	}
+
+	fields := c.SerializeSignableFields()
+	c.Sig, err = blsSignatures.SignMessage(d.privKey, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.shardStorage.PutShard(ctx, shard, timeout)
+	if err != nil {
+		return nil, err
+	}
+	err = d.shardStorage.Sync(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	c.KeysetHash = d.keysetHash
 
@@ -16,6 +16,7 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
 	"github.com/offchainlabs/nitro/das/dastree"
+	"github.com/offchainlabs/nitro/das/erasure"
 	"github.com/offchainlabs/nitro/util/pretty"
 	"github.com/offchainlabs/nitro/util/stopwaiter"
 	flag "github.com/spf13/pflag"
@@ -35,6 +36,9 @@ type RestfulClientAggregatorConfig struct {
 	MaxPerEndpointStats          int                                `koanf:"max-per-endpoint-stats"`
 	SimpleExploreExploitStrategy SimpleExploreExploitStrategyConfig `koanf:"simple-explore-exploit-strategy"`
 	SyncToStorage                SyncToStorageConfig                `koanf:"sync-to-storage"`
+	ICCertified                  ICCertifiedReadConfig              `koanf:"ic-certified"`
+	ErasureCoded                 bool                               `koanf:"erasure-coded"`
+	Sampling                     AvailabilitySamplingConfig         `koanf:"sampling"`
 }
 
 var DefaultRestfulClientAggregatorConfig = RestfulClientAggregatorConfig{
@@ -47,6 +51,9 @@ var DefaultRestfulClientAggregatorConfig = RestfulClientAggregatorConfig{
 	MaxPerEndpointStats:          20,
 	SimpleExploreExploitStrategy: DefaultSimpleExploreExploitStrategyConfig,
 	SyncToStorage:                DefaultSyncToStorageConfig,
+	ICCertified:                  DefaultICCertifiedReadConfig,
+	ErasureCoded:                 false,
+	Sampling:                     DefaultAvailabilitySamplingConfig,
 }
 
 type SimpleExploreExploitStrategyConfig struct {
@@ -70,6 +77,9 @@ func RestfulClientAggregatorConfigAddOptions(prefix string, f *flag.FlagSet) {
 	f.Int(prefix+".max-per-endpoint-stats", DefaultRestfulClientAggregatorConfig.MaxPerEndpointStats, "number of stats entries (latency and success rate) to keep for each REST endpoint; controls whether strategy is faster or slower to respond to changing conditions")
 	SimpleExploreExploitStrategyConfigAddOptions(prefix+".simple-explore-exploit-strategy", f)
 	SyncToStorageConfigAddOptions(prefix+".sync-to-storage", f)
+	ICCertifiedReadConfigAddOptions(prefix+".ic-certified", f)
+	f.Bool(prefix+".erasure-coded", DefaultRestfulClientAggregatorConfig.ErasureCoded, "rebuild batches from the erasure-coded shards of the endpoints when none of them serves the whole batch, for committees whose batch poster sets rpc-aggregator.data-shards")
+	AvailabilitySamplingConfigAddOptions(prefix+".sampling", f)
 }
 
 func SimpleExploreExploitStrategyConfigAddOptions(prefix string, f *flag.FlagSet) {
@@ -82,6 +92,13 @@ func NewRestfulClientAggregator(ctx context.Context, config *RestfulClientAggreg
 		config: config,
 		stats:  make(map[daprovider.DASReader]readerStats),
 	}
+	if config.ICCertified.Enable {
+		icKeyset, err := config.ICCertified.Keyset()
+		if err != nil {
+			return nil, fmt.Errorf("invalid rest-aggregator.ic-certified config: %w", err)
+		}
+		a.icKeyset = icKeyset
+	}
 
 	combinedUrls := make(map[string]bool)
 	for _, url := range config.Urls {
@@ -129,6 +146,14 @@ func NewRestfulClientAggregator(ctx context.Context, config *RestfulClientAggreg
 		return nil, fmt.Errorf("unknown RestfulClientAggregator strategy '%s', use --help to see available strategies", config.Strategy)
 	}
 	a.strategy.update(a.readers, a.stats)
+
+	if config.Sampling.Enable {
+		sampler, err := NewAvailabilitySampler(&config.Sampling, a.currentReaders, config.ErasureCoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rest-aggregator.sampling config: %w", err)
+		}
+		a.sampler = sampler
+	}
 	return &a, nil
 }
 
@@ -172,20 +197,159 @@ type SimpleDASReaderAggregator struct {
 	// readers and stats are only to be updated by the stats goroutine
 	readers []daprovider.DASReader
 	stats   map[daprovider.DASReader]readerStats
+	// extraReaders are the readers added with AddReaders, which are kept when the URLs change
+	extraReaders []daprovider.DASReader
 
 	strategy aggregatorStrategy
 
 	statMessages chan readerStatMessage
+
+	// icKeyset is set if batches are fetched with their IC certificates, which are checked
+	// against it before a reader's response is accepted.
+	icKeyset *daprovider.ICDAKeyset
+
+	// sampler is set if the batches fetched are sampled from every REST endpoint.
+	sampler *AvailabilitySampler
 }
 
-func (a *SimpleDASReaderAggregator) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
+// Sampler returns the availability sampler of the aggregator, or nil if sampling isn't enabled.
+func (a *SimpleDASReaderAggregator) Sampler() *AvailabilitySampler {
+	return a.sampler
+}
+
+func (a *SimpleDASReaderAggregator) currentReaders() []daprovider.DASReader {
 	a.readersMutex.RLock()
 	defer a.readersMutex.RUnlock()
+	return append([]daprovider.DASReader{}, a.readers...)
+}
+
+// AddReaders adds readers that aren't REST endpoints, such as the read modes of IC storage, so that
+// the strategy picks between them and the REST endpoints by the same stats. It must be called
+// before Start.
+func (a *SimpleDASReaderAggregator) AddReaders(readers ...daprovider.DASReader) {
+	a.readersMutex.Lock()
+	defer a.readersMutex.Unlock()
+	for _, reader := range readers {
+		a.readers = append(a.readers, reader)
+		a.extraReaders = append(a.extraReaders, reader)
+		a.stats[reader] = make([]readerStat, 0, a.config.MaxPerEndpointStats)
+	}
+	a.strategy.update(a.readers, a.stats)
+}
+
+func (a *SimpleDASReaderAggregator) GetByHash(ctx context.Context, hash common.Hash) ([]byte, error) {
 	log.Trace("das.SimpleDASReaderAggregator.GetByHash", "key", pretty.PrettyHash(hash), "this", a)
+	data, _, err := a.getByHash(ctx, hash)
+	if err != nil && a.config.ErasureCoded && ctx.Err() == nil {
+		var shardsErr error
+		data, shardsErr = a.getByShards(ctx, hash)
+		if shardsErr != nil {
+			return nil, fmt.Errorf("%w; %w", err, shardsErr)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if a.sampler != nil {
+		a.sampler.Queue(hash, len(data))
+	}
+	return data, nil
+}
+
+// ShardReader is a reader that can serve the erasure-coded shard a committee member keeps of a
+// batch, with its checked proof.
+type ShardReader interface {
+	GetShard(ctx context.Context, root common.Hash) (*erasure.Shard, error)
+}
+
+// getByShards fetches the shards of the batch from every reader at once, and rebuilds it as soon
+// as enough shards under one commitment are in. Shards under a commitment that doesn't rebuild the
+// batch are ignored from then on.
+func (a *SimpleDASReaderAggregator) getByShards(ctx context.Context, hash common.Hash) ([]byte, error) {
+	a.readersMutex.RLock()
+	var shardReaders []ShardReader
+	for _, reader := range a.readers {
+		if shardReader, ok := reader.(ShardReader); ok {
+			shardReaders = append(shardReaders, shardReader)
+		}
+	}
+	a.readersMutex.RUnlock()
+
+	type shardErrorPair struct {
+		shard *erasure.Shard
+		err   error
+	}
+	results := make(chan shardErrorPair, len(shardReaders))
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for _, reader := range shardReaders {
+		go func(reader ShardReader) {
+			shard, err := reader.GetShard(subCtx, hash)
+			results <- shardErrorPair{shard, err}
+		}(reader)
+	}
+
+	commitments := make(map[common.Hash]map[uint8]*erasure.Shard)
+	failedCommitments := make(map[common.Hash]bool)
+	var errorCollection []error
+	for range shardReaders {
+		var result shardErrorPair
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case result = <-results:
+		}
+		if result.err != nil {
+			errorCollection = append(errorCollection, result.err)
+			continue
+		}
+		shard := result.shard
+		if failedCommitments[shard.Commitment] {
+			continue
+		}
+		shards, ok := commitments[shard.Commitment]
+		if !ok {
+			shards = make(map[uint8]*erasure.Shard)
+			commitments[shard.Commitment] = shards
+		}
+		shards[shard.Index] = shard
+		if len(shards) < int(shard.DataShards) {
+			continue
+		}
+		toReconstruct := make([]*erasure.Shard, 0, len(shards))
+		for _, s := range shards {
+			toReconstruct = append(toReconstruct, s)
+		}
+		data, err := erasure.Reconstruct(toReconstruct)
+		if err == nil {
+			return data, nil
+		}
+		log.Warn("SimpleDASReaderAggregator couldn't rebuild batch from erasure-coded shards", "key", pretty.PrettyHash(hash), "commitment", shard.Commitment, "err", err)
+		errorCollection = append(errorCollection, err)
+		failedCommitments[shard.Commitment] = true
+		delete(commitments, shard.Commitment)
+	}
+
+	return nil, fmt.Errorf("batch couldn't be rebuilt from erasure-coded shards: %v", errorCollection)
+}
+
+// GetCertifiedDataByHash returns a batch with the checked proof that a canister certified it. It
+// requires rest-aggregator.ic-certified to be enabled.
+func (a *SimpleDASReaderAggregator) GetCertifiedDataByHash(ctx context.Context, hash common.Hash) ([]byte, *daprovider.ICCertifiedData, error) {
+	log.Trace("das.SimpleDASReaderAggregator.GetCertifiedDataByHash", "key", pretty.PrettyHash(hash), "this", a)
+	if a.icKeyset == nil {
+		return nil, nil, errors.New("rest-aggregator.ic-certified isn't enabled")
+	}
+	return a.getByHash(ctx, hash)
+}
+
+func (a *SimpleDASReaderAggregator) getByHash(ctx context.Context, hash common.Hash) ([]byte, *daprovider.ICCertifiedData, error) {
+	a.readersMutex.RLock()
+	defer a.readersMutex.RUnlock()
 
 	type dataErrorPair struct {
-		data []byte
-		err  error
+		data  []byte
+		proof *daprovider.ICCertifiedData
+		err   error
 	}
 
 	results := make(chan dataErrorPair, len(a.readers))
@@ -201,13 +365,13 @@ func (a *SimpleDASReaderAggregator) GetByHash(ctx context.Context, hash common.H
 				wg.Add(1)
 				go func(reader daprovider.DASReader) {
 					defer wg.Done()
-					data, err := a.tryGetByHash(subCtx, hash, reader)
+					data, proof, err := a.tryGetByHash(subCtx, hash, reader)
 					if err != nil && errors.Is(ctx.Err(), context.Canceled) {
 						// Don't record a stats data point when a different
 						// client returned faster than this one.
 						return
 					}
-					results <- dataErrorPair{data, err}
+					results <- dataErrorPair{data, proof, err}
 				}(reader)
 			}
 			go func() {
@@ -229,34 +393,47 @@ func (a *SimpleDASReaderAggregator) GetByHash(ctx context.Context, hash common.H
 	for i := 0; i < len(a.readers); i++ {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return nil, nil, ctx.Err()
 		case result := <-results:
 			if result.err != nil {
 				errorCollection = append(errorCollection, result.err)
 			} else {
-				return result.data, nil
+				return result.data, result.proof, nil
 			}
 		}
 	}
 
-	return nil, fmt.Errorf("data wasn't able to be retrieved from any DAS Reader: %v", errorCollection)
+	return nil, nil, fmt.Errorf("data wasn't able to be retrieved from any DAS Reader: %v", errorCollection)
 }
 
 func (a *SimpleDASReaderAggregator) tryGetByHash(
 	ctx context.Context, hash common.Hash, reader daprovider.DASReader,
-) ([]byte, error) {
+) ([]byte, *daprovider.ICCertifiedData, error) {
 	stat := readerStatMessage{reader: reader}
 	stat.success = false
 
 	start := time.Now()
-	result, err := reader.GetByHash(ctx, hash)
-	if err == nil {
-		if dastree.ValidHash(hash, result) {
-			stat.success = true
-		} else {
+	var result []byte
+	var proof *daprovider.ICCertifiedData
+	var err error
+	if a.icKeyset == nil {
+		result, err = reader.GetByHash(ctx, hash)
+		if err == nil && !dastree.ValidHash(hash, result) {
 			err = fmt.Errorf("SimpleDASReaderAggregator got result from reader(%v) not matching hash", reader)
 		}
+	} else if certifiedReader, ok := reader.(CertifiedDASReader); ok {
+		// A reader serving an uncertified batch counts as a failure, so that the strategy
+		// stops preferring it.
+		result, proof, err = certifiedReader.GetCertifiedDataByHash(ctx, hash)
+		if err == nil {
+			if verifyErr := verifyICCertifiedData(a.icKeyset, hash, result, proof); verifyErr != nil {
+				err = fmt.Errorf("SimpleDASReaderAggregator got uncertified result from reader(%v): %w", reader, verifyErr)
+			}
+		}
+	} else {
+		err = fmt.Errorf("reader(%v) can't serve certified data", reader)
 	}
+	stat.success = err == nil
 	stat.latency = time.Since(start)
 
 	select {
@@ -266,11 +443,17 @@ func (a *SimpleDASReaderAggregator) tryGetByHash(
 		log.Warn("SimpleDASReaderAggregator stats processing goroutine is backed up, dropping", "dropped stats", stat)
 	}
 
-	return result, err
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, proof, nil
 }
 
 func (a *SimpleDASReaderAggregator) Start(ctx context.Context) {
 	a.StopWaiter.Start(ctx, a)
+	if a.sampler != nil {
+		a.sampler.Start(a.StopWaiter.GetContext())
+	}
 	onlineUrlsChan := StartRestfulServerListFetchDaemon(a.StopWaiter.GetContext(), a.config.OnlineUrlList, a.config.OnlineUrlListFetchInterval)
 
 	updateRestfulDasClients := func(urls []string) {
@@ -286,7 +469,10 @@ func (a *SimpleDASReaderAggregator) Start(ctx context.Context) {
 			}
 			combinedReaders[reader] = true
 		}
-		a.readers = make([]daprovider.DASReader, 0, len(combinedUrls))
+		for _, reader := range a.extraReaders {
+			combinedReaders[reader] = true
+		}
+		a.readers = make([]daprovider.DASReader, 0, len(combinedReaders))
 		// Update reader and add newly added stats
 		for reader := range combinedReaders {
 			a.readers = append(a.readers, reader)
@@ -329,6 +515,9 @@ func (a *SimpleDASReaderAggregator) Start(ctx context.Context) {
 }
 
 func (a *SimpleDASReaderAggregator) Close(ctx context.Context) error {
+	if a.sampler != nil {
+		a.sampler.StopOnly()
+	}
 	a.StopWaiter.StopOnly()
 	waitChan, err := a.StopWaiter.GetWaitChannel()
 	if err != nil {
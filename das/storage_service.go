@@ -13,6 +13,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/offchainlabs/nitro/arbstate/daprovider"
+	"github.com/offchainlabs/nitro/das/erasure"
 )
 
 var ErrNotFound = errors.New("not found")
@@ -26,6 +27,33 @@ type StorageService interface {
 	HealthCheck(ctx context.Context) error
 }
 
+// ShardStorageService is a StorageService that can also keep a committee member's erasure-coded
+// shard of a batch. The shard is stored under erasure.ShardKey of the batch's root, so it is read
+// back with GetByHash.
+type ShardStorageService interface {
+	StorageService
+	PutShard(ctx context.Context, shard *erasure.Shard, expirationTime uint64) error
+}
+
+// HasChecker is a reader that can tell whether it holds the data with a given hash without
+// reading it.
+type HasChecker interface {
+	Has(ctx context.Context, key common.Hash) (bool, error)
+}
+
+// hasByHash reports whether the reader holds the data with the given hash, reading it only if the
+// reader can't tell otherwise.
+func hasByHash(ctx context.Context, reader daprovider.DASReader, key common.Hash) (bool, error) {
+	if checker, ok := reader.(HasChecker); ok {
+		return checker.Has(ctx, key)
+	}
+	_, err := reader.GetByHash(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 const defaultStorageRetention = time.Hour * 24 * 21 // 6 days longer than the batch poster default
 
 func EncodeStorageServiceKey(key common.Hash) string {
@@ -329,7 +329,7 @@ func (v *StatelessBlockValidator) ValidationEntryRecord(ctx context.Context, e *
 				if err != nil {
 					// Matches the way keyset validation was done inside DAS readers i.e logging the error
 					//  But other daproviders might just want to return the error
-					if errors.Is(err, daprovider.ErrSeqMsgValidation) && daprovider.IsDASMessageHeaderByte(batch.Data[40]) {
+					if errors.Is(err, daprovider.ErrSeqMsgValidation) && (daprovider.IsDASMessageHeaderByte(batch.Data[40]) || daprovider.IsICDAMessageHeaderByte(batch.Data[40])) {
 						log.Error(err.Error())
 					} else {
 						return err
@@ -342,6 +342,8 @@ func (v *StatelessBlockValidator) ValidationEntryRecord(ctx context.Context, e *
 		if !foundDA {
 			if daprovider.IsDASMessageHeaderByte(batch.Data[40]) {
 				log.Error("No DAS Reader configured, but sequencer message found with DAS header")
+			} else if daprovider.IsICDAMessageHeaderByte(batch.Data[40]) {
+				log.Error("No ICDA Reader configured, but sequencer message found with ICDA header")
 			}
 		}
 	}
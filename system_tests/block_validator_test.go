@@ -78,6 +78,7 @@ func testBlockValidatorSimple(t *testing.T, opts Options) {
 	defer cleanup()
 
 	authorizeDASKeyset(t, ctx, dasSignerKey, builder.L1Info, builder.L1.Client)
+	authorizeICDAKeyset(t, ctx, l1NodeConfigA.DataAvailability.ICStorage, builder.L1Info, builder.L1.Client)
 
 	validatorConfig := arbnode.ConfigDefaultL1NonSequencerTest()
 	validatorConfig.BlockValidator.Enable = true
@@ -319,6 +320,19 @@ func TestBlockValidatorSimpleLocalDAS(t *testing.T) {
 	testBlockValidatorSimple(t, opts)
 }
 
+// The validator checks the IC certificates of the batches both natively and in the arbitrator,
+// and only validates the blocks if both agree.
+func TestBlockValidatorSimpleICDA(t *testing.T) {
+	t.Skip("the pinned SequencerInbox rejects batches with the ICDA header byte")
+	opts := Options{
+		dasModeString: "icda",
+		workloadLoops: 1,
+		workload:      ethSend,
+		arbitrator:    true,
+	}
+	testBlockValidatorSimple(t, opts)
+}
+
 func TestBlockValidatorSimpleJITOnchain(t *testing.T) {
 	opts := Options{
 		dasModeString: "files",
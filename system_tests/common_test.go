@@ -19,6 +19,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aviate-labs/agent-go/principal"
 	"github.com/go-redis/redis/v8"
 	"github.com/offchainlabs/nitro/arbos"
 	"github.com/offchainlabs/nitro/arbos/arbostypes"
@@ -29,6 +30,7 @@ import (
 	"github.com/offchainlabs/nitro/cmd/conf"
 	"github.com/offchainlabs/nitro/cmd/genericconf"
 	"github.com/offchainlabs/nitro/das"
+	"github.com/offchainlabs/nitro/das/ictest"
 	"github.com/offchainlabs/nitro/deploy"
 	"github.com/offchainlabs/nitro/execution/gethexec"
 	"github.com/offchainlabs/nitro/util/arbmath"
@@ -1217,8 +1219,43 @@ func authorizeDASKeyset(
 	wr := bytes.NewBuffer([]byte{})
 	err := keyset.Serialize(wr)
 	Require(t, err, "unable to serialize DAS keyset")
-	keysetBytes := wr.Bytes()
+	setValidKeyset(t, ctx, wr.Bytes(), l1info, l1client)
+}
 
+func authorizeICDAKeyset(
+	t *testing.T,
+	ctx context.Context,
+	icConfig das.ICStorageConfig,
+	l1info info,
+	l1client arbutil.L1Interface,
+) {
+	if !icConfig.Enable {
+		return
+	}
+	rootKey, err := das.ParseICRootKey(icConfig.RootKey)
+	Require(t, err, "unable to parse IC root key")
+	keyset := &daprovider.ICDAKeyset{
+		Threshold: uint64(icConfig.CertificateThreshold),
+		RootKey:   rootKey,
+	}
+	for _, canister := range icConfig.Canisters {
+		id, err := principal.Decode(canister)
+		Require(t, err, "unable to decode canister id")
+		keyset.Canisters = append(keyset.Canisters, id.Raw)
+	}
+	wr := bytes.NewBuffer([]byte{})
+	err = keyset.Serialize(wr)
+	Require(t, err, "unable to serialize ICDA keyset")
+	setValidKeyset(t, ctx, wr.Bytes(), l1info, l1client)
+}
+
+func setValidKeyset(
+	t *testing.T,
+	ctx context.Context,
+	keysetBytes []byte,
+	l1info info,
+	l1client arbutil.L1Interface,
+) {
 	sequencerInboxABI, err := abi.JSON(strings.NewReader(bridgegen.SequencerInboxABI))
 	Require(t, err, "unable to parse sequencer inbox ABI")
 	setKeysetCalldata, err := sequencerInboxABI.Pack("setValidKeyset", keysetBytes)
@@ -1243,8 +1280,11 @@ func setupConfigWithDAS(
 	var dbPath string
 	var err error
 
-	enableFileStorage, enableDbStorage, enableDas := false, false, true
+	enableFileStorage, enableDbStorage, enableDas, enableICStorage := false, false, true, false
 	switch dasModeString {
+	case "icda":
+		enableICStorage = true
+		chainConfig = params.ArbitrumDevTestDASChainConfig()
 	case "db":
 		enableDbStorage = true
 		chainConfig = params.ArbitrumDevTestDASChainConfig()
@@ -1264,6 +1304,18 @@ func setupConfigWithDAS(
 	dbConfig.Enable = enableDbStorage
 	dbConfig.DataDir = dbPath
 
+	icConfig := das.DefaultICStorageConfig
+	if enableICStorage {
+		// The batch poster stores the batches in an emulated IC canister, which the DAS serves them from.
+		replica, err := ictest.NewReplicaOnRandomPort()
+		Require(t, err)
+		t.Cleanup(func() { Require(t, replica.Shutdown()) })
+		icConfig.Enable = true
+		icConfig.Network = replica.URL()
+		icConfig.Canisters = []string{replica.CanisterID().Encode()}
+		icConfig.RootKey = "0x" + hex.EncodeToString(replica.RootKey())
+	}
+
 	dasConfig := &das.DataAvailabilityConfig{
 		Enable: enableDas,
 		Key: das.KeyConfig{
@@ -1274,6 +1326,7 @@ func setupConfigWithDAS(
 			DataDir: dbPath,
 		},
 		LocalDBStorage:           dbConfig,
+		ICStorage:                icConfig,
 		RequestTimeout:           5 * time.Second,
 		ParentChainNodeURL:       "none",
 		SequencerInboxAddress:    "none",
@@ -1311,6 +1364,11 @@ func setupConfigWithDAS(
 		l1NodeConfigA.DataAvailability.RestAggregator.Urls = []string{"http://" + restLis.Addr().String()}
 		l1NodeConfigA.DataAvailability.ParentChainNodeURL = "none"
 	}
+	if enableICStorage {
+		// ICDA batch posters certify batches with the IC instead of the DAS committee
+		l1NodeConfigA.DataAvailability.RPCAggregator.Enable = false
+		l1NodeConfigA.DataAvailability.ICStorage = icConfig
+	}
 
 	return chainConfig, l1NodeConfigA, lifecycleManager, dbPath, dasSignerKey
 }